@@ -32,13 +32,25 @@ import (
 
 	_ "net/http/pprof"
 
+	_ "github.com/wereliang/govoy/pkg/filter/http/compressor"
+	_ "github.com/wereliang/govoy/pkg/filter/http/cors"
+	_ "github.com/wereliang/govoy/pkg/filter/http/decompressor"
+	_ "github.com/wereliang/govoy/pkg/filter/http/ext_authz"
+	_ "github.com/wereliang/govoy/pkg/filter/http/fault"
+	_ "github.com/wereliang/govoy/pkg/filter/http/istio_stats"
+	_ "github.com/wereliang/govoy/pkg/filter/http/jwt_authn"
+	_ "github.com/wereliang/govoy/pkg/filter/http/local_ratelimit"
+	_ "github.com/wereliang/govoy/pkg/filter/http/metadata_exchange"
 	_ "github.com/wereliang/govoy/pkg/filter/http/myrouter"
+	_ "github.com/wereliang/govoy/pkg/filter/http/rbac"
 	_ "github.com/wereliang/govoy/pkg/filter/http/router"
 	_ "github.com/wereliang/govoy/pkg/filter/listener/http_inspector"
 	_ "github.com/wereliang/govoy/pkg/filter/listener/original_dst"
 	_ "github.com/wereliang/govoy/pkg/filter/listener/tls_inspector"
 	_ "github.com/wereliang/govoy/pkg/filter/network/echo"
 	_ "github.com/wereliang/govoy/pkg/filter/network/http_connection_manager"
+	_ "github.com/wereliang/govoy/pkg/filter/network/metadata_exchange"
+	_ "github.com/wereliang/govoy/pkg/filter/network/rbac"
 	"github.com/wereliang/govoy/pkg/log"
 	"github.com/wereliang/govoy/pkg/server"
 )
@@ -65,7 +77,7 @@ func main() {
 	}
 	defer svc.Stop()
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
 	log.Debug("stopping...")
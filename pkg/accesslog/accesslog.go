@@ -0,0 +1,160 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package accesslog
+
+import (
+	"fmt"
+
+	envoy_config_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_access_loggers_file_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	envoy_access_loggers_stream_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/formatter"
+)
+
+// DefaultFormat is same as envoy's default access log format
+const DefaultFormat = "[%START_TIME%] \"%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%\" " +
+	"%RESPONSE_CODE% %RESPONSE_FLAGS% %BYTES_RECEIVED% %BYTES_SENT% %DURATION% " +
+	"%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)% \"%REQ(X-FORWARDED-FOR)%\" \"%REQ(USER-AGENT)%\" " +
+	"\"%REQ(X-REQUEST-ID)%\" \"%REQ(:AUTHORITY)%\" \"%UPSTREAM_HOST%\"\n"
+
+// accessLogCreator returns the formatter and the path to write
+type accessLogCreator func(proto.Message) (formatter.Formatter, string, error)
+
+type accessLogFactory struct {
+	config proto.Message
+	create accessLogCreator
+}
+
+var accessLogFactories = make(map[string]*accessLogFactory)
+
+func registAccessLog(config proto.Message, create accessLogCreator) {
+	accessLogFactories[proto.MessageName(config)] = &accessLogFactory{config: config, create: create}
+}
+
+func init() {
+	registAccessLog(&envoy_access_loggers_file_v3.FileAccessLog{}, newFileAccessLog)
+	registAccessLog(&envoy_access_loggers_stream_v3.StdoutAccessLog{}, func(pb proto.Message) (formatter.Formatter, string, error) {
+		f, err := newLogFormatter(pb.(*envoy_access_loggers_stream_v3.StdoutAccessLog).GetLogFormat())
+		return f, "/dev/stdout", err
+	})
+	registAccessLog(&envoy_access_loggers_stream_v3.StderrAccessLog{}, func(pb proto.Message) (formatter.Formatter, string, error) {
+		f, err := newLogFormatter(pb.(*envoy_access_loggers_stream_v3.StderrAccessLog).GetLogFormat())
+		return f, "/dev/stderr", err
+	})
+}
+
+func newLogFormatter(c *envoy_config_core_v3.SubstitutionFormatString) (formatter.Formatter, error) {
+	if c == nil {
+		return formatter.NewFormatter(DefaultFormat)
+	}
+	f, _, err := formatter.NewSubstitutionFormatter(c)
+	return f, err
+}
+
+func newFileAccessLog(pb proto.Message) (formatter.Formatter, string, error) {
+	c := pb.(*envoy_access_loggers_file_v3.FileAccessLog)
+	var (
+		f   formatter.Formatter
+		err error
+	)
+	switch c.GetAccessLogFormat().(type) {
+	case *envoy_access_loggers_file_v3.FileAccessLog_Format:
+		f, err = formatter.NewFormatter(c.GetFormat())
+	case *envoy_access_loggers_file_v3.FileAccessLog_JsonFormat:
+		f, err = newLogFormatter(&envoy_config_core_v3.SubstitutionFormatString{
+			Format: &envoy_config_core_v3.SubstitutionFormatString_JsonFormat{JsonFormat: c.GetJsonFormat()}})
+	case *envoy_access_loggers_file_v3.FileAccessLog_TypedJsonFormat:
+		f, err = newLogFormatter(&envoy_config_core_v3.SubstitutionFormatString{
+			Format: &envoy_config_core_v3.SubstitutionFormatString_JsonFormat{JsonFormat: c.GetTypedJsonFormat()}})
+	default:
+		f, err = newLogFormatter(c.GetLogFormat())
+	}
+	return f, c.GetPath(), err
+}
+
+type accessLog struct {
+	filter    Filter
+	formatter formatter.Formatter
+	writer    *asyncWriter
+}
+
+// NewAccessLog create access log by envoy.config.accesslog.v3.AccessLog
+func NewAccessLog(c *envoy_config_accesslog_v3.AccessLog) (api.AccessLog, error) {
+	a := c.GetTypedConfig()
+	if a == nil {
+		return nil, fmt.Errorf("access log %s typed config required", c.GetName())
+	}
+	name, err := ptypes.AnyMessageName(a)
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := accessLogFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("not support access log: %s", name)
+	}
+	pb := proto.Clone(factory.config)
+	if err = ptypes.UnmarshalAny(a, pb); err != nil {
+		return nil, err
+	}
+
+	l := &accessLog{}
+	var path string
+	if l.formatter, path, err = factory.create(pb); err != nil {
+		return nil, err
+	}
+	if l.writer, err = getWriter(path); err != nil {
+		return nil, err
+	}
+	if c.GetFilter() != nil {
+		if l.filter, err = NewFilter(c.GetFilter()); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// NewAccessLogs create access logs, returns error if any is invalid
+func NewAccessLogs(configs []*envoy_config_accesslog_v3.AccessLog) ([]api.AccessLog, error) {
+	var logs []api.AccessLog
+	for _, c := range configs {
+		l, err := NewAccessLog(c)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+func (l *accessLog) Log(ctx api.StreamContext) {
+	if l.filter != nil && !l.filter.Evaluate(ctx) {
+		return
+	}
+	l.writer.Write([]byte(l.formatter.Format(ctx)))
+}
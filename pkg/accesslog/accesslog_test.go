@@ -0,0 +1,155 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	envoy_config_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_access_loggers_file_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+func newTestContext(code int, requestID string) api.StreamContext {
+	req, rsp := &fasthttp.Request{}, &fasthttp.Response{}
+	req.SetRequestURI("/productpage")
+	req.Header.SetMethod("GET")
+	req.Header.Set("x-request-id", requestID)
+	rsp.SetStatusCode(code)
+	rsp.SetBodyString("hello")
+	return http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+}
+
+func newStatusCodeConfig(op envoy_config_accesslog_v3.ComparisonFilter_Op, code uint32) *envoy_config_accesslog_v3.AccessLogFilter {
+	return &envoy_config_accesslog_v3.AccessLogFilter{
+		FilterSpecifier: &envoy_config_accesslog_v3.AccessLogFilter_StatusCodeFilter{
+			StatusCodeFilter: &envoy_config_accesslog_v3.StatusCodeFilter{
+				Comparison: &envoy_config_accesslog_v3.ComparisonFilter{
+					Op: op, Value: &envoy_config_core_v3.RuntimeUInt32{DefaultValue: code}},
+			},
+		},
+	}
+}
+
+func TestFileAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	typed, err := ptypes.MarshalAny(&envoy_access_loggers_file_v3.FileAccessLog{
+		Path: path,
+		AccessLogFormat: &envoy_access_loggers_file_v3.FileAccessLog_LogFormat{
+			LogFormat: &envoy_config_core_v3.SubstitutionFormatString{
+				Format: &envoy_config_core_v3.SubstitutionFormatString_TextFormatSource{
+					TextFormatSource: &envoy_config_core_v3.DataSource{
+						Specifier: &envoy_config_core_v3.DataSource_InlineString{
+							InlineString: "%REQ(:METHOD)% %REQ(:PATH)% %RESPONSE_CODE% %BYTES_SENT%\n",
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	l, err := NewAccessLog(&envoy_config_accesslog_v3.AccessLog{
+		Name:       "envoy.access_loggers.file",
+		Filter:     newStatusCodeConfig(envoy_config_accesslog_v3.ComparisonFilter_GE, 400),
+		ConfigType: &envoy_config_accesslog_v3.AccessLog_TypedConfig{TypedConfig: typed},
+	})
+	assert.NoError(t, err)
+
+	l.Log(newTestContext(200, ""))
+	l.Log(newTestContext(503, ""))
+	l.(*accessLog).writer.flush()
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "GET /productpage 503 5\n", string(data))
+}
+
+func TestFilter(t *testing.T) {
+	or, err := NewFilter(&envoy_config_accesslog_v3.AccessLogFilter{
+		FilterSpecifier: &envoy_config_accesslog_v3.AccessLogFilter_OrFilter{
+			OrFilter: &envoy_config_accesslog_v3.OrFilter{
+				Filters: []*envoy_config_accesslog_v3.AccessLogFilter{
+					newStatusCodeConfig(envoy_config_accesslog_v3.ComparisonFilter_LE, 200),
+					newStatusCodeConfig(envoy_config_accesslog_v3.ComparisonFilter_EQ, 404),
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, or.Evaluate(newTestContext(200, "")))
+	assert.True(t, or.Evaluate(newTestContext(404, "")))
+	assert.False(t, or.Evaluate(newTestContext(503, "")))
+
+	runtime, err := NewFilter(&envoy_config_accesslog_v3.AccessLogFilter{
+		FilterSpecifier: &envoy_config_accesslog_v3.AccessLogFilter_RuntimeFilter{
+			RuntimeFilter: &envoy_config_accesslog_v3.RuntimeFilter{
+				RuntimeKey:     "access_log.sampling",
+				PercentSampled: &envoy_type_v3.FractionalPercent{Numerator: 10},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	// 0x00000005 % 100 < 10, 0x00000063 % 100 = 99
+	assert.True(t, runtime.Evaluate(newTestContext(200, "00000005-6f9c-4e3b-9a4e-0f1a2b3c4d5e")))
+	assert.False(t, runtime.Evaluate(newTestContext(200, "00000063-6f9c-4e3b-9a4e-0f1a2b3c4d5e")))
+}
+
+type testOutput struct {
+	mu   sync.Mutex
+	data bytes.Buffer
+}
+
+func (o *testOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.data.Write(p)
+}
+
+func (o *testOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.data.String()
+}
+
+func TestAsyncWriter(t *testing.T) {
+	out := &testOutput{}
+	dropped := stats.NewStore().Counter("envoy_access_log_dropped", nil)
+	w := newAsyncWriter(out, dropped)
+
+	// the log is dropped if the buffer is full
+	line := bytes.Repeat([]byte("a"), maxBufferSize/2)
+	w.mu.Lock()
+	w.buf.Write(line)
+	w.buf.Write(line)
+	w.mu.Unlock()
+	n, err := w.Write([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, uint64(1), dropped.Value())
+
+	// flushed on close, and dropped after closing
+	w.close()
+	assert.Equal(t, maxBufferSize, len(out.String()))
+	w.Write([]byte("c"))
+	assert.Equal(t, uint64(2), dropped.Value())
+	assert.Equal(t, maxBufferSize, len(out.String()))
+}
+
+func TestStatusCodeFilterNoResponse(t *testing.T) {
+	f, err := NewFilter(newStatusCodeConfig(envoy_config_accesslog_v3.ComparisonFilter_EQ, 0))
+	assert.NoError(t, err)
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), &fasthttp.Request{}, nil)
+	assert.True(t, f.Evaluate(ctx))
+	assert.False(t, f.Evaluate(newTestContext(200, "")))
+}
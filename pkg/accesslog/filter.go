@@ -0,0 +1,206 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package accesslog
+
+import (
+	"fmt"
+	"strconv"
+
+	envoy_config_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/formatter"
+	"github.com/wereliang/govoy/pkg/router"
+	"github.com/wereliang/govoy/pkg/utils"
+)
+
+// Filter decide whether to log by the stream
+type Filter interface {
+	Evaluate(api.StreamContext) bool
+}
+
+// NewFilter create filter by envoy.config.accesslog.v3.AccessLogFilter
+func NewFilter(c *envoy_config_accesslog_v3.AccessLogFilter) (Filter, error) {
+	switch spec := c.GetFilterSpecifier().(type) {
+	case *envoy_config_accesslog_v3.AccessLogFilter_StatusCodeFilter:
+		return &statusCodeFilter{comparison: newComparison(spec.StatusCodeFilter.GetComparison())}, nil
+	case *envoy_config_accesslog_v3.AccessLogFilter_DurationFilter:
+		return &durationFilter{comparison: newComparison(spec.DurationFilter.GetComparison())}, nil
+	case *envoy_config_accesslog_v3.AccessLogFilter_RuntimeFilter:
+		return &runtimeFilter{spec.RuntimeFilter}, nil
+	case *envoy_config_accesslog_v3.AccessLogFilter_ResponseFlagFilter:
+		return newResponseFlagFilter(spec.ResponseFlagFilter)
+	case *envoy_config_accesslog_v3.AccessLogFilter_HeaderFilter:
+		m, err := router.NewHeaderMatcher(spec.HeaderFilter.GetHeader())
+		if err != nil {
+			return nil, err
+		}
+		return &headerFilter{matcher: m}, nil
+	case *envoy_config_accesslog_v3.AccessLogFilter_AndFilter:
+		filters, err := newFilters(spec.AndFilter.GetFilters())
+		if err != nil {
+			return nil, err
+		}
+		return &andFilter{filters}, nil
+	case *envoy_config_accesslog_v3.AccessLogFilter_OrFilter:
+		filters, err := newFilters(spec.OrFilter.GetFilters())
+		if err != nil {
+			return nil, err
+		}
+		return &orFilter{filters}, nil
+	}
+	return nil, fmt.Errorf("not support access log filter: %T", c.GetFilterSpecifier())
+}
+
+func newFilters(configs []*envoy_config_accesslog_v3.AccessLogFilter) ([]Filter, error) {
+	var filters []Filter
+	for _, c := range configs {
+		f, err := NewFilter(c)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+type comparison struct {
+	op    envoy_config_accesslog_v3.ComparisonFilter_Op
+	value uint64
+}
+
+// runtime is not supported, so just use default value
+func newComparison(c *envoy_config_accesslog_v3.ComparisonFilter) *comparison {
+	return &comparison{op: c.GetOp(), value: uint64(c.GetValue().GetDefaultValue())}
+}
+
+func (c *comparison) compare(v uint64) bool {
+	switch c.op {
+	case envoy_config_accesslog_v3.ComparisonFilter_GE:
+		return v >= c.value
+	case envoy_config_accesslog_v3.ComparisonFilter_LE:
+		return v <= c.value
+	default:
+		return v == c.value
+	}
+}
+
+type statusCodeFilter struct {
+	comparison *comparison
+}
+
+func (f *statusCodeFilter) Evaluate(ctx api.StreamContext) bool {
+	return f.comparison.compare(uint64(formatter.ResponseCode(ctx)))
+}
+
+type durationFilter struct {
+	comparison *comparison
+}
+
+func (f *durationFilter) Evaluate(ctx api.StreamContext) bool {
+	return f.comparison.compare(uint64(ctx.StreamInfo().Duration().Milliseconds()))
+}
+
+// runtimeFilter samples by percent_sampled, as runtime is not supported
+type runtimeFilter struct {
+	*envoy_config_accesslog_v3.RuntimeFilter
+}
+
+func (f *runtimeFilter) Evaluate(ctx api.StreamContext) bool {
+	if !f.GetUseIndependentRandomness() {
+		// stable sampling for the same request id like envoy
+		if v, ok := requestIDValue(ctx.Request().Header().Get("x-request-id")); ok {
+			return utils.FractionalPercentHit(f.GetPercentSampled(), v)
+		}
+	}
+	return utils.FractionalPercentSample(f.GetPercentSampled())
+}
+
+// requestIDValue use the first 8 hex chars of uuid as the value
+func requestIDValue(id []byte) (uint64, bool) {
+	if len(id) < 8 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(id[:8]), 16, 64)
+	return v, err == nil
+}
+
+type responseFlagFilter struct {
+	flags api.ResponseFlag
+}
+
+func newResponseFlagFilter(c *envoy_config_accesslog_v3.ResponseFlagFilter) (Filter, error) {
+	f := &responseFlagFilter{}
+	for _, s := range c.GetFlags() {
+		flag := api.ParseResponseFlag(s)
+		if flag == 0 {
+			return nil, fmt.Errorf("invalid response flag: %s", s)
+		}
+		f.flags |= flag
+	}
+	return f, nil
+}
+
+// Evaluate returns true if any flag is set when no flags configured
+func (f *responseFlagFilter) Evaluate(ctx api.StreamContext) bool {
+	flags := ctx.StreamInfo().ResponseFlags()
+	if f.flags == 0 {
+		return flags != 0
+	}
+	return flags&f.flags != 0
+}
+
+type headerFilter struct {
+	matcher router.HeaderMatcher
+}
+
+func (f *headerFilter) Evaluate(ctx api.StreamContext) bool {
+	return f.matcher.Match(ctx.Request().Header())
+}
+
+type andFilter struct {
+	filters []Filter
+}
+
+func (f *andFilter) Evaluate(ctx api.StreamContext) bool {
+	for _, filter := range f.filters {
+		if !filter.Evaluate(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+type orFilter struct {
+	filters []Filter
+}
+
+func (f *orFilter) Evaluate(ctx api.StreamContext) bool {
+	for _, filter := range f.filters {
+		if filter.Evaluate(ctx) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package accesslog
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+const (
+	flushInterval = time.Second
+	// flush immediately if the buffer is larger than this
+	flushSize = 64 * 1024
+	// logs are dropped if the buffer is full, when the output is slower than logging
+	maxBufferSize = 16 * flushSize
+)
+
+var (
+	writersMu sync.Mutex
+	writers   = make(map[string]*asyncWriter)
+)
+
+// getWriter returns the writer of the path, which is shared by all access logs
+func getWriter(path string) (*asyncWriter, error) {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+
+	if w, ok := writers[path]; ok {
+		return w, nil
+	}
+	var out io.Writer
+	switch path {
+	case "/dev/stdout":
+		out = os.Stdout
+	case "/dev/stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	w := newAsyncWriter(out, stats.DefaultStore.Counter("envoy_access_log_dropped", []stats.Tag{{Name: "path", Value: path}}))
+	writers[path] = w
+	return w, nil
+}
+
+// Flush writes the buffered logs of the access logs, called when the owner is closed
+func Flush(logs []api.AccessLog) {
+	for _, l := range logs {
+		if al, ok := l.(*accessLog); ok {
+			al.writer.flush()
+		}
+	}
+}
+
+// Close flushes and closes all the writers, the logs after closing are dropped
+func Close() {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+	for path, w := range writers {
+		w.close()
+		delete(writers, path)
+	}
+}
+
+// asyncWriter buffers the logs and writes them in background, so logging never blocks the stream
+type asyncWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	out     io.Writer
+	closed  bool
+	dropped *stats.Counter
+	signal  chan struct{}
+	done    chan struct{}
+	// writeMu keeps the order of the flushed data
+	writeMu sync.Mutex
+}
+
+func newAsyncWriter(out io.Writer, dropped *stats.Counter) *asyncWriter {
+	w := &asyncWriter{
+		out:     out,
+		dropped: dropped,
+		signal:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	if w.closed || w.buf.Len()+len(p) > maxBufferSize {
+		w.mu.Unlock()
+		w.dropped.Inc()
+		return 0, nil
+	}
+	n, _ := w.buf.Write(p)
+	size := w.buf.Len()
+	w.mu.Unlock()
+
+	if size >= flushSize {
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+	return n, nil
+}
+
+func (w *asyncWriter) loop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.signal:
+		case <-w.done:
+			return
+		}
+		w.flush()
+	}
+}
+
+func (w *asyncWriter) flush() {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.mu.Lock()
+	if w.buf.Len() == 0 {
+		w.mu.Unlock()
+		return
+	}
+	data := make([]byte, w.buf.Len())
+	copy(data, w.buf.Bytes())
+	w.buf.Reset()
+	w.mu.Unlock()
+
+	if _, err := w.out.Write(data); err != nil {
+		log.Error("write access log error: %s", err)
+	}
+}
+
+// close stops the background loop and flushes the buffer, stdout and stderr are not closed
+func (w *asyncWriter) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.done)
+	w.flush()
+	if f, ok := w.out.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		f.Close()
+	}
+}
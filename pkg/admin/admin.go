@@ -39,7 +39,9 @@ import (
 	"github.com/wereliang/govoy/pkg/api"
 	"github.com/wereliang/govoy/pkg/config"
 	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/stats"
 	"github.com/wereliang/govoy/pkg/utils"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -89,6 +91,22 @@ func (s *adminServer) handle() {
 	})
 	http.HandleFunc("/config_dump", s.configDump)
 	http.HandleFunc("/cluster", s.cluster)
+	http.HandleFunc("/stats", s.stats)
+	http.HandleFunc("/stats/prometheus", s.prometheusStats)
+}
+
+func (s *adminServer) stats(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("format") == "prometheus" {
+		s.prometheusStats(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	stats.DefaultStore.WriteText(w)
+}
+
+func (s *adminServer) prometheusStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=UTF-8")
+	stats.DefaultStore.WritePrometheus(w)
 }
 
 func (s *adminServer) configDump(w http.ResponseWriter, r *http.Request) {
@@ -196,6 +214,30 @@ func (s *adminServer) dumpRouteConfig() *envoy_admin_v3.RoutesConfigDump {
 	return routeConfigDump
 }
 
+// adminHost is the host of cluster output, the health flags are like envoy /clusters
+type adminHost struct {
+	Address     string          `json:"address"`
+	Hostname    string          `json:"hostname,omitempty"`
+	Weight      uint32          `json:"weight"`
+	HealthFlags string          `json:"health_flags"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+}
+
+func newAdminHost(h api.Host) *adminHost {
+	host := &adminHost{
+		Address:     h.Address().String(),
+		Hostname:    h.Hostname(),
+		Weight:      h.Weight(),
+		HealthFlags: api.HealthFlagsString(h),
+	}
+	if md := h.Metadata(); md != nil {
+		if data, err := protojson.Marshal(md); err == nil {
+			host.Metadata = data
+		}
+	}
+	return host
+}
+
 func (s *adminServer) cluster(w http.ResponseWriter, r *http.Request) {
 
 	var err error
@@ -203,7 +245,7 @@ func (s *adminServer) cluster(w http.ResponseWriter, r *http.Request) {
 
 	temp := struct {
 		Cluster *envoy_config_cluster_v3.Cluster `json:"cluster"`
-		Hosts   api.HostSet                      `json:"hosts"`
+		Hosts   []*adminHost                     `json:"hosts"`
 	}{}
 
 	if name := r.FormValue("name"); name == "" {
@@ -215,9 +257,9 @@ func (s *adminServer) cluster(w http.ResponseWriter, r *http.Request) {
 		} else {
 			snapShot := cluster.Snapshot()
 			temp.Cluster = snapShot.ClusterInfo().Config()
-			temp.Hosts = snapShot.HostSet()
-			for _, h := range temp.Hosts {
+			for _, h := range snapShot.HostSet() {
 				log.Debug("ip:%s weight:%d", h.Address().String(), h.Weight())
+				temp.Hosts = append(temp.Hosts, newAdminHost(h))
 			}
 			data, _ := json.Marshal(temp)
 			w.Write(data)
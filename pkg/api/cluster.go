@@ -26,18 +26,81 @@ package api
 
 import (
 	"net"
+	"sync/atomic"
 
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 )
 
 type HostInfo interface {
 	SetWeight(uint32)
 	Weight() uint32
+
+	// Hostname is the hostname of endpoint, such as the pod name
+	SetHostname(string)
+	Hostname() string
+
+	// Metadata is the endpoint metadata, such as the istio workload labels
+	SetMetadata(*envoy_config_core_v3.Metadata)
+	Metadata() *envoy_config_core_v3.Metadata
+
+	// HealthFlagSet sets the health flag of host, the host is unhealthy if any failed flag is set
+	HealthFlagSet(HealthFlag)
+	HealthFlagClear(HealthFlag)
+	HealthFlagGet(HealthFlag) bool
+
+	// Healthy returns true if no failed flag is set, the degraded host is healthy but selected only
+	// if there is no other healthy host. Load balancers skip the unhealthy hosts.
+	Healthy() bool
+}
+
+// HealthFlag is the reason why a host is unhealthy, same as envoy Host::HealthFlag
+type HealthFlag uint32
+
+const (
+	// FailedActiveHealthCheck the host failed the active health checking
+	FailedActiveHealthCheck HealthFlag = 1 << iota
+	// FailedOutlierCheck the host is ejected by outlier detection
+	FailedOutlierCheck
+	// FailedEDSHealth the host is marked unhealthy, draining or timeout by EDS
+	FailedEDSHealth
+	// DegradedEDSHealth the host is marked degraded by EDS
+	DegradedEDSHealth
+)
+
+var healthFlagNames = []struct {
+	flag HealthFlag
+	name string
+}{
+	{FailedActiveHealthCheck, "failed_active_hc"},
+	{FailedOutlierCheck, "failed_outlier_check"},
+	{FailedEDSHealth, "failed_eds_health"},
+	{DegradedEDSHealth, "degraded_eds_health"},
+}
+
+// HealthFlagsString returns the health flags of host like envoy admin, such as /failed_outlier_check,
+// or healthy if no flag is set
+func HealthFlagsString(h Host) string {
+	var s string
+	for _, f := range healthFlagNames {
+		if h.HealthFlagGet(f.flag) {
+			s += "/" + f.name
+		}
+	}
+	if s == "" {
+		return "healthy"
+	}
+	return s
 }
 
 type Host interface {
 	HostInfo
 	Address() net.Addr
+
+	// ActiveRequests is the number of requests sending to the host, used by least request load balancer
+	ActiveRequests() int64
+	IncActiveRequests()
+	DecActiveRequests()
 }
 
 type HostSet []Host
@@ -62,10 +125,85 @@ type Cluster interface {
 	// UpdateHosts updates the host set's hosts
 	UpdateHosts([]Host)
 
+	// ConnPool returns the upstream connection pool of cluster for the priority
+	ConnPool(RoutingPriority) ConnPool
+
+	// ResourceManager returns the circuit breakers of cluster for the priority
+	ResourceManager(RoutingPriority) ResourceManager
+
+	// OutlierDetector returns the outlier detector of cluster, nil if outlier detection is not configured
+	OutlierDetector() OutlierDetector
+
 	// Close destory cluster
 	Close()
 }
 
+// OutlierResult is the result of request which has no upstream response
+type OutlierResult int
+
+const (
+	// LocalOriginConnectFailed the connection to the host is failed
+	LocalOriginConnectFailed OutlierResult = iota
+	// LocalOriginTimeout the request to the host is timeout
+	LocalOriginTimeout
+	// ExtOriginRequestFailed the host resets the request
+	ExtOriginRequestFailed
+)
+
+// OutlierDetector ejects the hosts which keep failing from the load balancing
+type OutlierDetector interface {
+	// PutHTTPResponseCode reports the response code of the host
+	PutHTTPResponseCode(Host, int)
+
+	// PutResult reports the result of the host which has no response
+	PutResult(Host, OutlierResult)
+}
+
+// RoutingPriority is the priority of route, same as envoy RoutingPriority
+type RoutingPriority int32
+
+const (
+	PriorityDefault RoutingPriority = 0
+	PriorityHigh    RoutingPriority = 1
+)
+
+func (p RoutingPriority) String() string {
+	if p == PriorityHigh {
+		return "high"
+	}
+	return "default"
+}
+
+// ResourceLimit is a threshold of circuit breakers
+type ResourceLimit interface {
+	// CanCreate returns false if the resource is exhausted, that is the breaker is open
+	CanCreate() bool
+	// TryInc increases the count if the resource is not exhausted, it returns false if the breaker is open
+	TryInc() bool
+	Inc()
+	Dec()
+	Count() uint64
+	Max() uint64
+}
+
+// ResourceManager is the circuit breakers of cluster for a priority
+type ResourceManager interface {
+	Connections() ResourceLimit
+	PendingRequests() ResourceLimit
+	Requests() ResourceLimit
+	Retries() ResourceLimit
+}
+
+// ConnPool is the upstream connection pool owned by cluster
+type ConnPool interface {
+	// Call sends the request of stream to the host of request uri, and waits for the response.
+	// The request fails without sending if the circuit breakers are open.
+	Call(StreamContext) error
+
+	// Close closes the pool when the cluster is removed
+	Close()
+}
+
 // ClusterManager is a manager for cluster
 type ClusterManager interface {
 	// AddOrUpdateCluster add or update cluster
@@ -118,18 +256,78 @@ func NewHost(addr net.Addr) Host {
 }
 
 type host struct {
-	weight uint32
-	addr   net.Addr
+	weight   uint32
+	addr     net.Addr
+	flags    uint32
+	active   int64
+	hostname atomic.Value
+	metadata atomic.Value
 }
 
 func (h *host) Weight() uint32 {
-	return h.weight
+	return atomic.LoadUint32(&h.weight)
 }
 
 func (h *host) SetWeight(w uint32) {
-	h.weight = w
+	atomic.StoreUint32(&h.weight, w)
+}
+
+func (h *host) SetHostname(name string) {
+	h.hostname.Store(name)
+}
+
+func (h *host) Hostname() string {
+	name, _ := h.hostname.Load().(string)
+	return name
+}
+
+func (h *host) SetMetadata(md *envoy_config_core_v3.Metadata) {
+	h.metadata.Store(md)
+}
+
+func (h *host) Metadata() *envoy_config_core_v3.Metadata {
+	md, _ := h.metadata.Load().(*envoy_config_core_v3.Metadata)
+	return md
 }
 
 func (h *host) Address() net.Addr {
 	return h.addr
 }
+
+func (h *host) ActiveRequests() int64 {
+	return atomic.LoadInt64(&h.active)
+}
+
+func (h *host) IncActiveRequests() {
+	atomic.AddInt64(&h.active, 1)
+}
+
+func (h *host) DecActiveRequests() {
+	atomic.AddInt64(&h.active, -1)
+}
+
+func (h *host) HealthFlagSet(flag HealthFlag) {
+	for {
+		old := atomic.LoadUint32(&h.flags)
+		if atomic.CompareAndSwapUint32(&h.flags, old, old|uint32(flag)) {
+			return
+		}
+	}
+}
+
+func (h *host) HealthFlagClear(flag HealthFlag) {
+	for {
+		old := atomic.LoadUint32(&h.flags)
+		if atomic.CompareAndSwapUint32(&h.flags, old, old&^uint32(flag)) {
+			return
+		}
+	}
+}
+
+func (h *host) HealthFlagGet(flag HealthFlag) bool {
+	return atomic.LoadUint32(&h.flags)&uint32(flag) != 0
+}
+
+func (h *host) Healthy() bool {
+	return atomic.LoadUint32(&h.flags)&^uint32(DegradedEDSHealth) == 0
+}
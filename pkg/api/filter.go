@@ -28,6 +28,7 @@ import (
 	"bufio"
 	"bytes"
 
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	"github.com/golang/protobuf/proto"
 )
@@ -37,6 +38,14 @@ type FilterStatus int
 const (
 	Stop     FilterStatus = 0
 	Continue FilterStatus = 1
+
+	// StopIteration for http filters stops calling the following filters until ContinueDecoding
+	// or ContinueEncoding is called, for network read filters it stops calling the following
+	// filters until more data is read
+	StopIteration FilterStatus = 2
+
+	// StopAndBuffer is same as StopIteration, and the body is buffered until continue
+	StopAndBuffer FilterStatus = 3
 )
 
 // ListenerFilterCallbacks
@@ -66,31 +75,83 @@ type WriteFilter interface {
 	OnWrite(*bufio.Writer) FilterStatus
 }
 
-// StreamDecoderFilter for http stream filter
+// StreamDecoderFilter for http stream filter, the decoder filters are called in order of registration.
+// The request is read completely, so data and trailers are called at most once.
 type StreamDecoderFilter interface {
-	// Decode call before request
-	Decode(StreamContext) FilterStatus
+	// DecodeHeaders is called with request headers, endStream is true if no body and trailers
+	DecodeHeaders(ctx StreamContext, endStream bool) FilterStatus
+
+	// DecodeData is called with request body if not empty, endStream is true if no trailers
+	DecodeData(ctx StreamContext, data Body, endStream bool) FilterStatus
+
+	// DecodeTrailers is called if the request has trailers
+	DecodeTrailers(ctx StreamContext, trailers HeaderMap) FilterStatus
 
 	// SetDecoderFilterCallbacks
 	SetDecoderFilterCallbacks(DecoderFilterCallbacks)
 }
 
-// DecoderFilterCallbacks
-type DecoderFilterCallbacks interface {
+// Dispatcher runs the posted events in the goroutine of a stream
+type Dispatcher interface {
+	// Post the event to the stream, it's dropped if the stream is done
+	Post(event func())
+}
+
+// StreamFilterCallbacks is common callbacks of decoder and encoder filters.
+// The callbacks are applied to the current stream of the connection, so the async
+// work should post its callback to the Dispatcher of the stream started it.
+type StreamFilterCallbacks interface {
 	// Connection return api.Connection
 	Connection() Connection
 
 	// Route return route config matcher
 	Route() RouteConfigMatcher
 
+	// SendLocalReply stop the filter chain and reply to downstream directly
+	SendLocalReply(code int, body string, details string)
+
+	// Dispatcher returns the dispatcher of current stream
+	Dispatcher() Dispatcher
+}
+
+// DecoderFilterCallbacks
+type DecoderFilterCallbacks interface {
+	StreamFilterCallbacks
+
 	// SetRoute
 	SetRoute(RouteConfigMatcher)
+
+	// RouteEntry returns the route entry matched by current request, which is cached in stream info
+	RouteEntry() RouteEntry
+
+	// ClearRouteCache clear the cached route entry, called when the headers affecting routing are modified
+	ClearRouteCache()
+
+	// ContinueDecoding continue to call the following decoder filters after StopIteration
+	ContinueDecoding()
 }
 
-// StreamEncoderFilter for http stream filter
+// StreamEncoderFilter for http stream filter, the encoder filters are called in reverse order of registration
 type StreamEncoderFilter interface {
-	// Encode call after request
-	Encode(StreamContext) FilterStatus
+	// EncodeHeaders is called with response headers, endStream is true if no body and trailers
+	EncodeHeaders(ctx StreamContext, endStream bool) FilterStatus
+
+	// EncodeData is called with response body if not empty, endStream is true if no trailers
+	EncodeData(ctx StreamContext, data Body, endStream bool) FilterStatus
+
+	// EncodeTrailers is called if the response has trailers
+	EncodeTrailers(ctx StreamContext, trailers HeaderMap) FilterStatus
+
+	// SetEncoderFilterCallbacks
+	SetEncoderFilterCallbacks(EncoderFilterCallbacks)
+}
+
+// EncoderFilterCallbacks
+type EncoderFilterCallbacks interface {
+	StreamFilterCallbacks
+
+	// ContinueEncoding continue to call the following encoder filters after StopIteration
+	ContinueEncoding()
 }
 
 // Factory is basic factory
@@ -144,10 +205,19 @@ type HTTPFilterManager interface {
 
 	// AddEncodeFilter add http encoder filter
 	AddEncodeFilter(StreamEncoderFilter)
+
+	// AddAccessLog add access log which is called when stream is complete
+	AddAccessLog(AccessLog)
 }
 
 type HTTPFilterCreator func(HTTPFilterManager)
 
+// RouteSpecificFilterFactory is optional for http factory, which creates the per route config
+// from typed_per_filter_config of virtual host, route and weighted cluster
+type RouteSpecificFilterFactory interface {
+	CreateRouteSpecificFilterConfig(proto.Message) (interface{}, error)
+}
+
 // HTTPFactory for http factory
 type HTTPFactory interface {
 	Factory
@@ -166,6 +236,9 @@ type FactoryContext interface {
 
 	// RouteConfigManager
 	RouteConfigManager() RouteConfigManager
+
+	// LocalNode returns the node of bootstrap, which is nil if not set
+	LocalNode() *envoy_config_core_v3.Node
 }
 
 // FilterChainManager filter chain manager
@@ -32,6 +32,7 @@ type HeaderMap interface {
 	Add(key, value string)
 	Set(key, value string)
 	Get(key string) []byte
+	VisitAll(f func(key, value []byte))
 }
 
 // StreamContext http stream context
@@ -39,6 +40,7 @@ type StreamContext interface {
 	Context() context.Context
 	Request() Request
 	Response() Response
+	StreamInfo() StreamInfo
 }
 
 // Request http request
@@ -67,6 +69,7 @@ type RequestHeader interface {
 	SetRequestURI(requestURI string)
 	Path() []byte
 	SetPath(path string)
+	QueryArg(key string) ([]byte, bool)
 }
 
 // ResponseHeader http response header
@@ -80,4 +83,5 @@ type ResponseHeader interface {
 type Body interface {
 	AppendBody([]byte)
 	SetBody([]byte)
+	Bytes() []byte
 }
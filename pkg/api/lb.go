@@ -27,8 +27,12 @@ package api
 type LoadBalancerType int32
 
 const (
-	Round_Robin  LoadBalancerType = 0
-	Original_Dst LoadBalancerType = 10
+	Round_Robin   LoadBalancerType = 0
+	Least_Request LoadBalancerType = 1
+	Random        LoadBalancerType = 3
+	Original_Dst  LoadBalancerType = 10
+	// Weighted_Round_Robin is the EDF round robin of load_balancing_policy round_robin extension
+	Weighted_Round_Robin LoadBalancerType = 11
 )
 
 // LoadBalancerContext use for lb context
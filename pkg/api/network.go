@@ -26,6 +26,8 @@ package api
 
 import (
 	"net"
+
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 type Listener interface {
@@ -62,4 +64,7 @@ type ConnectionContext interface {
 	LocalAddressRestored() bool
 	SetOriginalDestination(net.IP, uint32)
 	SetApplicationProtocol(string)
+	// GetPeerMetadata returns the node id and metadata of peer, which are set by metadata exchange
+	GetPeerMetadata() (string, *structpb.Struct)
+	SetPeerMetadata(id string, metadata *structpb.Struct)
 }
@@ -0,0 +1,56 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package api
+
+// TraceReason is the tracing decision packed in the request id
+type TraceReason int
+
+const (
+	TraceNotTraceable TraceReason = iota
+	TraceSampled
+	TraceClient
+	TraceForced
+)
+
+// RequestIDExtension handle the x-request-id, like envoy's request id extension
+type RequestIDExtension interface {
+	// Set generate the request id if not present or force is true
+	Set(header RequestHeader, force bool)
+
+	// SetInResponse copy the request id to response
+	SetInResponse(response ResponseHeader, request RequestHeader)
+
+	// ModBy returns the request id mod by mod, false if request id is invalid
+	ModBy(header RequestHeader, mod uint64) (uint64, bool)
+
+	// TraceReason returns the trace reason packed in request id
+	TraceReason(header RequestHeader) TraceReason
+
+	// SetTraceReason pack the trace reason into the request id
+	SetTraceReason(header RequestHeader, reason TraceReason)
+
+	// UseRequestIDForTraceSampling returns whether to sample by request id
+	UseRequestIDForTraceSampling() bool
+}
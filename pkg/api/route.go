@@ -25,6 +25,8 @@
 package api
 
 import (
+	"time"
+
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 )
 
@@ -36,7 +38,76 @@ const (
 )
 
 type RouteEntry interface {
+	// ClusterName returns the upstream cluster name
 	ClusterName() string
+
+	// FinalizeRequestHeaders apply request header mutations of the route
+	FinalizeRequestHeaders(StreamContext)
+
+	// FinalizeResponseHeaders apply response header mutations of the route
+	FinalizeResponseHeaders(StreamContext)
+
+	// CorsPolicy returns the cors policy of route, or virtual host's if route's is not set
+	CorsPolicy() CorsPolicy
+
+	// PerFilterConfig returns the most specific per filter config by filter name, which is
+	// created by RouteSpecificFilterFactory or the proto message of typed_per_filter_config
+	PerFilterConfig(name string) interface{}
+
+	// RateLimitPolicy returns the rate limits of route, and virtual host's if route's are not
+	// set or include_vh_rate_limits
+	RateLimitPolicy() RateLimitPolicy
+
+	// Decorator returns the operation name of the route's decorator, empty if not set
+	Decorator() string
+
+	// Priority returns the routing priority, which selects the circuit breakers and connection pool
+	Priority() RoutingPriority
+
+	// MirrorPolicies returns the request mirror policies of route, or virtual host's if route's are not set
+	MirrorPolicies() []MirrorPolicy
+
+	// Timeout returns the timeout of upstream response, 15s if not set and 0 means disabled like envoy
+	Timeout() time.Duration
+}
+
+// MirrorPolicy is the request mirror policy, the mirror's response is ignored
+type MirrorPolicy interface {
+	// ClusterName returns the mirror cluster, which is from request header if cluster_header is set
+	ClusterName(header RequestHeader) string
+
+	// Enabled sample by runtime_fraction, which is enabled if not set
+	Enabled() bool
+}
+
+// RateLimitDescriptorEntry is a key value pair of descriptor
+type RateLimitDescriptorEntry struct {
+	Key   string
+	Value string
+}
+
+// RateLimitDescriptor is generated by the actions of a rate limit
+type RateLimitDescriptor []RateLimitDescriptorEntry
+
+// RateLimitPolicy is the rate limits of virtual host and route
+type RateLimitPolicy interface {
+	// Descriptors generate descriptors by rate limits of the stage, a descriptor is skipped
+	// if any of its actions can not be applied
+	Descriptors(stage uint32, ctx StreamContext) []RateLimitDescriptor
+}
+
+// CorsPolicy is the cors policy of virtual host or route
+type CorsPolicy interface {
+	// AllowOrigin returns whether the origin is allowed
+	AllowOrigin(origin string) bool
+	AllowMethods() string
+	AllowHeaders() string
+	ExposeHeaders() string
+	MaxAge() string
+	AllowCredentials() bool
+
+	// Enabled sample by filter_enabled, which is enabled if not set
+	Enabled() bool
 }
 
 type RouteConfigMatcher interface {
@@ -0,0 +1,160 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package api
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ResponseFlag is the additional detail of the response, like envoy's response flags
+type ResponseFlag uint64
+
+const (
+	FailedLocalHealthCheck ResponseFlag = 1 << iota
+	NoHealthyUpstream
+	UpstreamRequestTimeout
+	LocalReset
+	UpstreamRemoteReset
+	UpstreamConnectionFailure
+	UpstreamConnectionTermination
+	UpstreamOverflow
+	NoRouteFound
+	DelayInjected
+	FaultInjected
+	RateLimited
+	UnauthorizedExternalService
+	RateLimitServiceError
+	DownstreamConnectionTermination
+	UpstreamRetryLimitExceeded
+	StreamIdleTimeout
+	InvalidEnvoyRequestHeaders
+	DownstreamProtocolError
+	UpstreamMaxStreamDurationReached
+	ResponseFromCacheFilter
+	NoFilterConfigFound
+	DurationTimeout
+	UpstreamProtocolError
+	NoClusterFound
+	OverloadManager
+	DnsResolutionFailed
+)
+
+var responseFlagStrings = []string{
+	"LH", "UH", "UT", "LR", "UR", "UF", "UC", "UO", "NR", "DI", "FI", "RL", "UAEX", "RLSE",
+	"DC", "URX", "SI", "IH", "DPE", "UMSDR", "RFCF", "NFCF", "DT", "UPE", "NC", "OM", "DF",
+}
+
+// String returns the short strings of flags joined by ",", like "UH,UF"
+func (f ResponseFlag) String() string {
+	var flags []string
+	for i, s := range responseFlagStrings {
+		if f&(1<<i) != 0 {
+			flags = append(flags, s)
+		}
+	}
+	return strings.Join(flags, ",")
+}
+
+// ParseResponseFlag returns the response flag by short string, 0 if invalid
+func ParseResponseFlag(s string) ResponseFlag {
+	for i, fs := range responseFlagStrings {
+		if fs == s {
+			return 1 << i
+		}
+	}
+	return 0
+}
+
+// StreamInfo holds the information of a http stream, used by logging and header formatting
+type StreamInfo interface {
+	// StartTime returns the time when the stream started
+	StartTime() time.Time
+
+	// OnRequestComplete set the end time of the stream
+	OnRequestComplete()
+
+	// Duration returns the duration from start to complete, or to now if not complete
+	Duration() time.Duration
+
+	// Protocol returns the downstream http protocol, like HTTP/1.1
+	Protocol() string
+
+	// DownstreamRemoteAddress returns the downstream remote address
+	DownstreamRemoteAddress() net.Addr
+
+	// DownstreamLocalAddress returns the downstream local address, which is
+	// the original destination if it's restored
+	DownstreamLocalAddress() net.Addr
+
+	// UpstreamHost returns the selected upstream host, nil if no host is selected
+	UpstreamHost() Host
+
+	// SetUpstreamHost set the selected upstream host
+	SetUpstreamHost(Host)
+
+	// RouteEntry returns the matched route entry, nil if no route is matched
+	RouteEntry() RouteEntry
+
+	// SetRouteEntry set the matched route entry
+	SetRouteEntry(RouteEntry)
+
+	// RequestID returns the x-request-id of the stream
+	RequestID() string
+
+	// SetRequestID set the request id
+	SetRequestID(string)
+
+	// ResponseFlags returns all the response flags
+	ResponseFlags() ResponseFlag
+
+	// HasResponseFlag returns whether the flag is set
+	HasResponseFlag(ResponseFlag) bool
+
+	// SetResponseFlag set a response flag
+	SetResponseFlag(ResponseFlag)
+
+	// ResponseCodeDetails returns the details of response code, like "route_not_found"
+	ResponseCodeDetails() string
+
+	// SetResponseCodeDetails set the response code details
+	SetResponseCodeDetails(string)
+
+	// DynamicMetadata returns the metadata set by filters, keyed by filter name
+	DynamicMetadata() *envoy_config_core_v3.Metadata
+
+	// SetDynamicMetadata merge the fields into the metadata of the filter
+	SetDynamicMetadata(name string, value *structpb.Struct)
+
+	// ActiveSpan returns the span of the stream, nil if tracing is not enabled
+	ActiveSpan() Span
+
+	// SetActiveSpan set the span of the stream
+	SetActiveSpan(Span)
+}
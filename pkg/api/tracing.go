@@ -0,0 +1,55 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package api
+
+import "time"
+
+// Span is an operation of a trace, which is reported when finished if sampled
+type Span interface {
+	// SetOperation set the name of span
+	SetOperation(name string)
+
+	// SetTag set a tag of span, like http.status_code
+	SetTag(key, value string)
+
+	// InjectContext set the trace context headers of the span into request for propagation
+	InjectContext(header RequestHeader)
+
+	// SpawnChild creates a child span with the same sampling decision, like the upstream request of router
+	SpawnChild(operation string, start time.Time) Span
+
+	// Sampled returns whether the span is reported
+	Sampled() bool
+
+	// Finish ends the span and report it if sampled
+	Finish()
+}
+
+// Tracer is the tracing driver of http connection manager
+type Tracer interface {
+	// StartSpan creates the span of downstream request, the parent is extracted from request headers,
+	// and the sampled flag of headers takes precedence over sampled
+	StartSpan(header RequestHeader, operation string, start time.Time, sampled bool) Span
+}
@@ -31,8 +31,10 @@ import (
 	"time"
 
 	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
 	"github.com/wereliang/govoy/pkg/lb"
 	"github.com/wereliang/govoy/pkg/log"
 	"github.com/wereliang/govoy/pkg/utils"
@@ -84,13 +86,13 @@ func GetEndpointFromClusterLoad(
 func newSimpleCluster(cluster *envoy_config_cluster_v3.Cluster) *simpleCluster {
 
 	clusterType := api.ClusterType(cluster.GetType())
-	lbType := api.LoadBalancerType(cluster.GetLbPolicy())
-	if clusterType == api.Cluster_ORIGINAL_DST {
-		lbType = api.Original_Dst
+	lbType := api.Original_Dst
+	if clusterType != api.Cluster_ORIGINAL_DST {
+		lbType = lb.LoadBalancerType(cluster)
 	}
 	log.Debug("cluster:%s type:%d lb:%d", cluster.Name, clusterType, lbType)
 
-	return &simpleCluster{
+	c := &simpleCluster{
 		info: &clusterInfo{
 			name:        cluster.Name,
 			clusterType: clusterType,
@@ -98,12 +100,31 @@ func newSimpleCluster(cluster *envoy_config_cluster_v3.Cluster) *simpleCluster {
 			config:      cluster,
 			ts:          time.Now(),
 		},
+		resources: newResourceManagers(cluster),
 	}
+	// connection pools are separated by priority like envoy
+	for _, rm := range c.resources {
+		c.pools = append(c.pools, http.NewConnPool(cluster, rm))
+	}
+	if od := cluster.GetOutlierDetection(); od != nil {
+		c.outlier = newOutlierDetector(cluster.Name, od)
+	}
+	if hcs := cluster.GetHealthChecks(); len(hcs) > 0 {
+		if len(hcs) > 1 {
+			log.Warn("just support one health check for cluster %s", cluster.Name)
+		}
+		c.healthChecker = newHealthChecker(cluster.Name, hcs[0])
+	}
+	return c
 }
 
 type simpleCluster struct {
-	snapShot atomic.Value
-	info     api.ClusterInfo
+	snapShot      atomic.Value
+	info          api.ClusterInfo
+	resources     []*resourceManager
+	pools         []api.ConnPool
+	outlier       *outlierDetector
+	healthChecker *healthChecker
 }
 
 func (c *simpleCluster) Snapshot() api.ClusterSnapshot {
@@ -115,19 +136,73 @@ func (c *simpleCluster) Snapshot() api.ClusterSnapshot {
 }
 
 func (c *simpleCluster) UpdateHosts(hosts []api.Host) {
+	hosts = c.reuseHosts(hosts)
+	if c.outlier != nil {
+		c.outlier.updateHosts(hosts)
+	}
+	if c.healthChecker != nil {
+		c.healthChecker.updateHosts(hosts)
+	}
 	snapShot := &clusterSnapShot{
 		clusterInfo: c.info,
-		lb:          lb.NewLoadBalancer(c.info.LbType(), hosts),
+		lb:          lb.NewLoadBalancer(c.info, hosts),
 		hosts:       hosts,
 	}
 	c.snapShot.Store(snapShot)
 }
 
+// reuseHosts keeps the existing hosts with the same address, so the health of hosts is not lost by updating
+func (c *simpleCluster) reuseHosts(hosts []api.Host) []api.Host {
+	ss := c.Snapshot()
+	if ss == nil || len(ss.HostSet()) == 0 {
+		return hosts
+	}
+	existing := make(map[string]api.Host, len(ss.HostSet()))
+	for _, h := range ss.HostSet() {
+		existing[h.Address().String()] = h
+	}
+	reused := make([]api.Host, 0, len(hosts))
+	for _, h := range hosts {
+		if old, ok := existing[h.Address().String()]; ok {
+			copyHostInfo(old, h)
+			h = old
+		}
+		reused = append(reused, h)
+	}
+	return reused
+}
+
 func (c *simpleCluster) getConfigHosts() (api.HostSet, error) {
 	return GetClusterEndpoint(c.info.Config())
 }
 
-func (c *simpleCluster) Close() {}
+func (c *simpleCluster) ConnPool(priority api.RoutingPriority) api.ConnPool {
+	return c.pools[priority]
+}
+
+func (c *simpleCluster) ResourceManager(priority api.RoutingPriority) api.ResourceManager {
+	return c.resources[priority]
+}
+
+func (c *simpleCluster) OutlierDetector() api.OutlierDetector {
+	if c.outlier == nil {
+		return nil
+	}
+	return c.outlier
+}
+
+func (c *simpleCluster) Close() {
+	if c.outlier != nil {
+		c.outlier.close()
+	}
+	if c.healthChecker != nil {
+		c.healthChecker.close()
+	}
+	for i := range c.pools {
+		c.pools[i].Close()
+		c.resources[i].close()
+	}
+}
 
 type clusterSnapShot struct {
 	clusterInfo api.ClusterInfo
@@ -200,5 +275,30 @@ func NewHostByEndpoint(lbedp *envoy_config_endpoint_v3.LbEndpoint, ctype api.Clu
 		// set default weight
 		h.SetWeight(api.DEFAULT_WEIGHT)
 	}
+	h.SetHostname(edp.GetHostname())
+	h.SetMetadata(lbedp.GetMetadata())
+
+	// same as envoy, the draining and timeout hosts are unhealthy
+	switch lbedp.GetHealthStatus() {
+	case envoy_config_core_v3.HealthStatus_UNHEALTHY, envoy_config_core_v3.HealthStatus_DRAINING,
+		envoy_config_core_v3.HealthStatus_TIMEOUT:
+		h.HealthFlagSet(api.FailedEDSHealth)
+	case envoy_config_core_v3.HealthStatus_DEGRADED:
+		h.HealthFlagSet(api.DegradedEDSHealth)
+	}
 	return h, nil
 }
+
+// copyHostInfo copies the endpoint info and the EDS health of src to dst
+func copyHostInfo(dst, src api.Host) {
+	dst.SetWeight(src.Weight())
+	dst.SetHostname(src.Hostname())
+	dst.SetMetadata(src.Metadata())
+	for _, flag := range []api.HealthFlag{api.FailedEDSHealth, api.DegradedEDSHealth} {
+		if src.HealthFlagGet(flag) {
+			dst.HealthFlagSet(flag)
+		} else {
+			dst.HealthFlagClear(flag)
+		}
+	}
+}
@@ -77,7 +77,10 @@ func (cm *clusterManager) Range(cb func(string, api.ObjectConfig) bool) {
 }
 
 func (cm *clusterManager) DeleteCluster(name string) error {
-	cm.clusterMap.Delete(name)
+	if c, ok := cm.clusterMap.LoadAndDelete(name); ok {
+		log.Debug("cluster %s close", name)
+		c.(api.ObjectConfig).Object().(api.Cluster).Close()
+	}
 	return nil
 }
 
@@ -89,3 +92,20 @@ func (cm *clusterManager) UpdateClusterHosts(clusterName string, hosts api.HostS
 	cluster.UpdateHosts(hosts)
 	return nil
 }
+
+// SelectHost choose a host of the cluster by load balancer, used by filters calling other services
+func SelectHost(cm api.ClusterManager, name string, ctx api.LoadBalancerContext) (api.Host, error) {
+	cluster := cm.GetCluster(name)
+	if cluster == nil {
+		return nil, fmt.Errorf("not found cluster: %s", name)
+	}
+	snapshot := cluster.Snapshot()
+	if snapshot == nil || snapshot.LoadBalancer() == nil {
+		return nil, fmt.Errorf("no healthy upstream for cluster: %s", name)
+	}
+	host := snapshot.LoadBalancer().Select(ctx)
+	if host == nil {
+		return nil, fmt.Errorf("no healthy upstream for cluster: %s", name)
+	}
+	return host, nil
+}
@@ -18,6 +18,7 @@ type strictDNSCluster struct {
 
 func (c *strictDNSCluster) Close() {
 	close(c.stopCh)
+	c.simpleCluster.Close()
 }
 
 func newStrictDNSCluster(c *envoy_config_cluster_v3.Cluster) (api.Cluster, error) {
@@ -84,7 +85,10 @@ func (c *strictDNSCluster) resolveAndUpdate(hosts api.HostSet) {
 		}
 		for _, ip := range ips {
 			tcphost := api.NewHost(&net.TCPAddr{IP: ip, Port: port})
-			tcphost.SetWeight(h.Weight())
+			copyHostInfo(tcphost, h)
+			if tcphost.Hostname() == "" {
+				tcphost.SetHostname(name)
+			}
 			destHosts = append(destHosts, tcphost)
 			// log.Trace("resolve dns:%s ip:%s", name, ip)
 		}
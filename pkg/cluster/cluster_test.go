@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"testing"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newTestLbEndpoint(ip string, hostname string, status envoy_config_core_v3.HealthStatus) *envoy_config_endpoint_v3.LbEndpoint {
+	labels, _ := structpb.NewStruct(map[string]interface{}{"app": "reviews", "version": "v1"})
+	return &envoy_config_endpoint_v3.LbEndpoint{
+		HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+			Endpoint: &envoy_config_endpoint_v3.Endpoint{
+				Address: &envoy_config_core_v3.Address{
+					Address: &envoy_config_core_v3.Address_SocketAddress{
+						SocketAddress: &envoy_config_core_v3.SocketAddress{
+							Address:       ip,
+							PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: 9080},
+						},
+					},
+				},
+				Hostname: hostname,
+			},
+		},
+		HealthStatus: status,
+		Metadata: &envoy_config_core_v3.Metadata{
+			FilterMetadata: map[string]*structpb.Struct{"istio": labels},
+		},
+	}
+}
+
+func TestEDSHealthAndMetadata(t *testing.T) {
+	c, err := NewCluster(&envoy_config_cluster_v3.Cluster{
+		Name:                 "outbound|9080||reviews.default.svc.cluster.local",
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{Type: envoy_config_cluster_v3.Cluster_EDS},
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	load := func(statuses ...envoy_config_core_v3.HealthStatus) api.HostSet {
+		la := &envoy_config_endpoint_v3.ClusterLoadAssignment{
+			Endpoints: []*envoy_config_endpoint_v3.LocalityLbEndpoints{{}},
+		}
+		ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+		names := []string{"reviews-v1-0", "reviews-v1-1", "reviews-v1-2"}
+		for i, status := range statuses {
+			la.Endpoints[0].LbEndpoints = append(la.Endpoints[0].LbEndpoints, newTestLbEndpoint(ips[i], names[i], status))
+		}
+		hosts, err := GetEndpointFromClusterLoad(envoy_config_cluster_v3.Cluster_EDS, la)
+		assert.NoError(t, err)
+		return hosts
+	}
+
+	c.UpdateHosts(load(envoy_config_core_v3.HealthStatus_HEALTHY, envoy_config_core_v3.HealthStatus_DRAINING,
+		envoy_config_core_v3.HealthStatus_DEGRADED))
+	hosts := c.Snapshot().HostSet()
+	assert.Equal(t, "reviews-v1-0", hosts[0].Hostname())
+	assert.Equal(t, "v1", hosts[0].Metadata().GetFilterMetadata()["istio"].GetFields()["version"].GetStringValue())
+	assert.Equal(t, "healthy", api.HealthFlagsString(hosts[0]))
+	assert.Equal(t, "/failed_eds_health", api.HealthFlagsString(hosts[1]))
+	assert.True(t, hosts[2].Healthy())
+	assert.Equal(t, "/degraded_eds_health", api.HealthFlagsString(hosts[2]))
+
+	// the degraded host is selected only if no other host is healthy
+	lb := c.Snapshot().LoadBalancer()
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, hosts[0], lb.Select(nil))
+	}
+	hosts[0].HealthFlagSet(api.FailedOutlierCheck)
+	assert.Equal(t, hosts[2], lb.Select(nil))
+
+	// the EDS health is updated, and the other flags are kept
+	c.UpdateHosts(load(envoy_config_core_v3.HealthStatus_HEALTHY, envoy_config_core_v3.HealthStatus_HEALTHY,
+		envoy_config_core_v3.HealthStatus_UNKNOWN))
+	updated := c.Snapshot().HostSet()
+	assert.Equal(t, hosts[1], updated[1])
+	assert.True(t, updated[1].Healthy())
+	assert.Equal(t, "healthy", api.HealthFlagsString(updated[2]))
+	assert.Equal(t, "/failed_outlier_check", api.HealthFlagsString(updated[0]))
+}
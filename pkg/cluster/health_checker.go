@@ -0,0 +1,240 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+const defaultNoTrafficInterval = 60 * time.Second
+
+// healthProbe checks the host once, it's created for each host
+type healthProbe interface {
+	// check returns nil if the host is healthy
+	check(addr string, timeout time.Duration) error
+	close()
+}
+
+// healthChecker runs the active health check for each host of cluster like envoy. The new host is unhealthy
+// until it passes the first check, then it's marked unhealthy after unhealthy_threshold consecutive failures,
+// and healthy after healthy_threshold consecutive successes.
+type healthChecker struct {
+	sync.Mutex
+	config   *envoy_config_core_v3.HealthCheck
+	newProbe func() healthProbe
+	sessions map[api.Host]*healthCheckSession
+	closed   bool
+
+	timeout            time.Duration
+	healthyThreshold   uint32
+	unhealthyThreshold uint32
+
+	traffic *stats.Counter
+	attempt *stats.Counter
+	success *stats.Counter
+	failure *stats.Counter
+}
+
+type healthCheckSession struct {
+	host      api.Host
+	probe     healthProbe
+	stopCh    chan struct{}
+	healthy   uint32
+	unhealthy uint32
+	checked   bool
+}
+
+// newHealthChecker returns nil if the health checker is not supported
+func newHealthChecker(cluster string, hc *envoy_config_core_v3.HealthCheck) *healthChecker {
+	var newProbe func() healthProbe
+	switch {
+	case hc.GetHttpHealthCheck() != nil:
+		newProbe = newHTTPProbe(cluster, hc)
+	case hc.GetTcpHealthCheck() != nil:
+		newProbe = newTCPProbe(hc.GetTcpHealthCheck())
+	case hc.GetGrpcHealthCheck() != nil:
+		newProbe = newGrpcProbe(hc.GetGrpcHealthCheck())
+	default:
+		log.Warn("not support health checker of cluster %s", cluster)
+		return nil
+	}
+
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: cluster}}
+	return &healthChecker{
+		config:             hc,
+		newProbe:           newProbe,
+		sessions:           make(map[api.Host]*healthCheckSession),
+		timeout:            durationValue(hc.GetTimeout(), time.Second),
+		healthyThreshold:   uint32Value(hc.GetHealthyThreshold(), 1),
+		unhealthyThreshold: uint32Value(hc.GetUnhealthyThreshold(), 1),
+		traffic:            stats.DefaultStore.Counter("envoy_cluster_upstream_rq_total", tags),
+		attempt:            stats.DefaultStore.Counter("envoy_cluster_health_check_attempt", tags),
+		success:            stats.DefaultStore.Counter("envoy_cluster_health_check_success", tags),
+		failure:            stats.DefaultStore.Counter("envoy_cluster_health_check_failure", tags),
+	}
+}
+
+// updateHosts starts the sessions of new hosts, and stops the sessions of removed hosts
+func (hc *healthChecker) updateHosts(hosts api.HostSet) {
+	hc.Lock()
+	defer hc.Unlock()
+	if hc.closed {
+		return
+	}
+	sessions := make(map[api.Host]*healthCheckSession, len(hosts))
+	for _, h := range hosts {
+		if s, ok := hc.sessions[h]; ok {
+			sessions[h] = s
+			delete(hc.sessions, h)
+			continue
+		}
+		h.HealthFlagSet(api.FailedActiveHealthCheck)
+		s := &healthCheckSession{host: h, probe: hc.newProbe(), stopCh: make(chan struct{})}
+		sessions[h] = s
+		go hc.run(s)
+	}
+	for _, s := range hc.sessions {
+		close(s.stopCh)
+	}
+	hc.sessions = sessions
+}
+
+func (hc *healthChecker) run(s *healthCheckSession) {
+	defer s.probe.close()
+	var delay time.Duration
+	if jitter := hc.config.GetInitialJitter().AsDuration(); jitter > 0 {
+		delay = time.Duration(rand.Int63n(int64(jitter)))
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-timer.C:
+		}
+		changed := hc.onResult(s, s.probe.check(hc.checkAddress(s.host), hc.timeout))
+		timer.Reset(hc.nextInterval(s, changed))
+	}
+}
+
+// checkAddress returns the host address, and the port is replaced by alt_port if set
+func (hc *healthChecker) checkAddress(h api.Host) string {
+	addr := h.Address().String()
+	if port := hc.config.GetAltPort(); port != nil {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return net.JoinHostPort(host, strconv.Itoa(int(port.GetValue())))
+		}
+	}
+	return addr
+}
+
+// onResult updates the host health by the result, and returns true if the health is changed
+func (hc *healthChecker) onResult(s *healthCheckSession, err error) bool {
+	hc.attempt.Inc()
+	unhealthy := s.host.HealthFlagGet(api.FailedActiveHealthCheck)
+	if err == nil {
+		hc.success.Inc()
+		s.unhealthy = 0
+		s.healthy++
+		// a single success is required for the first check
+		if unhealthy && (!s.checked || s.healthy >= hc.healthyThreshold) {
+			s.checked = true
+			s.host.HealthFlagClear(api.FailedActiveHealthCheck)
+			log.Info("health check host %s healthy", s.host.Address())
+			return true
+		}
+		s.checked = true
+		return false
+	}
+
+	hc.failure.Inc()
+	s.checked = true
+	s.healthy = 0
+	s.unhealthy++
+	if hc.config.GetAlwaysLogHealthCheckFailures() {
+		log.Warn("health check host %s failure: %s", s.host.Address(), err)
+	}
+	if !unhealthy && s.unhealthy >= hc.unhealthyThreshold {
+		s.host.HealthFlagSet(api.FailedActiveHealthCheck)
+		log.Info("health check host %s unhealthy: %s", s.host.Address(), err)
+		return true
+	}
+	return false
+}
+
+// nextInterval returns no_traffic_interval if the cluster has no traffic yet, or the edge interval if the
+// health is changed, and the jitters are added
+func (hc *healthChecker) nextInterval(s *healthCheckSession, changed bool) time.Duration {
+	c := hc.config
+	interval := durationValue(c.GetInterval(), time.Second)
+	unhealthyInterval := durationValue(c.GetUnhealthyInterval(), interval)
+	healthy := !s.host.HealthFlagGet(api.FailedActiveHealthCheck)
+	switch {
+	case hc.traffic.Value() == 0:
+		interval = durationValue(c.GetNoTrafficInterval(), defaultNoTrafficInterval)
+		if healthy {
+			interval = durationValue(c.GetNoTrafficHealthyInterval(), interval)
+		}
+	case changed && healthy:
+		interval = durationValue(c.GetHealthyEdgeInterval(), interval)
+	case changed:
+		interval = durationValue(c.GetUnhealthyEdgeInterval(), unhealthyInterval)
+	case !healthy:
+		interval = unhealthyInterval
+	}
+
+	if percent := c.GetIntervalJitterPercent(); percent > 0 {
+		if jitter := int64(interval) * int64(percent) / 100; jitter > 0 {
+			interval += time.Duration(rand.Int63n(jitter))
+		}
+	}
+	if jitter := c.GetIntervalJitter().AsDuration(); jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return interval
+}
+
+func (hc *healthChecker) close() {
+	hc.Lock()
+	defer hc.Unlock()
+	if hc.closed {
+		return
+	}
+	hc.closed = true
+	for _, s := range hc.sessions {
+		close(s.stopCh)
+	}
+	hc.sessions = nil
+}
@@ -0,0 +1,213 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/stats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func listenerPort(t *testing.T, addr string) uint32 {
+	_, port, err := net.SplitHostPort(addr)
+	assert.NoError(t, err)
+	p, _ := strconv.Atoi(port)
+	return uint32(p)
+}
+
+func newTestHealthCheck() *envoy_config_core_v3.HealthCheck {
+	return &envoy_config_core_v3.HealthCheck{
+		Timeout:            durationpb.New(time.Second),
+		Interval:           durationpb.New(10 * time.Millisecond),
+		NoTrafficInterval:  durationpb.New(10 * time.Millisecond),
+		UnhealthyThreshold: wrapperspb.UInt32(2),
+		HealthyThreshold:   wrapperspb.UInt32(2),
+	}
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	var status int32 = nethttp.StatusOK
+	var host, userAgent atomic.Value
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		host.Store(r.Host)
+		userAgent.Store(r.UserAgent())
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+
+	hc := newTestHealthCheck()
+	hc.HealthChecker = &envoy_config_core_v3.HealthCheck_HttpHealthCheck_{
+		HttpHealthCheck: &envoy_config_core_v3.HealthCheck_HttpHealthCheck{
+			Path:             "/healthz",
+			ExpectedStatuses: []*envoy_type_v3.Int64Range{{Start: 200, End: 300}},
+		},
+	}
+	name := "reviews.default.svc.cluster.local"
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name:         name,
+		HealthChecks: []*envoy_config_core_v3.HealthCheck{hc},
+	}, listenerPort(t, server.Listener.Addr().String()))
+	defer c.Close()
+
+	// the new host is unhealthy until the first check passes
+	h := c.Snapshot().HostSet()[0]
+	assert.Eventually(t, h.Healthy, time.Second, 5*time.Millisecond)
+	assert.Equal(t, name, host.Load())
+	assert.Equal(t, "Envoy/HC", userAgent.Load())
+
+	atomic.StoreInt32(&status, nethttp.StatusServiceUnavailable)
+	assert.Eventually(t, func() bool { return h.HealthFlagGet(api.FailedActiveHealthCheck) },
+		time.Second, 5*time.Millisecond)
+	atomic.StoreInt32(&status, nethttp.StatusNoContent)
+	assert.Eventually(t, h.Healthy, time.Second, 5*time.Millisecond)
+
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: name}}
+	assert.True(t, stats.DefaultStore.Counter("envoy_cluster_health_check_failure", tags).Value() >= 2)
+	assert.True(t, stats.DefaultStore.Counter("envoy_cluster_health_check_success", tags).Value() >= 3)
+}
+
+func TestTCPHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4)
+				if _, err := conn.Read(buf); err == nil && string(buf) == "PING" {
+					conn.Write([]byte("PO"))
+					conn.Write([]byte("NG"))
+				}
+			}()
+		}
+	}()
+
+	hc := newTestHealthCheck()
+	hc.HealthChecker = &envoy_config_core_v3.HealthCheck_TcpHealthCheck_{
+		TcpHealthCheck: &envoy_config_core_v3.HealthCheck_TcpHealthCheck{
+			Send: &envoy_config_core_v3.HealthCheck_Payload{
+				Payload: &envoy_config_core_v3.HealthCheck_Payload_Text{Text: "50494e47"},
+			},
+			Receive: []*envoy_config_core_v3.HealthCheck_Payload{{
+				Payload: &envoy_config_core_v3.HealthCheck_Payload_Binary{Binary: []byte("PONG")},
+			}},
+		},
+	}
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name:         "outbound|9080||details",
+		HealthChecks: []*envoy_config_core_v3.HealthCheck{hc},
+	}, listenerPort(t, ln.Addr().String()))
+	defer c.Close()
+	h := c.Snapshot().HostSet()[0]
+	assert.Eventually(t, h.Healthy, time.Second, 5*time.Millisecond)
+
+	ln.Close()
+	assert.Eventually(t, func() bool { return !h.Healthy() }, time.Second, 5*time.Millisecond)
+}
+
+func TestGrpcHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("ratings", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	hc := newTestHealthCheck()
+	hc.HealthChecker = &envoy_config_core_v3.HealthCheck_GrpcHealthCheck_{
+		GrpcHealthCheck: &envoy_config_core_v3.HealthCheck_GrpcHealthCheck{ServiceName: "ratings"},
+	}
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name:         "outbound|9080||ratings-grpc",
+		HealthChecks: []*envoy_config_core_v3.HealthCheck{hc},
+	}, listenerPort(t, ln.Addr().String()))
+	defer c.Close()
+	h := c.Snapshot().HostSet()[0]
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, h.Healthy())
+
+	healthServer.SetServingStatus("ratings", grpc_health_v1.HealthCheckResponse_SERVING)
+	assert.Eventually(t, h.Healthy, time.Second, 5*time.Millisecond)
+}
+
+func TestHealthCheckInterval(t *testing.T) {
+	hc := newTestHealthCheck()
+	hc.HealthChecker = &envoy_config_core_v3.HealthCheck_TcpHealthCheck_{
+		TcpHealthCheck: &envoy_config_core_v3.HealthCheck_TcpHealthCheck{},
+	}
+	hc.Interval = durationpb.New(time.Second)
+	hc.NoTrafficInterval = durationpb.New(time.Minute)
+	hc.UnhealthyInterval = durationpb.New(2 * time.Second)
+	hc.UnhealthyEdgeInterval = durationpb.New(3 * time.Second)
+	hc.IntervalJitterPercent = 10
+	checker := newHealthChecker("outbound|9080||interval", hc)
+	checker.traffic = stats.NewStore().Counter("envoy_cluster_upstream_rq_total", nil)
+
+	h := api.NewHost(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9080})
+	s := &healthCheckSession{host: h}
+	interval := checker.nextInterval(s, false)
+	assert.True(t, interval >= time.Minute && interval < 66*time.Second)
+
+	checker.traffic.Inc()
+	interval = checker.nextInterval(s, false)
+	assert.True(t, interval >= time.Second && interval < 1100*time.Millisecond)
+	h.HealthFlagSet(api.FailedActiveHealthCheck)
+	assert.True(t, checker.nextInterval(s, false) >= 2*time.Second)
+	assert.True(t, checker.nextInterval(s, true) >= 3*time.Second)
+}
+
+func TestHealthCheckNoTrafficInterval(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer server.Close()
+
+	hc := newTestHealthCheck()
+	hc.HealthChecker = &envoy_config_core_v3.HealthCheck_HttpHealthCheck_{
+		HttpHealthCheck: &envoy_config_core_v3.HealthCheck_HttpHealthCheck{Path: "/healthz"},
+	}
+	hc.Interval = durationpb.New(time.Second)
+	hc.NoTrafficInterval = durationpb.New(time.Minute)
+	// the traffic counter is shared by cluster name, so the name is unique for -count
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name:         fmt.Sprintf("outbound|9080||traffic-%d", time.Now().UnixNano()),
+		HealthChecks: []*envoy_config_core_v3.HealthCheck{hc},
+	}, listenerPort(t, server.Listener.Addr().String()))
+	defer c.Close()
+	s := &healthCheckSession{host: c.Snapshot().HostSet()[0]}
+	assert.Equal(t, time.Minute, c.healthChecker.nextInterval(s, false))
+
+	// the interval is switched once the cluster has traffic
+	req := &fasthttp.Request{}
+	req.SetRequestURI("http://" + server.Listener.Addr().String() + "/productpage")
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, &fasthttp.Response{})
+	assert.NoError(t, c.ConnPool(api.PriorityDefault).Call(ctx))
+	assert.Equal(t, time.Second, c.healthChecker.nextInterval(s, false))
+}
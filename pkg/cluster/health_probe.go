@@ -0,0 +1,244 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const healthCheckUserAgent = "Envoy/HC"
+
+func payloadBytes(p *envoy_config_core_v3.HealthCheck_Payload) []byte {
+	if text := p.GetText(); text != "" {
+		b, err := hex.DecodeString(text)
+		if err != nil {
+			log.Error("invalid health check payload %s: %s", text, err)
+		}
+		return b
+	}
+	return p.GetBinary()
+}
+
+// containsPayloads returns true if the payloads are found in order, like envoy fuzzy matching
+func containsPayloads(data []byte, payloads [][]byte) bool {
+	for _, p := range payloads {
+		i := bytes.Index(data, p)
+		if i < 0 {
+			return false
+		}
+		data = data[i+len(p):]
+	}
+	return true
+}
+
+// httpProbe sends the request of path, the host header is the cluster name by default
+type httpProbe struct {
+	client   *fasthttp.Client
+	config   *envoy_config_core_v3.HealthCheck_HttpHealthCheck
+	host     string
+	expected []*envoy_type_v3.Int64Range
+	receive  []byte
+	reuse    bool
+}
+
+func newHTTPProbe(cluster string, hc *envoy_config_core_v3.HealthCheck) func() healthProbe {
+	config := hc.GetHttpHealthCheck()
+	if config.GetCodecClientType() != envoy_type_v3.CodecClientType_HTTP1 {
+		log.Warn("health check just support http1 for cluster %s", cluster)
+	}
+	host := config.GetHost()
+	if host == "" {
+		host = cluster
+	}
+	expected := config.GetExpectedStatuses()
+	if len(expected) == 0 {
+		expected = []*envoy_type_v3.Int64Range{{Start: 200, End: 201}}
+	}
+	reuse := true
+	if v := hc.GetReuseConnection(); v != nil {
+		reuse = v.GetValue()
+	}
+	return func() healthProbe {
+		return &httpProbe{
+			client:   &fasthttp.Client{DisableHeaderNamesNormalizing: true},
+			config:   config,
+			host:     host,
+			expected: expected,
+			receive:  payloadBytes(config.GetReceive()),
+			reuse:    reuse,
+		}
+	}
+}
+
+func (p *httpProbe) check(addr string, timeout time.Duration) error {
+	req, rsp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(rsp)
+	}()
+	path := p.config.GetPath()
+	if path == "" {
+		path = "/"
+	}
+	req.SetRequestURI("http://" + addr + path)
+	req.UseHostHeader = true
+	req.Header.SetHost(p.host)
+	req.Header.SetUserAgent(healthCheckUserAgent)
+	for _, h := range p.config.GetRequestHeadersToAdd() {
+		if h.GetAppend().GetValue() {
+			req.Header.Add(h.GetHeader().GetKey(), h.GetHeader().GetValue())
+		} else {
+			req.Header.Set(h.GetHeader().GetKey(), h.GetHeader().GetValue())
+		}
+	}
+	for _, h := range p.config.GetRequestHeadersToRemove() {
+		req.Header.Del(h)
+	}
+	if !p.reuse {
+		req.SetConnectionClose()
+	}
+
+	if err := p.client.DoTimeout(req, rsp, timeout); err != nil {
+		return err
+	}
+	code := int64(rsp.StatusCode())
+	matched := false
+	for _, r := range p.expected {
+		if code >= r.GetStart() && code < r.GetEnd() {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("unexpected status %d", code)
+	}
+	if len(p.receive) > 0 && !bytes.Contains(rsp.Body(), p.receive) {
+		return fmt.Errorf("response body not matched")
+	}
+	return nil
+}
+
+func (p *httpProbe) close() {
+	p.client.CloseIdleConnections()
+}
+
+// tcpProbe connects the host and sends the payload, then expects the received payloads.
+// The host is healthy if connected when no payload is configured.
+type tcpProbe struct {
+	send    []byte
+	receive [][]byte
+}
+
+func newTCPProbe(config *envoy_config_core_v3.HealthCheck_TcpHealthCheck) func() healthProbe {
+	p := &tcpProbe{send: payloadBytes(config.GetSend())}
+	for _, r := range config.GetReceive() {
+		p.receive = append(p.receive, payloadBytes(r))
+	}
+	return func() healthProbe { return p }
+}
+
+func (p *tcpProbe) check(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(p.send) == 0 {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err = conn.Write(p.send); err != nil {
+		return err
+	}
+
+	var data []byte
+	buf := make([]byte, 1024)
+	for !containsPayloads(data, p.receive) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		data = append(data, buf[:n]...)
+	}
+	return nil
+}
+
+func (p *tcpProbe) close() {
+}
+
+// grpcProbe calls grpc.health.v1.Health/Check, the host is healthy if serving
+type grpcProbe struct {
+	config *envoy_config_core_v3.HealthCheck_GrpcHealthCheck
+	conn   *grpc.ClientConn
+}
+
+func newGrpcProbe(config *envoy_config_core_v3.HealthCheck_GrpcHealthCheck) func() healthProbe {
+	return func() healthProbe { return &grpcProbe{config: config} }
+}
+
+func (p *grpcProbe) check(addr string, timeout time.Duration) error {
+	if p.conn == nil {
+		options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		if authority := p.config.GetAuthority(); authority != "" {
+			options = append(options, grpc.WithAuthority(authority))
+		}
+		conn, err := grpc.Dial(addr, options...)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rsp, err := grpc_health_v1.NewHealthClient(p.conn).Check(ctx,
+		&grpc_health_v1.HealthCheckRequest{Service: p.config.GetServiceName()})
+	if err != nil {
+		return err
+	}
+	if rsp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc health status %s", rsp.GetStatus())
+	}
+	return nil
+}
+
+func (p *grpcProbe) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
@@ -0,0 +1,429 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+type ejectionType int
+
+const (
+	ejectConsecutive5xx ejectionType = iota
+	ejectConsecutiveGatewayFailure
+	ejectConsecutiveLocalOriginFailure
+	ejectSuccessRate
+	ejectSuccessRateLocalOrigin
+	ejectFailurePercentage
+	ejectFailurePercentageLocalOrigin
+)
+
+var ejectionTypeNames = []string{
+	"consecutive_5xx",
+	"consecutive_gateway_failure",
+	"consecutive_local_origin_failure",
+	"success_rate",
+	"local_origin_success_rate",
+	"failure_percentage",
+	"local_origin_failure_percentage",
+}
+
+// outlierConfig is the outlier detection config with envoy defaults
+type outlierConfig struct {
+	interval           time.Duration
+	baseEjectionTime   time.Duration
+	maxEjectionTime    time.Duration
+	maxEjectionPercent uint32
+	split              bool
+	consecutive        [3]uint32 // threshold of consecutive 5xx, gateway failure and local origin failure
+	enforcing          [7]uint32 // enforcing percent indexed by ejectionType
+
+	successRateMinimumHosts        uint32
+	successRateRequestVolume       uint32
+	successRateStdevFactor         uint32
+	failurePercentageThreshold     uint32
+	failurePercentageMinimumHosts  uint32
+	failurePercentageRequestVolume uint32
+}
+
+func uint32Value(v *wrappers.UInt32Value, def uint32) uint32 {
+	if v != nil {
+		return v.GetValue()
+	}
+	return def
+}
+
+func durationValue(d *duration.Duration, def time.Duration) time.Duration {
+	if d != nil {
+		return d.AsDuration()
+	}
+	return def
+}
+
+func newOutlierConfig(od *envoy_config_cluster_v3.OutlierDetection) *outlierConfig {
+	c := &outlierConfig{
+		interval:           durationValue(od.GetInterval(), 10*time.Second),
+		baseEjectionTime:   durationValue(od.GetBaseEjectionTime(), 30*time.Second),
+		maxEjectionTime:    durationValue(od.GetMaxEjectionTime(), 300*time.Second),
+		maxEjectionPercent: uint32Value(od.GetMaxEjectionPercent(), 10),
+		split:              od.GetSplitExternalLocalOriginErrors(),
+		consecutive: [3]uint32{
+			uint32Value(od.GetConsecutive_5Xx(), 5),
+			uint32Value(od.GetConsecutiveGatewayFailure(), 5),
+			uint32Value(od.GetConsecutiveLocalOriginFailure(), 5),
+		},
+		enforcing: [7]uint32{
+			uint32Value(od.GetEnforcingConsecutive_5Xx(), 100),
+			uint32Value(od.GetEnforcingConsecutiveGatewayFailure(), 0),
+			uint32Value(od.GetEnforcingConsecutiveLocalOriginFailure(), 100),
+			uint32Value(od.GetEnforcingSuccessRate(), 100),
+			uint32Value(od.GetEnforcingLocalOriginSuccessRate(), 100),
+			uint32Value(od.GetEnforcingFailurePercentage(), 0),
+			uint32Value(od.GetEnforcingFailurePercentageLocalOrigin(), 0),
+		},
+		successRateMinimumHosts:        uint32Value(od.GetSuccessRateMinimumHosts(), 5),
+		successRateRequestVolume:       uint32Value(od.GetSuccessRateRequestVolume(), 100),
+		successRateStdevFactor:         uint32Value(od.GetSuccessRateStdevFactor(), 1900),
+		failurePercentageThreshold:     uint32Value(od.GetFailurePercentageThreshold(), 85),
+		failurePercentageMinimumHosts:  uint32Value(od.GetFailurePercentageMinimumHosts(), 5),
+		failurePercentageRequestVolume: uint32Value(od.GetFailurePercentageRequestVolume(), 50),
+	}
+	if c.maxEjectionTime < c.baseEjectionTime {
+		c.maxEjectionTime = c.baseEjectionTime
+	}
+	return c
+}
+
+// bucket counts the requests of host in an interval
+type bucket struct {
+	success uint64
+	total   uint64
+}
+
+func (b *bucket) put(success bool) {
+	b.total++
+	if success {
+		b.success++
+	}
+}
+
+type hostMonitor struct {
+	host api.Host
+	// consecutive failures indexed by ejectionType
+	consecutive  [3]uint32
+	external     bucket
+	local        bucket
+	ejectedAt    time.Time
+	numEjections uint32
+}
+
+func (m *hostMonitor) ejected() bool {
+	return m.host.HealthFlagGet(api.FailedOutlierCheck)
+}
+
+// outlierDetector ejects the hosts like envoy outlier detection. The consecutive failures eject the host
+// immediately, and the success rate and failure percentage are evaluated every interval. The ejected host
+// is returned after base_ejection_time multiplied by the number of ejections, which is decreased by one
+// every interval the host stays healthy.
+type outlierDetector struct {
+	sync.Mutex
+	config   *outlierConfig
+	monitors map[api.Host]*hostMonitor
+	now      func() time.Time
+	stopCh   chan struct{}
+	closed   bool
+
+	ejectionsActive *stats.Gauge
+	ejectionsTotal  *stats.Counter
+	ejections       []*stats.Counter
+	overflow        *stats.Counter
+}
+
+func newOutlierDetector(cluster string, od *envoy_config_cluster_v3.OutlierDetection) *outlierDetector {
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: cluster}}
+	prefix := "envoy_cluster_outlier_detection_"
+	d := &outlierDetector{
+		config:          newOutlierConfig(od),
+		monitors:        make(map[api.Host]*hostMonitor),
+		now:             time.Now,
+		stopCh:          make(chan struct{}),
+		ejectionsActive: stats.DefaultStore.Gauge(prefix+"ejections_active", tags),
+		ejectionsTotal:  stats.DefaultStore.Counter(prefix+"ejections_enforced_total", tags),
+		overflow:        stats.DefaultStore.Counter(prefix+"ejections_overflow", tags),
+	}
+	for _, name := range ejectionTypeNames {
+		d.ejections = append(d.ejections, stats.DefaultStore.Counter(prefix+"ejections_enforced_"+name, tags))
+	}
+
+	go func() {
+		t := time.NewTicker(d.config.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-d.stopCh:
+				return
+			case <-t.C:
+				d.onInterval()
+			}
+		}
+	}()
+	return d
+}
+
+// updateHosts keeps the monitors of existing hosts and creates monitors for new hosts
+func (d *outlierDetector) updateHosts(hosts api.HostSet) {
+	d.Lock()
+	defer d.Unlock()
+	monitors := make(map[api.Host]*hostMonitor, len(hosts))
+	for _, h := range hosts {
+		if m, ok := d.monitors[h]; ok {
+			monitors[h] = m
+		} else {
+			monitors[h] = &hostMonitor{host: h}
+		}
+	}
+	for h, m := range d.monitors {
+		if _, ok := monitors[h]; !ok && m.ejected() && !d.closed {
+			d.ejectionsActive.Add(-1)
+		}
+	}
+	d.monitors = monitors
+}
+
+func (d *outlierDetector) PutHTTPResponseCode(host api.Host, code int) {
+	d.Lock()
+	defer d.Unlock()
+	m := d.monitors[host]
+	if m == nil {
+		return
+	}
+	if d.config.split {
+		// the response means the connection is successful
+		m.local.put(true)
+		m.consecutive[ejectConsecutiveLocalOriginFailure] = 0
+	}
+	d.putCode(m, code)
+}
+
+func (d *outlierDetector) PutResult(host api.Host, result api.OutlierResult) {
+	d.Lock()
+	defer d.Unlock()
+	m := d.monitors[host]
+	if m == nil {
+		return
+	}
+	switch {
+	case result == api.ExtOriginRequestFailed:
+		d.putCode(m, 502)
+	case d.config.split:
+		m.local.put(false)
+		d.putConsecutive(m, ejectConsecutiveLocalOriginFailure)
+	case result == api.LocalOriginTimeout:
+		d.putCode(m, 504)
+	default:
+		d.putCode(m, 503)
+	}
+}
+
+func (d *outlierDetector) putCode(m *hostMonitor, code int) {
+	if code < 500 {
+		m.external.put(true)
+		m.consecutive[ejectConsecutive5xx] = 0
+		m.consecutive[ejectConsecutiveGatewayFailure] = 0
+		return
+	}
+	m.external.put(false)
+	if code == 502 || code == 503 || code == 504 {
+		d.putConsecutive(m, ejectConsecutiveGatewayFailure)
+	} else {
+		m.consecutive[ejectConsecutiveGatewayFailure] = 0
+	}
+	d.putConsecutive(m, ejectConsecutive5xx)
+}
+
+func (d *outlierDetector) putConsecutive(m *hostMonitor, t ejectionType) {
+	m.consecutive[t]++
+	if m.consecutive[t] >= d.config.consecutive[t] {
+		m.consecutive[t] = 0
+		d.eject(m, t)
+	}
+}
+
+// ejectionAllowed returns false if max_ejection_percent would be exceeded by the ejection, the same as
+// envoy, one host can be ejected at least. No host is ejected if max_ejection_percent is 0.
+func (d *outlierDetector) ejectionAllowed() bool {
+	if d.config.maxEjectionPercent == 0 {
+		return false
+	}
+	ejected := 0
+	for _, m := range d.monitors {
+		if m.ejected() {
+			ejected++
+		}
+	}
+	percent := float64(ejected+1) * 100 / float64(len(d.monitors))
+	return ejected == 0 || percent <= float64(d.config.maxEjectionPercent)
+}
+
+func (d *outlierDetector) eject(m *hostMonitor, t ejectionType) {
+	if d.closed || m.ejected() {
+		return
+	}
+	if !d.ejectionAllowed() {
+		d.overflow.Inc()
+		return
+	}
+	if uint32(rand.Intn(100)) >= d.config.enforcing[t] {
+		return
+	}
+	m.host.HealthFlagSet(api.FailedOutlierCheck)
+	m.numEjections++
+	m.ejectedAt = d.now()
+	d.ejectionsActive.Add(1)
+	d.ejectionsTotal.Inc()
+	d.ejections[t].Inc()
+	log.Info("outlier detection eject host %s by %s, ejection time %s",
+		m.host.Address(), ejectionTypeNames[t], d.ejectionTime(m))
+}
+
+func (d *outlierDetector) ejectionTime(m *hostMonitor) time.Duration {
+	t := d.config.baseEjectionTime * time.Duration(m.numEjections)
+	if t > d.config.maxEjectionTime {
+		t = d.config.maxEjectionTime
+	}
+	return t
+}
+
+func (d *outlierDetector) onInterval() {
+	d.Lock()
+	defer d.Unlock()
+	if d.closed {
+		return
+	}
+	now := d.now()
+	for _, m := range d.monitors {
+		if m.ejected() {
+			if now.Sub(m.ejectedAt) >= d.ejectionTime(m) {
+				m.host.HealthFlagClear(api.FailedOutlierCheck)
+				d.ejectionsActive.Add(-1)
+				log.Info("outlier detection uneject host %s", m.host.Address())
+			}
+		} else if m.numEjections > 0 {
+			m.numEjections--
+		}
+	}
+
+	external := func(m *hostMonitor) bucket { return m.external }
+	d.checkSuccessRate(ejectSuccessRate, external)
+	d.checkFailurePercentage(ejectFailurePercentage, external)
+	if d.config.split {
+		local := func(m *hostMonitor) bucket { return m.local }
+		d.checkSuccessRate(ejectSuccessRateLocalOrigin, local)
+		d.checkFailurePercentage(ejectFailurePercentageLocalOrigin, local)
+	}
+	for _, m := range d.monitors {
+		m.external, m.local = bucket{}, bucket{}
+	}
+}
+
+// checkSuccessRate ejects the hosts whose success rate is less than mean - stdev * success_rate_stdev_factor / 1000
+func (d *outlierDetector) checkSuccessRate(t ejectionType, get func(*hostMonitor) bucket) {
+	var (
+		hosts []*hostMonitor
+		rates []float64
+		sum   float64
+	)
+	for _, m := range d.monitors {
+		b := get(m)
+		if b.total == 0 || b.total < uint64(d.config.successRateRequestVolume) {
+			continue
+		}
+		rate := float64(b.success) * 100 / float64(b.total)
+		hosts = append(hosts, m)
+		rates = append(rates, rate)
+		sum += rate
+	}
+	if len(hosts) == 0 || len(hosts) < int(d.config.successRateMinimumHosts) {
+		return
+	}
+	mean := sum / float64(len(rates))
+	var variance float64
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	stdev := math.Sqrt(variance / float64(len(rates)))
+	threshold := mean - stdev*float64(d.config.successRateStdevFactor)/1000
+	for i, m := range hosts {
+		if rates[i] < threshold {
+			d.eject(m, t)
+		}
+	}
+}
+
+// checkFailurePercentage ejects the hosts whose failure percentage is not less than failure_percentage_threshold
+func (d *outlierDetector) checkFailurePercentage(t ejectionType, get func(*hostMonitor) bucket) {
+	var hosts []*hostMonitor
+	for _, m := range d.monitors {
+		b := get(m)
+		if b.total > 0 && b.total >= uint64(d.config.failurePercentageRequestVolume) {
+			hosts = append(hosts, m)
+		}
+	}
+	if len(hosts) < int(d.config.failurePercentageMinimumHosts) {
+		return
+	}
+	for _, m := range hosts {
+		b := get(m)
+		if (b.total-b.success)*100/b.total >= uint64(d.config.failurePercentageThreshold) {
+			d.eject(m, t)
+		}
+	}
+}
+
+// close stops the interval timer, the active ejections are removed from the gauge shared with the new cluster
+func (d *outlierDetector) close() {
+	d.Lock()
+	defer d.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	close(d.stopCh)
+	for _, m := range d.monitors {
+		if m.ejected() {
+			d.ejectionsActive.Add(-1)
+		}
+	}
+}
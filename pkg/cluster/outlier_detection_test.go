@@ -0,0 +1,245 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/stats"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newStaticTestCluster fills the static type and the endpoints of 127.0.0.1 to the cluster config
+func newStaticTestCluster(t *testing.T, c *envoy_config_cluster_v3.Cluster, ports ...uint32) *simpleCluster {
+	var endpoints []*envoy_config_endpoint_v3.LbEndpoint
+	for _, port := range ports {
+		endpoints = append(endpoints, &envoy_config_endpoint_v3.LbEndpoint{
+			HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+				Endpoint: &envoy_config_endpoint_v3.Endpoint{
+					Address: &envoy_config_core_v3.Address{
+						Address: &envoy_config_core_v3.Address_SocketAddress{
+							SocketAddress: &envoy_config_core_v3.SocketAddress{
+								Address:       "127.0.0.1",
+								PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: port},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	c.ClusterDiscoveryType = &envoy_config_cluster_v3.Cluster_Type{Type: envoy_config_cluster_v3.Cluster_STATIC}
+	c.LoadAssignment = &envoy_config_endpoint_v3.ClusterLoadAssignment{
+		ClusterName: c.Name,
+		Endpoints:   []*envoy_config_endpoint_v3.LocalityLbEndpoints{{LbEndpoints: endpoints}},
+	}
+	cluster, err := NewCluster(c)
+	assert.NoError(t, err)
+	return cluster.(*staticCluster).simpleCluster
+}
+
+func outlierCounter(cluster, name string) *stats.Counter {
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: cluster}}
+	return stats.DefaultStore.Counter("envoy_cluster_outlier_detection_"+name, tags)
+}
+
+func TestOutlierDetectionConsecutive(t *testing.T) {
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name: "outbound|9080||ratings",
+		OutlierDetection: &envoy_config_cluster_v3.OutlierDetection{
+			Consecutive_5Xx:  wrapperspb.UInt32(3),
+			BaseEjectionTime: durationpb.New(10 * time.Second),
+			MaxEjectionTime:  durationpb.New(15 * time.Second),
+		},
+	}, 8001, 8002, 8003)
+	defer c.Close()
+	now := time.Now()
+	c.outlier.now = func() time.Time { return now }
+
+	hosts := c.Snapshot().HostSet()
+	bad := hosts[0]
+	detector := c.OutlierDetector()
+	consecutive5xx := outlierCounter(c.info.Name(), "ejections_enforced_consecutive_5xx")
+	overflow := outlierCounter(c.info.Name(), "ejections_overflow")
+	consecutive5xxBefore, overflowBefore := consecutive5xx.Value(), overflow.Value()
+	detector.PutHTTPResponseCode(bad, 500)
+	detector.PutHTTPResponseCode(bad, 500)
+	// success resets the consecutive failures
+	detector.PutHTTPResponseCode(bad, 200)
+	detector.PutHTTPResponseCode(bad, 500)
+	detector.PutResult(bad, api.LocalOriginTimeout)
+	assert.True(t, bad.Healthy())
+	detector.PutResult(bad, api.LocalOriginConnectFailed)
+	assert.False(t, bad.Healthy())
+	assert.True(t, bad.HealthFlagGet(api.FailedOutlierCheck))
+
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: "outbound|9080||ratings"}}
+	assert.Equal(t, int64(1), stats.DefaultStore.Gauge("envoy_cluster_outlier_detection_ejections_active", tags).Value())
+	assert.Equal(t, consecutive5xxBefore+1, consecutive5xx.Value())
+
+	// the ejected host is skipped by load balancer
+	for i := 0; i < 10; i++ {
+		assert.NotEqual(t, bad, c.Snapshot().LoadBalancer().Select(nil))
+	}
+
+	// max_ejection_percent 10% allows only one ejected host
+	for i := 0; i < 3; i++ {
+		detector.PutHTTPResponseCode(hosts[1], 503)
+	}
+	assert.True(t, hosts[1].Healthy())
+	assert.Equal(t, overflowBefore+1, overflow.Value())
+
+	// the ejection state is kept when the hosts are updated
+	newHosts, err := c.getConfigHosts()
+	assert.NoError(t, err)
+	c.UpdateHosts(newHosts)
+	assert.Equal(t, bad, c.Snapshot().HostSet()[0])
+	assert.False(t, c.Snapshot().HostSet()[0].Healthy())
+
+	// uneject after base_ejection_time
+	now = now.Add(9 * time.Second)
+	c.outlier.onInterval()
+	assert.False(t, bad.Healthy())
+	now = now.Add(time.Second)
+	c.outlier.onInterval()
+	assert.True(t, bad.Healthy())
+	assert.Equal(t, int64(0), stats.DefaultStore.Gauge("envoy_cluster_outlier_detection_ejections_active", tags).Value())
+
+	// the second ejection time is doubled but limited by max_ejection_time
+	for i := 0; i < 3; i++ {
+		detector.PutHTTPResponseCode(bad, 500)
+	}
+	assert.False(t, bad.Healthy())
+	assert.Equal(t, 15*time.Second, c.outlier.ejectionTime(c.outlier.monitors[bad]))
+	now = now.Add(15 * time.Second)
+	c.outlier.onInterval()
+	assert.True(t, bad.Healthy())
+
+	// the number of ejections decreases every interval the host is healthy
+	c.outlier.onInterval()
+	c.outlier.onInterval()
+	assert.Equal(t, uint32(0), c.outlier.monitors[bad].numEjections)
+}
+
+func TestOutlierDetectionMaxEjectionPercent(t *testing.T) {
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name: "outbound|9080||details",
+		OutlierDetection: &envoy_config_cluster_v3.OutlierDetection{
+			Consecutive_5Xx:    wrapperspb.UInt32(1),
+			MaxEjectionPercent: wrapperspb.UInt32(0),
+		},
+	}, 8001, 8002)
+	defer c.Close()
+
+	// no host is ejected if max_ejection_percent is 0
+	overflow := outlierCounter(c.info.Name(), "ejections_overflow")
+	overflowBefore := overflow.Value()
+	host := c.Snapshot().HostSet()[0]
+	c.OutlierDetector().PutHTTPResponseCode(host, 500)
+	assert.True(t, host.Healthy())
+	assert.Equal(t, overflowBefore+1, overflow.Value())
+
+	// one host can be ejected at least, the second is over 50%
+	c.outlier.config.maxEjectionPercent = 50
+	hosts := c.Snapshot().HostSet()
+	c.OutlierDetector().PutHTTPResponseCode(hosts[0], 500)
+	c.OutlierDetector().PutHTTPResponseCode(hosts[1], 500)
+	assert.False(t, hosts[0].Healthy())
+	assert.True(t, hosts[1].Healthy())
+}
+
+func TestOutlierDetectionGatewayAndLocalOrigin(t *testing.T) {
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name: "outbound|9080||details",
+		OutlierDetection: &envoy_config_cluster_v3.OutlierDetection{
+			Consecutive_5Xx:                    wrapperspb.UInt32(100),
+			ConsecutiveGatewayFailure:          wrapperspb.UInt32(2),
+			EnforcingConsecutiveGatewayFailure: wrapperspb.UInt32(100),
+			MaxEjectionPercent:                 wrapperspb.UInt32(100),
+			SplitExternalLocalOriginErrors:     true,
+			ConsecutiveLocalOriginFailure:      wrapperspb.UInt32(2),
+		},
+	}, 8001, 8002)
+	defer c.Close()
+	hosts := c.Snapshot().HostSet()
+	detector := c.OutlierDetector()
+
+	// 500 is not a gateway failure
+	detector.PutHTTPResponseCode(hosts[0], 502)
+	detector.PutHTTPResponseCode(hosts[0], 500)
+	detector.PutHTTPResponseCode(hosts[0], 502)
+	assert.True(t, hosts[0].Healthy())
+	detector.PutResult(hosts[0], api.ExtOriginRequestFailed)
+	assert.False(t, hosts[0].Healthy())
+
+	// local origin failures are counted separately
+	detector.PutResult(hosts[1], api.LocalOriginConnectFailed)
+	detector.PutHTTPResponseCode(hosts[1], 200)
+	detector.PutResult(hosts[1], api.LocalOriginTimeout)
+	assert.True(t, hosts[1].Healthy())
+	detector.PutResult(hosts[1], api.LocalOriginConnectFailed)
+	assert.False(t, hosts[1].Healthy())
+
+	// all hosts are selected if no host is healthy
+	assert.NotNil(t, c.Snapshot().LoadBalancer().Select(nil))
+}
+
+func TestOutlierDetectionSuccessRate(t *testing.T) {
+	c := newStaticTestCluster(t, &envoy_config_cluster_v3.Cluster{
+		Name: "outbound|9080||productpage",
+		OutlierDetection: &envoy_config_cluster_v3.OutlierDetection{
+			Consecutive_5Xx:                wrapperspb.UInt32(1000),
+			SuccessRateRequestVolume:       wrapperspb.UInt32(10),
+			FailurePercentageRequestVolume: wrapperspb.UInt32(10),
+			FailurePercentageThreshold:     wrapperspb.UInt32(50),
+			EnforcingFailurePercentage:     wrapperspb.UInt32(100),
+			MaxEjectionPercent:             wrapperspb.UInt32(50),
+		},
+	}, 8001, 8002, 8003, 8004, 8005)
+	defer c.Close()
+	hosts := c.Snapshot().HostSet()
+	detector := c.OutlierDetector()
+	successRate := outlierCounter(c.info.Name(), "ejections_enforced_success_rate")
+	failurePercentage := outlierCounter(c.info.Name(), "ejections_enforced_failure_percentage")
+	successRateBefore, failurePercentageBefore := successRate.Value(), failurePercentage.Value()
+
+	put := func(h api.Host, success, failure int) {
+		for i := 0; i < success; i++ {
+			detector.PutHTTPResponseCode(h, 200)
+		}
+		for i := 0; i < failure; i++ {
+			detector.PutHTTPResponseCode(h, 500)
+		}
+	}
+	for _, h := range hosts[:4] {
+		put(h, 20, 0)
+	}
+	// not enough request volume
+	put(hosts[4], 5, 4)
+	c.outlier.onInterval()
+	assert.True(t, hosts[4].Healthy())
+
+	// success rate 70% is less than mean 94% - 1.9 * stdev 12%
+	put(hosts[4], 14, 6)
+	for _, h := range hosts[:4] {
+		put(h, 20, 0)
+	}
+	c.outlier.onInterval()
+	assert.False(t, hosts[4].Healthy())
+
+	// failure percentage 60% reaches the threshold 50%, but success rate 40% is within mean 64% - 1.9 * stdev 19.6%
+	for _, h := range hosts[:3] {
+		put(h, 12, 8)
+	}
+	put(hosts[3], 8, 12)
+	put(hosts[4], 20, 0)
+	c.outlier.onInterval()
+	assert.False(t, hosts[3].Healthy())
+	assert.Equal(t, successRateBefore+1, successRate.Value())
+	assert.Equal(t, failurePercentageBefore+1, failurePercentage.Value())
+}
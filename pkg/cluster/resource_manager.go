@@ -0,0 +1,225 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cluster
+
+import (
+	"sync/atomic"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+const (
+	defaultMaxConnections      = 1024
+	defaultMaxPendingRequests  = 1024
+	defaultMaxRequests         = 1024
+	defaultMaxRetries          = 3
+	defaultRetryBudgetPercent  = 20.0
+	defaultMinRetryConcurrency = 3
+)
+
+// resource is a threshold of circuit breakers, the gauges of open and remaining are updated with the count
+type resource struct {
+	manager *resourceManager
+	max     uint64
+	// budget overrides max of the retries if retry_budget is set
+	budget    *retryBudget
+	count     int64
+	open      *stats.Gauge
+	remaining *stats.Gauge
+}
+
+func (r *resource) CanCreate() bool {
+	return r.Count() < r.Max()
+}
+
+func (r *resource) TryInc() bool {
+	for {
+		count := atomic.LoadInt64(&r.count)
+		if uint64(count) >= r.Max() {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&r.count, count, count+1) {
+			r.updateGauges()
+			return true
+		}
+	}
+}
+
+func (r *resource) Inc() {
+	atomic.AddInt64(&r.count, 1)
+	r.updateGauges()
+}
+
+func (r *resource) Dec() {
+	atomic.AddInt64(&r.count, -1)
+	r.updateGauges()
+}
+
+func (r *resource) Count() uint64 {
+	return uint64(atomic.LoadInt64(&r.count))
+}
+
+func (r *resource) Max() uint64 {
+	if r.budget != nil {
+		return r.budget.max(r.manager)
+	}
+	return r.max
+}
+
+// updateGauges is skipped after the cluster is closed, the gauges are shared with the new cluster
+func (r *resource) updateGauges() {
+	if atomic.LoadInt32(&r.manager.closed) == 1 {
+		return
+	}
+	count, max := r.Count(), r.Max()
+	if count >= max {
+		r.open.Set(1)
+	} else {
+		r.open.Set(0)
+	}
+	if r.remaining != nil {
+		if count >= max {
+			r.remaining.Set(0)
+		} else {
+			r.remaining.Set(int64(max - count))
+		}
+	}
+	// the retry budget changes with the active requests
+	if rm := r.manager; rm.retries.budget != nil && (r == rm.requests || r == rm.pending) {
+		rm.retries.updateGauges()
+	}
+}
+
+// retryBudget limits the retries by the percent of active requests, which are the requests and
+// pending requests, the min concurrency is allowed even if there are few active requests
+type retryBudget struct {
+	percent        float64
+	minConcurrency uint64
+}
+
+func newRetryBudget(c *envoy_config_cluster_v3.CircuitBreakers_Thresholds_RetryBudget) *retryBudget {
+	b := &retryBudget{percent: defaultRetryBudgetPercent, minConcurrency: defaultMinRetryConcurrency}
+	if p := c.GetBudgetPercent(); p != nil {
+		b.percent = p.GetValue()
+	}
+	if v := c.GetMinRetryConcurrency(); v != nil {
+		b.minConcurrency = uint64(v.GetValue())
+	}
+	return b
+}
+
+func (b *retryBudget) max(rm *resourceManager) uint64 {
+	active := rm.requests.Count() + rm.pending.Count()
+	if max := uint64(float64(active) * b.percent / 100); max > b.minConcurrency {
+		return max
+	}
+	return b.minConcurrency
+}
+
+type resourceManager struct {
+	connections *resource
+	pending     *resource
+	requests    *resource
+	retries     *resource
+	closed      int32
+}
+
+// newResourceManager the defaults are same as envoy if thresholds is nil. The gauges are named like
+// envoy_cluster_circuit_breakers_default_rq_open, and the remaining gauges are exposed if track_remaining.
+func newResourceManager(cluster string, priority api.RoutingPriority,
+	t *envoy_config_cluster_v3.CircuitBreakers_Thresholds) *resourceManager {
+	rm := &resourceManager{}
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: cluster}}
+	prefix := "envoy_cluster_circuit_breakers_" + priority.String() + "_"
+	newResource := func(open, remaining string, max uint64) *resource {
+		r := &resource{manager: rm, max: max, open: stats.DefaultStore.Gauge(prefix+open, tags)}
+		if t.GetTrackRemaining() {
+			r.remaining = stats.DefaultStore.Gauge(prefix+remaining, tags)
+		}
+		return r
+	}
+
+	maxConnections, maxPending, maxRequests, maxRetries := uint64(defaultMaxConnections),
+		uint64(defaultMaxPendingRequests), uint64(defaultMaxRequests), uint64(defaultMaxRetries)
+	if v := t.GetMaxConnections(); v != nil {
+		maxConnections = uint64(v.GetValue())
+	}
+	if v := t.GetMaxPendingRequests(); v != nil {
+		maxPending = uint64(v.GetValue())
+	}
+	if v := t.GetMaxRequests(); v != nil {
+		maxRequests = uint64(v.GetValue())
+	}
+	if v := t.GetMaxRetries(); v != nil {
+		maxRetries = uint64(v.GetValue())
+	}
+	rm.connections = newResource("cx_open", "remaining_cx", maxConnections)
+	rm.pending = newResource("rq_pending_open", "remaining_pending", maxPending)
+	rm.requests = newResource("rq_open", "remaining_rq", maxRequests)
+	rm.retries = newResource("rq_retry_open", "remaining_retries", maxRetries)
+	// max_retries is ignored if retry budget is set
+	if budget := t.GetRetryBudget(); budget != nil {
+		rm.retries.budget = newRetryBudget(budget)
+	}
+
+	for _, r := range []*resource{rm.connections, rm.pending, rm.requests, rm.retries} {
+		r.updateGauges()
+	}
+	return rm
+}
+
+// newResourceManagers returns the resource managers indexed by priority
+func newResourceManagers(c *envoy_config_cluster_v3.Cluster) []*resourceManager {
+	thresholds := make(map[api.RoutingPriority]*envoy_config_cluster_v3.CircuitBreakers_Thresholds)
+	for _, t := range c.GetCircuitBreakers().GetThresholds() {
+		thresholds[api.RoutingPriority(t.GetPriority())] = t
+	}
+	return []*resourceManager{
+		newResourceManager(c.GetName(), api.PriorityDefault, thresholds[api.PriorityDefault]),
+		newResourceManager(c.GetName(), api.PriorityHigh, thresholds[api.PriorityHigh]),
+	}
+}
+
+func (rm *resourceManager) Connections() api.ResourceLimit {
+	return rm.connections
+}
+
+func (rm *resourceManager) PendingRequests() api.ResourceLimit {
+	return rm.pending
+}
+
+func (rm *resourceManager) Requests() api.ResourceLimit {
+	return rm.requests
+}
+
+func (rm *resourceManager) Retries() api.ResourceLimit {
+	return rm.retries
+}
+
+func (rm *resourceManager) close() {
+	atomic.StoreInt32(&rm.closed, 1)
+}
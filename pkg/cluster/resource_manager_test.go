@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"testing"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/stats"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestResourceManager(t *testing.T) {
+	c := &envoy_config_cluster_v3.Cluster{
+		Name:                 "outbound|9080||reviews",
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{Type: envoy_config_cluster_v3.Cluster_EDS},
+		CircuitBreakers: &envoy_config_cluster_v3.CircuitBreakers{
+			Thresholds: []*envoy_config_cluster_v3.CircuitBreakers_Thresholds{{
+				Priority:       envoy_config_core_v3.RoutingPriority_HIGH,
+				MaxConnections: wrapperspb.UInt32(1),
+				MaxRequests:    wrapperspb.UInt32(10),
+				RetryBudget: &envoy_config_cluster_v3.CircuitBreakers_Thresholds_RetryBudget{
+					BudgetPercent:       &envoy_type_v3.Percent{Value: 50},
+					MinRetryConcurrency: wrapperspb.UInt32(2),
+				},
+				TrackRemaining: true,
+			}},
+		},
+	}
+	cluster, err := NewCluster(c)
+	assert.NoError(t, err)
+
+	// defaults of envoy
+	rm := cluster.ResourceManager(api.PriorityDefault)
+	assert.Equal(t, uint64(1024), rm.Connections().Max())
+	assert.Equal(t, uint64(1024), rm.PendingRequests().Max())
+	assert.Equal(t, uint64(1024), rm.Requests().Max())
+	assert.Equal(t, uint64(3), rm.Retries().Max())
+
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: c.Name}}
+	gauge := func(name string) int64 {
+		return stats.DefaultStore.Gauge("envoy_cluster_circuit_breakers_high_"+name, tags).Value()
+	}
+	rm = cluster.ResourceManager(api.PriorityHigh)
+	assert.True(t, rm.Connections().CanCreate())
+	assert.Equal(t, int64(0), gauge("cx_open"))
+	assert.Equal(t, int64(1), gauge("remaining_cx"))
+	rm.Connections().Inc()
+	assert.False(t, rm.Connections().CanCreate())
+	assert.Equal(t, int64(1), gauge("cx_open"))
+	assert.Equal(t, int64(0), gauge("remaining_cx"))
+	rm.Connections().Dec()
+	assert.Equal(t, int64(0), gauge("cx_open"))
+	assert.True(t, rm.Connections().TryInc())
+	assert.False(t, rm.Connections().TryInc())
+	assert.Equal(t, uint64(1), rm.Connections().Count())
+	rm.Connections().Dec()
+
+	// retry budget is 50% of active requests, and at least 2
+	assert.Equal(t, uint64(2), rm.Retries().Max())
+	assert.True(t, rm.Retries().TryInc())
+	assert.True(t, rm.Retries().TryInc())
+	assert.False(t, rm.Retries().CanCreate())
+	assert.Equal(t, int64(1), gauge("rq_retry_open"))
+	for i := 0; i < 6; i++ {
+		rm.Requests().Inc()
+	}
+	rm.PendingRequests().Inc()
+	assert.Equal(t, uint64(3), rm.Retries().Max())
+	assert.Equal(t, int64(4), gauge("remaining_rq"))
+	// the retry gauges follow the budget of active requests
+	assert.Equal(t, int64(0), gauge("rq_retry_open"))
+	assert.Equal(t, int64(1), gauge("remaining_retries"))
+
+	// the gauges are not updated after closed
+	cluster.Close()
+	rm.Requests().Inc()
+	assert.Equal(t, int64(4), gauge("remaining_rq"))
+}
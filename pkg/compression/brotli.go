@@ -0,0 +1,79 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compression
+
+import (
+	envoy_brotli_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/brotli/compressor/v3"
+	envoy_brotli_decompressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/brotli/decompressor/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	EncodingBrotli = "br"
+
+	// same as envoy's default quality
+	defaultBrotliQuality = 3
+)
+
+func init() {
+	registCompressor(&envoy_brotli_compressor_v3.Brotli{}, newBrotliCompressor)
+	registDecompressor(&envoy_brotli_decompressor_v3.Brotli{}, func(proto.Message) (Decompressor, error) {
+		return brotliDecompressor{}, nil
+	})
+}
+
+// brotliCompressor only quality is supported, the other options are ignored
+type brotliCompressor struct {
+	quality int
+}
+
+func newBrotliCompressor(pb proto.Message) (Compressor, error) {
+	c := pb.(*envoy_brotli_compressor_v3.Brotli)
+	b := &brotliCompressor{quality: defaultBrotliQuality}
+	if q := c.GetQuality(); q != nil {
+		b.quality = int(q.GetValue())
+	}
+	return b, nil
+}
+
+func (b *brotliCompressor) Encoding() string {
+	return EncodingBrotli
+}
+
+func (b *brotliCompressor) Compress(dst, src []byte) []byte {
+	return fasthttp.AppendBrotliBytesLevel(dst, src, b.quality)
+}
+
+type brotliDecompressor struct {
+}
+
+func (brotliDecompressor) Encoding() string {
+	return EncodingBrotli
+}
+
+func (brotliDecompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return fasthttp.AppendUnbrotliBytes(dst, src)
+}
@@ -0,0 +1,116 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compression
+
+import (
+	"fmt"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+)
+
+// Compressor compress the whole body, the body is buffered so streaming is not needed
+type Compressor interface {
+	// Encoding is the content-encoding of the compressed body, like gzip or br
+	Encoding() string
+
+	// Compress append the compressed src to dst
+	Compress(dst, src []byte) []byte
+}
+
+// Decompressor decompress the whole body
+type Decompressor interface {
+	// Encoding is the content-encoding the decompressor handles
+	Encoding() string
+
+	// Decompress append the decompressed src to dst
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+type compressorFactory struct {
+	config proto.Message
+	create func(proto.Message) (Compressor, error)
+}
+
+type decompressorFactory struct {
+	config proto.Message
+	create func(proto.Message) (Decompressor, error)
+}
+
+var (
+	compressorFactories   = make(map[string]*compressorFactory)
+	decompressorFactories = make(map[string]*decompressorFactory)
+)
+
+func registCompressor(config proto.Message, create func(proto.Message) (Compressor, error)) {
+	compressorFactories[proto.MessageName(config)] = &compressorFactory{config: config, create: create}
+}
+
+func registDecompressor(config proto.Message, create func(proto.Message) (Decompressor, error)) {
+	decompressorFactories[proto.MessageName(config)] = &decompressorFactory{config: config, create: create}
+}
+
+func unmarshalLibrary(c *envoy_config_core_v3.TypedExtensionConfig, config proto.Message) (proto.Message, error) {
+	pb := proto.Clone(config)
+	if err := ptypes.UnmarshalAny(c.GetTypedConfig(), pb); err != nil {
+		return nil, err
+	}
+	return pb, nil
+}
+
+// NewCompressor create compressor by compressor_library, like envoy.compression.gzip.compressor
+func NewCompressor(c *envoy_config_core_v3.TypedExtensionConfig) (Compressor, error) {
+	name, err := ptypes.AnyMessageName(c.GetTypedConfig())
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := compressorFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("not support compressor library: %s", name)
+	}
+	pb, err := unmarshalLibrary(c, factory.config)
+	if err != nil {
+		return nil, err
+	}
+	return factory.create(pb)
+}
+
+// NewDecompressor create decompressor by decompressor_library, like envoy.compression.gzip.decompressor
+func NewDecompressor(c *envoy_config_core_v3.TypedExtensionConfig) (Decompressor, error) {
+	name, err := ptypes.AnyMessageName(c.GetTypedConfig())
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := decompressorFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("not support decompressor library: %s", name)
+	}
+	pb, err := unmarshalLibrary(c, factory.config)
+	if err != nil {
+		return nil, err
+	}
+	return factory.create(pb)
+}
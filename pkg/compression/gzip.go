@@ -0,0 +1,78 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compression
+
+import (
+	envoy_gzip_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/gzip/compressor/v3"
+	envoy_gzip_decompressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/gzip/decompressor/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/valyala/fasthttp"
+)
+
+const EncodingGzip = "gzip"
+
+func init() {
+	registCompressor(&envoy_gzip_compressor_v3.Gzip{}, newGzipCompressor)
+	registDecompressor(&envoy_gzip_decompressor_v3.Gzip{}, func(proto.Message) (Decompressor, error) {
+		return gzipDecompressor{}, nil
+	})
+}
+
+// gzipCompressor only compression_level and HUFFMAN_ONLY strategy are supported,
+// memory_level, window_bits and chunk_size are ignored
+type gzipCompressor struct {
+	level int
+}
+
+func newGzipCompressor(pb proto.Message) (Compressor, error) {
+	c := pb.(*envoy_gzip_compressor_v3.Gzip)
+	g := &gzipCompressor{level: int(c.GetCompressionLevel())}
+	if c.GetCompressionLevel() == envoy_gzip_compressor_v3.Gzip_DEFAULT_COMPRESSION {
+		g.level = fasthttp.CompressDefaultCompression
+	}
+	if c.GetCompressionStrategy() == envoy_gzip_compressor_v3.Gzip_HUFFMAN_ONLY {
+		g.level = fasthttp.CompressHuffmanOnly
+	}
+	return g, nil
+}
+
+func (g *gzipCompressor) Encoding() string {
+	return EncodingGzip
+}
+
+func (g *gzipCompressor) Compress(dst, src []byte) []byte {
+	return fasthttp.AppendGzipBytesLevel(dst, src, g.level)
+}
+
+type gzipDecompressor struct {
+}
+
+func (gzipDecompressor) Encoding() string {
+	return EncodingGzip
+}
+
+func (gzipDecompressor) Decompress(dst, src []byte) ([]byte, error) {
+	return fasthttp.AppendGunzipBytes(dst, src)
+}
@@ -0,0 +1,139 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package filtertest provides the configs, handlers and stream contexts shared by http filter tests
+package filtertest
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/cluster"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/router"
+)
+
+// Route returns a prefix route to the cluster, with the typed per filter configs
+func Route(prefix string, cluster string, perFilter map[string]*any.Any) *envoy_config_route_v3.Route {
+	return &envoy_config_route_v3.Route{
+		Match: &envoy_config_route_v3.RouteMatch{
+			PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: prefix}},
+		Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+			ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{Cluster: cluster}}},
+		TypedPerFilterConfig: perFilter,
+	}
+}
+
+// RouteConfig returns the route config of a virtual host matching all domains
+func RouteConfig(name string, routes ...*envoy_config_route_v3.Route) *envoy_config_route_v3.RouteConfiguration {
+	return &envoy_config_route_v3.RouteConfiguration{
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:    name,
+			Domains: []string{"*"},
+			Routes:  routes,
+		}},
+	}
+}
+
+// NewHandler creates the handler of a connection with the filters, the route matcher is nil if rc is nil
+func NewHandler(rc *envoy_config_route_v3.RouteConfiguration, creators ...api.HTTPFilterCreator) http.Handler {
+	var matcher api.RouteConfigMatcher
+	if rc != nil {
+		matcher = router.NewRouterMatcher(rc)
+	}
+	handler := http.NewHandler(matcher, nil)
+	for _, create := range creators {
+		create(handler)
+	}
+	return handler
+}
+
+// NewContext returns the stream context of the request uri and headers, with an empty response
+func NewContext(uri string, headers map[string]string) api.StreamContext {
+	req := &fasthttp.Request{}
+	req.SetRequestURI(uri)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, &fasthttp.Response{})
+}
+
+// Handle runs the filters for the stream and completes it, so the access logs of filters are called
+func Handle(t *testing.T, handler http.Handler, ctx api.StreamContext) api.StreamContext {
+	assert.NoError(t, handler.Handle(ctx))
+	handler.OnComplete(ctx)
+	return ctx
+}
+
+type factoryContext struct {
+	api.FactoryContext
+	cm api.ClusterManager
+}
+
+func (c *factoryContext) ClusterManager() api.ClusterManager {
+	return c.cm
+}
+
+// NewFactoryContext returns the factory context with the cluster manager of clusters, it has no other managers
+func NewFactoryContext(t *testing.T, clusters ...*envoy_config_cluster_v3.Cluster) api.FactoryContext {
+	cm, err := cluster.NewClusterManager(clusters)
+	assert.NoError(t, err)
+	return &factoryContext{cm: cm}
+}
+
+// StaticCluster returns the static cluster of the host addresses like 127.0.0.1:8080
+func StaticCluster(name string, addrs ...string) *envoy_config_cluster_v3.Cluster {
+	var endpoints []*envoy_config_endpoint_v3.LbEndpoint
+	for _, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		endpoints = append(endpoints, &envoy_config_endpoint_v3.LbEndpoint{
+			HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+				Endpoint: &envoy_config_endpoint_v3.Endpoint{
+					Address: &envoy_config_core_v3.Address{
+						Address: &envoy_config_core_v3.Address_SocketAddress{
+							SocketAddress: &envoy_config_core_v3.SocketAddress{
+								Address:       host,
+								PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: uint32(p)},
+							}}}}}})
+	}
+	return &envoy_config_cluster_v3.Cluster{
+		Name:                 name,
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{Type: envoy_config_cluster_v3.Cluster_STATIC},
+		LoadAssignment: &envoy_config_endpoint_v3.ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints:   []*envoy_config_endpoint_v3.LocalityLbEndpoints{{LbEndpoints: endpoints}},
+		},
+	}
+}
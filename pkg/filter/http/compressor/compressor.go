@@ -0,0 +1,180 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compressor
+
+import (
+	"strconv"
+	"strings"
+
+	envoy_extensions_filters_http_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/compressor/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(CompressorFactory))
+}
+
+// acceptEncoding returns whether the encoding is acceptable by accept-encoding header,
+// the encoding or wildcard with q=0 is not acceptable
+func acceptEncoding(header []byte, encoding string) bool {
+	wildcard := false
+	for _, item := range strings.Split(string(header), ",") {
+		name, q := parseQValue(item)
+		if strings.EqualFold(name, encoding) {
+			return q > 0
+		}
+		if name == "*" {
+			wildcard = q > 0
+		}
+	}
+	return wildcard
+}
+
+// parseQValue parse the coding like "gzip;q=0.8", the q is 1 if not present
+func parseQValue(item string) (string, float64) {
+	params := strings.Split(item, ";")
+	name, q := strings.TrimSpace(params[0]), 1.0
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+		if len(p) > 2 && (p[0] == 'q' || p[0] == 'Q') && p[1] == '=' {
+			if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return name, q
+}
+
+func hasNoTransform(header api.HeaderMap) bool {
+	return strings.Contains(strings.ToLower(string(header.Get("cache-control"))), "no-transform")
+}
+
+// insertVary append Accept-Encoding to vary header if not present
+func insertVary(header api.HeaderMap) {
+	vary := string(header.Get("vary"))
+	if vary == "" {
+		header.Set("vary", "Accept-Encoding")
+		return
+	}
+	for _, v := range strings.Split(vary, ",") {
+		if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, "accept-encoding") {
+			return
+		}
+	}
+	header.Set("vary", vary+", Accept-Encoding")
+}
+
+// Compressor compress the request and response body by the compressor library. If several compressor
+// filters are configured, the response is compressed by the last one whose encoding is acceptable.
+type Compressor struct {
+	filter.PassThroughFilter
+	config *compressorConfig
+	// accepted is whether the client accepts the encoding, and the directions to compress.
+	// All of them are decided again in DecodeHeaders.
+	accepted         bool
+	compressRequest  bool
+	compressResponse bool
+}
+
+func (c *Compressor) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Request().Header()
+	c.accepted = acceptEncoding(header.Get("accept-encoding"), c.config.compressor.Encoding())
+	c.compressRequest, c.compressResponse = false, false
+	if c.config.removeAcceptEncodingHeader {
+		header.Del("accept-encoding")
+	}
+
+	if r := c.config.request; r != nil && !endStream && r.isEnabled() &&
+		r.eligible(header, len(ctx.Request().Body().Bytes())) {
+		c.compressRequest = true
+		header.Set("content-encoding", c.config.compressor.Encoding())
+		header.Del("content-length")
+	}
+	return api.Continue
+}
+
+func (c *Compressor) DecodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	if c.compressRequest {
+		data.SetBody(c.config.compressor.Compress(nil, data.Bytes()))
+	}
+	return api.Continue
+}
+
+func (c *Compressor) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Response().Header()
+	r := c.config.response
+	if endStream || !r.isEnabled() || !r.eligible(header, len(ctx.Response().Body().Bytes())) || hasNoTransform(header) {
+		return api.Continue
+	}
+	etag := string(header.Get("etag"))
+	if etag != "" && c.config.disableOnEtagHeader {
+		return api.Continue
+	}
+
+	insertVary(header)
+	if !c.accepted {
+		return api.Continue
+	}
+	// the compressed body is not byte-for-byte identical, so the strong etag is weakened
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("etag", "W/"+etag)
+	}
+	c.compressResponse = true
+	header.Set("content-encoding", c.config.compressor.Encoding())
+	header.Del("content-length")
+	return api.Continue
+}
+
+func (c *Compressor) EncodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	if c.compressResponse {
+		data.SetBody(c.config.compressor.Compress(nil, data.Bytes()))
+	}
+	return api.Continue
+}
+
+type CompressorFactory struct {
+}
+
+func (f *CompressorFactory) Name() string {
+	return filter.HTTP_Compressor
+}
+
+func (f *CompressorFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_compressor_v3.Compressor{}
+}
+
+func (f *CompressorFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newCompressorConfig(pb)
+	if err != nil {
+		panic(err)
+	}
+	return func(cb api.HTTPFilterManager) {
+		compressor := &Compressor{config: config}
+		cb.AddDecodeFilter(compressor)
+		cb.AddEncodeFilter(compressor)
+	}
+}
@@ -0,0 +1,108 @@
+package compressor
+
+import (
+	"strings"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_brotli_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/brotli/compressor/v3"
+	envoy_gzip_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/gzip/compressor/v3"
+	envoy_extensions_filters_http_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/compressor/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var jsonBody = `{"reviews": [` + strings.Repeat(`{"reviewer": "Reviewer1", "text": "An extremely entertaining play by Shakespeare."},`, 10) + `{}]}`
+
+func newConfig(t *testing.T, library proto.Message) *envoy_extensions_filters_http_compressor_v3.Compressor {
+	a, err := ptypes.MarshalAny(library)
+	assert.NoError(t, err)
+	return &envoy_extensions_filters_http_compressor_v3.Compressor{
+		CompressorLibrary: &envoy_config_core_v3.TypedExtensionConfig{Name: "compressor", TypedConfig: a},
+	}
+}
+
+func newTestHandler(configs ...*envoy_extensions_filters_http_compressor_v3.Compressor) http.Handler {
+	var creators []api.HTTPFilterCreator
+	for _, c := range configs {
+		creators = append(creators, new(CompressorFactory).CreateFilterFactory(c, nil))
+	}
+	return filtertest.NewHandler(nil, creators...)
+}
+
+func handle(t *testing.T, handler http.Handler, acceptEncoding string, contentType string, body string) api.StreamContext {
+	headers := map[string]string{}
+	if acceptEncoding != "" {
+		headers["accept-encoding"] = acceptEncoding
+	}
+	ctx := filtertest.NewContext("http://reviews/reviews/0", headers)
+	rsp := ctx.Response().Raw().(*fasthttp.Response)
+	rsp.Header.SetContentType(contentType)
+	rsp.Header.Set("etag", `"v1"`)
+	rsp.SetBodyString(body)
+	assert.NoError(t, handler.Handle(ctx))
+	return ctx
+}
+
+func TestCompressor(t *testing.T) {
+	handler := newTestHandler(newConfig(t, &envoy_gzip_compressor_v3.Gzip{}))
+
+	ctx := handle(t, handler, "deflate, gzip;q=0.8", "application/json; charset=utf-8", jsonBody)
+	header := ctx.Response().Header()
+	assert.Equal(t, "gzip", string(header.Get("content-encoding")))
+	assert.Equal(t, "Accept-Encoding", string(header.Get("vary")))
+	assert.Equal(t, `W/"v1"`, string(header.Get("etag")))
+	body, err := fasthttp.AppendGunzipBytes(nil, ctx.Response().Body().Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, jsonBody, string(body))
+
+	// not acceptable, but vary is set
+	for _, accept := range []string{"", "br", "gzip;q=0", "*;q=0"} {
+		ctx = handle(t, handler, accept, "application/json", jsonBody)
+		assert.Nil(t, ctx.Response().Header().Get("content-encoding"), accept)
+		assert.Equal(t, "Accept-Encoding", string(ctx.Response().Header().Get("vary")))
+		assert.Equal(t, jsonBody, string(ctx.Response().Body().Bytes()))
+	}
+	ctx = handle(t, handler, "*", "application/json", jsonBody)
+	assert.Equal(t, "gzip", string(ctx.Response().Header().Get("content-encoding")))
+
+	// content type and length not allowed
+	ctx = handle(t, handler, "gzip", "image/png", jsonBody)
+	assert.Nil(t, ctx.Response().Header().Get("content-encoding"))
+	assert.Nil(t, ctx.Response().Header().Get("vary"))
+	ctx = handle(t, handler, "gzip", "application/json", "{}")
+	assert.Nil(t, ctx.Response().Header().Get("content-encoding"))
+}
+
+func TestCompressorResponseDirection(t *testing.T) {
+	config := newConfig(t, &envoy_brotli_compressor_v3.Brotli{})
+	config.ResponseDirectionConfig = &envoy_extensions_filters_http_compressor_v3.Compressor_ResponseDirectionConfig{
+		CommonConfig: &envoy_extensions_filters_http_compressor_v3.Compressor_CommonDirectionConfig{
+			MinContentLength: wrapperspb.UInt32(10),
+			ContentType:      []string{"text/plain"},
+		},
+		RemoveAcceptEncodingHeader: true,
+	}
+	handler := newTestHandler(newConfig(t, &envoy_gzip_compressor_v3.Gzip{}), config)
+
+	ctx := handle(t, handler, "gzip, br", "text/plain", "hello, govoy")
+	assert.Nil(t, ctx.Request().Header().Get("accept-encoding"))
+	// the last compressor is called first in encoding
+	assert.Equal(t, "br", string(ctx.Response().Header().Get("content-encoding")))
+	body, err := fasthttp.AppendUnbrotliBytes(nil, ctx.Response().Body().Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello, govoy", string(body))
+
+	config.ResponseDirectionConfig.CommonConfig.Enabled = &envoy_config_core_v3.RuntimeFeatureFlag{
+		DefaultValue: wrapperspb.Bool(false)}
+	config.ResponseDirectionConfig.DisableOnEtagHeader = true
+	handler = newTestHandler(config)
+	ctx = handle(t, handler, "br", "text/plain", "hello, govoy")
+	assert.Nil(t, ctx.Response().Header().Get("content-encoding"))
+}
@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package compressor
+
+import (
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_compressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/compressor/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/compression"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// same as envoy's defaults
+const defaultMinContentLength = 30
+
+var defaultContentTypes = []string{
+	"text/html", "text/plain", "text/css", "application/javascript", "application/x-javascript",
+	"text/javascript", "text/x-javascript", "text/ecmascript", "text/js", "text/jscript", "text/x-js",
+	"application/ecmascript", "application/x-json", "application/xml", "application/json",
+	"image/svg+xml", "text/xml", "application/xhtml+xml",
+}
+
+// directionConfig is built from CommonDirectionConfig
+type directionConfig struct {
+	enabled          *envoy_config_core_v3.RuntimeFeatureFlag
+	minContentLength int
+	contentTypes     map[string]struct{}
+}
+
+func newDirectionConfig(enabled *envoy_config_core_v3.RuntimeFeatureFlag, minContentLength *wrapperspb.UInt32Value,
+	contentTypes []string) *directionConfig {
+	d := &directionConfig{
+		enabled:          enabled,
+		minContentLength: defaultMinContentLength,
+		contentTypes:     make(map[string]struct{}),
+	}
+	if minContentLength != nil {
+		d.minContentLength = int(minContentLength.GetValue())
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = defaultContentTypes
+	}
+	for _, t := range contentTypes {
+		d.contentTypes[strings.ToLower(t)] = struct{}{}
+	}
+	return d
+}
+
+// isEnabled is true if enabled not set
+func (d *directionConfig) isEnabled() bool {
+	if d.enabled == nil || d.enabled.GetDefaultValue() == nil {
+		return true
+	}
+	return d.enabled.GetDefaultValue().GetValue()
+}
+
+// eligible returns whether the body of headers should be compressed, the body is not encoded,
+// and the content type and length are allowed
+func (d *directionConfig) eligible(header api.HeaderMap, length int) bool {
+	if length < d.minContentLength {
+		return false
+	}
+	if encoding := string(header.Get("content-encoding")); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	contentType := string(header.Get("content-type"))
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	_, ok := d.contentTypes[strings.ToLower(strings.TrimSpace(contentType))]
+	return ok
+}
+
+// compressorConfig is built from envoy.extensions.filters.http.compressor.v3.Compressor
+type compressorConfig struct {
+	compressor compression.Compressor
+	// request is nil if request_direction_config not set
+	request                    *directionConfig
+	response                   *directionConfig
+	disableOnEtagHeader        bool
+	removeAcceptEncodingHeader bool
+}
+
+func newCompressorConfig(pb proto.Message) (*compressorConfig, error) {
+	c := pb.(*envoy_extensions_filters_http_compressor_v3.Compressor)
+	compressor, err := compression.NewCompressor(c.GetCompressorLibrary())
+	if err != nil {
+		return nil, err
+	}
+	config := &compressorConfig{compressor: compressor}
+
+	if r := c.GetRequestDirectionConfig(); r != nil {
+		common := r.GetCommonConfig()
+		config.request = newDirectionConfig(common.GetEnabled(), common.GetMinContentLength(), common.GetContentType())
+	}
+
+	// the deprecated fields are used if response_direction_config not set
+	if r := c.GetResponseDirectionConfig(); r != nil {
+		common := r.GetCommonConfig()
+		config.response = newDirectionConfig(common.GetEnabled(), common.GetMinContentLength(), common.GetContentType())
+		config.disableOnEtagHeader = r.GetDisableOnEtagHeader()
+		config.removeAcceptEncodingHeader = r.GetRemoveAcceptEncodingHeader()
+	} else {
+		config.response = newDirectionConfig(c.GetRuntimeEnabled(), c.GetContentLength(), c.GetContentType())
+		config.disableOnEtagHeader = c.GetDisableOnEtagHeader()
+		config.removeAcceptEncodingHeader = c.GetRemoveAcceptEncodingHeader()
+	}
+	return config, nil
+}
@@ -0,0 +1,133 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cors
+
+import (
+	"net/http"
+
+	envoy_extensions_filters_http_cors_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+)
+
+const (
+	headerOrigin                     = "Origin"
+	headerAccessControlRequestMethod = "Access-Control-Request-Method"
+	headerAllowOrigin                = "Access-Control-Allow-Origin"
+	headerAllowCredentials           = "Access-Control-Allow-Credentials"
+	headerAllowMethods               = "Access-Control-Allow-Methods"
+	headerAllowHeaders               = "Access-Control-Allow-Headers"
+	headerExposeHeaders              = "Access-Control-Expose-Headers"
+	headerMaxAge                     = "Access-Control-Max-Age"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(CorsFactory))
+}
+
+// Cors answer the preflight requests and decorate the responses by the cors policy of route
+type Cors struct {
+	filter.PassThroughFilter
+	// policy and origin of the allowed cross origin request, and whether it's a preflight.
+	// They are reset by DecodeHeaders of each stream.
+	policy    api.CorsPolicy
+	origin    string
+	preflight bool
+}
+
+func (c *Cors) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	c.policy, c.origin, c.preflight = nil, "", false
+
+	header := ctx.Request().Header()
+	origin := string(header.Get(headerOrigin))
+	if origin == "" {
+		return api.Continue
+	}
+	entry := c.DecoderCallbacks.RouteEntry()
+	if entry == nil {
+		return api.Continue
+	}
+	policy := entry.CorsPolicy()
+	if policy == nil || !policy.Enabled() || !policy.AllowOrigin(origin) {
+		return api.Continue
+	}
+
+	c.policy, c.origin = policy, origin
+	if string(header.Method()) != http.MethodOptions || len(header.Get(headerAccessControlRequestMethod)) == 0 {
+		return api.Continue
+	}
+	// the preflight headers are added in EncodeHeaders
+	c.preflight = true
+	c.DecoderCallbacks.SendLocalReply(http.StatusOK, "", "cors_response")
+	return api.StopIteration
+}
+
+func (c *Cors) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if c.policy == nil {
+		return api.Continue
+	}
+	header := ctx.Response().Header()
+	header.Set(headerAllowOrigin, c.origin)
+	if c.policy.AllowCredentials() {
+		header.Set(headerAllowCredentials, "true")
+	}
+
+	if !c.preflight {
+		if v := c.policy.ExposeHeaders(); v != "" {
+			header.Set(headerExposeHeaders, v)
+		}
+		return api.Continue
+	}
+	if v := c.policy.AllowMethods(); v != "" {
+		header.Set(headerAllowMethods, v)
+	}
+	if v := c.policy.AllowHeaders(); v != "" {
+		header.Set(headerAllowHeaders, v)
+	}
+	if v := c.policy.MaxAge(); v != "" {
+		header.Set(headerMaxAge, v)
+	}
+	return api.Continue
+}
+
+type CorsFactory struct {
+}
+
+func (f *CorsFactory) Name() string {
+	return filter.HTTP_Cors
+}
+
+func (f *CorsFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_cors_v3.Cors{}
+}
+
+func (f *CorsFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	return func(cb api.HTTPFilterManager) {
+		cors := &Cors{}
+		cb.AddDecodeFilter(cors)
+		cb.AddEncodeFilter(cors)
+	}
+}
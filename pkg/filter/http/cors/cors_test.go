@@ -0,0 +1,67 @@
+package cors
+
+import (
+	"testing"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+func newTestHandler() http.Handler {
+	rc := filtertest.RouteConfig("bookinfo", filtertest.Route("/", "productpage", nil))
+	rc.VirtualHosts[0].Cors = &envoy_config_route_v3.CorsPolicy{
+		AllowOriginStringMatch: []*envoy_type_matcher_v3.StringMatcher{{
+			MatchPattern: &envoy_type_matcher_v3.StringMatcher_Suffix{Suffix: ".example.com"},
+		}},
+		AllowMethods:     "GET,POST",
+		AllowHeaders:     "content-type",
+		ExposeHeaders:    "x-custom",
+		MaxAge:           "600",
+		AllowCredentials: &wrappers.BoolValue{Value: true},
+	}
+	return filtertest.NewHandler(rc, new(CorsFactory).CreateFilterFactory(nil, nil))
+}
+
+func newTestContext(method, origin string) api.StreamContext {
+	headers := map[string]string{"Origin": origin}
+	if method == "OPTIONS" {
+		headers["Access-Control-Request-Method"] = "POST"
+	}
+	ctx := filtertest.NewContext("http://bookinfo.com/productpage", headers)
+	ctx.Request().Header().SetMethod(method)
+	return ctx
+}
+
+func TestCors(t *testing.T) {
+	handler := newTestHandler()
+
+	ctx := newTestContext("OPTIONS", "https://www.example.com")
+	assert.NoError(t, handler.Handle(ctx))
+	header := ctx.Response().Header()
+	assert.Equal(t, 200, header.StatusCode())
+	assert.Equal(t, "cors_response", ctx.StreamInfo().ResponseCodeDetails())
+	assert.Equal(t, "https://www.example.com", string(header.Get(headerAllowOrigin)))
+	assert.Equal(t, "GET,POST", string(header.Get(headerAllowMethods)))
+	assert.Equal(t, "content-type", string(header.Get(headerAllowHeaders)))
+	assert.Equal(t, "600", string(header.Get(headerMaxAge)))
+	assert.Equal(t, "true", string(header.Get(headerAllowCredentials)))
+
+	ctx = newTestContext("GET", "https://www.example.com")
+	assert.NoError(t, handler.Handle(ctx))
+	header = ctx.Response().Header()
+	assert.Equal(t, "", ctx.StreamInfo().ResponseCodeDetails())
+	assert.Equal(t, "https://www.example.com", string(header.Get(headerAllowOrigin)))
+	assert.Equal(t, "x-custom", string(header.Get(headerExposeHeaders)))
+	assert.Nil(t, header.Get(headerAllowMethods))
+
+	// origin not allowed
+	ctx = newTestContext("OPTIONS", "https://evil.com")
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Nil(t, ctx.Response().Header().Get(headerAllowOrigin))
+	assert.Equal(t, "", ctx.StreamInfo().ResponseCodeDetails())
+}
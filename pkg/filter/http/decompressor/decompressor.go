@@ -0,0 +1,185 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package decompressor
+
+import (
+	nethttp "net/http"
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_decompressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/decompressor/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/compression"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(DecompressorFactory))
+}
+
+// decompressorConfig is built from envoy.extensions.filters.http.decompressor.v3.Decompressor
+type decompressorConfig struct {
+	*envoy_extensions_filters_http_decompressor_v3.Decompressor
+	decompressor compression.Decompressor
+}
+
+func newDecompressorConfig(pb proto.Message) (*decompressorConfig, error) {
+	c := &decompressorConfig{Decompressor: pb.(*envoy_extensions_filters_http_decompressor_v3.Decompressor)}
+	var err error
+	if c.decompressor, err = compression.NewDecompressor(c.GetDecompressorLibrary()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// isEnabled is true if enabled not set
+func isEnabled(enabled *envoy_config_core_v3.RuntimeFeatureFlag) bool {
+	if enabled.GetDefaultValue() == nil {
+		return true
+	}
+	return enabled.GetDefaultValue().GetValue()
+}
+
+// advertiseAcceptEncoding is true if not set
+func (c *decompressorConfig) advertiseAcceptEncoding() bool {
+	if v := c.GetRequestDirectionConfig().GetAdvertiseAcceptEncoding(); v != nil {
+		return v.GetValue()
+	}
+	return true
+}
+
+// matched returns whether the body should be decompressed. Only the last content coding is decompressed,
+// which is the one applied at last.
+func (c *decompressorConfig) matched(header api.HeaderMap, ignoreNoTransform bool) bool {
+	if !ignoreNoTransform && strings.Contains(strings.ToLower(string(header.Get("cache-control"))), "no-transform") {
+		return false
+	}
+	codings := strings.Split(string(header.Get("content-encoding")), ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), c.decompressor.Encoding())
+}
+
+// removeEncoding remove the last content coding, and the content-length is recalculated by the body
+func removeEncoding(header api.HeaderMap) {
+	codings := strings.Split(string(header.Get("content-encoding")), ",")
+	if len(codings) == 1 {
+		header.Del("content-encoding")
+	} else {
+		header.Set("content-encoding", strings.Join(codings[:len(codings)-1], ","))
+	}
+	header.Del("content-length")
+}
+
+// Decompressor decompress the request and response body encoded by the decompressor library
+type Decompressor struct {
+	filter.PassThroughFilter
+	config *decompressorConfig
+	// directions to decompress, which are cleared at the start of DecodeHeaders
+	decompressRequest  bool
+	decompressResponse bool
+}
+
+func (d *Decompressor) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	d.decompressRequest, d.decompressResponse = false, false
+	header := ctx.Request().Header()
+
+	request := d.config.GetRequestDirectionConfig().GetCommonConfig()
+	if !endStream && isEnabled(request.GetEnabled()) && d.config.matched(header, request.GetIgnoreNoTransformHeader()) {
+		d.decompressRequest = true
+		removeEncoding(header)
+	}
+
+	// tell upstream the encoding can be decompressed
+	if isEnabled(d.config.GetResponseDirectionConfig().GetCommonConfig().GetEnabled()) && d.config.advertiseAcceptEncoding() {
+		if accept := string(header.Get("accept-encoding")); accept == "" {
+			header.Set("accept-encoding", d.config.decompressor.Encoding())
+		} else {
+			header.Set("accept-encoding", accept+","+d.config.decompressor.Encoding())
+		}
+	}
+	return api.Continue
+}
+
+func (d *Decompressor) DecodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	if !d.decompressRequest {
+		return api.Continue
+	}
+	body, err := d.config.decompressor.Decompress(nil, data.Bytes())
+	if err != nil {
+		log.Error("decompress request error: %s", err)
+		d.DecoderCallbacks.SendLocalReply(nethttp.StatusBadRequest, "", "decompressor_failed")
+		return api.StopIteration
+	}
+	data.SetBody(body)
+	return api.Continue
+}
+
+func (d *Decompressor) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Response().Header()
+	response := d.config.GetResponseDirectionConfig().GetCommonConfig()
+	if !endStream && isEnabled(response.GetEnabled()) && d.config.matched(header, response.GetIgnoreNoTransformHeader()) {
+		d.decompressResponse = true
+		removeEncoding(header)
+	}
+	return api.Continue
+}
+
+func (d *Decompressor) EncodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	if !d.decompressResponse {
+		return api.Continue
+	}
+	body, err := d.config.decompressor.Decompress(nil, data.Bytes())
+	if err != nil {
+		log.Error("decompress response error: %s", err)
+		d.EncoderCallbacks.SendLocalReply(nethttp.StatusBadGateway, "", "decompressor_failed")
+		return api.StopIteration
+	}
+	data.SetBody(body)
+	return api.Continue
+}
+
+type DecompressorFactory struct {
+}
+
+func (f *DecompressorFactory) Name() string {
+	return filter.HTTP_Decompressor
+}
+
+func (f *DecompressorFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_decompressor_v3.Decompressor{}
+}
+
+func (f *DecompressorFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newDecompressorConfig(pb)
+	if err != nil {
+		panic(err)
+	}
+	return func(cb api.HTTPFilterManager) {
+		decompressor := &Decompressor{config: config}
+		cb.AddDecodeFilter(decompressor)
+		cb.AddEncodeFilter(decompressor)
+	}
+}
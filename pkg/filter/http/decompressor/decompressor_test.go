@@ -0,0 +1,68 @@
+package decompressor
+
+import (
+	"context"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_gzip_decompressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/compression/gzip/decompressor/v3"
+	envoy_extensions_filters_http_decompressor_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/decompressor/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+const body = "The Comedy of Errors"
+
+func newTestHandler(t *testing.T) http.Handler {
+	a, err := ptypes.MarshalAny(&envoy_gzip_decompressor_v3.Gzip{})
+	assert.NoError(t, err)
+	return filtertest.NewHandler(nil, new(DecompressorFactory).CreateFilterFactory(
+		&envoy_extensions_filters_http_decompressor_v3.Decompressor{
+			DecompressorLibrary: &envoy_config_core_v3.TypedExtensionConfig{Name: "gzip", TypedConfig: a},
+		}, nil))
+}
+
+func TestDecompressor(t *testing.T) {
+	handler := newTestHandler(t)
+
+	req := &fasthttp.Request{}
+	req.SetRequestURI("http://details/details/0")
+	req.Header.Set("accept-encoding", "br")
+	req.Header.Set("content-encoding", "gzip")
+	req.SetBody(fasthttp.AppendGzipBytes(nil, []byte(body)))
+	rsp := &fasthttp.Response{}
+	rsp.Header.Set("content-encoding", "br, gzip")
+	rsp.SetBody(fasthttp.AppendGzipBytes(nil, []byte(body)))
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+	assert.NoError(t, handler.Handle(ctx))
+
+	assert.Equal(t, "br,gzip", string(req.Header.Peek("accept-encoding")))
+	assert.Nil(t, ctx.Request().Header().Get("content-encoding"))
+	assert.Equal(t, body, string(req.Body()))
+	assert.Equal(t, "br", string(ctx.Response().Header().Get("content-encoding")))
+	assert.Equal(t, body, string(rsp.Body()))
+
+	// no-transform is kept
+	req.Reset()
+	req.SetRequestURI("http://details/details/0")
+	rsp.Reset()
+	rsp.Header.Set("content-encoding", "gzip")
+	rsp.Header.Set("cache-control", "no-transform")
+	rsp.SetBodyString(body)
+	ctx = http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, "gzip", string(ctx.Response().Header().Get("content-encoding")))
+	assert.Equal(t, body, string(rsp.Body()))
+
+	// invalid request body
+	req.Header.Set("content-encoding", "gzip")
+	req.SetBodyString(body)
+	rsp.Reset()
+	ctx = http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, 400, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "decompressor_failed", ctx.StreamInfo().ResponseCodeDetails())
+}
@@ -0,0 +1,62 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package extauthz
+
+import (
+	"context"
+
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkDenied
+)
+
+type headerValue struct {
+	key   string
+	value string
+}
+
+// checkResponse is the authorization result of grpc or http service
+type checkResponse struct {
+	status checkStatus
+	// headers to mutate the request if ok
+	headersToSet    []headerValue
+	headersToAppend []headerValue
+	headersToRemove []string
+	// status, body and headers of the response to client
+	httpStatus      int
+	body            string
+	responseHeaders []headerValue
+}
+
+// authzClient call the authorization service, error means the service is unavailable
+type authzClient interface {
+	check(ctx context.Context, lbCtx api.LoadBalancerContext, req *envoy_service_auth_v3.CheckRequest) (*checkResponse, error)
+}
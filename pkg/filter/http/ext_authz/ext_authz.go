@@ -0,0 +1,294 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package extauthz
+
+import (
+	"context"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_ext_authz_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/utils"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultGrpcTimeout = 200 * time.Millisecond
+
+	headerFailureModeAllowed = "x-envoy-auth-failure-mode-allowed"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(ExtAuthzFactory))
+}
+
+// authzConfig is built from envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+type authzConfig struct {
+	*envoy_extensions_filters_http_ext_authz_v3.ExtAuthz
+	client        authzClient
+	timeout       time.Duration
+	statusOnError int
+}
+
+func newAuthzConfig(pb proto.Message, context api.FactoryContext) (*authzConfig, error) {
+	c := &authzConfig{
+		ExtAuthz:      pb.(*envoy_extensions_filters_http_ext_authz_v3.ExtAuthz),
+		statusOnError: nethttp.StatusForbidden,
+	}
+	if code := c.GetStatusOnError().GetCode(); code > 0 {
+		c.statusOnError = int(code)
+	}
+
+	var err error
+	switch spec := c.GetServices().(type) {
+	case *envoy_extensions_filters_http_ext_authz_v3.ExtAuthz_GrpcService:
+		c.timeout = defaultGrpcTimeout
+		if t := spec.GrpcService.GetTimeout(); t != nil {
+			c.timeout = t.AsDuration()
+		}
+		c.client, err = newGrpcClient(spec.GrpcService, context.ClusterManager())
+	case *envoy_extensions_filters_http_ext_authz_v3.ExtAuthz_HttpService:
+		c.timeout = spec.HttpService.GetServerUri().GetTimeout().AsDuration()
+		c.client, err = newHTTPClient(spec.HttpService, context.ClusterManager())
+	default:
+		err = fmt.Errorf("ext_authz service is not set")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// enabled is 100% if filter_enabled not set
+func (c *authzConfig) enabled() bool {
+	enabled := c.GetFilterEnabled()
+	if enabled == nil {
+		return true
+	}
+	return utils.FractionalPercentSample(enabled.GetDefaultValue())
+}
+
+// ExtAuthz check the request by external authorization service
+type ExtAuthz struct {
+	filter.PassThroughFilter
+	config *authzConfig
+	// headers added to the response of current stream
+	responseHeaders []headerValue
+}
+
+// DecodeHeaders the request body has been read by fasthttp, so the check request
+// can be sent with the body here.
+func (a *ExtAuthz) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	a.responseHeaders = nil
+
+	var settings *envoy_extensions_filters_http_ext_authz_v3.CheckSettings
+	if entry := a.DecoderCallbacks.RouteEntry(); entry != nil {
+		if perRoute, ok := entry.PerFilterConfig(filter.HTTP_ExtAuthz).(*envoy_extensions_filters_http_ext_authz_v3.ExtAuthzPerRoute); ok {
+			if perRoute.GetDisabled() {
+				return api.Continue
+			}
+			settings = perRoute.GetCheckSettings()
+		}
+	}
+
+	if !a.config.enabled() {
+		if a.config.GetDenyAtDisable().GetDefaultValue().GetValue() {
+			ctx.StreamInfo().SetResponseFlag(api.UnauthorizedExternalService)
+			a.DecoderCallbacks.SendLocalReply(a.config.statusOnError, "", "ext_authz_disabled")
+			return api.StopIteration
+		}
+		return api.Continue
+	}
+
+	req, ok := a.newCheckRequest(ctx, settings)
+	if !ok {
+		a.DecoderCallbacks.SendLocalReply(nethttp.StatusRequestEntityTooLarge, "", "request_payload_too_large")
+		return api.StopIteration
+	}
+
+	dispatcher := a.DecoderCallbacks.Dispatcher()
+	go func() {
+		checkCtx, cancel := context.WithTimeout(ctx.Context(), a.config.timeout)
+		defer cancel()
+		rsp, err := a.config.client.check(checkCtx, a.DecoderCallbacks, req)
+		dispatcher.Post(func() { a.onComplete(ctx, rsp, err) })
+	}()
+	return api.StopIteration
+}
+
+// onComplete is posted to the stream started the check, so the headers and filter
+// state are modified in the stream goroutine
+func (a *ExtAuthz) onComplete(ctx api.StreamContext, rsp *checkResponse, err error) {
+	if ctx.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Error("ext_authz check error: %s", err)
+		if a.config.GetFailureModeAllow() {
+			ctx.Request().Header().Set(headerFailureModeAllowed, "true")
+			a.DecoderCallbacks.ContinueDecoding()
+			return
+		}
+		ctx.StreamInfo().SetResponseFlag(api.UnauthorizedExternalService)
+		a.DecoderCallbacks.SendLocalReply(a.config.statusOnError, "", "ext_authz_error")
+		return
+	}
+
+	a.responseHeaders = rsp.responseHeaders
+	if rsp.status == checkDenied {
+		ctx.StreamInfo().SetResponseFlag(api.UnauthorizedExternalService)
+		a.DecoderCallbacks.SendLocalReply(rsp.httpStatus, rsp.body, "ext_authz_denied")
+		return
+	}
+
+	header := ctx.Request().Header()
+	for _, key := range rsp.headersToRemove {
+		header.Del(key)
+	}
+	for _, h := range rsp.headersToSet {
+		header.Set(h.key, h.value)
+	}
+	for _, h := range rsp.headersToAppend {
+		if v := header.Get(h.key); v != nil {
+			header.Set(h.key, string(v)+","+h.value)
+		} else {
+			header.Set(h.key, h.value)
+		}
+	}
+	modified := len(rsp.headersToRemove)+len(rsp.headersToSet)+len(rsp.headersToAppend) > 0
+	if modified && a.config.GetClearRouteCache() {
+		a.DecoderCallbacks.ClearRouteCache()
+	}
+	a.DecoderCallbacks.ContinueDecoding()
+}
+
+func (a *ExtAuthz) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Response().Header()
+	for _, h := range a.responseHeaders {
+		header.Add(h.key, h.value)
+	}
+	return api.Continue
+}
+
+// newCheckRequest returns false if the body exceeds max_request_bytes and partial message not allowed
+func (a *ExtAuthz) newCheckRequest(ctx api.StreamContext,
+	settings *envoy_extensions_filters_http_ext_authz_v3.CheckSettings) (*envoy_service_auth_v3.CheckRequest, bool) {
+	info := ctx.StreamInfo()
+	header := ctx.Request().Header()
+	body := ctx.Request().Body().Bytes()
+
+	httpAttrs := &envoy_service_auth_v3.AttributeContext_HttpRequest{
+		Id:       info.RequestID(),
+		Method:   string(header.Method()),
+		Path:     string(ctx.Request().Raw().(*fasthttp.Request).URI().RequestURI()),
+		Host:     string(header.Host()),
+		Scheme:   "http",
+		Size:     int64(len(body)),
+		Protocol: info.Protocol(),
+		Headers:  make(map[string]string),
+	}
+	header.VisitAll(func(key, value []byte) {
+		k := strings.ToLower(string(key))
+		if v, ok := httpAttrs.Headers[k]; ok {
+			httpAttrs.Headers[k] = v + "," + string(value)
+		} else {
+			httpAttrs.Headers[k] = string(value)
+		}
+	})
+
+	if buffer := a.config.GetWithRequestBody(); buffer != nil && !settings.GetDisableRequestBodyBuffering() {
+		if max := int(buffer.GetMaxRequestBytes()); len(body) > max {
+			if !buffer.GetAllowPartialMessage() {
+				return nil, false
+			}
+			body = body[:max]
+		}
+		if buffer.GetPackAsBytes() {
+			httpAttrs.RawBody = body
+		} else {
+			httpAttrs.Body = string(body)
+		}
+	}
+
+	return &envoy_service_auth_v3.CheckRequest{
+		Attributes: &envoy_service_auth_v3.AttributeContext{
+			Source:      &envoy_service_auth_v3.AttributeContext_Peer{Address: socketAddress(info.DownstreamRemoteAddress())},
+			Destination: &envoy_service_auth_v3.AttributeContext_Peer{Address: socketAddress(info.DownstreamLocalAddress())},
+			Request: &envoy_service_auth_v3.AttributeContext_Request{
+				Time: timestamppb.New(info.StartTime()),
+				Http: httpAttrs,
+			},
+			ContextExtensions: settings.GetContextExtensions(),
+		},
+	}, true
+}
+
+func socketAddress(addr net.Addr) *envoy_config_core_v3.Address {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr == nil {
+		return nil
+	}
+	return &envoy_config_core_v3.Address{
+		Address: &envoy_config_core_v3.Address_SocketAddress{
+			SocketAddress: &envoy_config_core_v3.SocketAddress{
+				Address:       tcpAddr.IP.String(),
+				PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: uint32(tcpAddr.Port)},
+			},
+		},
+	}
+}
+
+type ExtAuthzFactory struct {
+}
+
+func (f *ExtAuthzFactory) Name() string {
+	return filter.HTTP_ExtAuthz
+}
+
+func (f *ExtAuthzFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz{}
+}
+
+func (f *ExtAuthzFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newAuthzConfig(pb, context)
+	if err != nil {
+		panic(err)
+	}
+	return func(cb api.HTTPFilterManager) {
+		authz := &ExtAuthz{config: config}
+		cb.AddDecodeFilter(authz)
+		cb.AddEncodeFilter(authz)
+	}
+}
@@ -0,0 +1,211 @@
+package extauthz
+
+import (
+	"context"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_ext_authz_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/cluster"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newTestHandler(config *envoy_extensions_filters_http_ext_authz_v3.ExtAuthz, context api.FactoryContext) http.Handler {
+	rc := filtertest.RouteConfig("ratings", filtertest.Route("/", "ratings", nil))
+	return filtertest.NewHandler(rc, new(ExtAuthzFactory).CreateFilterFactory(config, context))
+}
+
+func handle(t *testing.T, handler http.Handler, headers map[string]string) api.StreamContext {
+	return filtertest.Handle(t, handler, filtertest.NewContext("http://ratings/ratings/1", headers))
+}
+
+func newFactoryContext(t *testing.T, name string, addr string) api.FactoryContext {
+	return filtertest.NewFactoryContext(t, filtertest.StaticCluster(name, addr))
+}
+
+type authorizationServer struct {
+	envoy_service_auth_v3.UnimplementedAuthorizationServer
+}
+
+func (s *authorizationServer) Check(ctx context.Context,
+	req *envoy_service_auth_v3.CheckRequest) (*envoy_service_auth_v3.CheckResponse, error) {
+	attrs := req.GetAttributes().GetRequest().GetHttp()
+	if attrs.GetHeaders()["authorization"] == "Bearer alice" && attrs.GetPath() == "/ratings/1" {
+		return &envoy_service_auth_v3.CheckResponse{
+			Status: &status.Status{Code: int32(code.Code_OK)},
+			HttpResponse: &envoy_service_auth_v3.CheckResponse_OkResponse{
+				OkResponse: &envoy_service_auth_v3.OkHttpResponse{
+					Headers: []*envoy_config_core_v3.HeaderValueOption{{
+						Header: &envoy_config_core_v3.HeaderValue{Key: "x-user", Value: "alice"}}},
+					HeadersToRemove: []string{"authorization"},
+				}},
+		}, nil
+	}
+	return &envoy_service_auth_v3.CheckResponse{
+		Status: &status.Status{Code: int32(code.Code_PERMISSION_DENIED)},
+		HttpResponse: &envoy_service_auth_v3.CheckResponse_DeniedResponse{
+			DeniedResponse: &envoy_service_auth_v3.DeniedHttpResponse{
+				Status: &envoy_type_v3.HttpStatus{Code: envoy_type_v3.StatusCode_Unauthorized},
+				Headers: []*envoy_config_core_v3.HeaderValueOption{{
+					Header: &envoy_config_core_v3.HeaderValue{Key: "www-authenticate", Value: "Bearer"}}},
+				Body: "unauthorized",
+			}},
+	}, nil
+}
+
+// newAuthorizationServer starts the grpc authorization server on a random local port
+func newAuthorizationServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	envoy_service_auth_v3.RegisterAuthorizationServer(server, &authorizationServer{})
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	return ln.Addr().String()
+}
+
+func TestGrpcService(t *testing.T) {
+	addr := newAuthorizationServer(t)
+
+	handler := newTestHandler(&envoy_extensions_filters_http_ext_authz_v3.ExtAuthz{
+		Services: &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz_GrpcService{
+			GrpcService: &envoy_config_core_v3.GrpcService{
+				TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{ClusterName: "ext_authz"}},
+				Timeout: durationpb.New(time.Second),
+			}},
+	}, newFactoryContext(t, "ext_authz", addr))
+
+	ctx := handle(t, handler, map[string]string{"authorization": "Bearer alice"})
+	assert.Equal(t, "alice", string(ctx.Request().Header().Get("x-user")))
+	assert.Nil(t, ctx.Request().Header().Get("authorization"))
+	assert.False(t, ctx.StreamInfo().HasResponseFlag(api.UnauthorizedExternalService))
+
+	ctx = handle(t, handler, map[string]string{"authorization": "Bearer bob"})
+	assert.Equal(t, 401, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "unauthorized", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "Bearer", string(ctx.Response().Header().Get("www-authenticate")))
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.UnauthorizedExternalService))
+}
+
+func TestGrpcClientConns(t *testing.T) {
+	addrs := []string{newAuthorizationServer(t), newAuthorizationServer(t)}
+	cm := newFactoryContext(t, "ext_authz", addrs[0]).ClusterManager()
+	client, err := newGrpcClient(&envoy_config_core_v3.GrpcService{
+		TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
+			EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{ClusterName: "ext_authz"}}}, cm)
+	assert.NoError(t, err)
+
+	check := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		rsp, err := client.check(ctx, nil, &envoy_service_auth_v3.CheckRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, checkDenied, rsp.status)
+	}
+	check()
+	conn := client.conns[addrs[0]]
+	assert.NotNil(t, conn)
+
+	// the connection of removed host is closed
+	hosts, err := cluster.GetClusterEndpoint(filtertest.StaticCluster("ext_authz", addrs[1]))
+	assert.NoError(t, err)
+	assert.NoError(t, cm.UpdateClusterHosts("ext_authz", hosts))
+	check()
+	assert.Len(t, client.conns, 1)
+	assert.NotNil(t, client.conns[addrs[1]])
+	assert.Equal(t, connectivity.Shutdown, conn.GetState())
+}
+
+func TestHTTPService(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/authz/ratings/1" && r.Header.Get("authorization") == "Bearer alice" &&
+			r.Header.Get("x-tenant") == "test" && r.Header.Get("x-ignored") == "" {
+			w.Header().Set("x-user", "alice")
+			w.Header().Set("x-ignored", "true")
+			return
+		}
+		w.Header().Set("www-authenticate", "Bearer")
+		w.WriteHeader(nethttp.StatusForbidden)
+		w.Write([]byte("forbidden"))
+	}))
+	defer server.Close()
+
+	prefix := func(p string) *envoy_type_matcher_v3.ListStringMatcher {
+		return &envoy_type_matcher_v3.ListStringMatcher{Patterns: []*envoy_type_matcher_v3.StringMatcher{{
+			MatchPattern: &envoy_type_matcher_v3.StringMatcher_Prefix{Prefix: p}}}}
+	}
+	handler := newTestHandler(&envoy_extensions_filters_http_ext_authz_v3.ExtAuthz{
+		Services: &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz_HttpService{
+			HttpService: &envoy_extensions_filters_http_ext_authz_v3.HttpService{
+				ServerUri: &envoy_config_core_v3.HttpUri{
+					Uri:              "http://ext_authz",
+					HttpUpstreamType: &envoy_config_core_v3.HttpUri_Cluster{Cluster: "ext_authz"},
+					Timeout:          durationpb.New(time.Second),
+				},
+				PathPrefix: "/authz",
+				AuthorizationRequest: &envoy_extensions_filters_http_ext_authz_v3.AuthorizationRequest{
+					AllowedHeaders: prefix("x-tenant"),
+				},
+				AuthorizationResponse: &envoy_extensions_filters_http_ext_authz_v3.AuthorizationResponse{
+					AllowedUpstreamHeaders: prefix("x-user"),
+				},
+			}},
+	}, newFactoryContext(t, "ext_authz", server.Listener.Addr().String()))
+
+	ctx := handle(t, handler, map[string]string{"authorization": "Bearer alice", "x-tenant": "test", "x-ignored": "1"})
+	assert.Equal(t, "alice", string(ctx.Request().Header().Get("x-user")))
+	assert.Equal(t, "1", string(ctx.Request().Header().Get("x-ignored")))
+
+	ctx = handle(t, handler, map[string]string{"authorization": "Bearer bob"})
+	assert.Equal(t, 403, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "forbidden", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "Bearer", string(ctx.Response().Header().Get("www-authenticate")))
+}
+
+func TestFailureMode(t *testing.T) {
+	// nothing listens on the address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ln.Close()
+
+	config := &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz{
+		Services: &envoy_extensions_filters_http_ext_authz_v3.ExtAuthz_GrpcService{
+			GrpcService: &envoy_config_core_v3.GrpcService{
+				TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{ClusterName: "ext_authz"}}}},
+		StatusOnError: &envoy_type_v3.HttpStatus{Code: envoy_type_v3.StatusCode_ServiceUnavailable},
+	}
+	context := newFactoryContext(t, "ext_authz", ln.Addr().String())
+
+	ctx := handle(t, newTestHandler(config, context), nil)
+	assert.Equal(t, 503, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "ext_authz_error", ctx.StreamInfo().ResponseCodeDetails())
+
+	config.FailureModeAllow = true
+	ctx = handle(t, newTestHandler(config, context), nil)
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "true", string(ctx.Request().Header().Get(headerFailureModeAllowed)))
+
+	// disabled by filter_enabled and deny_at_disable
+	config.FilterEnabled = &envoy_config_core_v3.RuntimeFractionalPercent{DefaultValue: &envoy_type_v3.FractionalPercent{}}
+	config.DenyAtDisable = &envoy_config_core_v3.RuntimeFeatureFlag{DefaultValue: wrapperspb.Bool(true)}
+	ctx = handle(t, newTestHandler(config, context), nil)
+	assert.Equal(t, 503, ctx.Response().Header().StatusCode())
+}
@@ -0,0 +1,151 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package extauthz
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"sync"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// grpcClient call envoy.service.auth.v3.Authorization of the envoy_grpc cluster
+type grpcClient struct {
+	cm      api.ClusterManager
+	cluster string
+
+	mu sync.Mutex
+	// the hosts of cluster when conns are updated last time
+	snapshot api.ClusterSnapshot
+	// client connections by host address, closed when the host is removed from cluster
+	conns map[string]*grpc.ClientConn
+}
+
+func newGrpcClient(c *envoy_config_core_v3.GrpcService, cm api.ClusterManager) (*grpcClient, error) {
+	if c.GetEnvoyGrpc() == nil {
+		return nil, fmt.Errorf("just support envoy_grpc")
+	}
+	return &grpcClient{
+		cm:      cm,
+		cluster: c.GetEnvoyGrpc().GetClusterName(),
+		conns:   make(map[string]*grpc.ClientConn),
+	}, nil
+}
+
+// conn returns the connection of host, the cluster snapshot is updated if hosts change
+func (c *grpcClient) conn(snapshot api.ClusterSnapshot, host api.Host) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if snapshot != c.snapshot {
+		c.snapshot = snapshot
+		c.removeConns(snapshot.HostSet())
+	}
+	addr := host.Address().String()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// removeConns closes the connections of hosts not in the host set
+func (c *grpcClient) removeConns(hosts api.HostSet) {
+	exists := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		exists[h.Address().String()] = struct{}{}
+	}
+	for addr, conn := range c.conns {
+		if _, ok := exists[addr]; !ok {
+			conn.Close()
+			delete(c.conns, addr)
+		}
+	}
+}
+
+func (c *grpcClient) check(ctx context.Context, lbCtx api.LoadBalancerContext,
+	req *envoy_service_auth_v3.CheckRequest) (*checkResponse, error) {
+	cl := c.cm.GetCluster(c.cluster)
+	if cl == nil {
+		return nil, fmt.Errorf("not found cluster: %s", c.cluster)
+	}
+	snapshot := cl.Snapshot()
+	if snapshot == nil || snapshot.LoadBalancer() == nil {
+		return nil, fmt.Errorf("no healthy upstream for cluster: %s", c.cluster)
+	}
+	host := snapshot.LoadBalancer().Select(lbCtx)
+	if host == nil {
+		return nil, fmt.Errorf("no healthy upstream for cluster: %s", c.cluster)
+	}
+	conn, err := c.conn(snapshot, host)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := envoy_service_auth_v3.NewAuthorizationClient(conn).Check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if codes.Code(rsp.GetStatus().GetCode()) == codes.OK {
+		result := &checkResponse{status: checkOK}
+		ok := rsp.GetOkResponse()
+		for _, h := range ok.GetHeaders() {
+			hv := headerValue{h.GetHeader().GetKey(), h.GetHeader().GetValue()}
+			if h.GetAppend().GetValue() {
+				result.headersToAppend = append(result.headersToAppend, hv)
+			} else {
+				result.headersToSet = append(result.headersToSet, hv)
+			}
+		}
+		result.headersToRemove = ok.GetHeadersToRemove()
+		for _, h := range ok.GetResponseHeadersToAdd() {
+			result.responseHeaders = append(result.responseHeaders,
+				headerValue{h.GetHeader().GetKey(), h.GetHeader().GetValue()})
+		}
+		return result, nil
+	}
+
+	result := &checkResponse{status: checkDenied, httpStatus: nethttp.StatusForbidden}
+	denied := rsp.GetDeniedResponse()
+	if code := denied.GetStatus().GetCode(); code > 0 {
+		result.httpStatus = int(code)
+	}
+	result.body = denied.GetBody()
+	for _, h := range denied.GetHeaders() {
+		result.responseHeaders = append(result.responseHeaders,
+			headerValue{h.GetHeader().GetKey(), h.GetHeader().GetValue()})
+	}
+	return result, nil
+}
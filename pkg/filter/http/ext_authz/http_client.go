@@ -0,0 +1,196 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package extauthz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	envoy_extensions_filters_http_ext_authz_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_authz/v3"
+	envoy_service_auth_v3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/cluster"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/router"
+)
+
+// request headers always sent to the http authorization service, host and
+// content-length are set by the request itself
+var defaultAllowedHeaders = map[string]struct{}{
+	"authorization": {},
+}
+
+// response headers not sent to client when allowed_client_headers not set
+var skippedClientHeaders = map[string]struct{}{
+	"host":              {},
+	"content-length":    {},
+	"transfer-encoding": {},
+}
+
+type listMatcher []router.StringMatcher
+
+func newListMatcher(c *envoy_type_matcher_v3.ListStringMatcher) (listMatcher, error) {
+	var lm listMatcher
+	for _, p := range c.GetPatterns() {
+		m, err := router.NewStringMatcher(p)
+		if err != nil {
+			return nil, err
+		}
+		lm = append(lm, m)
+	}
+	return lm, nil
+}
+
+func (lm listMatcher) match(s string) bool {
+	for _, m := range lm {
+		if m.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClient call the http authorization service of server_uri cluster by its connection pool,
+// the request is ok if the service responds 200, otherwise denied
+type httpClient struct {
+	*envoy_extensions_filters_http_ext_authz_v3.HttpService
+	cm                   api.ClusterManager
+	allowedHeaders       listMatcher
+	upstreamHeaders      listMatcher
+	upstreamAppend       listMatcher
+	clientHeaders        listMatcher
+	clientHeadersSuccess listMatcher
+}
+
+func newHTTPClient(c *envoy_extensions_filters_http_ext_authz_v3.HttpService, cm api.ClusterManager) (*httpClient, error) {
+	hc := &httpClient{
+		HttpService: c,
+		cm:          cm,
+	}
+	var err error
+	if hc.allowedHeaders, err = newListMatcher(c.GetAuthorizationRequest().GetAllowedHeaders()); err != nil {
+		return nil, err
+	}
+	rsp := c.GetAuthorizationResponse()
+	if hc.upstreamHeaders, err = newListMatcher(rsp.GetAllowedUpstreamHeaders()); err != nil {
+		return nil, err
+	}
+	if hc.upstreamAppend, err = newListMatcher(rsp.GetAllowedUpstreamHeadersToAppend()); err != nil {
+		return nil, err
+	}
+	if hc.clientHeaders, err = newListMatcher(rsp.GetAllowedClientHeaders()); err != nil {
+		return nil, err
+	}
+	if hc.clientHeadersSuccess, err = newListMatcher(rsp.GetAllowedClientHeadersOnSuccess()); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+func (c *httpClient) check(ctx context.Context, lbCtx api.LoadBalancerContext,
+	req *envoy_service_auth_v3.CheckRequest) (*checkResponse, error) {
+	name := c.GetServerUri().GetCluster()
+	host, err := cluster.SelectHost(c.cm, name, lbCtx)
+	if err != nil {
+		return nil, err
+	}
+	cl := c.cm.GetCluster(name)
+	if cl == nil {
+		return nil, fmt.Errorf("not found cluster: %s", name)
+	}
+	addr := host.Address().String()
+
+	attrs := req.GetAttributes().GetRequest().GetHttp()
+	request := fasthttp.AcquireRequest()
+	response := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(request)
+		fasthttp.ReleaseResponse(response)
+	}()
+
+	request.Header.DisableNormalizing()
+	request.Header.SetMethod(attrs.GetMethod())
+	request.SetRequestURI("http://" + addr + c.GetPathPrefix() + attrs.GetPath())
+	request.UseHostHeader = true
+	request.Header.SetHost(attrs.GetHost())
+	for k, v := range attrs.GetHeaders() {
+		if _, ok := defaultAllowedHeaders[k]; ok || c.allowedHeaders.match(k) {
+			request.Header.Set(k, v)
+		}
+	}
+	for _, h := range c.GetAuthorizationRequest().GetHeadersToAdd() {
+		request.Header.Set(h.GetKey(), h.GetValue())
+	}
+	if body := attrs.GetRawBody(); len(body) > 0 {
+		request.SetBody(body)
+	} else if attrs.GetBody() != "" {
+		request.SetBodyString(attrs.GetBody())
+	}
+
+	// the timeout of ctx is set by the filter
+	stream := http.NewStreamContext(ctx, http.NewStreamInfo(nil, "HTTP/1.1"), request, response)
+	if err := cl.ConnPool(api.PriorityDefault).Call(stream); err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode() == fasthttp.StatusOK {
+		result := &checkResponse{status: checkOK}
+		response.Header.VisitAll(func(key, value []byte) {
+			k := strings.ToLower(string(key))
+			hv := headerValue{k, string(value)}
+			if c.upstreamHeaders.match(k) {
+				result.headersToSet = append(result.headersToSet, hv)
+			}
+			if c.upstreamAppend.match(k) {
+				result.headersToAppend = append(result.headersToAppend, hv)
+			}
+			if c.clientHeadersSuccess.match(k) {
+				result.responseHeaders = append(result.responseHeaders, hv)
+			}
+		})
+		return result, nil
+	}
+
+	result := &checkResponse{
+		status:     checkDenied,
+		httpStatus: response.StatusCode(),
+		body:       string(response.Body()),
+	}
+	response.Header.VisitAll(func(key, value []byte) {
+		k := strings.ToLower(string(key))
+		if len(c.clientHeaders) == 0 {
+			if _, ok := skippedClientHeaders[k]; ok {
+				return
+			}
+		} else if !c.clientHeaders.match(k) {
+			return
+		}
+		result.responseHeaders = append(result.responseHeaders, headerValue{k, string(value)})
+	})
+	return result, nil
+}
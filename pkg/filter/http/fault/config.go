@@ -0,0 +1,212 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fault
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	envoy_extensions_filters_common_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/common/fault/v3"
+	envoy_extensions_filters_http_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/router"
+	"github.com/wereliang/govoy/pkg/utils"
+)
+
+// headers to control the faults, only work with header_delay, header_abort and header_limit
+const (
+	headerDelayRequest                 = "x-envoy-fault-delay-request"
+	headerDelayRequestPercentage       = "x-envoy-fault-delay-request-percentage"
+	headerAbortRequest                 = "x-envoy-fault-abort-request"
+	headerAbortGrpcRequest             = "x-envoy-fault-abort-grpc-request"
+	headerAbortRequestPercentage       = "x-envoy-fault-abort-request-percentage"
+	headerThroughputResponse           = "x-envoy-fault-throughput-response"
+	headerThroughputResponsePercentage = "x-envoy-fault-throughput-response-percentage"
+	headerDownstreamServiceNode        = "x-envoy-downstream-service-node"
+)
+
+// faultConfig is built from envoy.extensions.filters.http.fault.v3.HTTPFault
+type faultConfig struct {
+	*envoy_extensions_filters_http_fault_v3.HTTPFault
+	headers         []router.HeaderMatcher
+	downstreamNodes map[string]struct{}
+}
+
+func newFaultConfig(pb proto.Message) (*faultConfig, error) {
+	c := &faultConfig{HTTPFault: pb.(*envoy_extensions_filters_http_fault_v3.HTTPFault)}
+	for _, h := range c.GetHeaders() {
+		m, err := router.NewHeaderMatcher(h)
+		if err != nil {
+			return nil, err
+		}
+		c.headers = append(c.headers, m)
+	}
+	if nodes := c.GetDownstreamNodes(); len(nodes) > 0 {
+		c.downstreamNodes = make(map[string]struct{})
+		for _, n := range nodes {
+			c.downstreamNodes[n] = struct{}{}
+		}
+	}
+	return c, nil
+}
+
+// match checks upstream cluster, downstream nodes and headers
+func (c *faultConfig) match(ctx api.StreamContext, entry api.RouteEntry) bool {
+	if cluster := c.GetUpstreamCluster(); cluster != "" && (entry == nil || entry.ClusterName() != cluster) {
+		return false
+	}
+	header := ctx.Request().Header()
+	if c.downstreamNodes != nil {
+		if _, ok := c.downstreamNodes[string(header.Get(headerDownstreamServiceNode))]; !ok {
+			return false
+		}
+	}
+	for _, m := range c.headers {
+		if !m.Match(header) {
+			return false
+		}
+	}
+	return true
+}
+
+// tryIncActiveFaults returns false if max_active_faults is reached
+func (c *faultConfig) tryIncActiveFaults(activeFaults *int64) bool {
+	max := c.GetMaxActiveFaults()
+	for {
+		n := atomic.LoadInt64(activeFaults)
+		if max != nil && n >= int64(max.GetValue()) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(activeFaults, n, n+1) {
+			return true
+		}
+	}
+}
+
+// percentage returns the configured percentage, whose numerator can be lowered by header
+func percentage(header api.HeaderMap, name string, fp *envoy_type_v3.FractionalPercent) *envoy_type_v3.FractionalPercent {
+	v := header.Get(name)
+	if v == nil {
+		return fp
+	}
+	n, err := strconv.ParseUint(string(v), 10, 32)
+	if err != nil || uint32(n) >= fp.GetNumerator() {
+		return fp
+	}
+	return &envoy_type_v3.FractionalPercent{Numerator: uint32(n), Denominator: fp.GetDenominator()}
+}
+
+func headerUint(header api.HeaderMap, name string) (uint64, bool) {
+	v := header.Get(name)
+	if v == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(v), 10, 64)
+	return n, err == nil
+}
+
+// delay returns the delay duration, 0 if no delay
+func (c *faultConfig) delay(header api.HeaderMap) time.Duration {
+	d := c.GetDelay()
+	if d == nil {
+		return 0
+	}
+	var duration time.Duration
+	fp := d.GetPercentage()
+	switch d.GetFaultDelaySecifier().(type) {
+	case *envoy_extensions_filters_common_fault_v3.FaultDelay_FixedDelay:
+		duration = d.GetFixedDelay().AsDuration()
+	case *envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay_:
+		ms, ok := headerUint(header, headerDelayRequest)
+		if !ok {
+			return 0
+		}
+		duration = time.Duration(ms) * time.Millisecond
+		fp = percentage(header, headerDelayRequestPercentage, fp)
+	}
+	if duration <= 0 || !utils.FractionalPercentSample(fp) {
+		return 0
+	}
+	return duration
+}
+
+// abort returns the http status and grpc status(-1 if not grpc), 0 http status means no abort
+func (c *faultConfig) abort(header api.HeaderMap) (int, int) {
+	a := c.GetAbort()
+	if a == nil {
+		return 0, -1
+	}
+	httpStatus, grpcStatus := 0, -1
+	fp := a.GetPercentage()
+	switch a.GetErrorType().(type) {
+	case *envoy_extensions_filters_http_fault_v3.FaultAbort_HttpStatus:
+		httpStatus = int(a.GetHttpStatus())
+	case *envoy_extensions_filters_http_fault_v3.FaultAbort_GrpcStatus:
+		grpcStatus = int(a.GetGrpcStatus())
+	case *envoy_extensions_filters_http_fault_v3.FaultAbort_HeaderAbort_:
+		if code, ok := headerUint(header, headerAbortRequest); ok && code >= 200 && code < 600 {
+			httpStatus = int(code)
+		} else if code, ok := headerUint(header, headerAbortGrpcRequest); ok {
+			grpcStatus = int(code)
+		}
+		fp = percentage(header, headerAbortRequestPercentage, fp)
+	}
+	if grpcStatus >= 0 {
+		// grpc error is returned with http 200
+		httpStatus = 200
+	}
+	if httpStatus == 0 || !utils.FractionalPercentSample(fp) {
+		return 0, -1
+	}
+	return httpStatus, grpcStatus
+}
+
+// rateLimitKbps returns the response rate limit, 0 if no limit
+func (c *faultConfig) rateLimitKbps(header api.HeaderMap) uint64 {
+	rl := c.GetResponseRateLimit()
+	if rl == nil {
+		return 0
+	}
+	var kbps uint64
+	fp := rl.GetPercentage()
+	switch rl.GetLimitType().(type) {
+	case *envoy_extensions_filters_common_fault_v3.FaultRateLimit_FixedLimit_:
+		kbps = rl.GetFixedLimit().GetLimitKbps()
+	case *envoy_extensions_filters_common_fault_v3.FaultRateLimit_HeaderLimit_:
+		v, ok := headerUint(header, headerThroughputResponse)
+		if !ok {
+			return 0
+		}
+		kbps = v
+		fp = percentage(header, headerThroughputResponsePercentage, fp)
+	}
+	if kbps == 0 || !utils.FractionalPercentSample(fp) {
+		return 0
+	}
+	return kbps
+}
@@ -0,0 +1,179 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package fault
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	envoy_extensions_filters_http_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+)
+
+const abortBody = "fault filter abort"
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(FaultFactory))
+}
+
+// Fault inject delay, abort and response rate limit
+type Fault struct {
+	filter.PassThroughFilter
+	config *faultConfig
+	// active faults of the filter config, the max_active_faults of per route config also check it
+	activeFaults *int64
+	// active is whether the stream holds an active fault, with the sampled abort status and
+	// response rate limit of it. They are reset by DecodeHeaders of each stream.
+	active     bool
+	httpStatus int
+	grpcStatus int
+	kbps       uint64
+}
+
+func (f *Fault) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	f.active, f.httpStatus, f.grpcStatus, f.kbps = false, 0, -1, 0
+
+	config := f.config
+	entry := f.DecoderCallbacks.RouteEntry()
+	if entry != nil {
+		if c, ok := entry.PerFilterConfig(filter.HTTP_Fault).(*faultConfig); ok {
+			config = c
+		}
+	}
+	if !config.match(ctx, entry) {
+		return api.Continue
+	}
+
+	// only the stream with any fault applied takes the active fault
+	header := ctx.Request().Header()
+	delay := config.delay(header)
+	httpStatus, grpcStatus := config.abort(header)
+	kbps := config.rateLimitKbps(header)
+	if delay == 0 && httpStatus == 0 && kbps == 0 {
+		return api.Continue
+	}
+	if !config.tryIncActiveFaults(f.activeFaults) {
+		return api.Continue
+	}
+	f.active, f.httpStatus, f.grpcStatus, f.kbps = true, httpStatus, grpcStatus, kbps
+
+	if delay > 0 {
+		ctx.StreamInfo().SetResponseFlag(api.DelayInjected)
+		dispatcher := f.DecoderCallbacks.Dispatcher()
+		go func() {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				// abort after delay
+				dispatcher.Post(func() {
+					if !f.maybeAbort(ctx) {
+						f.DecoderCallbacks.ContinueDecoding()
+					}
+				})
+			case <-ctx.Context().Done():
+			}
+		}()
+		return api.StopIteration
+	}
+	if f.maybeAbort(ctx) {
+		return api.StopIteration
+	}
+	return api.Continue
+}
+
+func (f *Fault) maybeAbort(ctx api.StreamContext) bool {
+	if f.httpStatus == 0 {
+		return false
+	}
+	ctx.StreamInfo().SetResponseFlag(api.FaultInjected)
+	f.DecoderCallbacks.SendLocalReply(f.httpStatus, abortBody, "fault_filter_abort")
+	return true
+}
+
+func (f *Fault) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if f.grpcStatus >= 0 && ctx.StreamInfo().HasResponseFlag(api.FaultInjected) {
+		header := ctx.Response().Header()
+		header.Set("content-type", "application/grpc")
+		header.Set("grpc-status", strconv.Itoa(f.grpcStatus))
+		header.Set("grpc-message", abortBody)
+	}
+	return api.Continue
+}
+
+// EncodeData the response is written at once, so the rate limit is simulated by delaying
+// the response for the time of sending body at the limited rate
+func (f *Fault) EncodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	if f.kbps == 0 {
+		return api.Continue
+	}
+	delay := time.Duration(uint64(len(data.Bytes())) * uint64(time.Second) / (f.kbps * 1024))
+	if delay <= 0 {
+		return api.Continue
+	}
+	dispatcher := f.EncoderCallbacks.Dispatcher()
+	time.AfterFunc(delay, func() { dispatcher.Post(f.EncoderCallbacks.ContinueEncoding) })
+	return api.StopIteration
+}
+
+// Log is called when stream is complete, to release the active fault
+func (f *Fault) Log(ctx api.StreamContext) {
+	if f.active {
+		atomic.AddInt64(f.activeFaults, -1)
+		f.active = false
+	}
+}
+
+type FaultFactory struct {
+}
+
+func (f *FaultFactory) Name() string {
+	return filter.HTTP_Fault
+}
+
+func (f *FaultFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_fault_v3.HTTPFault{}
+}
+
+func (f *FaultFactory) CreateRouteSpecificFilterConfig(pb proto.Message) (interface{}, error) {
+	return newFaultConfig(pb)
+}
+
+func (f *FaultFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newFaultConfig(pb)
+	if err != nil {
+		panic(err)
+	}
+	activeFaults := new(int64)
+	return func(cb api.HTTPFilterManager) {
+		fault := &Fault{config: config, activeFaults: activeFaults}
+		cb.AddDecodeFilter(fault)
+		cb.AddEncodeFilter(fault)
+		cb.AddAccessLog(fault)
+	}
+}
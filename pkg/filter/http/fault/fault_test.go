@@ -0,0 +1,129 @@
+package fault
+
+import (
+	"testing"
+	"time"
+
+	envoy_extensions_filters_common_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/common/fault/v3"
+	envoy_extensions_filters_http_fault_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+var percent100 = &envoy_type_v3.FractionalPercent{Numerator: 100}
+
+func newTestHandler(t *testing.T, config *envoy_extensions_filters_http_fault_v3.HTTPFault) http.Handler {
+	routeFault, err := ptypes.MarshalAny(&envoy_extensions_filters_http_fault_v3.HTTPFault{
+		Abort: &envoy_extensions_filters_http_fault_v3.FaultAbort{
+			ErrorType:  &envoy_extensions_filters_http_fault_v3.FaultAbort_GrpcStatus{GrpcStatus: 14},
+			Percentage: percent100,
+		},
+	})
+	assert.NoError(t, err)
+
+	rc := filtertest.RouteConfig("ratings",
+		filtertest.Route("/grpc", "ratings", map[string]*any.Any{filter.HTTP_Fault: routeFault}),
+		filtertest.Route("/", "ratings", nil),
+	)
+	return filtertest.NewHandler(rc, new(FaultFactory).CreateFilterFactory(config, nil))
+}
+
+func newTestContext(path string, headers map[string]string) api.StreamContext {
+	return filtertest.NewContext("http://ratings"+path, headers)
+}
+
+func TestFaultAbort(t *testing.T) {
+	handler := newTestHandler(t, &envoy_extensions_filters_http_fault_v3.HTTPFault{
+		Abort: &envoy_extensions_filters_http_fault_v3.FaultAbort{
+			ErrorType:  &envoy_extensions_filters_http_fault_v3.FaultAbort_HttpStatus{HttpStatus: 503},
+			Percentage: percent100,
+		},
+		UpstreamCluster: "ratings",
+	})
+
+	ctx := newTestContext("/ratings", nil)
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, 503, ctx.Response().Header().StatusCode())
+	assert.Equal(t, abortBody, string(ctx.Response().Body().Bytes()))
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.FaultInjected))
+	handler.OnComplete(ctx)
+
+	// per route config overrides
+	ctx = newTestContext("/grpc", nil)
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "14", string(ctx.Response().Header().Get("grpc-status")))
+	handler.OnComplete(ctx)
+}
+
+func TestFaultHeaderDelay(t *testing.T) {
+	handler := newTestHandler(t, &envoy_extensions_filters_http_fault_v3.HTTPFault{
+		Delay: &envoy_extensions_filters_common_fault_v3.FaultDelay{
+			FaultDelaySecifier: &envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay_{
+				HeaderDelay: &envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay{}},
+			Percentage: percent100,
+		},
+		MaxActiveFaults: &wrappers.UInt32Value{Value: 1},
+	})
+
+	ctx := newTestContext("/ratings", map[string]string{headerDelayRequest: "50"})
+	start := time.Now()
+	assert.NoError(t, handler.Handle(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.DelayInjected))
+	handler.OnComplete(ctx)
+
+	// percentage header 0 disables the delay
+	ctx = newTestContext("/ratings", map[string]string{headerDelayRequest: "50", headerDelayRequestPercentage: "0"})
+	assert.NoError(t, handler.Handle(ctx))
+	assert.False(t, ctx.StreamInfo().HasResponseFlag(api.DelayInjected))
+	handler.OnComplete(ctx)
+
+	// no delay without header
+	ctx = newTestContext("/ratings", nil)
+	assert.NoError(t, handler.Handle(ctx))
+	assert.False(t, ctx.StreamInfo().HasResponseFlag(api.DelayInjected))
+}
+
+func TestMaxActiveFaults(t *testing.T) {
+	config, err := newFaultConfig(&envoy_extensions_filters_http_fault_v3.HTTPFault{
+		MaxActiveFaults: &wrappers.UInt32Value{Value: 1},
+	})
+	assert.NoError(t, err)
+	activeFaults := new(int64)
+	assert.True(t, config.tryIncActiveFaults(activeFaults))
+	assert.False(t, config.tryIncActiveFaults(activeFaults))
+}
+
+func TestMaxActiveFaultsNotApplied(t *testing.T) {
+	handler := newTestHandler(t, &envoy_extensions_filters_http_fault_v3.HTTPFault{
+		Delay: &envoy_extensions_filters_common_fault_v3.FaultDelay{
+			FaultDelaySecifier: &envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay_{
+				HeaderDelay: &envoy_extensions_filters_common_fault_v3.FaultDelay_HeaderDelay{}},
+			Percentage: percent100,
+		},
+		MaxActiveFaults: &wrappers.UInt32Value{Value: 1},
+	})
+
+	// the stream without delay header doesn't take the only active fault
+	idle := newTestContext("/ratings", nil)
+	assert.NoError(t, handler.Handle(idle))
+	assert.False(t, idle.StreamInfo().HasResponseFlag(api.DelayInjected))
+
+	ctx := newTestContext("/ratings", map[string]string{headerDelayRequest: "10"})
+	filtertest.Handle(t, handler, ctx)
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.DelayInjected))
+	handler.OnComplete(idle)
+
+	// the active fault is released when the faulted stream is complete
+	ctx = newTestContext("/ratings", map[string]string{headerDelayRequest: "10"})
+	filtertest.Handle(t, handler, ctx)
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.DelayInjected))
+}
@@ -0,0 +1,175 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package istio_stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	udpa_type_v1 "github.com/cncf/xds/go/udpa/type/v1"
+	envoy_extensions_filters_http_wasm_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/wasm/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/istio"
+	"github.com/wereliang/govoy/pkg/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	stats "istio.io/api/envoy/extensions/stats"
+)
+
+const (
+	reporterSource      = "source"
+	reporterDestination = "destination"
+
+	// the root id of istio's wasm stats filter decides the reporter
+	rootIDInbound  = "stats_inbound"
+	rootIDOutbound = "stats_outbound"
+
+	defaultStatPrefix = "istio"
+)
+
+type metric int
+
+const (
+	requestsTotal metric = iota
+	requestDurationMilliseconds
+	requestBytes
+	responseBytes
+	metricCount
+)
+
+var metricNames = [metricCount]string{
+	"requests_total", "request_duration_milliseconds", "request_bytes", "response_bytes",
+}
+
+// metricConfig is the override of a standard metric
+type metricConfig struct {
+	name    string
+	drop    bool
+	removed map[string]bool
+}
+
+type statsConfig struct {
+	// reporter is decided by the cluster of route if empty
+	reporter                  string
+	disableHostHeaderFallback bool
+	local                     *istio.Workload
+	metrics                   [metricCount]*metricConfig
+}
+
+func newStatsConfig(c *stats.PluginConfig, reporter string, local *istio.Workload) *statsConfig {
+	config := &statsConfig{
+		reporter:                  reporter,
+		disableHostHeaderFallback: c.GetDisableHostHeaderFallback(),
+		local:                     local,
+	}
+	prefix := c.GetStatPrefix()
+	if prefix == "" {
+		prefix = defaultStatPrefix
+	}
+	for i, name := range metricNames {
+		config.metrics[i] = &metricConfig{name: prefix + "_" + name, removed: make(map[string]bool)}
+	}
+	for _, m := range c.GetMetrics() {
+		if len(m.GetDimensions()) > 0 {
+			log.Warn("dimensions of istio stats metric are not supported")
+		}
+		for i, name := range metricNames {
+			if m.GetName() != "" && m.GetName() != name {
+				continue
+			}
+			config.metrics[i].drop = m.GetDrop()
+			for _, tag := range m.GetTagsToRemove() {
+				config.metrics[i].removed[tag] = true
+			}
+		}
+	}
+	if len(c.GetDefinitions()) > 0 {
+		log.Warn("definitions of istio stats are not supported")
+	}
+	return config
+}
+
+// parseConfig returns the plugin config and reporter of the filter config, which is stats.PluginConfig,
+// or the wasm config of istio whose configuration is the json of stats.PluginConfig
+func parseConfig(pb proto.Message) (*stats.PluginConfig, string, error) {
+	switch c := pb.(type) {
+	case nil:
+		return &stats.PluginConfig{}, "", nil
+	case *stats.PluginConfig:
+		return c, "", nil
+	case *udpa_type_v1.TypedStruct:
+		if !strings.HasSuffix(c.GetTypeUrl(), "envoy.extensions.filters.http.wasm.v3.Wasm") {
+			return nil, "", fmt.Errorf("invalid typed struct %s", c.GetTypeUrl())
+		}
+		b, err := protojson.Marshal(c.GetValue())
+		if err != nil {
+			return nil, "", err
+		}
+		wasm := &envoy_extensions_filters_http_wasm_v3.Wasm{}
+		if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, wasm); err != nil {
+			return nil, "", err
+		}
+		return parseConfig(wasm)
+	case *envoy_extensions_filters_http_wasm_v3.Wasm:
+		reporter := ""
+		switch c.GetConfig().GetRootId() {
+		case rootIDInbound:
+			reporter = reporterDestination
+		case rootIDOutbound:
+			reporter = reporterSource
+		}
+		config := &stats.PluginConfig{}
+		if a := c.GetConfig().GetConfiguration(); a != nil {
+			s := &wrapperspb.StringValue{}
+			if err := a.UnmarshalTo(s); err != nil {
+				return nil, "", err
+			}
+			if err := unmarshalPluginConfig(s.GetValue(), config); err != nil {
+				return nil, "", err
+			}
+		}
+		return config, reporter, nil
+	default:
+		return nil, "", fmt.Errorf("invalid istio stats config %T", pb)
+	}
+}
+
+// unmarshalPluginConfig ignores the deprecated debug field, which is "false" string in istio's config
+func unmarshalPluginConfig(s string, config *stats.PluginConfig) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return err
+	}
+	delete(m, "debug")
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, config)
+}
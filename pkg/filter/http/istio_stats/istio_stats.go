@@ -0,0 +1,239 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package istio_stats
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/istio"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+const (
+	unknown = "unknown"
+
+	// the cluster names of istio are like inbound|9080|| and outbound|9080|v1|reviews.default.svc.cluster.local
+	inboundClusterPrefix  = "inbound|"
+	outboundClusterPrefix = "outbound|"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(IstioStatsFactory))
+}
+
+func valueOrUnknown(s string) string {
+	if s == "" {
+		return unknown
+	}
+	return s
+}
+
+// headerSize returns the size of headers in wire format
+func headerSize(header api.HeaderMap) int {
+	size := 0
+	header.VisitAll(func(key, value []byte) {
+		size += len(key) + len(value) + 4
+	})
+	return size
+}
+
+// peerPrincipal returns the uri san of downstream certificate, empty if not mtls
+func peerPrincipal(conn api.Connection) string {
+	if conn == nil {
+		return ""
+	}
+	if tlsConn, ok := conn.Raw().(*tls.Conn); ok {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 && len(certs[0].URIs) > 0 {
+			return certs[0].URIs[0].String()
+		}
+	}
+	return ""
+}
+
+// IstioStats records istio standard metrics when the stream is complete. The source and destination
+// workloads are the local node and the peer exchanged by istio.metadata_exchange.
+type IstioStats struct {
+	filter.PassThroughDecoderFilter
+	config *statsConfig
+	store  *stats.Store
+}
+
+// destinationService returns the service host, name and namespace from the outbound cluster name,
+// or the host header if fallback is enabled
+func (s *IstioStats) destinationService(ctx api.StreamContext, cluster string, namespace string) (string, string, string) {
+	host := ""
+	if strings.HasPrefix(cluster, outboundClusterPrefix) {
+		if parts := strings.SplitN(cluster, "|", 4); len(parts) == 4 {
+			host = parts[3]
+		}
+	}
+	if host == "" && !s.config.disableHostHeaderFallback {
+		host = string(ctx.Request().Header().Host())
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	if host == "" {
+		return "", "", ""
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) > 1 {
+		namespace = labels[1]
+	}
+	return host, labels[0], namespace
+}
+
+func (s *IstioStats) tags(ctx api.StreamContext) []stats.Tag {
+	info := ctx.StreamInfo()
+	cluster := ""
+	if re := info.RouteEntry(); re != nil {
+		cluster = re.ClusterName()
+	}
+	reporter := s.config.reporter
+	if reporter == "" {
+		reporter = reporterSource
+		if strings.HasPrefix(cluster, inboundClusterPrefix) {
+			reporter = reporterDestination
+		}
+	}
+
+	var source, destination *istio.Workload
+	var sourcePrincipal, securityPolicy string
+	if reporter == reporterDestination {
+		_, metadata := istio.DownstreamPeer(info)
+		source, destination = istio.NewWorkload(metadata), s.config.local
+		sourcePrincipal = peerPrincipal(s.DecoderCallbacks.Connection())
+		securityPolicy = "none"
+		if sourcePrincipal != "" {
+			securityPolicy = "mutual_tls"
+		}
+	} else {
+		_, metadata := istio.UpstreamPeer(info)
+		source, destination = s.config.local, istio.NewWorkload(metadata)
+	}
+	service, serviceName, serviceNamespace := s.destinationService(ctx, cluster, destination.Namespace)
+
+	protocol, grpcStatus := "http", ""
+	if strings.HasPrefix(string(ctx.Request().Header().Get("content-type")), "application/grpc") {
+		protocol = "grpc"
+		grpcStatus = string(ctx.Response().Header().Get("grpc-status"))
+	}
+	flags := info.ResponseFlags().String()
+	if flags == "" {
+		flags = "-"
+	}
+
+	return []stats.Tag{
+		{Name: "reporter", Value: reporter},
+		{Name: "source_workload", Value: valueOrUnknown(source.Name)},
+		{Name: "source_canonical_service", Value: valueOrUnknown(source.CanonicalService)},
+		{Name: "source_canonical_revision", Value: valueOrUnknown(source.CanonicalRevision)},
+		{Name: "source_workload_namespace", Value: valueOrUnknown(source.Namespace)},
+		{Name: "source_principal", Value: valueOrUnknown(sourcePrincipal)},
+		{Name: "source_app", Value: valueOrUnknown(source.App)},
+		{Name: "source_version", Value: valueOrUnknown(source.Version)},
+		{Name: "source_cluster", Value: valueOrUnknown(source.Cluster)},
+		{Name: "destination_workload", Value: valueOrUnknown(destination.Name)},
+		{Name: "destination_workload_namespace", Value: valueOrUnknown(destination.Namespace)},
+		{Name: "destination_principal", Value: unknown},
+		{Name: "destination_app", Value: valueOrUnknown(destination.App)},
+		{Name: "destination_version", Value: valueOrUnknown(destination.Version)},
+		{Name: "destination_service", Value: valueOrUnknown(service)},
+		{Name: "destination_canonical_service", Value: valueOrUnknown(destination.CanonicalService)},
+		{Name: "destination_canonical_revision", Value: valueOrUnknown(destination.CanonicalRevision)},
+		{Name: "destination_service_name", Value: valueOrUnknown(serviceName)},
+		{Name: "destination_service_namespace", Value: valueOrUnknown(serviceNamespace)},
+		{Name: "destination_cluster", Value: valueOrUnknown(destination.Cluster)},
+		{Name: "request_protocol", Value: protocol},
+		{Name: "response_code", Value: strconv.Itoa(ctx.Response().Header().StatusCode())},
+		{Name: "grpc_response_status", Value: grpcStatus},
+		{Name: "response_flags", Value: flags},
+		{Name: "connection_security_policy", Value: valueOrUnknown(securityPolicy)},
+	}
+}
+
+// metricTags returns the tags of metric without the removed
+func (c *metricConfig) metricTags(tags []stats.Tag) []stats.Tag {
+	if len(c.removed) == 0 {
+		return tags
+	}
+	filtered := make([]stats.Tag, 0, len(tags))
+	for _, t := range tags {
+		if !c.removed[t.Name] {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// Log is called when stream is complete
+func (s *IstioStats) Log(ctx api.StreamContext) {
+	tags := s.tags(ctx)
+	metrics := s.config.metrics
+	if m := metrics[requestsTotal]; !m.drop {
+		s.store.Counter(m.name, m.metricTags(tags)).Inc()
+	}
+	values := [metricCount]float64{
+		requestDurationMilliseconds: float64(ctx.StreamInfo().Duration().Milliseconds()),
+		requestBytes:                float64(headerSize(ctx.Request().Header()) + len(ctx.Request().Body().Bytes())),
+		responseBytes:               float64(headerSize(ctx.Response().Header()) + len(ctx.Response().Body().Bytes())),
+	}
+	for _, i := range []metric{requestDurationMilliseconds, requestBytes, responseBytes} {
+		if m := metrics[i]; !m.drop {
+			s.store.Histogram(m.name, m.metricTags(tags)).Record(values[i])
+		}
+	}
+}
+
+// IstioStatsFactory is registered by name only, the config is stats.PluginConfig or istio's wasm config
+type IstioStatsFactory struct {
+}
+
+func (f *IstioStatsFactory) Name() string {
+	return filter.HTTP_IstioStats
+}
+
+func (f *IstioStatsFactory) CreateEmptyConfigProto() proto.Message {
+	return nil
+}
+
+func (f *IstioStatsFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	c, reporter, err := parseConfig(pb)
+	if err != nil {
+		panic(err)
+	}
+	config := newStatsConfig(c, reporter, istio.NewWorkload(istio.LocalMetadata(context.LocalNode())))
+	return func(cb api.HTTPFilterManager) {
+		s := &IstioStats{config: config, store: stats.DefaultStore}
+		cb.AddDecodeFilter(s)
+		cb.AddAccessLog(s)
+	}
+}
@@ -0,0 +1,126 @@
+package istio_stats
+
+import (
+	"bytes"
+	"testing"
+
+	udpa_type_v1 "github.com/cncf/xds/go/udpa/type/v1"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/istio"
+	"github.com/wereliang/govoy/pkg/router"
+	"github.com/wereliang/govoy/pkg/stats"
+	"google.golang.org/protobuf/types/known/structpb"
+	istio_stats "istio.io/api/envoy/extensions/stats"
+)
+
+type testFactoryContext struct {
+	api.FactoryContext
+}
+
+func (c *testFactoryContext) LocalNode() *envoy_config_core_v3.Node {
+	metadata, _ := structpb.NewStruct(map[string]interface{}{
+		"WORKLOAD_NAME": "productpage-v1",
+		"NAMESPACE":     "default",
+		"CLUSTER_ID":    "Kubernetes",
+		"LABELS":        map[string]interface{}{"app": "productpage", "version": "v1"},
+	})
+	return &envoy_config_core_v3.Node{Id: "sidecar~10.0.0.1~productpage-v1.default", Metadata: metadata}
+}
+
+func peerMetadata(t *testing.T) *structpb.Struct {
+	metadata, err := structpb.NewStruct(map[string]interface{}{
+		"WORKLOAD_NAME": "reviews-v2",
+		"NAMESPACE":     "default",
+		"LABELS": map[string]interface{}{
+			"app": "reviews", "version": "v2", "service.istio.io/canonical-name": "reviews-svc"},
+	})
+	assert.NoError(t, err)
+	return metadata
+}
+
+var routeMatcher = router.NewRouterMatcher(filtertest.RouteConfig("reviews",
+	filtertest.Route("/inbound", "inbound|9080||", nil),
+	filtertest.Route("/", "outbound|9080|v2|reviews.default.svc.cluster.local", nil),
+))
+
+func newTestHandler(pb proto.Message) http.Handler {
+	return filtertest.NewHandler(nil, new(IstioStatsFactory).CreateFilterFactory(pb, &testFactoryContext{}))
+}
+
+func handle(t *testing.T, handler http.Handler, path string, setPeer func(api.StreamInfo, string, *structpb.Struct)) {
+	ctx := filtertest.NewContext(path, map[string]string{"host": "reviews:9080"})
+	ctx.Response().Raw().(*fasthttp.Response).SetBodyString("reviews")
+	// the route entry is set by router filter
+	ctx.StreamInfo().SetRouteEntry(routeMatcher.Match(ctx.Request().Header()))
+	setPeer(ctx.StreamInfo(), "", peerMetadata(t))
+	filtertest.Handle(t, handler, ctx)
+}
+
+func dump() string {
+	var b bytes.Buffer
+	stats.DefaultStore.WriteText(&b)
+	return b.String()
+}
+
+func TestIstioStats(t *testing.T) {
+	handler := newTestHandler(nil)
+	handle(t, handler, "/reviews/0", istio.SetUpstreamPeer)
+	out := dump()
+	assert.Contains(t, out, `istio_requests_total{reporter="source",source_workload="productpage-v1",`+
+		`source_canonical_service="productpage",source_canonical_revision="v1",source_workload_namespace="default",`+
+		`source_principal="unknown",source_app="productpage",source_version="v1",source_cluster="Kubernetes",`+
+		`destination_workload="reviews-v2",destination_workload_namespace="default",destination_principal="unknown",`+
+		`destination_app="reviews",destination_version="v2",destination_service="reviews.default.svc.cluster.local",`+
+		`destination_canonical_service="reviews-svc",destination_canonical_revision="v2",`+
+		`destination_service_name="reviews",destination_service_namespace="default",destination_cluster="unknown",`+
+		`request_protocol="http",response_code="200",grpc_response_status="",response_flags="-",`+
+		`connection_security_policy="unknown"}: 1`)
+	assert.Contains(t, out, `istio_request_duration_milliseconds{reporter="source",`)
+	assert.Contains(t, out, `istio_request_bytes{reporter="source",`)
+	assert.Contains(t, out, `istio_response_bytes{reporter="source",`)
+
+	// inbound, the destination service falls back to host header
+	handle(t, handler, "/inbound", istio.SetDownstreamPeer)
+	out = dump()
+	assert.Contains(t, out, `istio_requests_total{reporter="destination",source_workload="reviews-v2",`)
+	assert.Contains(t, out, `destination_workload="productpage-v1",destination_workload_namespace="default",`+
+		`destination_principal="unknown",destination_app="productpage",destination_version="v1",`+
+		`destination_service="reviews",destination_canonical_service="productpage",destination_canonical_revision="v1",`+
+		`destination_service_name="reviews",destination_service_namespace="default",destination_cluster="Kubernetes",`)
+	assert.Contains(t, out, `connection_security_policy="none"}: 1`)
+}
+
+func TestIstioStatsWasmConfig(t *testing.T) {
+	value, err := structpb.NewStruct(map[string]interface{}{
+		"config": map[string]interface{}{
+			"root_id": "stats_inbound",
+			"configuration": map[string]interface{}{
+				"@type": "type.googleapis.com/google.protobuf.StringValue",
+				"value": `{"debug": "false", "stat_prefix": "wasm", "metrics": [` +
+					`{"name": "requests_total", "tags_to_remove": ["source_principal", "response_flags"]},` +
+					`{"name": "request_bytes", "drop": true}]}`,
+			},
+		},
+	})
+	assert.NoError(t, err)
+	handler := newTestHandler(&udpa_type_v1.TypedStruct{
+		TypeUrl: "type.googleapis.com/envoy.extensions.filters.http.wasm.v3.Wasm", Value: value})
+	handle(t, handler, "/reviews/0", istio.SetDownstreamPeer)
+	out := dump()
+	assert.Contains(t, out, `wasm_requests_total{reporter="destination",source_workload="reviews-v2",`+
+		`source_canonical_service="reviews-svc",source_canonical_revision="v2",source_workload_namespace="default",`+
+		`source_app="reviews"`)
+	assert.Contains(t, out, `grpc_response_status="",connection_security_policy="none"}: 1`)
+	assert.Contains(t, out, `wasm_response_bytes{reporter="destination",`)
+	assert.NotContains(t, out, "wasm_request_bytes")
+
+	assert.Panics(t, func() {
+		newTestHandler(&istio_stats.MetricConfig{})
+	})
+}
@@ -0,0 +1,222 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwtauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// errors with the same messages as envoy
+var (
+	errJwtMissing            = errors.New("Jwt is missing")
+	errJwtBadFormat          = errors.New("Jwt is not in the form of Header.Payload.Signature")
+	errJwtHeaderBadAlg       = errors.New("Jwt header [alg] is not supported")
+	errJwtVerificationFail   = errors.New("Jwt verification fails")
+	errJwtExpired            = errors.New("Jwt is expired")
+	errJwtNotYetValid        = errors.New("Jwt not yet valid")
+	errJwtAudienceNotAllowed = errors.New("Audiences in Jwt are not allowed")
+	errJwtUnknownIssuer      = errors.New("Jwt issuer is not configured")
+	errJwksKidAlgMismatch    = errors.New("Jwks doesn't have key to match kid or alg from Jwt")
+	errJwksFetchFail         = errors.New("Jwks remote fetch is failed")
+)
+
+// algorithm is the signing algorithm of jwt header alg
+type algorithm struct {
+	kty  string
+	hash crypto.Hash
+	// size of r and s for ecdsa
+	keySize int
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {kty: "RSA", hash: crypto.SHA256},
+	"RS384": {kty: "RSA", hash: crypto.SHA384},
+	"RS512": {kty: "RSA", hash: crypto.SHA512},
+	"ES256": {kty: "EC", hash: crypto.SHA256, keySize: 32},
+	"ES384": {kty: "EC", hash: crypto.SHA384, keySize: 48},
+	"ES512": {kty: "EC", hash: crypto.SHA512, keySize: 66},
+	"HS256": {kty: "oct", hash: crypto.SHA256},
+	"HS384": {kty: "oct", hash: crypto.SHA384},
+	"HS512": {kty: "oct", hash: crypto.SHA512},
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwt struct {
+	header       jwtHeader
+	headerJSON   []byte
+	payload      string
+	claims       map[string]interface{}
+	signingInput string
+	signature    []byte
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func parseJWT(token string) (*jwt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJwtBadFormat
+	}
+	j := &jwt{payload: parts[1], signingInput: parts[0] + "." + parts[1]}
+
+	var err error
+	if j.headerJSON, err = decodeSegment(parts[0]); err != nil {
+		return nil, errJwtBadFormat
+	}
+	if err = json.Unmarshal(j.headerJSON, &j.header); err != nil {
+		return nil, errJwtBadFormat
+	}
+	if _, ok := algorithms[j.header.Alg]; !ok {
+		return nil, errJwtHeaderBadAlg
+	}
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, errJwtBadFormat
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+	if err = decoder.Decode(&j.claims); err != nil {
+		return nil, errJwtBadFormat
+	}
+	if j.signature, err = decodeSegment(parts[2]); err != nil {
+		return nil, errJwtBadFormat
+	}
+	return j, nil
+}
+
+func (j *jwt) issuer() string {
+	iss, _ := j.claims["iss"].(string)
+	return iss
+}
+
+// audiences the aud claim is a string or an array of strings
+func (j *jwt) audiences() []string {
+	switch aud := j.claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var auds []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	}
+	return nil
+}
+
+// claim returns the claim by path, nested claims are separated by "."
+func (j *jwt) claim(path string) interface{} {
+	var value interface{} = j.claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func (j *jwt) numericClaim(name string) (int64, bool) {
+	n, ok := j.claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return int64(f), err == nil
+}
+
+// verifyTime checks exp and nbf with clock skew
+func (j *jwt) verifyTime(now time.Time, skew time.Duration) error {
+	if exp, ok := j.numericClaim("exp"); ok && now.Add(-skew).After(time.Unix(exp, 0)) {
+		return errJwtExpired
+	}
+	if nbf, ok := j.numericClaim("nbf"); ok && now.Add(skew).Before(time.Unix(nbf, 0)) {
+		return errJwtNotYetValid
+	}
+	return nil
+}
+
+// verifySignature tries the keys matching kid and alg
+func (j *jwt) verifySignature(keys []*jwk) error {
+	alg := algorithms[j.header.Alg]
+	matched := false
+	for _, key := range keys {
+		if key.kty != alg.kty || (key.alg != "" && key.alg != j.header.Alg) ||
+			(j.header.Kid != "" && key.kid != "" && key.kid != j.header.Kid) {
+			continue
+		}
+		matched = true
+		if verify(alg, key.key, j.signingInput, j.signature) {
+			return nil
+		}
+	}
+	if !matched {
+		return errJwksKidAlgMismatch
+	}
+	return errJwtVerificationFail
+}
+
+func verify(alg algorithm, key interface{}, input string, signature []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		h := alg.hash.New()
+		h.Write([]byte(input))
+		return rsa.VerifyPKCS1v15(k, alg.hash, h.Sum(nil), signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 2*alg.keySize {
+			return false
+		}
+		h := alg.hash.New()
+		h.Write([]byte(input))
+		r := new(big.Int).SetBytes(signature[:alg.keySize])
+		s := new(big.Int).SetBytes(signature[alg.keySize:])
+		return ecdsa.Verify(k, h.Sum(nil), r, s)
+	case []byte:
+		mac := hmac.New(alg.hash.New, k)
+		mac.Write([]byte(input))
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	return false
+}
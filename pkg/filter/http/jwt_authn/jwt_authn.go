@@ -0,0 +1,254 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwtauthn
+
+import (
+	"fmt"
+	nethttp "net/http"
+	"strings"
+
+	envoy_extensions_filters_http_jwt_authn_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/jwt_authn/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/router"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(JwtAuthnFactory))
+}
+
+type requirementRule struct {
+	match    router.RequestMatcher
+	verifier verifier
+}
+
+// authnConfig is built from envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication
+type authnConfig struct {
+	*envoy_extensions_filters_http_jwt_authn_v3.JwtAuthentication
+	rules        []*requirementRule
+	requirements map[string]verifier
+}
+
+func newAuthnConfig(pb proto.Message, context api.FactoryContext) (*authnConfig, error) {
+	c := &authnConfig{
+		JwtAuthentication: pb.(*envoy_extensions_filters_http_jwt_authn_v3.JwtAuthentication),
+		requirements:      make(map[string]verifier),
+	}
+	providers := make(map[string]*provider)
+	for name, pc := range c.GetProviders() {
+		p, err := newProvider(name, pc, context.ClusterManager())
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = p
+	}
+	for name, r := range c.GetRequirementMap() {
+		v, err := newVerifier(r, providers)
+		if err != nil {
+			return nil, err
+		}
+		c.requirements[name] = v
+	}
+	for _, r := range c.GetRules() {
+		rule := &requirementRule{match: router.NewRequestMatcher(r.GetMatch())}
+		switch spec := r.GetRequirementType().(type) {
+		case *envoy_extensions_filters_http_jwt_authn_v3.RequirementRule_Requires:
+			v, err := newVerifier(spec.Requires, providers)
+			if err != nil {
+				return nil, err
+			}
+			rule.verifier = v
+		case *envoy_extensions_filters_http_jwt_authn_v3.RequirementRule_RequirementName:
+			v, ok := c.requirements[spec.RequirementName]
+			if !ok {
+				return nil, fmt.Errorf("not found requirement: %s", spec.RequirementName)
+			}
+			rule.verifier = v
+		}
+		// rule without requirement means no verification
+		c.rules = append(c.rules, rule)
+	}
+	if c.GetFilterStateRules() != nil {
+		log.Warn("filter_state_rules of jwt_authn is not supported")
+	}
+	return c, nil
+}
+
+// findVerifier by per route config, or the first matched rule
+func (c *authnConfig) findVerifier(header api.RequestHeader, entry api.RouteEntry) verifier {
+	if entry != nil {
+		if perRoute, ok := entry.PerFilterConfig(filter.HTTP_JwtAuthn).(*envoy_extensions_filters_http_jwt_authn_v3.PerRouteConfig); ok {
+			if perRoute.GetDisabled() {
+				return nil
+			}
+			return c.requirements[perRoute.GetRequirementName()]
+		}
+	}
+	for _, rule := range c.rules {
+		if rule.match.Match(header) {
+			return rule.verifier
+		}
+	}
+	return nil
+}
+
+func isCorsPreflight(header api.RequestHeader) bool {
+	return string(header.Method()) == nethttp.MethodOptions && header.Get("origin") != nil &&
+		header.Get("access-control-request-method") != nil
+}
+
+// JwtAuthn verify the jwt of request by the requirement of route or rules
+type JwtAuthn struct {
+	filter.PassThroughFilter
+	config *authnConfig
+	// www-authenticate of current stream if failed
+	authenticate string
+}
+
+func (a *JwtAuthn) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	a.authenticate = ""
+
+	header := ctx.Request().Header()
+	if a.config.GetBypassCorsPreflight() && isCorsPreflight(header) {
+		return api.Continue
+	}
+	v := a.config.findVerifier(header, a.DecoderCallbacks.RouteEntry())
+	if v == nil {
+		return api.Continue
+	}
+
+	// remote jwks may be fetched
+	dispatcher := a.DecoderCallbacks.Dispatcher()
+	go func() {
+		vc := newVerifyContext(ctx, a.DecoderCallbacks)
+		err := v.verify(vc)
+		dispatcher.Post(func() { a.onComplete(ctx, vc, err) })
+	}()
+	return api.StopIteration
+}
+
+// onComplete is posted to the stream started the verification, and ignored
+// if the stream is cancelled during fetching jwks
+func (a *JwtAuthn) onComplete(ctx api.StreamContext, vc *verifyContext, err error) {
+	if ctx.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		header := ctx.Request().Header()
+		a.authenticate = fmt.Sprintf(`Bearer realm="http://%s%s"`, header.Host(), header.Path())
+		if err != errJwtMissing {
+			a.authenticate += `, error="invalid_token"`
+		}
+		code := nethttp.StatusUnauthorized
+		if err == errJwtAudienceNotAllowed {
+			code = nethttp.StatusForbidden
+		}
+		a.DecoderCallbacks.SendLocalReply(code, err.Error(),
+			"jwt_authn_access_denied{"+strings.ReplaceAll(err.Error(), " ", "_")+"}")
+		return
+	}
+	for _, t := range vc.verified {
+		a.onVerified(ctx, t)
+	}
+	a.DecoderCallbacks.ContinueDecoding()
+}
+
+// onVerified forward the payload and claims, and remove the token if not forward
+func (a *JwtAuthn) onVerified(ctx api.StreamContext, t *verifiedToken) {
+	p, header := t.provider, ctx.Request().Header()
+	if !p.GetForward() && t.token.location.header != "" {
+		header.Del(t.token.location.header)
+	}
+	if name := p.GetForwardPayloadHeader(); name != "" {
+		payload := t.jwt.payload
+		if p.GetPadForwardPayloadHeader() {
+			payload += strings.Repeat("=", (4-len(payload)%4)%4)
+		}
+		header.Set(name, payload)
+	}
+	for _, ch := range p.claimToHeaders {
+		switch v := t.jwt.claim(ch.claim).(type) {
+		case string:
+			header.Set(ch.header, v)
+		case fmt.Stringer, bool:
+			header.Set(ch.header, fmt.Sprint(v))
+		}
+	}
+
+	metadata := &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	if key := p.GetPayloadInMetadata(); key != "" {
+		if payload, err := decodeSegment(t.jwt.payload); err == nil {
+			addMetadata(metadata, key, payload)
+		}
+	}
+	if key := p.GetHeaderInMetadata(); key != "" {
+		addMetadata(metadata, key, t.jwt.headerJSON)
+	}
+	if len(metadata.Fields) > 0 {
+		ctx.StreamInfo().SetDynamicMetadata(filter.HTTP_JwtAuthn, metadata)
+	}
+}
+
+func addMetadata(metadata *structpb.Struct, key string, data []byte) {
+	value := &structpb.Struct{}
+	if err := value.UnmarshalJSON(data); err != nil {
+		log.Error("jwt metadata error: %s", err)
+		return
+	}
+	metadata.Fields[key] = structpb.NewStructValue(value)
+}
+
+func (a *JwtAuthn) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if a.authenticate != "" {
+		ctx.Response().Header().Set("www-authenticate", a.authenticate)
+	}
+	return api.Continue
+}
+
+type JwtAuthnFactory struct {
+}
+
+func (f *JwtAuthnFactory) Name() string {
+	return filter.HTTP_JwtAuthn
+}
+
+func (f *JwtAuthnFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_jwt_authn_v3.JwtAuthentication{}
+}
+
+func (f *JwtAuthnFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newAuthnConfig(pb, context)
+	if err != nil {
+		panic(err)
+	}
+	return func(cb api.HTTPFilterManager) {
+		authn := &JwtAuthn{config: config}
+		cb.AddDecodeFilter(authn)
+		cb.AddEncodeFilter(authn)
+	}
+}
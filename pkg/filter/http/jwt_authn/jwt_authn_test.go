@@ -0,0 +1,252 @@
+package jwtauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_filters_http_jwt_authn_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/jwt_authn/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var (
+	rsaKey, _  = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _   = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hmacSecret = []byte("govoy-secret")
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newJwks() string {
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "oct", "kid": "hmac", "k": b64(hmacSecret)},
+	}})
+	return string(jwks)
+}
+
+func sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	kid := map[string]string{"RS256": "rsa", "ES256": "ec", "HS256": "hmac"}[alg]
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	hash := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+	case "ES256":
+		r, s, e := ecdsa.Sign(rand.Reader, ecKey, hash[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), e
+	case "HS256":
+		mac := hmac.New(sha256.New, hmacSecret)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	}
+	assert.NoError(t, err)
+	return input + "." + b64(sig)
+}
+
+func newClaims(aud string, exp time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": "https://issuer.govoy.io", "sub": "alice", "aud": aud,
+		"exp": time.Now().Add(exp).Unix(), "group": map[string]interface{}{"name": "dev"},
+	}
+}
+
+func newFactoryContext(t *testing.T, addr string) api.FactoryContext {
+	return filtertest.NewFactoryContext(t, filtertest.StaticCluster("jwks", addr))
+}
+
+func newTestHandler(config *envoy_extensions_filters_http_jwt_authn_v3.JwtAuthentication, context api.FactoryContext) http.Handler {
+	rc := filtertest.RouteConfig("ratings", filtertest.Route("/", "ratings", nil))
+	return filtertest.NewHandler(rc, new(JwtAuthnFactory).CreateFilterFactory(config, context))
+}
+
+func handle(t *testing.T, handler http.Handler, path string, token string) api.StreamContext {
+	headers := map[string]string{"host": "ratings"}
+	if token != "" {
+		headers["authorization"] = "Bearer " + token
+	}
+	return filtertest.Handle(t, handler, filtertest.NewContext("http://ratings"+path, headers))
+}
+
+func newRule(prefix string, requires *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement) *envoy_extensions_filters_http_jwt_authn_v3.RequirementRule {
+	return &envoy_extensions_filters_http_jwt_authn_v3.RequirementRule{
+		Match: &envoy_config_route_v3.RouteMatch{PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: prefix}},
+		RequirementType: &envoy_extensions_filters_http_jwt_authn_v3.RequirementRule_Requires{
+			Requires: requires},
+	}
+}
+
+func requiresProvider(name string) *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement {
+	return &envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement{
+		RequiresType: &envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_ProviderName{ProviderName: name}}
+}
+
+func TestLocalJwks(t *testing.T) {
+	provider := &envoy_extensions_filters_http_jwt_authn_v3.JwtProvider{
+		Issuer:    "https://issuer.govoy.io",
+		Audiences: []string{"ratings"},
+		JwksSourceSpecifier: &envoy_extensions_filters_http_jwt_authn_v3.JwtProvider_LocalJwks{
+			LocalJwks: &envoy_config_core_v3.DataSource{
+				Specifier: &envoy_config_core_v3.DataSource_InlineString{InlineString: newJwks()}}},
+		ForwardPayloadHeader: "x-jwt-payload",
+		PayloadInMetadata:    "payload",
+	}
+	// claim_to_headers: [{header_name: x-jwt-group, claim_name: group.name}]
+	claimToHeader := protowire.AppendTag(nil, 1, protowire.BytesType)
+	claimToHeader = protowire.AppendString(claimToHeader, "x-jwt-group")
+	claimToHeader = protowire.AppendTag(claimToHeader, 2, protowire.BytesType)
+	claimToHeader = protowire.AppendString(claimToHeader, "group.name")
+	unknown := protowire.AppendTag(nil, fieldClaimToHeaders, protowire.BytesType)
+	provider.ProtoReflect().SetUnknown(protowire.AppendBytes(unknown, claimToHeader))
+
+	handler := newTestHandler(&envoy_extensions_filters_http_jwt_authn_v3.JwtAuthentication{
+		Providers: map[string]*envoy_extensions_filters_http_jwt_authn_v3.JwtProvider{"govoy": provider},
+		Rules: []*envoy_extensions_filters_http_jwt_authn_v3.RequirementRule{
+			newRule("/optional", &envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement{
+				RequiresType: &envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_AllowMissing{
+					AllowMissing: &emptypb.Empty{}}}),
+			newRule("/", requiresProvider("govoy")),
+		},
+	}, filtertest.NewFactoryContext(t))
+
+	for _, alg := range []string{"RS256", "ES256", "HS256"} {
+		ctx := handle(t, handler, "/ratings", sign(t, alg, newClaims("ratings", time.Hour)))
+		assert.Equal(t, 200, ctx.Response().Header().StatusCode(), alg)
+		assert.Nil(t, ctx.Request().Header().Get("authorization"))
+		assert.NotEmpty(t, ctx.Request().Header().Get("x-jwt-payload"))
+		assert.Equal(t, "dev", string(ctx.Request().Header().Get("x-jwt-group")))
+		payload := ctx.StreamInfo().DynamicMetadata().GetFilterMetadata()[filter.HTTP_JwtAuthn].GetFields()["payload"]
+		assert.Equal(t, "alice", payload.GetStructValue().GetFields()["sub"].GetStringValue())
+	}
+
+	ctx := handle(t, handler, "/ratings", "")
+	assert.Equal(t, 401, ctx.Response().Header().StatusCode())
+	assert.Equal(t, errJwtMissing.Error(), string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, `Bearer realm="http://ratings/ratings"`, string(ctx.Response().Header().Get("www-authenticate")))
+
+	ctx = handle(t, handler, "/ratings", sign(t, "RS256", newClaims("ratings", -time.Hour)))
+	assert.Equal(t, 401, ctx.Response().Header().StatusCode())
+	assert.Equal(t, errJwtExpired.Error(), string(ctx.Response().Body().Bytes()))
+
+	ctx = handle(t, handler, "/ratings", sign(t, "RS256", newClaims("reviews", time.Hour)))
+	assert.Equal(t, 403, ctx.Response().Header().StatusCode())
+
+	ctx = handle(t, handler, "/ratings", sign(t, "RS256", newClaims("ratings", time.Hour))+"x")
+	assert.Equal(t, 401, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "jwt_authn_access_denied{Jwt_verification_fails}", ctx.StreamInfo().ResponseCodeDetails())
+
+	// allow missing
+	ctx = handle(t, handler, "/optional", "")
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	ctx = handle(t, handler, "/optional", "invalid")
+	assert.Equal(t, 401, ctx.Response().Header().StatusCode())
+}
+
+func TestRemoteJwks(t *testing.T) {
+	var fetched int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path != "/jwks.json" || r.Host != "issuer.govoy.io" {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&fetched, 1)
+		w.Write([]byte(newJwks()))
+	}))
+	defer server.Close()
+
+	handler := newTestHandler(&envoy_extensions_filters_http_jwt_authn_v3.JwtAuthentication{
+		Providers: map[string]*envoy_extensions_filters_http_jwt_authn_v3.JwtProvider{"govoy": {
+			Issuer: "https://issuer.govoy.io",
+			JwksSourceSpecifier: &envoy_extensions_filters_http_jwt_authn_v3.JwtProvider_RemoteJwks{
+				RemoteJwks: &envoy_extensions_filters_http_jwt_authn_v3.RemoteJwks{
+					HttpUri: &envoy_config_core_v3.HttpUri{
+						Uri:              "http://issuer.govoy.io/jwks.json",
+						HttpUpstreamType: &envoy_config_core_v3.HttpUri_Cluster{Cluster: "jwks"},
+					}}},
+		}},
+		Rules: []*envoy_extensions_filters_http_jwt_authn_v3.RequirementRule{newRule("/", requiresProvider("govoy"))},
+	}, newFactoryContext(t, server.Listener.Addr().String()))
+
+	for i := 0; i < 2; i++ {
+		ctx := handle(t, handler, "/ratings", sign(t, "ES256", newClaims("ratings", time.Hour)))
+		assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+}
+
+func TestRemoteJwksFetch(t *testing.T) {
+	var fetched, failed int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		atomic.AddInt32(&fetched, 1)
+		time.Sleep(20 * time.Millisecond)
+		if atomic.LoadInt32(&failed) == 1 {
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(newJwks()))
+	}))
+	defer server.Close()
+
+	r, err := newRemoteJwks(&envoy_extensions_filters_http_jwt_authn_v3.RemoteJwks{
+		HttpUri: &envoy_config_core_v3.HttpUri{
+			Uri:              "http://issuer.govoy.io/jwks.json",
+			HttpUpstreamType: &envoy_config_core_v3.HttpUri_Cluster{Cluster: "jwks"},
+		},
+		CacheDuration: durationpb.New(50 * time.Millisecond),
+	}, newFactoryContext(t, server.Listener.Addr().String()).ClusterManager())
+	assert.NoError(t, err)
+
+	// only one fetch is in flight, the others wait for it
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := r.keys(nil)
+			assert.NoError(t, err)
+			assert.Len(t, keys, 3)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+
+	// the last keys are kept if refresh fails, and no fetch in backoff
+	atomic.StoreInt32(&failed, 1)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		keys, err := r.keys(nil)
+		assert.NoError(t, err)
+		assert.Len(t, keys, 3)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetched))
+}
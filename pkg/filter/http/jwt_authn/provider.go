@@ -0,0 +1,401 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwtauthn
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	envoy_extensions_filters_http_jwt_authn_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/jwt_authn/v3"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/cluster"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/utils"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultClockSkew     = 60 * time.Second
+	defaultCacheDuration = 10 * time.Minute
+	defaultFetchTimeout  = time.Second
+	minFetchBackoff      = time.Second
+	maxFetchBackoff      = time.Minute
+
+	// claim_to_headers of JwtProvider is newer than the go-control-plane, parse it from unknown fields
+	fieldClaimToHeaders = 15
+)
+
+// tokenLocation is where the token is extracted from
+type tokenLocation struct {
+	header string
+	prefix string
+	param  string
+	cookie string
+}
+
+type token struct {
+	value    string
+	location *tokenLocation
+}
+
+type claimToHeader struct {
+	header string
+	claim  string
+}
+
+// provider verify the tokens by the issuer, audiences and jwks of JwtProvider
+type provider struct {
+	*envoy_extensions_filters_http_jwt_authn_v3.JwtProvider
+	name           string
+	locations      []*tokenLocation
+	audiences      map[string]struct{}
+	claimToHeaders []claimToHeader
+	jwks           jwksSource
+}
+
+func newProvider(name string, c *envoy_extensions_filters_http_jwt_authn_v3.JwtProvider, cm api.ClusterManager) (*provider, error) {
+	p := &provider{JwtProvider: c, name: name, audiences: stringSet(c.GetAudiences())}
+
+	for _, h := range c.GetFromHeaders() {
+		p.locations = append(p.locations, &tokenLocation{header: h.GetName(), prefix: h.GetValuePrefix()})
+	}
+	for _, param := range c.GetFromParams() {
+		p.locations = append(p.locations, &tokenLocation{param: param})
+	}
+	for _, cookie := range c.GetFromCookies() {
+		p.locations = append(p.locations, &tokenLocation{cookie: cookie})
+	}
+	if len(p.locations) == 0 {
+		p.locations = []*tokenLocation{{header: "authorization", prefix: "Bearer "}, {param: "access_token"}}
+	}
+
+	var err error
+	if p.claimToHeaders, err = parseClaimToHeaders(c); err != nil {
+		return nil, err
+	}
+
+	switch spec := c.GetJwksSourceSpecifier().(type) {
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtProvider_LocalJwks:
+		data, err := utils.ReadDataSource(spec.LocalJwks)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseJwks(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local jwks of provider(%s): %s", name, err)
+		}
+		p.jwks = &localJwks{jwks: keys}
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtProvider_RemoteJwks:
+		if p.jwks, err = newRemoteJwks(spec.RemoteJwks, cm); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("jwks of provider(%s) is not set", name)
+	}
+	return p, nil
+}
+
+// parseClaimToHeaders parse JwtClaimToHeader{header_name = 1, claim_name = 2} of unknown fields
+func parseClaimToHeaders(c *envoy_extensions_filters_http_jwt_authn_v3.JwtProvider) ([]claimToHeader, error) {
+	var result []claimToHeader
+	b := c.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num != fieldClaimToHeaders || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var ch claimToHeader
+		for len(msg) > 0 {
+			num, typ, n := protowire.ConsumeTag(msg)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			msg = msg[n:]
+			if typ != protowire.BytesType {
+				if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+					return nil, protowire.ParseError(n)
+				}
+				msg = msg[n:]
+				continue
+			}
+			v, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			msg = msg[n:]
+			switch num {
+			case 1:
+				ch.header = string(v)
+			case 2:
+				ch.claim = string(v)
+			}
+		}
+		result = append(result, ch)
+	}
+	return result, nil
+}
+
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// extract returns the tokens of all locations
+func (p *provider) extract(ctx api.StreamContext) []*token {
+	var tokens []*token
+	header := ctx.Request().Header()
+	for _, l := range p.locations {
+		var value string
+		switch {
+		case l.header != "":
+			v := string(header.Get(l.header))
+			if v == "" || !strings.HasPrefix(v, l.prefix) {
+				continue
+			}
+			value = strings.TrimSpace(v[len(l.prefix):])
+		case l.param != "":
+			v, _ := header.QueryArg(l.param)
+			value = string(v)
+		case l.cookie != "":
+			value = string(ctx.Request().Raw().(*fasthttp.Request).Header.Cookie(l.cookie))
+		}
+		if value != "" {
+			tokens = append(tokens, &token{value: value, location: l})
+		}
+	}
+	return tokens
+}
+
+// verify the token, audiences override the provider's if not nil
+func (p *provider) verify(value string, audiences map[string]struct{}, lbCtx api.LoadBalancerContext) (*jwt, error) {
+	j, err := parseJWT(value)
+	if err != nil {
+		return nil, err
+	}
+	if p.GetIssuer() != "" && j.issuer() != p.GetIssuer() {
+		return nil, errJwtUnknownIssuer
+	}
+
+	skew := defaultClockSkew
+	if s := p.GetClockSkewSeconds(); s > 0 {
+		skew = time.Duration(s) * time.Second
+	}
+	if err = j.verifyTime(time.Now(), skew); err != nil {
+		return nil, err
+	}
+
+	if audiences == nil {
+		audiences = p.audiences
+	}
+	if len(audiences) > 0 {
+		allowed := false
+		for _, aud := range j.audiences() {
+			if _, ok := audiences[aud]; ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, errJwtAudienceNotAllowed
+		}
+	}
+
+	keys, err := p.jwks.keys(lbCtx)
+	if err != nil {
+		return nil, err
+	}
+	if err = j.verifySignature(keys); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+type jwksSource interface {
+	keys(lbCtx api.LoadBalancerContext) ([]*jwk, error)
+}
+
+type localJwks struct {
+	jwks []*jwk
+}
+
+func (l *localJwks) keys(api.LoadBalancerContext) ([]*jwk, error) {
+	return l.jwks, nil
+}
+
+type remoteJwks struct {
+	cm            api.ClusterManager
+	cluster       string
+	uri           *url.URL
+	timeout       time.Duration
+	cacheDuration time.Duration
+
+	mu      sync.Mutex
+	cached  []*jwk
+	expired time.Time
+	// fetching is closed when the fetch in flight is done, nil if not fetching
+	fetching chan struct{}
+	// no fetch before retryAt after failures, the backoff doubles for each failure
+	backoff time.Duration
+	retryAt time.Time
+}
+
+func newRemoteJwks(c *envoy_extensions_filters_http_jwt_authn_v3.RemoteJwks, cm api.ClusterManager) (*remoteJwks, error) {
+	uri, err := url.Parse(c.GetHttpUri().GetUri())
+	if err != nil {
+		return nil, err
+	}
+	r := &remoteJwks{
+		cm:            cm,
+		cluster:       c.GetHttpUri().GetCluster(),
+		uri:           uri,
+		timeout:       defaultFetchTimeout,
+		cacheDuration: defaultCacheDuration,
+	}
+	if t := c.GetHttpUri().GetTimeout(); t != nil {
+		r.timeout = t.AsDuration()
+	}
+	if d := c.GetCacheDuration(); d != nil {
+		r.cacheDuration = d.AsDuration()
+	}
+	if c.GetAsyncFetch() != nil {
+		go r.keys(nil)
+	}
+	return r, nil
+}
+
+// keys returns the cached keys, or fetch from the cluster if expired. Only one fetch is in flight, the
+// others wait for it if no keys are cached, and the last fetched keys are used until a fetch succeeds.
+func (r *remoteJwks) keys(lbCtx api.LoadBalancerContext) ([]*jwk, error) {
+	r.mu.Lock()
+	now := time.Now()
+	if r.cached != nil && now.Before(r.expired) || now.Before(r.retryAt) {
+		return r.unlockCached()
+	}
+	if fetching := r.fetching; fetching != nil {
+		if r.cached != nil {
+			return r.unlockCached()
+		}
+		r.mu.Unlock()
+		<-fetching
+		r.mu.Lock()
+		return r.unlockCached()
+	}
+	fetching := make(chan struct{})
+	r.fetching = fetching
+	r.mu.Unlock()
+
+	keys, err := r.fetch(lbCtx)
+
+	r.mu.Lock()
+	r.fetching = nil
+	close(fetching)
+	if err != nil {
+		log.Error("fetch jwks %s error: %s", r.uri, err)
+		r.backoff = nextFetchBackoff(r.backoff)
+		r.retryAt = time.Now().Add(r.backoff)
+	} else {
+		r.cached, r.expired = keys, time.Now().Add(r.cacheDuration)
+		r.backoff, r.retryAt = 0, time.Time{}
+	}
+	return r.unlockCached()
+}
+
+func (r *remoteJwks) unlockCached() ([]*jwk, error) {
+	keys := r.cached
+	r.mu.Unlock()
+	if keys == nil {
+		return nil, errJwksFetchFail
+	}
+	return keys, nil
+}
+
+func nextFetchBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return minFetchBackoff
+	}
+	if backoff *= 2; backoff > maxFetchBackoff {
+		return maxFetchBackoff
+	}
+	return backoff
+}
+
+// fetch the jwks by http from the host of cluster with its connection pool, tls is not supported
+func (r *remoteJwks) fetch(lbCtx api.LoadBalancerContext) ([]*jwk, error) {
+	host, err := cluster.SelectHost(r.cm, r.cluster, lbCtx)
+	if err != nil {
+		return nil, err
+	}
+	cl := r.cm.GetCluster(r.cluster)
+	if cl == nil {
+		return nil, fmt.Errorf("not found cluster: %s", r.cluster)
+	}
+	request := fasthttp.AcquireRequest()
+	response := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(request)
+		fasthttp.ReleaseResponse(response)
+	}()
+	request.SetRequestURI("http://" + host.Address().String() + r.uri.RequestURI())
+	request.UseHostHeader = true
+	request.Header.SetHost(r.uri.Host)
+
+	// the fetch is shared by streams, so it's not cancelled by any of them
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	stream := http.NewStreamContext(ctx, http.NewStreamInfo(nil, "HTTP/1.1"), request, response)
+	if err = cl.ConnPool(api.PriorityDefault).Call(stream); err != nil {
+		return nil, err
+	}
+	if response.StatusCode() != fasthttp.StatusOK {
+		return nil, fmt.Errorf("status code %d", response.StatusCode())
+	}
+	return parseJwks(response.Body())
+}
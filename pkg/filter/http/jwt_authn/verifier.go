@@ -0,0 +1,194 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package jwtauthn
+
+import (
+	"fmt"
+	"sort"
+
+	envoy_extensions_filters_http_jwt_authn_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/jwt_authn/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// verifyContext holds the verified tokens of a request
+type verifyContext struct {
+	ctx      api.StreamContext
+	lbCtx    api.LoadBalancerContext
+	results  map[string]error
+	verified []*verifiedToken
+}
+
+type verifiedToken struct {
+	provider *provider
+	token    *token
+	jwt      *jwt
+}
+
+func newVerifyContext(ctx api.StreamContext, lbCtx api.LoadBalancerContext) *verifyContext {
+	return &verifyContext{ctx: ctx, lbCtx: lbCtx, results: make(map[string]error)}
+}
+
+// verifyToken the result is cached, as a token may be verified by several requirements
+func (vc *verifyContext) verifyToken(p *provider, t *token, audiences map[string]struct{}) error {
+	key := fmt.Sprintf("%s|%v|%s", p.name, audiences, t.value)
+	if err, ok := vc.results[key]; ok {
+		return err
+	}
+	j, err := p.verify(t.value, audiences, vc.lbCtx)
+	if err == nil {
+		vc.verified = append(vc.verified, &verifiedToken{provider: p, token: t, jwt: j})
+	}
+	vc.results[key] = err
+	return err
+}
+
+// verifier is built from JwtRequirement
+type verifier interface {
+	verify(vc *verifyContext) error
+}
+
+func newVerifier(c *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement, providers map[string]*provider) (verifier, error) {
+	switch spec := c.GetRequiresType().(type) {
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_ProviderName:
+		p, ok := providers[spec.ProviderName]
+		if !ok {
+			return nil, fmt.Errorf("not found provider: %s", spec.ProviderName)
+		}
+		return &providerVerifier{provider: p}, nil
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_ProviderAndAudiences:
+		p, ok := providers[spec.ProviderAndAudiences.GetProviderName()]
+		if !ok {
+			return nil, fmt.Errorf("not found provider: %s", spec.ProviderAndAudiences.GetProviderName())
+		}
+		return &providerVerifier{provider: p, audiences: stringSet(spec.ProviderAndAudiences.GetAudiences())}, nil
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_RequiresAny:
+		children, err := newVerifiers(spec.RequiresAny.GetRequirements(), providers)
+		return anyVerifier(children), err
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_RequiresAll:
+		children, err := newVerifiers(spec.RequiresAll.GetRequirements(), providers)
+		return allVerifier(children), err
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_AllowMissingOrFailed:
+		return &allowVerifier{providers: sortedProviders(providers), allowFailed: true}, nil
+	case *envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement_AllowMissing:
+		return &allowVerifier{providers: sortedProviders(providers)}, nil
+	}
+	return nil, fmt.Errorf("invalid jwt requirement: %T", c.GetRequiresType())
+}
+
+func newVerifiers(requirements []*envoy_extensions_filters_http_jwt_authn_v3.JwtRequirement,
+	providers map[string]*provider) ([]verifier, error) {
+	var verifiers []verifier
+	for _, r := range requirements {
+		v, err := newVerifier(r, providers)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, v)
+	}
+	return verifiers, nil
+}
+
+func sortedProviders(providers map[string]*provider) []*provider {
+	var sorted []*provider
+	for _, p := range providers {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+	return sorted
+}
+
+// providerVerifier requires all the tokens of the provider are valid
+type providerVerifier struct {
+	provider  *provider
+	audiences map[string]struct{}
+}
+
+func (v *providerVerifier) verify(vc *verifyContext) error {
+	tokens := v.provider.extract(vc.ctx)
+	if len(tokens) == 0 {
+		return errJwtMissing
+	}
+	for _, t := range tokens {
+		if err := vc.verifyToken(v.provider, t, v.audiences); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// anyVerifier is ok if any child is ok, otherwise returns the first error other than missing
+type anyVerifier []verifier
+
+func (v anyVerifier) verify(vc *verifyContext) error {
+	var result error
+	for _, child := range v {
+		err := child.verify(vc)
+		if err == nil {
+			return nil
+		}
+		if result == nil || result == errJwtMissing {
+			result = err
+		}
+	}
+	return result
+}
+
+// allVerifier is ok if all children are ok
+type allVerifier []verifier
+
+func (v allVerifier) verify(vc *verifyContext) error {
+	for _, child := range v {
+		if err := child.verify(vc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allowVerifier verifies the tokens of all providers, it's ok if no token presents. if allowFailed,
+// it's always ok, otherwise it fails if no token is valid.
+type allowVerifier struct {
+	providers   []*provider
+	allowFailed bool
+}
+
+func (v *allowVerifier) verify(vc *verifyContext) error {
+	var result error
+	for _, p := range v.providers {
+		for _, t := range p.extract(vc.ctx) {
+			err := vc.verifyToken(p, t, nil)
+			if err == nil {
+				return nil
+			}
+			if result == nil {
+				result = err
+			}
+		}
+	}
+	if v.allowFailed {
+		return nil
+	}
+	return result
+}
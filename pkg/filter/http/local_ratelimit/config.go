@@ -0,0 +1,92 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package localratelimit
+
+import (
+	nethttp "net/http"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_common_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	envoy_extensions_filters_http_local_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/router"
+	"github.com/wereliang/govoy/pkg/utils"
+)
+
+// rateLimitConfig is built from envoy.extensions.filters.http.local_ratelimit.v3.LocalRateLimit
+type rateLimitConfig struct {
+	*envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit
+	status int
+	// limiter is nil if token_bucket not set, and shared by all connections
+	// unless local_rate_limit_per_downstream_connection
+	limiter                    *localRateLimiter
+	requestHeadersNotEnforced  router.HeaderParser
+	responseHeadersWhenLimited router.HeaderParser
+}
+
+func newRateLimitConfig(pb proto.Message) (*rateLimitConfig, error) {
+	c := &rateLimitConfig{
+		LocalRateLimit: pb.(*envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit),
+		status:         nethttp.StatusTooManyRequests,
+	}
+	if code := c.GetStatus().GetCode(); code > 0 {
+		c.status = int(code)
+	}
+
+	var err error
+	if tb := c.GetTokenBucket(); tb != nil {
+		if c.limiter, err = newLocalRateLimiter(tb, c.GetDescriptors()); err != nil {
+			return nil, err
+		}
+	}
+	if c.requestHeadersNotEnforced, err = router.NewHeaderParser(c.GetRequestHeadersToAddWhenNotEnforced(), nil); err != nil {
+		return nil, err
+	}
+	if c.responseHeadersWhenLimited, err = router.NewHeaderParser(c.GetResponseHeadersToAdd(), nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// enabled is 0% if filter_enabled not set
+func (c *rateLimitConfig) enabled() bool {
+	return runtimeFractionalPercent(c.GetFilterEnabled())
+}
+
+// enforced is 0% if filter_enforced not set
+func (c *rateLimitConfig) enforced() bool {
+	return runtimeFractionalPercent(c.GetFilterEnforced())
+}
+
+func (c *rateLimitConfig) xRateLimitHeaders() bool {
+	return c.GetEnableXRatelimitHeaders() == envoy_extensions_common_ratelimit_v3.XRateLimitHeadersRFCVersion_DRAFT_VERSION_03
+}
+
+func runtimeFractionalPercent(p *envoy_config_core_v3.RuntimeFractionalPercent) bool {
+	if p == nil {
+		return false
+	}
+	return utils.FractionalPercentSample(p.GetDefaultValue())
+}
@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package localratelimit
+
+import (
+	"math"
+	"strconv"
+
+	envoy_extensions_filters_http_local_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+)
+
+const (
+	rateLimitedBody    = "local_rate_limited"
+	rateLimitedDetails = "local_rate_limited"
+
+	headerRateLimitLimit     = "x-ratelimit-limit"
+	headerRateLimitRemaining = "x-ratelimit-remaining"
+	headerRateLimitReset     = "x-ratelimit-reset"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(LocalRateLimitFactory))
+}
+
+// LocalRateLimit limit requests by token buckets of the filter or route
+type LocalRateLimit struct {
+	filter.PassThroughFilter
+	config *rateLimitConfig
+	// limiters of current connection if local_rate_limit_per_downstream_connection
+	limiters map[*rateLimitConfig]*localRateLimiter
+	// active config, the bucket taken from and whether the stream is limited,
+	// cleared when DecodeHeaders starts a new stream
+	active  *rateLimitConfig
+	bucket  *tokenBucket
+	limited bool
+}
+
+func (l *LocalRateLimit) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	l.active, l.bucket, l.limited = nil, nil, false
+
+	config := l.config
+	entry := l.DecoderCallbacks.RouteEntry()
+	if entry != nil {
+		if c, ok := entry.PerFilterConfig(filter.HTTP_LocalRateLimit).(*rateLimitConfig); ok {
+			config = c
+		}
+	}
+	limiter := l.limiter(config)
+	if limiter == nil || !config.enabled() {
+		return api.Continue
+	}
+	l.active = config
+
+	var descriptors []api.RateLimitDescriptor
+	if len(limiter.descriptors) > 0 && entry != nil {
+		descriptors = entry.RateLimitPolicy().Descriptors(config.GetStage(), ctx)
+	}
+	allowed, bucket := limiter.requestAllowed(descriptors)
+	l.bucket = bucket
+	if allowed {
+		return api.Continue
+	}
+
+	if !config.enforced() {
+		if config.requestHeadersNotEnforced != nil {
+			config.requestHeadersNotEnforced.Evaluate(ctx.Request().Header(), ctx)
+		}
+		return api.Continue
+	}
+	l.limited = true
+	ctx.StreamInfo().SetResponseFlag(api.RateLimited)
+	l.DecoderCallbacks.SendLocalReply(config.status, rateLimitedBody, rateLimitedDetails)
+	return api.StopIteration
+}
+
+// limiter returns the limiter of current connection if local_rate_limit_per_downstream_connection
+func (l *LocalRateLimit) limiter(config *rateLimitConfig) *localRateLimiter {
+	if config.limiter == nil || !config.GetLocalRateLimitPerDownstreamConnection() {
+		return config.limiter
+	}
+	limiter, ok := l.limiters[config]
+	if !ok {
+		if l.limiters == nil {
+			l.limiters = make(map[*rateLimitConfig]*localRateLimiter)
+		}
+		limiter = config.limiter.clone()
+		l.limiters[config] = limiter
+	}
+	return limiter
+}
+
+func (l *LocalRateLimit) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if l.active == nil {
+		return api.Continue
+	}
+	header := ctx.Response().Header()
+	if l.limited && l.active.responseHeadersWhenLimited != nil {
+		l.active.responseHeadersWhenLimited.Evaluate(header, ctx)
+	}
+	if l.active.xRateLimitHeaders() && l.bucket != nil {
+		header.Set(headerRateLimitLimit, strconv.FormatUint(uint64(l.bucket.maxTokens), 10))
+		header.Set(headerRateLimitRemaining, strconv.FormatUint(uint64(l.bucket.remaining()), 10))
+		header.Set(headerRateLimitReset, strconv.Itoa(int(math.Ceil(l.bucket.resetAfter().Seconds()))))
+	}
+	return api.Continue
+}
+
+type LocalRateLimitFactory struct {
+}
+
+func (f *LocalRateLimitFactory) Name() string {
+	return filter.HTTP_LocalRateLimit
+}
+
+func (f *LocalRateLimitFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit{}
+}
+
+func (f *LocalRateLimitFactory) CreateRouteSpecificFilterConfig(pb proto.Message) (interface{}, error) {
+	return newRateLimitConfig(pb)
+}
+
+// CreateFilterFactory the buckets are created once and shared by the handlers of all connections
+func (f *LocalRateLimitFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newRateLimitConfig(pb)
+	if err != nil {
+		panic(err)
+	}
+	return func(cb api.HTTPFilterManager) {
+		l := &LocalRateLimit{config: config}
+		cb.AddDecodeFilter(l)
+		cb.AddEncodeFilter(l)
+	}
+}
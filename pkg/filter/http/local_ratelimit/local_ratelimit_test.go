@@ -0,0 +1,125 @@
+package localratelimit
+
+import (
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_common_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	envoy_extensions_filters_http_local_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/router"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var percent100 = &envoy_config_core_v3.RuntimeFractionalPercent{
+	DefaultValue: &envoy_type_v3.FractionalPercent{Numerator: 100}}
+
+func newTokenBucketConfig(maxTokens uint32) *envoy_type_v3.TokenBucket {
+	return &envoy_type_v3.TokenBucket{
+		MaxTokens:     maxTokens,
+		TokensPerFill: &wrappers.UInt32Value{Value: 1},
+		FillInterval:  durationpb.New(time.Hour),
+	}
+}
+
+func newTestRouteConfig() *envoy_config_route_v3.RouteConfiguration {
+	return &envoy_config_route_v3.RouteConfiguration{
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:    "ratings",
+			Domains: []string{"*"},
+			Routes: []*envoy_config_route_v3.Route{{
+				Match: &envoy_config_route_v3.RouteMatch{
+					PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+					ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{Cluster: "ratings"},
+					RateLimits: []*envoy_config_route_v3.RateLimit{{
+						Actions: []*envoy_config_route_v3.RateLimit_Action{{
+							ActionSpecifier: &envoy_config_route_v3.RateLimit_Action_RequestHeaders_{
+								RequestHeaders: &envoy_config_route_v3.RateLimit_Action_RequestHeaders{
+									HeaderName: "x-user", DescriptorKey: "user"}},
+						}},
+					}},
+				}},
+			}},
+		}},
+	}
+}
+
+func newTestContext(headers map[string]string) api.StreamContext {
+	return filtertest.NewContext("http://ratings/ratings", headers)
+}
+
+func TestLocalRateLimit(t *testing.T) {
+	creator := new(LocalRateLimitFactory).CreateFilterFactory(
+		&envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit{
+			StatPrefix:     "http_local_rate_limiter",
+			TokenBucket:    newTokenBucketConfig(2),
+			FilterEnabled:  percent100,
+			FilterEnforced: percent100,
+			Descriptors: []*envoy_extensions_common_ratelimit_v3.LocalRateLimitDescriptor{{
+				Entries:     []*envoy_extensions_common_ratelimit_v3.RateLimitDescriptor_Entry{{Key: "user", Value: "alice"}},
+				TokenBucket: newTokenBucketConfig(1),
+			}},
+			ResponseHeadersToAdd: []*envoy_config_core_v3.HeaderValueOption{{
+				Header: &envoy_config_core_v3.HeaderValue{Key: "x-local-rate-limit", Value: "true"}}},
+			EnableXRatelimitHeaders: envoy_extensions_common_ratelimit_v3.XRateLimitHeadersRFCVersion_DRAFT_VERSION_03,
+		}, nil)
+
+	// buckets are shared by the handlers of connections
+	handlers := make([]http.Handler, 2)
+	for i := range handlers {
+		handlers[i] = http.NewHandler(router.NewRouterMatcher(newTestRouteConfig()), nil)
+		creator(handlers[i])
+	}
+
+	do := func(handler http.Handler, headers map[string]string) api.StreamContext {
+		return filtertest.Handle(t, handler, newTestContext(headers))
+	}
+
+	ctx := do(handlers[0], map[string]string{"x-user": "alice"})
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "1", string(ctx.Response().Header().Get(headerRateLimitLimit)))
+	assert.Equal(t, "0", string(ctx.Response().Header().Get(headerRateLimitRemaining)))
+	assert.Equal(t, "3600", string(ctx.Response().Header().Get(headerRateLimitReset)))
+
+	ctx = do(handlers[1], map[string]string{"x-user": "alice"})
+	assert.Equal(t, 429, ctx.Response().Header().StatusCode())
+	assert.Equal(t, rateLimitedBody, string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "true", string(ctx.Response().Header().Get("x-local-rate-limit")))
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.RateLimited))
+
+	// not matched descriptor use the default bucket
+	for i := 0; i < 2; i++ {
+		ctx = do(handlers[i], map[string]string{"x-user": "bob"})
+		assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	}
+	ctx = do(handlers[0], nil)
+	assert.Equal(t, 429, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "2", string(ctx.Response().Header().Get(headerRateLimitLimit)))
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	tb, err := newTokenBucket(&envoy_type_v3.TokenBucket{
+		MaxTokens:     2,
+		TokensPerFill: &wrappers.UInt32Value{Value: 1},
+		FillInterval:  durationpb.New(time.Second),
+	})
+	assert.NoError(t, err)
+	assert.True(t, tb.consume())
+	assert.True(t, tb.consume())
+	assert.False(t, tb.consume())
+
+	// two intervals elapsed but not exceed max tokens
+	tb.lastFill = tb.lastFill.Add(-3 * time.Second)
+	assert.Equal(t, uint32(2), tb.remaining())
+
+	_, err = newTokenBucket(&envoy_type_v3.TokenBucket{MaxTokens: 1, FillInterval: durationpb.New(time.Millisecond)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package localratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	envoy_extensions_common_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+const minFillInterval = 50 * time.Millisecond
+
+// tokenBucket is refilled lazily by the elapsed fill intervals when consumed
+type tokenBucket struct {
+	sync.Mutex
+	maxTokens     uint32
+	tokensPerFill uint32
+	fillInterval  time.Duration
+	tokens        uint32
+	lastFill      time.Time
+}
+
+func newTokenBucket(c *envoy_type_v3.TokenBucket) (*tokenBucket, error) {
+	tb := &tokenBucket{
+		maxTokens:     c.GetMaxTokens(),
+		tokensPerFill: 1,
+		fillInterval:  c.GetFillInterval().AsDuration(),
+	}
+	if tpf := c.GetTokensPerFill(); tpf != nil {
+		tb.tokensPerFill = tpf.GetValue()
+	}
+	if tb.fillInterval < minFillInterval {
+		return nil, fmt.Errorf("fill interval must be >= %s", minFillInterval)
+	}
+	tb.tokens = tb.maxTokens
+	tb.lastFill = time.Now()
+	return tb, nil
+}
+
+// clone returns a full bucket with the same config
+func (tb *tokenBucket) clone() *tokenBucket {
+	return &tokenBucket{
+		maxTokens:     tb.maxTokens,
+		tokensPerFill: tb.tokensPerFill,
+		fillInterval:  tb.fillInterval,
+		tokens:        tb.maxTokens,
+		lastFill:      time.Now(),
+	}
+}
+
+func (tb *tokenBucket) refill(now time.Time) {
+	n := now.Sub(tb.lastFill) / tb.fillInterval
+	if n <= 0 {
+		return
+	}
+	tokens := uint64(tb.tokens) + uint64(n)*uint64(tb.tokensPerFill)
+	if tokens > uint64(tb.maxTokens) {
+		tokens = uint64(tb.maxTokens)
+	}
+	tb.tokens = uint32(tokens)
+	tb.lastFill = tb.lastFill.Add(n * tb.fillInterval)
+}
+
+func (tb *tokenBucket) consume() bool {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	if tb.tokens == 0 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+func (tb *tokenBucket) remaining() uint32 {
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	return tb.tokens
+}
+
+// resetAfter returns the duration until next fill
+func (tb *tokenBucket) resetAfter() time.Duration {
+	tb.Lock()
+	defer tb.Unlock()
+	return tb.fillInterval - time.Since(tb.lastFill)%tb.fillInterval
+}
+
+// tokensPerSecond used to sort the descriptors, the most restrictive first
+func (tb *tokenBucket) tokensPerSecond() float64 {
+	return float64(tb.tokensPerFill) / tb.fillInterval.Seconds()
+}
+
+type descriptorBucket struct {
+	entries api.RateLimitDescriptor
+	bucket  *tokenBucket
+}
+
+func (d *descriptorBucket) match(descriptor api.RateLimitDescriptor) bool {
+	if len(d.entries) != len(descriptor) {
+		return false
+	}
+	for i := range d.entries {
+		if d.entries[i] != descriptor[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// localRateLimiter has a default bucket and buckets of descriptors
+type localRateLimiter struct {
+	tokens      *tokenBucket
+	descriptors []*descriptorBucket
+}
+
+func newLocalRateLimiter(c *envoy_type_v3.TokenBucket,
+	descriptors []*envoy_extensions_common_ratelimit_v3.LocalRateLimitDescriptor) (*localRateLimiter, error) {
+	tokens, err := newTokenBucket(c)
+	if err != nil {
+		return nil, err
+	}
+	rl := &localRateLimiter{tokens: tokens}
+	for _, d := range descriptors {
+		bucket, err := newTokenBucket(d.GetTokenBucket())
+		if err != nil {
+			return nil, err
+		}
+		db := &descriptorBucket{bucket: bucket}
+		for _, e := range d.GetEntries() {
+			db.entries = append(db.entries, api.RateLimitDescriptorEntry{Key: e.GetKey(), Value: e.GetValue()})
+		}
+		rl.descriptors = append(rl.descriptors, db)
+	}
+	sort.SliceStable(rl.descriptors, func(i, j int) bool {
+		return rl.descriptors[i].bucket.tokensPerSecond() < rl.descriptors[j].bucket.tokensPerSecond()
+	})
+	return rl, nil
+}
+
+// clone returns a limiter with full buckets, used for per connection rate limit
+func (rl *localRateLimiter) clone() *localRateLimiter {
+	c := &localRateLimiter{tokens: rl.tokens.clone()}
+	for _, d := range rl.descriptors {
+		c.descriptors = append(c.descriptors, &descriptorBucket{entries: d.entries, bucket: d.bucket.clone()})
+	}
+	return c
+}
+
+// requestAllowed consumes the buckets of matched descriptors, or the default bucket if no
+// descriptor matched. it returns the last consumed bucket.
+func (rl *localRateLimiter) requestAllowed(descriptors []api.RateLimitDescriptor) (bool, *tokenBucket) {
+	var matched *tokenBucket
+	for _, d := range rl.descriptors {
+		for _, descriptor := range descriptors {
+			if !d.match(descriptor) {
+				continue
+			}
+			matched = d.bucket
+			if !d.bucket.consume() {
+				return false, matched
+			}
+			break
+		}
+	}
+	if matched != nil {
+		return true, matched
+	}
+	return rl.tokens.consume(), rl.tokens
+}
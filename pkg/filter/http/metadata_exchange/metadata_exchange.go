@@ -0,0 +1,123 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package metadata_exchange
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/istio"
+	"github.com/wereliang/govoy/pkg/log"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(MetadataExchangeFactory))
+}
+
+// localConfig is the encoded headers of local node
+type localConfig struct {
+	id       string
+	metadata string
+}
+
+// MetadataExchange exchanges node metadata with peer by x-envoy-peer-metadata and x-envoy-peer-metadata-id
+// headers. The peer metadata of downstream falls back to the one exchanged by connection if no headers.
+type MetadataExchange struct {
+	filter.PassThroughFilter
+	local *localConfig
+}
+
+// peerFromHeader reads and removes the peer headers, metadata is nil if not found or invalid
+func peerFromHeader(header api.HeaderMap) (string, *structpb.Struct) {
+	id := string(header.Get(istio.HeaderPeerMetadataID))
+	value := string(header.Get(istio.HeaderPeerMetadata))
+	header.Del(istio.HeaderPeerMetadataID)
+	header.Del(istio.HeaderPeerMetadata)
+	if value == "" {
+		return id, nil
+	}
+	metadata, err := istio.DecodeMetadata(value)
+	if err != nil {
+		log.Warn("invalid peer metadata header: %s", err)
+		return id, nil
+	}
+	return id, metadata
+}
+
+func (m *MetadataExchange) setLocalHeader(header api.HeaderMap) {
+	header.Set(istio.HeaderPeerMetadataID, m.local.id)
+	header.Set(istio.HeaderPeerMetadata, m.local.metadata)
+}
+
+func (m *MetadataExchange) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Request().Header()
+	id, metadata := peerFromHeader(header)
+	if metadata == nil {
+		if conn := m.DecoderCallbacks.Connection(); conn != nil {
+			id, metadata = conn.Context().GetPeerMetadata()
+		}
+	}
+	if metadata != nil {
+		istio.SetDownstreamPeer(ctx.StreamInfo(), id, metadata)
+	}
+	m.setLocalHeader(header)
+	return api.Continue
+}
+
+func (m *MetadataExchange) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Response().Header()
+	if id, metadata := peerFromHeader(header); metadata != nil {
+		istio.SetUpstreamPeer(ctx.StreamInfo(), id, metadata)
+	}
+	m.setLocalHeader(header)
+	return api.Continue
+}
+
+// MetadataExchangeFactory is registered by name only, as the config of istio is wasm and not used
+type MetadataExchangeFactory struct {
+}
+
+func (f *MetadataExchangeFactory) Name() string {
+	return filter.HTTP_MetadataExchange
+}
+
+func (f *MetadataExchangeFactory) CreateEmptyConfigProto() proto.Message {
+	return nil
+}
+
+func (f *MetadataExchangeFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	node := context.LocalNode()
+	metadata, err := istio.EncodeMetadata(istio.LocalMetadata(node))
+	if err != nil {
+		panic(err)
+	}
+	local := &localConfig{id: node.GetId(), metadata: metadata}
+	return func(cb api.HTTPFilterManager) {
+		exchange := &MetadataExchange{local: local}
+		cb.AddDecodeFilter(exchange)
+		cb.AddEncodeFilter(exchange)
+	}
+}
@@ -0,0 +1,85 @@
+package metadata_exchange
+
+import (
+	"context"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+	"github.com/wereliang/govoy/pkg/istio"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type testFactoryContext struct {
+	api.FactoryContext
+	node *envoy_config_core_v3.Node
+}
+
+func (c *testFactoryContext) LocalNode() *envoy_config_core_v3.Node {
+	return c.node
+}
+
+func newNode(t *testing.T, id, name string) *envoy_config_core_v3.Node {
+	metadata, err := structpb.NewStruct(map[string]interface{}{
+		"NAME":      name,
+		"NAMESPACE": "default",
+		"LABELS":    map[string]interface{}{"app": "productpage"},
+		"PROXY_CONFIG": map[string]interface{}{
+			"concurrency": 2,
+		},
+	})
+	assert.NoError(t, err)
+	return &envoy_config_core_v3.Node{Id: id, Metadata: metadata}
+}
+
+func TestMetadataExchange(t *testing.T) {
+	handler := http.NewHandler(nil, nil)
+	new(MetadataExchangeFactory).CreateFilterFactory(nil,
+		&testFactoryContext{node: newNode(t, "sidecar~10.0.0.1~productpage-v1.default", "productpage-v1")})(handler)
+
+	peer, err := istio.EncodeMetadata(istio.LocalMetadata(newNode(t, "", "reviews-v1")))
+	assert.NoError(t, err)
+
+	req := &fasthttp.Request{}
+	req.SetRequestURI("http://reviews/reviews/0")
+	rsp := &fasthttp.Response{}
+	rsp.Header.Set(istio.HeaderPeerMetadataID, "sidecar~10.0.0.2~reviews-v1.default")
+	rsp.Header.Set(istio.HeaderPeerMetadata, peer)
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+	assert.NoError(t, handler.Handle(ctx))
+
+	// local metadata is sent to upstream, without the keys not exchanged
+	assert.Equal(t, "sidecar~10.0.0.1~productpage-v1.default", string(req.Header.Peek(istio.HeaderPeerMetadataID)))
+	local, err := istio.DecodeMetadata(string(req.Header.Peek(istio.HeaderPeerMetadata)))
+	assert.NoError(t, err)
+	assert.Equal(t, "productpage-v1", local.Fields["NAME"].GetStringValue())
+	assert.Nil(t, local.Fields["PROXY_CONFIG"])
+
+	id, metadata := istio.UpstreamPeer(ctx.StreamInfo())
+	assert.Equal(t, "sidecar~10.0.0.2~reviews-v1.default", id)
+	assert.Equal(t, "reviews-v1", metadata.Fields["NAME"].GetStringValue())
+	assert.Equal(t, "productpage", metadata.Fields["LABELS"].GetStructValue().Fields["app"].GetStringValue())
+	_, metadata = istio.DownstreamPeer(ctx.StreamInfo())
+	assert.Nil(t, metadata)
+
+	// peer headers of upstream are replaced by local
+	assert.Equal(t, "sidecar~10.0.0.1~productpage-v1.default", string(rsp.Header.Peek(istio.HeaderPeerMetadataID)))
+
+	// downstream peer from request headers, invalid metadata is ignored
+	req.Reset()
+	req.SetRequestURI("http://productpage/productpage")
+	req.Header.Set(istio.HeaderPeerMetadataID, "router~10.0.0.3~ingressgateway.istio-system")
+	req.Header.Set(istio.HeaderPeerMetadata, peer)
+	rsp.Reset()
+	rsp.Header.Set(istio.HeaderPeerMetadata, "invalid")
+	ctx = http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+	assert.NoError(t, handler.Handle(ctx))
+	id, metadata = istio.DownstreamPeer(ctx.StreamInfo())
+	assert.Equal(t, "router~10.0.0.3~ingressgateway.istio-system", id)
+	assert.Equal(t, "reviews-v1", metadata.Fields["NAME"].GetStringValue())
+	_, metadata = istio.UpstreamPeer(ctx.StreamInfo())
+	assert.Nil(t, metadata)
+}
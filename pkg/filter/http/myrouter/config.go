@@ -11,17 +11,13 @@ func init() {
 }
 
 type MyRouterFilter struct {
-	cb      api.DecoderFilterCallbacks
+	filter.PassThroughDecoderFilter
 	context api.FactoryContext
 }
 
 func (r *MyRouterFilter) SetDecoderFilterCallbacks(cb api.DecoderFilterCallbacks) {
-	r.cb = cb
-	r.cb.SetRoute(defaultRouter)
-}
-
-func (r *MyRouterFilter) Decode(ctx api.StreamContext) api.FilterStatus {
-	return api.Continue
+	r.DecoderCallbacks = cb
+	r.DecoderCallbacks.SetRoute(defaultRouter)
 }
 
 type Factory struct {
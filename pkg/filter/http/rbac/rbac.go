@@ -0,0 +1,167 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rbac
+
+import (
+	"fmt"
+	nethttp "net/http"
+
+	envoy_config_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	envoy_extensions_filters_http_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/rbac"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	accessDeniedBody = "RBAC: access denied"
+
+	// the metadata of shadow rules is keyed by shadow_rules_stat_prefix and these names, same as envoy
+	shadowEffectivePolicyID = "shadow_effective_policy_id"
+	shadowEngineResult      = "shadow_engine_result"
+
+	// access log hint is set in envoy.common if LOG action matched
+	commonMetadata = "envoy.common"
+	accessLogHint  = "access_log_hint"
+)
+
+func init() {
+	filter.HTTPFilterFactory.Regist(new(RBACFactory))
+}
+
+// rbacConfig is built from envoy.extensions.filters.http.rbac.v3.RBAC, the engines are nil if rules not set
+type rbacConfig struct {
+	engine       *rbac.Engine
+	shadowEngine *rbac.Engine
+	shadowPrefix string
+}
+
+func newRBACConfig(c *envoy_extensions_filters_http_rbac_v3.RBAC) (*rbacConfig, error) {
+	// matcher is not supported, the requests are never allowed by it without rules
+	if c.GetMatcher() != nil && c.GetRules() == nil {
+		return nil, fmt.Errorf("matcher of rbac is not supported, use rules instead")
+	}
+	if c.GetMatcher() != nil || c.GetShadowMatcher() != nil {
+		log.Warn("matcher of rbac is not supported, use rules instead")
+	}
+	config := &rbacConfig{shadowPrefix: c.GetShadowRulesStatPrefix()}
+	var err error
+	if config.engine, err = rbac.NewEngine(c.GetRules()); err != nil {
+		return nil, err
+	}
+	if config.shadowEngine, err = rbac.NewEngine(c.GetShadowRules()); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// RBAC allows or denies the request by the rules, and the result of shadow rules is only recorded
+// in dynamic metadata
+type RBAC struct {
+	filter.PassThroughDecoderFilter
+	config *rbacConfig
+}
+
+func (r *RBAC) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	config := r.config
+	if entry := r.DecoderCallbacks.RouteEntry(); entry != nil {
+		if c, ok := entry.PerFilterConfig(filter.HTTP_RBAC).(*rbacConfig); ok {
+			config = c
+		}
+	}
+	if config.engine == nil && config.shadowEngine == nil {
+		return api.Continue
+	}
+
+	attrs := rbac.NewAttributes(r.DecoderCallbacks.Connection())
+	attrs.Header = ctx.Request().Header()
+	attrs.Metadata = ctx.StreamInfo().DynamicMetadata()
+
+	if config.shadowEngine != nil {
+		allowed, policy := config.shadowEngine.Allowed(attrs)
+		result := "denied"
+		if allowed {
+			result = "allowed"
+		}
+		fields := map[string]*structpb.Value{config.shadowPrefix + shadowEngineResult: structpb.NewStringValue(result)}
+		if policy != "" {
+			fields[config.shadowPrefix+shadowEffectivePolicyID] = structpb.NewStringValue(policy)
+		}
+		ctx.StreamInfo().SetDynamicMetadata(filter.HTTP_RBAC, &structpb.Struct{Fields: fields})
+	}
+
+	if config.engine == nil {
+		return api.Continue
+	}
+	allowed, policy := config.engine.Allowed(attrs)
+	if config.engine.Action() == envoy_config_rbac_v3.RBAC_LOG {
+		ctx.StreamInfo().SetDynamicMetadata(commonMetadata, &structpb.Struct{Fields: map[string]*structpb.Value{
+			accessLogHint: structpb.NewBoolValue(policy != "")}})
+	}
+	if allowed {
+		return api.Continue
+	}
+	if policy == "" {
+		policy = "none"
+	}
+	r.DecoderCallbacks.SendLocalReply(nethttp.StatusForbidden, accessDeniedBody, "rbac_access_denied_matched_policy["+policy+"]")
+	return api.StopIteration
+}
+
+type RBACFactory struct {
+}
+
+func (f *RBACFactory) Name() string {
+	return filter.HTTP_RBAC
+}
+
+func (f *RBACFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_http_rbac_v3.RBAC{}
+}
+
+// CreateRouteSpecificFilterConfig the rbac of route is disabled if rbac of RBACPerRoute not set
+func (f *RBACFactory) CreateRouteSpecificFilterConfig(pb proto.Message) (interface{}, error) {
+	perRoute, ok := pb.(*envoy_extensions_filters_http_rbac_v3.RBACPerRoute)
+	if !ok {
+		return nil, fmt.Errorf("invalid rbac per route config: %T", pb)
+	}
+	if perRoute.GetRbac() == nil {
+		return &rbacConfig{}, nil
+	}
+	return newRBACConfig(perRoute.GetRbac())
+}
+
+func (f *RBACFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, err := newRBACConfig(pb.(*envoy_extensions_filters_http_rbac_v3.RBAC))
+	if err != nil {
+		panic(err)
+	}
+	return func(cb api.HTTPFilterManager) {
+		cb.AddDecodeFilter(&RBAC{config: config})
+	}
+}
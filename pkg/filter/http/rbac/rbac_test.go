@@ -0,0 +1,97 @@
+package rbac
+
+import (
+	"testing"
+
+	xds_type_matcher_v3 "github.com/cncf/xds/go/xds/type/matcher/v3"
+	envoy_config_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	envoy_extensions_filters_http_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/rbac/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+func newRules(action envoy_config_rbac_v3.RBAC_Action, path string) *envoy_config_rbac_v3.RBAC {
+	return &envoy_config_rbac_v3.RBAC{
+		Action: action,
+		Policies: map[string]*envoy_config_rbac_v3.Policy{"path": {
+			Permissions: []*envoy_config_rbac_v3.Permission{{Rule: &envoy_config_rbac_v3.Permission_UrlPath{
+				UrlPath: &envoy_type_matcher_v3.PathMatcher{Rule: &envoy_type_matcher_v3.PathMatcher_Path{
+					Path: &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Prefix{Prefix: path}}}}}}},
+			Principals: []*envoy_config_rbac_v3.Principal{{Identifier: &envoy_config_rbac_v3.Principal_Any{Any: true}}},
+		}},
+	}
+}
+
+func newTestHandler(t *testing.T, config *envoy_extensions_filters_http_rbac_v3.RBAC) http.Handler {
+	perRoute, err := ptypes.MarshalAny(&envoy_extensions_filters_http_rbac_v3.RBACPerRoute{})
+	assert.NoError(t, err)
+	rc := filtertest.RouteConfig("details",
+		filtertest.Route("/admin/public", "details", map[string]*any.Any{filter.HTTP_RBAC: perRoute}),
+		filtertest.Route("/", "details", nil),
+	)
+	return filtertest.NewHandler(rc, new(RBACFactory).CreateFilterFactory(config, nil))
+}
+
+func handle(t *testing.T, handler http.Handler, path string) api.StreamContext {
+	ctx := filtertest.NewContext("http://details"+path, nil)
+	assert.NoError(t, handler.Handle(ctx))
+	return ctx
+}
+
+func TestRBACMatcher(t *testing.T) {
+	// the matcher is not supported, so the config is rejected rather than allowing all requests
+	_, err := newRBACConfig(&envoy_extensions_filters_http_rbac_v3.RBAC{Matcher: &xds_type_matcher_v3.Matcher{}})
+	assert.Error(t, err)
+
+	_, err = newRBACConfig(&envoy_extensions_filters_http_rbac_v3.RBAC{
+		Rules:         newRules(envoy_config_rbac_v3.RBAC_DENY, "/admin"),
+		ShadowMatcher: &xds_type_matcher_v3.Matcher{},
+	})
+	assert.NoError(t, err)
+}
+
+func TestRBAC(t *testing.T) {
+	handler := newTestHandler(t, &envoy_extensions_filters_http_rbac_v3.RBAC{
+		Rules:                 newRules(envoy_config_rbac_v3.RBAC_DENY, "/admin"),
+		ShadowRules:           newRules(envoy_config_rbac_v3.RBAC_ALLOW, "/details"),
+		ShadowRulesStatPrefix: "istio_dry_run_allow_",
+	})
+
+	ctx := handle(t, handler, "/details/0")
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	metadata := ctx.StreamInfo().DynamicMetadata().GetFilterMetadata()[filter.HTTP_RBAC].GetFields()
+	assert.Equal(t, "allowed", metadata["istio_dry_run_allow_shadow_engine_result"].GetStringValue())
+	assert.Equal(t, "path", metadata["istio_dry_run_allow_shadow_effective_policy_id"].GetStringValue())
+
+	ctx = handle(t, handler, "/admin/config")
+	assert.Equal(t, 403, ctx.Response().Header().StatusCode())
+	assert.Equal(t, accessDeniedBody, string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "rbac_access_denied_matched_policy[path]", ctx.StreamInfo().ResponseCodeDetails())
+	metadata = ctx.StreamInfo().DynamicMetadata().GetFilterMetadata()[filter.HTTP_RBAC].GetFields()
+	assert.Equal(t, "denied", metadata["istio_dry_run_allow_shadow_engine_result"].GetStringValue())
+
+	// disabled by per route config
+	ctx = handle(t, handler, "/admin/public")
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+}
+
+func TestRBACLog(t *testing.T) {
+	handler := newTestHandler(t, &envoy_extensions_filters_http_rbac_v3.RBAC{
+		Rules: newRules(envoy_config_rbac_v3.RBAC_LOG, "/admin"),
+	})
+
+	ctx := handle(t, handler, "/admin/config")
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	hint := ctx.StreamInfo().DynamicMetadata().GetFilterMetadata()[commonMetadata].GetFields()[accessLogHint]
+	assert.True(t, hint.GetBoolValue())
+
+	ctx = handle(t, handler, "/details/0")
+	hint = ctx.StreamInfo().DynamicMetadata().GetFilterMetadata()[commonMetadata].GetFields()[accessLogHint]
+	assert.False(t, hint.GetBoolValue())
+}
@@ -25,12 +25,18 @@
 package httprouter
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net"
+	nethttp "net/http"
+	"strconv"
+	"time"
 
-	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	envoy_extensions_filters_http_router_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/valyala/fasthttp"
 	"github.com/wereliang/govoy/pkg/api"
 	"github.com/wereliang/govoy/pkg/filter"
 	"github.com/wereliang/govoy/pkg/http"
@@ -42,20 +48,35 @@ func init() {
 }
 
 type Router struct {
-	cb      api.DecoderFilterCallbacks
+	filter.PassThroughFilter
+	config  *envoy_extensions_filters_http_router_v3.Router
 	context api.FactoryContext
 }
 
-func (r *Router) SetDecoderFilterCallbacks(cb api.DecoderFilterCallbacks) {
-	r.cb = cb
+// DecodeHeaders forward the request when the whole request is decoded
+func (r *Router) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if !endStream {
+		return api.Continue
+	}
+	return r.forward(ctx)
+}
+
+func (r *Router) DecodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	if !endStream {
+		return api.Continue
+	}
+	return r.forward(ctx)
+}
+
+func (r *Router) DecodeTrailers(ctx api.StreamContext, trailers api.HeaderMap) api.FilterStatus {
+	return r.forward(ctx)
 }
 
-func (r *Router) Decode(ctx api.StreamContext) api.FilterStatus {
-	route := r.cb.Route()
-	entry := route.Match(ctx.Request().Header())
+func (r *Router) forward(ctx api.StreamContext) api.FilterStatus {
+	entry := r.DecoderCallbacks.RouteEntry()
 	if entry == nil {
 		log.Error("route match fail")
-		return api.Stop
+		return r.sendLocalReply(ctx, api.NoRouteFound, nethttp.StatusNotFound, "", "route_not_found")
 	}
 
 	log.Debug("[Cluster: %s]", entry.ClusterName())
@@ -63,45 +84,184 @@ func (r *Router) Decode(ctx api.StreamContext) api.FilterStatus {
 	cluster := r.context.ClusterManager().GetCluster(entry.ClusterName())
 	if cluster == nil {
 		log.Error("not found cluster:%s", entry.ClusterName())
-		return api.Stop
+		return r.sendLocalReply(ctx, api.NoClusterFound, nethttp.StatusServiceUnavailable, "", "cluster_not_found")
 	}
 
 	snapShot := cluster.Snapshot()
 	if snapShot == nil {
 		log.Error("snapshot is nil for cluster(%s)", entry.ClusterName())
-		return api.Stop
+		return r.sendLocalReply(ctx, api.NoHealthyUpstream, nethttp.StatusServiceUnavailable,
+			noHealthyUpstream, "no_healthy_upstream")
 	}
 
 	lb := snapShot.LoadBalancer()
 	if lb == nil {
 		log.Error("loadbalancer is nil. %s", entry.ClusterName())
-		return api.Stop
+		return r.sendLocalReply(ctx, api.NoHealthyUpstream, nethttp.StatusServiceUnavailable,
+			noHealthyUpstream, "no_healthy_upstream")
 	}
 
-	host := snapShot.LoadBalancer().Select(r.cb)
+	host := lb.Select(r.DecoderCallbacks)
+	if host == nil {
+		log.Error("no healthy host for cluster(%s)", entry.ClusterName())
+		return r.sendLocalReply(ctx, api.NoHealthyUpstream, nethttp.StatusServiceUnavailable,
+			noHealthyUpstream, "no_healthy_upstream")
+	}
 	log.Debug("[Endpoint: %s]", "http://"+host.Address().String())
 
+	ctx.StreamInfo().SetUpstreamHost(host)
 	ctx.Request().SetHost(host.Address().String())
+	entry.FinalizeRequestHeaders(ctx)
+	span := r.injectTracing(ctx, entry.ClusterName(), host)
+	r.mirror(ctx, entry)
 
-	err := http.Call(ctx, r.getSourceAddr(cluster.Snapshot().ClusterInfo().Config()))
+	host.IncActiveRequests()
+	err := cluster.ConnPool(entry.Priority()).Call(ctx)
+	host.DecActiveRequests()
+	if span != nil {
+		finishUpstreamSpan(span, ctx, err)
+	}
+	if err != nil && ctx.Context().Err() != nil {
+		// the stream is cancelled and ended by the handler
+		log.Debug("http call cancelled: %s", err)
+		return api.StopIteration
+	}
+	detector := cluster.OutlierDetector()
 	if err != nil {
 		log.Error("http call error: %s", err)
-		return api.Stop
+		flag, code, details := upstreamFailure(err)
+		if detector != nil {
+			putOutlierResult(detector, host, flag)
+		}
+		return r.sendLocalReply(ctx, flag, code, "upstream connect error or disconnect/reset before headers", details)
+	}
+	if detector != nil {
+		detector.PutHTTPResponseCode(host, ctx.Response().Header().StatusCode())
 	}
 
 	return api.Continue
 }
 
-func (r *Router) getSourceAddr(cluster *envoy_config_cluster_v3.Cluster) net.Addr {
-	if bind := cluster.GetUpstreamBindConfig(); bind != nil {
-		if addr := bind.GetSourceAddress(); addr != nil {
-			return &net.TCPAddr{IP: net.ParseIP(addr.GetAddress()), Port: int(addr.GetPortValue())}
+// putOutlierResult reports the upstream failure to outlier detection, the overflow is not the fault of host
+func putOutlierResult(detector api.OutlierDetector, host api.Host, flag api.ResponseFlag) {
+	switch flag {
+	case api.UpstreamOverflow:
+	case api.UpstreamRequestTimeout:
+		detector.PutResult(host, api.LocalOriginTimeout)
+	case api.UpstreamConnectionFailure:
+		detector.PutResult(host, api.LocalOriginConnectFailed)
+	default:
+		detector.PutResult(host, api.ExtOriginRequestFailed)
+	}
+}
+
+// injectTracing propagates the trace context to upstream, the child span is returned if start_child_span
+func (r *Router) injectTracing(ctx api.StreamContext, cluster string, host api.Host) api.Span {
+	active := ctx.StreamInfo().ActiveSpan()
+	if active == nil {
+		return nil
+	}
+	if !r.config.GetStartChildSpan() {
+		active.InjectContext(ctx.Request().Header())
+		return nil
+	}
+	span := active.SpawnChild("router "+cluster+" egress", time.Now())
+	span.SetTag("upstream_cluster", cluster)
+	span.SetTag("upstream_address", host.Address().String())
+	span.InjectContext(ctx.Request().Header())
+	return span
+}
+
+func finishUpstreamSpan(span api.Span, ctx api.StreamContext, err error) {
+	if err != nil {
+		span.SetTag("error", "true")
+		span.SetTag("error.message", err.Error())
+	} else {
+		code := ctx.Response().Header().StatusCode()
+		span.SetTag("http.status_code", strconv.Itoa(code))
+		if code >= 500 {
+			span.SetTag("error", "true")
 		}
 	}
-	return nil
+	span.Finish()
 }
 
-func (r *Router) Encode(ctx api.StreamContext) api.FilterStatus {
+// mirror copies the request to the mirror clusters, which is fire-and-forget and never affects the request
+func (r *Router) mirror(ctx api.StreamContext, entry api.RouteEntry) {
+	for _, policy := range entry.MirrorPolicies() {
+		if !policy.Enabled() {
+			continue
+		}
+		name := policy.ClusterName(ctx.Request().Header())
+		cluster := r.context.ClusterManager().GetCluster(name)
+		if cluster == nil {
+			log.Warn("not found mirror cluster:%s", name)
+			continue
+		}
+		snapShot := cluster.Snapshot()
+		if snapShot == nil || snapShot.LoadBalancer() == nil {
+			continue
+		}
+		host := snapShot.LoadBalancer().Select(r.DecoderCallbacks)
+		if host == nil {
+			log.Warn("no healthy host for mirror cluster(%s)", name)
+			continue
+		}
+
+		request, response := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		ctx.Request().Raw().(*fasthttp.Request).CopyTo(request)
+		shadow := http.NewStreamContext(context.Background(),
+			http.NewStreamInfo(nil, ctx.StreamInfo().Protocol()), request, response)
+		shadow.Request().Header().SetHost(shadowHost(string(ctx.Request().Header().Host())))
+		shadow.Request().SetHost(host.Address().String())
+		pool := cluster.ConnPool(entry.Priority())
+		go func() {
+			if err := pool.Call(shadow); err != nil {
+				log.Debug("mirror to cluster(%s) error: %s", name, err)
+			}
+			fasthttp.ReleaseRequest(request)
+			fasthttp.ReleaseResponse(response)
+		}()
+	}
+}
+
+// shadowHost appends -shadow to the host, the port is kept
+func shadowHost(host string) string {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		return net.JoinHostPort(h+"-shadow", port)
+	}
+	return host + "-shadow"
+}
+
+const noHealthyUpstream = "no healthy upstream"
+
+func (r *Router) sendLocalReply(ctx api.StreamContext,
+	flag api.ResponseFlag, code int, body string, details string) api.FilterStatus {
+	ctx.StreamInfo().SetResponseFlag(flag)
+	r.DecoderCallbacks.SendLocalReply(code, body, details)
+	return api.Stop
+}
+
+// upstreamFailure map the upstream error to response flag, status code and details
+func upstreamFailure(err error) (api.ResponseFlag, int, string) {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, http.ErrUpstreamOverflow):
+		return api.UpstreamOverflow, nethttp.StatusServiceUnavailable, "upstream_reset_before_response_started{overflow}"
+	case errors.Is(err, fasthttp.ErrTimeout):
+		return api.UpstreamRequestTimeout, nethttp.StatusGatewayTimeout, "upstream_response_timeout"
+	case errors.As(err, &opErr) && opErr.Op == "dial", errors.Is(err, fasthttp.ErrDialTimeout):
+		return api.UpstreamConnectionFailure, nethttp.StatusBadGateway, "upstream_reset_before_response_started{connection_failure}"
+	case errors.Is(err, fasthttp.ErrConnectionClosed), errors.Is(err, io.EOF):
+		return api.UpstreamConnectionTermination, nethttp.StatusBadGateway, "upstream_reset_before_response_started{connection_termination}"
+	}
+	return api.UpstreamProtocolError, nethttp.StatusBadGateway, "upstream_reset_before_response_started{protocol_error}"
+}
+
+func (r *Router) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if entry := ctx.StreamInfo().RouteEntry(); entry != nil {
+		entry.FinalizeResponseHeaders(ctx)
+	}
 	return api.Continue
 }
 
@@ -117,8 +277,9 @@ func (f *RouterFactory) CreateEmptyConfigProto() proto.Message {
 }
 
 func (f *RouterFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.HTTPFilterCreator {
+	config, _ := pb.(*envoy_extensions_filters_http_router_v3.Router)
 	return func(cb api.HTTPFilterManager) {
-		router := &Router{context: context}
+		router := &Router{config: config, context: context}
 		cb.AddDecodeFilter(router)
 		cb.AddEncodeFilter(router)
 	}
@@ -0,0 +1,88 @@
+package httprouter
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_extensions_filters_http_router_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+)
+
+// newUpstream starts a fasthttp server on a random local port
+func newUpstream(t *testing.T, handler fasthttp.RequestHandler) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := &fasthttp.Server{Handler: handler}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Shutdown() })
+	return ln.Addr().String()
+}
+
+func mirrorRoute(prefix string, numerator uint32) *envoy_config_route_v3.Route {
+	return &envoy_config_route_v3.Route{
+		Match: &envoy_config_route_v3.RouteMatch{
+			PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: prefix}},
+		Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+			ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{Cluster: "reviews"},
+			RequestMirrorPolicies: []*envoy_config_route_v3.RouteAction_RequestMirrorPolicy{{
+				Cluster: "reviews-shadow",
+				RuntimeFraction: &envoy_config_core_v3.RuntimeFractionalPercent{
+					DefaultValue: &envoy_type_v3.FractionalPercent{Numerator: numerator}},
+			}},
+		}},
+	}
+}
+
+func TestMirror(t *testing.T) {
+	primary := newUpstream(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("primary")
+	})
+	// the shadow is slow and failed, which never affects the primary response
+	shadowDelay := 500 * time.Millisecond
+	shadowHosts := make(chan string, 10)
+	shadow := newUpstream(t, func(ctx *fasthttp.RequestCtx) {
+		shadowHosts <- string(ctx.Host())
+		time.Sleep(shadowDelay)
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	})
+
+	context := filtertest.NewFactoryContext(t,
+		filtertest.StaticCluster("reviews", primary),
+		filtertest.StaticCluster("reviews-shadow", shadow))
+	rc := filtertest.RouteConfig("reviews", mirrorRoute("/never", 0), mirrorRoute("/", 100))
+	handler := filtertest.NewHandler(rc,
+		new(RouterFactory).CreateFilterFactory(&envoy_extensions_filters_http_router_v3.Router{}, context))
+
+	handle := func(path string) api.StreamContext {
+		ctx := filtertest.NewContext(path, map[string]string{"host": "reviews:9080"})
+		start := time.Now()
+		assert.NoError(t, handler.Handle(ctx))
+		assert.Less(t, time.Since(start), shadowDelay)
+		assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+		assert.Equal(t, "primary", string(ctx.Response().Body().Bytes()))
+		return ctx
+	}
+
+	handle("/reviews/0")
+	select {
+	case host := <-shadowHosts:
+		assert.Equal(t, "reviews-shadow:9080", host)
+	case <-time.After(time.Second):
+		t.Fatal("shadow request is not sent")
+	}
+
+	// runtime fraction 0 never mirrors
+	handle("/never")
+	select {
+	case host := <-shadowHosts:
+		t.Fatalf("unexpected shadow request: %s", host)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
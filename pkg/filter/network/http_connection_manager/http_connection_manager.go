@@ -27,10 +27,12 @@ package hcm
 import (
 	"bytes"
 	"fmt"
+	"sync"
 
 	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/accesslog"
 	"github.com/wereliang/govoy/pkg/api"
 	"github.com/wereliang/govoy/pkg/filter"
 	"github.com/wereliang/govoy/pkg/http"
@@ -66,22 +68,30 @@ func getRouteConfiguration(
 	panic("invalid route config")
 }
 
-func newHttpConnectionManager(pb proto.Message, cb api.ConnectionCallbacks, context api.FactoryContext) api.ReadFilter {
-	config := pb.(*envoy_filters_network_v3.HttpConnectionManager)
-	hcm := &HttpConnectionManager{config: config}
-
-	// TODO: 复用
+// hcmConfig is built once for each filter chain, and shared by connections
+type hcmConfig struct {
+	config         *envoy_filters_network_v3.HttpConnectionManager
+	context        api.FactoryContext
+	filterCreators []api.HTTPFilterCreator
+	localReply     *localReply
+	accessLogs     []api.AccessLog
+	requestID      *requestIDConfig
+	tracing        *tracingConfig
+
+	// matcher of the route config is shared by connections, so are the per route filter configs
+	// like the token buckets of local rate limit. It is rebuilt when rds updates the route config.
+	mu      sync.Mutex
+	matcher api.RouteConfigMatcher
+}
 
-	rc := getRouteConfiguration(config, context.RouteConfigManager())
-	if rc == nil {
-		return nil
+func newHcmConfig(config *envoy_filters_network_v3.HttpConnectionManager, context api.FactoryContext) *hcmConfig {
+	c := &hcmConfig{
+		config:    config,
+		context:   context,
+		requestID: newRequestIDConfig(config),
+		tracing:   mustTracingConfig(config, context),
 	}
-	log.Debug("[RouteConfig: %s]", rc.GetName())
-
-	matcher := router.NewRouterMatcher(rc)
-	handler := http.NewHandler(matcher, cb)
-
-	for _, f := range hcm.config.HttpFilters {
+	for _, f := range config.HttpFilters {
 		factory, pb := filter.GetHTTPFactory(f.GetTypedConfig(), f.Name)
 		if factory == nil {
 			if filter.IsWellknowName(f.Name) {
@@ -91,9 +101,60 @@ func newHttpConnectionManager(pb proto.Message, cb api.ConnectionCallbacks, cont
 				continue
 			}
 		}
-		factory.CreateFilterFactory(pb, context)(handler)
+		c.filterCreators = append(c.filterCreators, factory.CreateFilterFactory(pb, context))
+	}
+	if lc := config.GetLocalReplyConfig(); lc != nil {
+		c.localReply = mustLocalReply(lc)
+	}
+	accessLogs, err := accesslog.NewAccessLogs(config.GetAccessLog())
+	if err != nil {
+		panic(fmt.Errorf("invalid access log: %s", err))
+	}
+	c.accessLogs = accessLogs
+	return c
+}
+
+func (c *hcmConfig) routeMatcher() api.RouteConfigMatcher {
+	rc := getRouteConfiguration(c.config, c.context.RouteConfigManager())
+	if rc == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.matcher == nil || c.matcher.Config() != rc {
+		log.Debug("[RouteConfig: %s]", rc.GetName())
+		c.matcher = router.NewRouterMatcher(rc)
+	}
+	return c.matcher
+}
+
+func newHttpConnectionManager(c *hcmConfig, cb api.ConnectionCallbacks) api.ReadFilter {
+	hcm := &HttpConnectionManager{config: c.config}
+
+	matcher := c.routeMatcher()
+	if matcher == nil {
+		return nil
+	}
+	handler := http.NewHandler(matcher, cb)
+	if c.localReply != nil {
+		handler.SetLocalReply(c.localReply)
+	}
+	for _, l := range c.accessLogs {
+		handler.AddAccessLog(l)
+	}
+	// request id should be ready for the following filters
+	requestID := &requestIDFilter{config: c.requestID}
+	handler.AddDecodeFilter(requestID)
+	handler.AddEncodeFilter(requestID)
+	if c.tracing != nil {
+		tracing := &tracingFilter{config: c.tracing, requestID: c.requestID}
+		handler.AddDecodeFilter(tracing)
+		handler.AddAccessLog(tracing)
+	}
+	for _, creator := range c.filterCreators {
+		creator(handler)
 	}
-	hcm.streamServer = http.NewStreamServer(handler, cb)
+	hcm.streamServer = http.NewStreamServer(handler, cb, c.config.GetRequestTimeout().AsDuration())
 	return hcm
 }
 
@@ -122,8 +183,9 @@ func (f *HttpConnectionManagerFactory) CreateEmptyConfigProto() proto.Message {
 func (f *HttpConnectionManagerFactory) CreateFilterFactory(
 	pb proto.Message, context api.FactoryContext) api.NetworkFilterCreator {
 
+	c := newHcmConfig(pb.(*envoy_filters_network_v3.HttpConnectionManager), context)
 	return func(fm api.FilterManager, cb api.ConnectionCallbacks) error {
-		hcm := newHttpConnectionManager(pb, cb, context)
+		hcm := newHttpConnectionManager(c, cb)
 		if hcm == nil {
 			return fmt.Errorf("create http connection manager fail")
 		}
@@ -0,0 +1,76 @@
+package hcm
+
+import (
+	"testing"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_filters_http_local_ratelimit_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/local_ratelimit/v3"
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/filter/filtertest"
+	_ "github.com/wereliang/govoy/pkg/filter/http/local_ratelimit"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type testFactoryContext struct {
+	api.FactoryContext
+}
+
+func (c *testFactoryContext) RouteConfigManager() api.RouteConfigManager {
+	return nil
+}
+
+func TestRouteMatcherShared(t *testing.T) {
+	percent100 := &envoy_config_core_v3.RuntimeFractionalPercent{
+		DefaultValue: &envoy_type_v3.FractionalPercent{Numerator: 100}}
+	filterConfig, err := ptypes.MarshalAny(&envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit{
+		StatPrefix: "http_local_rate_limiter"})
+	assert.NoError(t, err)
+	routeConfig, err := ptypes.MarshalAny(&envoy_extensions_filters_http_local_ratelimit_v3.LocalRateLimit{
+		StatPrefix: "http_local_rate_limiter",
+		TokenBucket: &envoy_type_v3.TokenBucket{
+			MaxTokens:     1,
+			TokensPerFill: &wrappers.UInt32Value{Value: 1},
+			FillInterval:  durationpb.New(time.Hour),
+		},
+		FilterEnabled:  percent100,
+		FilterEnforced: percent100,
+	})
+	assert.NoError(t, err)
+
+	c := newHcmConfig(&envoy_filters_network_v3.HttpConnectionManager{
+		RouteSpecifier: &envoy_filters_network_v3.HttpConnectionManager_RouteConfig{
+			RouteConfig: filtertest.RouteConfig("ratings",
+				filtertest.Route("/ratings", "ratings", map[string]*any.Any{filter.HTTP_LocalRateLimit: routeConfig}),
+				filtertest.Route("/", "ratings", nil)),
+		},
+		HttpFilters: []*envoy_filters_network_v3.HttpFilter{{
+			Name:       filter.HTTP_LocalRateLimit,
+			ConfigType: &envoy_filters_network_v3.HttpFilter_TypedConfig{TypedConfig: filterConfig},
+		}},
+	}, &testFactoryContext{})
+
+	// handlers of two connections share the token bucket of the route
+	assert.Same(t, c.routeMatcher(), c.routeMatcher())
+	handlers := make([]http.Handler, 2)
+	for i := range handlers {
+		handlers[i] = http.NewHandler(c.routeMatcher(), nil)
+		for _, creator := range c.filterCreators {
+			creator(handlers[i])
+		}
+	}
+	ctx := filtertest.Handle(t, handlers[0], filtertest.NewContext("http://ratings/ratings", nil))
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	ctx = filtertest.Handle(t, handlers[1], filtertest.NewContext("http://ratings/ratings", nil))
+	assert.Equal(t, 429, ctx.Response().Header().StatusCode())
+	ctx = filtertest.Handle(t, handlers[1], filtertest.NewContext("http://ratings/details", nil))
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+}
@@ -0,0 +1,128 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package hcm
+
+import (
+	"fmt"
+
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/wereliang/govoy/pkg/accesslog"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/formatter"
+	"github.com/wereliang/govoy/pkg/router"
+	"github.com/wereliang/govoy/pkg/utils"
+)
+
+const defaultLocalReplyFormat = "%LOCAL_REPLY_BODY%"
+
+type responseMapper struct {
+	filter      accesslog.Filter
+	statusCode  int
+	body        []byte
+	bodyFormat  formatter.Formatter
+	contentType string
+	headers     router.HeaderParser
+}
+
+// localReply implement http.LocalReply by local_reply_config
+type localReply struct {
+	mappers     []*responseMapper
+	bodyFormat  formatter.Formatter
+	contentType string
+}
+
+func newLocalReply(c *envoy_filters_network_v3.LocalReplyConfig) (*localReply, error) {
+	var err error
+	lr := &localReply{}
+	for _, m := range c.GetMappers() {
+		mapper := &responseMapper{statusCode: int(m.GetStatusCode().GetValue())}
+		if mapper.filter, err = accesslog.NewFilter(m.GetFilter()); err != nil {
+			return nil, err
+		}
+		if m.GetBody() != nil {
+			if mapper.body, err = utils.ReadDataSource(m.GetBody()); err != nil {
+				return nil, err
+			}
+		}
+		if m.GetBodyFormatOverride() != nil {
+			mapper.bodyFormat, mapper.contentType, err = formatter.NewSubstitutionFormatter(m.GetBodyFormatOverride())
+			if err != nil {
+				return nil, err
+			}
+		}
+		if mapper.headers, err = router.NewHeaderParser(m.GetHeadersToAdd(), nil); err != nil {
+			return nil, err
+		}
+		lr.mappers = append(lr.mappers, mapper)
+	}
+
+	if c.GetBodyFormat() != nil {
+		lr.bodyFormat, lr.contentType, err = formatter.NewSubstitutionFormatter(c.GetBodyFormat())
+	} else {
+		lr.bodyFormat, err = formatter.NewFormatter(defaultLocalReplyFormat)
+		lr.contentType = formatter.ContentTypeText
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+func mustLocalReply(c *envoy_filters_network_v3.LocalReplyConfig) *localReply {
+	lr, err := newLocalReply(c)
+	if err != nil {
+		panic(fmt.Sprintf("invalid local reply config: %s", err))
+	}
+	return lr
+}
+
+// Rewrite the first matched mapper takes effect
+func (lr *localReply) Rewrite(ctx api.StreamContext) {
+	bodyFormat, contentType := lr.bodyFormat, lr.contentType
+	for _, m := range lr.mappers {
+		if !m.filter.Evaluate(ctx) {
+			continue
+		}
+		if m.headers != nil {
+			m.headers.Evaluate(ctx.Response().Header(), ctx)
+		}
+		if m.statusCode != 0 {
+			ctx.Response().Header().SetStatusCode(m.statusCode)
+		}
+		if m.body != nil {
+			ctx.Response().Body().SetBody(m.body)
+		}
+		if m.bodyFormat != nil {
+			bodyFormat, contentType = m.bodyFormat, m.contentType
+		}
+		break
+	}
+
+	body := bodyFormat.Format(ctx)
+	ctx.Response().Body().SetBody([]byte(body))
+	if body != "" {
+		ctx.Response().Header().Set("Content-Type", contentType)
+	}
+}
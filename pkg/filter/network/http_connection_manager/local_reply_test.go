@@ -0,0 +1,86 @@
+package hcm
+
+import (
+	"context"
+	"testing"
+
+	envoy_config_accesslog_v3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newLocalReplyContext(code int, body string, flag api.ResponseFlag) api.StreamContext {
+	req, rsp := &fasthttp.Request{}, &fasthttp.Response{}
+	req.SetRequestURI("/productpage")
+	rsp.SetStatusCode(code)
+	rsp.SetBodyString(body)
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+	ctx.StreamInfo().SetResponseFlag(flag)
+	return ctx
+}
+
+func TestLocalReply(t *testing.T) {
+	config := &envoy_filters_network_v3.LocalReplyConfig{
+		Mappers: []*envoy_filters_network_v3.ResponseMapper{
+			{
+				Filter: &envoy_config_accesslog_v3.AccessLogFilter{
+					FilterSpecifier: &envoy_config_accesslog_v3.AccessLogFilter_StatusCodeFilter{
+						StatusCodeFilter: &envoy_config_accesslog_v3.StatusCodeFilter{
+							Comparison: &envoy_config_accesslog_v3.ComparisonFilter{
+								Op:    envoy_config_accesslog_v3.ComparisonFilter_EQ,
+								Value: &envoy_config_core_v3.RuntimeUInt32{DefaultValue: 404},
+							},
+						},
+					},
+				},
+				StatusCode: &wrappers.UInt32Value{Value: 403},
+				Body: &envoy_config_core_v3.DataSource{
+					Specifier: &envoy_config_core_v3.DataSource_InlineString{InlineString: "forbidden"},
+				},
+				HeadersToAdd: []*envoy_config_core_v3.HeaderValueOption{
+					{Header: &envoy_config_core_v3.HeaderValue{Key: "x-mapped", Value: "true"}},
+				},
+			},
+			{
+				Filter: &envoy_config_accesslog_v3.AccessLogFilter{
+					FilterSpecifier: &envoy_config_accesslog_v3.AccessLogFilter_ResponseFlagFilter{
+						ResponseFlagFilter: &envoy_config_accesslog_v3.ResponseFlagFilter{Flags: []string{"UH"}},
+					},
+				},
+				BodyFormatOverride: &envoy_config_core_v3.SubstitutionFormatString{
+					Format: &envoy_config_core_v3.SubstitutionFormatString_TextFormat{
+						TextFormat: "%RESPONSE_CODE% %RESPONSE_FLAGS%: %LOCAL_REPLY_BODY%",
+					},
+				},
+			},
+		},
+		BodyFormat: &envoy_config_core_v3.SubstitutionFormatString{
+			Format: &envoy_config_core_v3.SubstitutionFormatString_JsonFormat{
+				JsonFormat: &structpb.Struct{Fields: map[string]*structpb.Value{
+					"code":    structpb.NewStringValue("%RESPONSE_CODE%"),
+					"message": structpb.NewStringValue("%LOCAL_REPLY_BODY%"),
+				}},
+			},
+		},
+	}
+	lr := mustLocalReply(config)
+
+	ctx := newLocalReplyContext(404, "", api.NoRouteFound)
+	lr.Rewrite(ctx)
+	assert.Equal(t, 403, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "true", string(ctx.Response().Header().Get("x-mapped")))
+	assert.Equal(t, "{\"code\":\"403\",\"message\":\"forbidden\"}\n", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "application/json", string(ctx.Response().Header().Get("Content-Type")))
+
+	ctx = newLocalReplyContext(503, "no healthy upstream", api.NoHealthyUpstream)
+	lr.Rewrite(ctx)
+	assert.Equal(t, 503, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "503 UH: no healthy upstream", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "text/plain", string(ctx.Response().Header().Get("Content-Type")))
+}
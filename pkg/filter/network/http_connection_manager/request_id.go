@@ -0,0 +1,202 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package hcm
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_request_id_uuid_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/request_id/uuid/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+)
+
+const requestIDHeader = "x-request-id"
+
+// the version char of uuid is used to pack the trace reason, same as envoy
+const (
+	traceByteOffset = 14
+	traceForced     = 'a'
+	traceSampled    = '9'
+	traceClient     = 'b'
+	noTrace         = '4'
+)
+
+// uuidRequestID implement api.RequestIDExtension by envoy.extensions.request_id.uuid.v3.UuidRequestIdConfig
+type uuidRequestID struct {
+	packTraceReason bool
+	useForSampling  bool
+}
+
+func newRequestIDExtension(c *envoy_filters_network_v3.RequestIDExtension) api.RequestIDExtension {
+	config := &envoy_request_id_uuid_v3.UuidRequestIdConfig{}
+	if a := c.GetTypedConfig(); a != nil {
+		if err := ptypes.UnmarshalAny(a, config); err != nil {
+			panic(fmt.Errorf("not support request id extension: %s", a.GetTypeUrl()))
+		}
+	}
+	ext := &uuidRequestID{packTraceReason: true, useForSampling: true}
+	if v := config.GetPackTraceReason(); v != nil {
+		ext.packTraceReason = v.GetValue()
+	}
+	if v := config.GetUseRequestIdForTraceSampling(); v != nil {
+		ext.useForSampling = v.GetValue()
+	}
+	return ext
+}
+
+// newUUID generate uuid version 4
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (u *uuidRequestID) Set(header api.RequestHeader, force bool) {
+	if !force && header.Get(requestIDHeader) != nil {
+		return
+	}
+	header.Set(requestIDHeader, newUUID())
+}
+
+func (u *uuidRequestID) SetInResponse(response api.ResponseHeader, request api.RequestHeader) {
+	if id := request.Get(requestIDHeader); id != nil {
+		response.Set(requestIDHeader, string(id))
+	}
+}
+
+// ModBy use the first 8 hex chars of uuid
+func (u *uuidRequestID) ModBy(header api.RequestHeader, mod uint64) (uint64, bool) {
+	id := header.Get(requestIDHeader)
+	if len(id) < 8 || mod == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(id[:8]), 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v % mod, true
+}
+
+func (u *uuidRequestID) TraceReason(header api.RequestHeader) api.TraceReason {
+	id := header.Get(requestIDHeader)
+	if !u.packTraceReason || len(id) != 36 {
+		return api.TraceNotTraceable
+	}
+	switch id[traceByteOffset] {
+	case traceForced:
+		return api.TraceForced
+	case traceSampled:
+		return api.TraceSampled
+	case traceClient:
+		return api.TraceClient
+	}
+	return api.TraceNotTraceable
+}
+
+func (u *uuidRequestID) SetTraceReason(header api.RequestHeader, reason api.TraceReason) {
+	id := header.Get(requestIDHeader)
+	if !u.packTraceReason || len(id) != 36 {
+		return
+	}
+	b := []byte(string(id))
+	switch reason {
+	case api.TraceForced:
+		b[traceByteOffset] = traceForced
+	case api.TraceSampled:
+		b[traceByteOffset] = traceSampled
+	case api.TraceClient:
+		b[traceByteOffset] = traceClient
+	default:
+		b[traceByteOffset] = noTrace
+	}
+	header.Set(requestIDHeader, string(b))
+}
+
+func (u *uuidRequestID) UseRequestIDForTraceSampling() bool {
+	return u.useForSampling
+}
+
+type requestIDConfig struct {
+	extension           api.RequestIDExtension
+	generate            bool
+	preserveExternal    bool
+	useRemoteAddress    bool
+	alwaysSetInResponse bool
+}
+
+func newRequestIDConfig(c *envoy_filters_network_v3.HttpConnectionManager) *requestIDConfig {
+	rc := &requestIDConfig{
+		extension:           newRequestIDExtension(c.GetRequestIdExtension()),
+		generate:            true,
+		preserveExternal:    c.GetPreserveExternalRequestId(),
+		useRemoteAddress:    c.GetUseRemoteAddress().GetValue(),
+		alwaysSetInResponse: c.GetAlwaysSetRequestIdInResponse(),
+	}
+	if v := c.GetGenerateRequestId(); v != nil {
+		rc.generate = v.GetValue()
+	}
+	return rc
+}
+
+// isInternal returns true for private and loopback address
+func isInternal(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && (tcp.IP.IsPrivate() || tcp.IP.IsLoopback())
+}
+
+// isEdge returns true if the request is from external address and use_remote_address is set
+func (rc *requestIDConfig) isEdge(info api.StreamInfo) bool {
+	return rc.useRemoteAddress && !isInternal(info.DownstreamRemoteAddress())
+}
+
+// requestIDFilter is the first http filter of connection manager, which handles the request id
+type requestIDFilter struct {
+	filter.PassThroughFilter
+	config *requestIDConfig
+}
+
+func (f *requestIDFilter) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Request().Header()
+	if f.config.generate {
+		// the external request id is not trusted for edge request
+		f.config.extension.Set(header, f.config.isEdge(ctx.StreamInfo()) && !f.config.preserveExternal)
+	}
+	ctx.StreamInfo().SetRequestID(string(header.Get(requestIDHeader)))
+	return api.Continue
+}
+
+func (f *requestIDFilter) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	if f.config.alwaysSetInResponse {
+		f.config.extension.SetInResponse(ctx.Response().Header(), ctx.Request().Header())
+	}
+	return api.Continue
+}
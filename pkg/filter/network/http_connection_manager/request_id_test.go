@@ -0,0 +1,88 @@
+package hcm
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+func TestRequestIDExtension(t *testing.T) {
+	ext := newRequestIDExtension(nil)
+	header := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"),
+		&fasthttp.Request{}, &fasthttp.Response{}).Request().Header()
+
+	ext.Set(header, false)
+	id := string(header.Get(requestIDHeader))
+	assert.Len(t, id, 36)
+	assert.Equal(t, byte('4'), id[14])
+	ext.Set(header, false)
+	assert.Equal(t, id, string(header.Get(requestIDHeader)))
+	ext.Set(header, true)
+	assert.NotEqual(t, id, string(header.Get(requestIDHeader)))
+
+	assert.Equal(t, api.TraceNotTraceable, ext.TraceReason(header))
+	ext.SetTraceReason(header, api.TraceForced)
+	assert.Equal(t, api.TraceForced, ext.TraceReason(header))
+	ext.SetTraceReason(header, api.TraceSampled)
+	assert.Equal(t, api.TraceSampled, ext.TraceReason(header))
+
+	header.Set(requestIDHeader, "0000000a-6f9c-4e3b-9a4e-0f1a2b3c4d5e")
+	v, ok := ext.ModBy(header, 3)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), v)
+	header.Set(requestIDHeader, "invalid")
+	_, ok = ext.ModBy(header, 3)
+	assert.False(t, ok)
+}
+
+func TestRequestIDFilter(t *testing.T) {
+	newContext := func(remote string, id string) api.StreamContext {
+		req := &fasthttp.Request{}
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		ctx := http.NewStreamContext(context.TODO(), &remoteStreamInfo{
+			StreamInfo: http.NewStreamInfo(nil, "HTTP/1.1"),
+			remote:     &net.TCPAddr{IP: net.ParseIP(remote), Port: 1234},
+		}, req, &fasthttp.Response{})
+		return ctx
+	}
+
+	f := &requestIDFilter{config: newRequestIDConfig(&envoy_filters_network_v3.HttpConnectionManager{
+		UseRemoteAddress:             &wrappers.BoolValue{Value: true},
+		AlwaysSetRequestIdInResponse: true,
+	})}
+
+	// internal request keeps the request id
+	ctx := newContext("10.0.0.1", "internal-id")
+	f.DecodeHeaders(ctx, true)
+	f.EncodeHeaders(ctx, true)
+	assert.Equal(t, "internal-id", ctx.StreamInfo().RequestID())
+	assert.Equal(t, "internal-id", string(ctx.Response().Header().Get(requestIDHeader)))
+
+	// edge request regenerates the request id
+	ctx = newContext("8.8.8.8", "external-id")
+	f.DecodeHeaders(ctx, true)
+	assert.Len(t, ctx.StreamInfo().RequestID(), 36)
+
+	f.config.preserveExternal = true
+	ctx = newContext("8.8.8.8", "external-id")
+	f.DecodeHeaders(ctx, true)
+	assert.Equal(t, "external-id", ctx.StreamInfo().RequestID())
+}
+
+type remoteStreamInfo struct {
+	api.StreamInfo
+	remote net.Addr
+}
+
+func (si *remoteStreamInfo) DownstreamRemoteAddress() net.Addr {
+	return si.remote
+}
@@ -0,0 +1,256 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package hcm
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/tracing"
+)
+
+const (
+	clientTraceIDHeader = "x-client-trace-id"
+	forceTraceHeader    = "x-envoy-force-trace"
+	downstreamCluster   = "x-envoy-downstream-service-cluster"
+
+	// sampling is decided by the value in [0, samplingDenominator)
+	samplingDenominator     = 10000
+	defaultMaxPathTagLength = 256
+)
+
+// customTag returns the value of tag, false if the tag is not set
+type customTag struct {
+	tag   string
+	value func(api.StreamContext) (string, bool)
+}
+
+func newCustomTags(c *envoy_filters_network_v3.HttpConnectionManager_Tracing) []*customTag {
+	var tags []*customTag
+	for _, t := range c.GetCustomTags() {
+		ct := &customTag{tag: t.GetTag()}
+		switch {
+		case t.GetLiteral() != nil:
+			value := t.GetLiteral().GetValue()
+			ct.value = func(api.StreamContext) (string, bool) { return value, true }
+		case t.GetEnvironment() != nil:
+			value, ok := os.LookupEnv(t.GetEnvironment().GetName())
+			if !ok {
+				value = t.GetEnvironment().GetDefaultValue()
+			}
+			ct.value = func(api.StreamContext) (string, bool) { return value, value != "" }
+		case t.GetRequestHeader() != nil:
+			name, defaultValue := t.GetRequestHeader().GetName(), t.GetRequestHeader().GetDefaultValue()
+			ct.value = func(ctx api.StreamContext) (string, bool) {
+				if v := ctx.Request().Header().Get(name); v != nil {
+					return string(v), true
+				}
+				return defaultValue, defaultValue != ""
+			}
+		default:
+			log.Warn("custom tag %s is not supported", t.GetTag())
+			continue
+		}
+		tags = append(tags, ct)
+	}
+	return tags
+}
+
+// percentToSampling converts percent to the value of samplingDenominator, 100% if not set
+func percentToSampling(p *envoy_type_v3.Percent) uint64 {
+	if p == nil {
+		return samplingDenominator
+	}
+	return uint64(p.GetValue() * samplingDenominator / 100)
+}
+
+type tracingConfig struct {
+	tracer           api.Tracer
+	clientSampling   uint64
+	randomSampling   uint64
+	overallSampling  uint64
+	maxPathTagLength int
+	customTags       []*customTag
+	nodeID           string
+}
+
+// newTracingConfig returns nil if the tracing provider is not set
+func newTracingConfig(c *envoy_filters_network_v3.HttpConnectionManager_Tracing, context api.FactoryContext) (*tracingConfig, error) {
+	if c.GetProvider() == nil {
+		log.Warn("tracing provider is not set, tracing is disabled")
+		return nil, nil
+	}
+	tracer, err := tracing.NewTracer(c.GetProvider(), context)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tracingConfig{
+		tracer:           tracer,
+		clientSampling:   percentToSampling(c.GetClientSampling()),
+		randomSampling:   percentToSampling(c.GetRandomSampling()),
+		overallSampling:  percentToSampling(c.GetOverallSampling()),
+		maxPathTagLength: defaultMaxPathTagLength,
+		customTags:       newCustomTags(c),
+		nodeID:           context.LocalNode().GetId(),
+	}
+	if v := c.GetMaxPathTagLength(); v != nil {
+		tc.maxPathTagLength = int(v.GetValue())
+	}
+	return tc, nil
+}
+
+// traceReason decides the trace reason like envoy, the reason packed in request id is kept if traced,
+// otherwise by client sampling of x-client-trace-id, x-envoy-force-trace, and random sampling. The
+// overall sampling is applied at last.
+func (c *tracingConfig) traceReason(header api.RequestHeader, ext api.RequestIDExtension) api.TraceReason {
+	result, ok := ext.ModBy(header, samplingDenominator)
+	if !ok {
+		return api.TraceNotTraceable
+	}
+	if !ext.UseRequestIDForTraceSampling() {
+		result = uint64(rand.Int63n(samplingDenominator))
+	}
+	reason := ext.TraceReason(header)
+	if reason == api.TraceNotTraceable {
+		switch {
+		case header.Get(clientTraceIDHeader) != nil && uint64(rand.Int63n(samplingDenominator)) < c.clientSampling:
+			reason = api.TraceClient
+		case header.Get(forceTraceHeader) != nil:
+			reason = api.TraceForced
+		case result < c.randomSampling:
+			reason = api.TraceSampled
+		}
+	}
+	if reason != api.TraceNotTraceable && result >= c.overallSampling {
+		reason = api.TraceNotTraceable
+	}
+	return reason
+}
+
+// tracingFilter starts the span of stream after the request id is ready, and finishes it when the stream is complete
+type tracingFilter struct {
+	filter.PassThroughDecoderFilter
+	config    *tracingConfig
+	requestID *requestIDConfig
+}
+
+func operationName(ctx api.StreamContext) string {
+	header := ctx.Request().Header()
+	return string(header.Host()) + string(header.Path())
+}
+
+func (f *tracingFilter) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	header := ctx.Request().Header()
+	// the headers to force tracing are not trusted for edge request
+	if f.requestID.isEdge(ctx.StreamInfo()) {
+		header.Del(clientTraceIDHeader)
+		header.Del(forceTraceHeader)
+	}
+	ext := f.requestID.extension
+	reason := f.config.traceReason(header, ext)
+	ext.SetTraceReason(header, reason)
+	span := f.config.tracer.StartSpan(header, operationName(ctx), ctx.StreamInfo().StartTime(),
+		reason != api.TraceNotTraceable)
+	ctx.StreamInfo().SetActiveSpan(span)
+	return api.Continue
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Log finishes the span with the tags of request and response
+func (f *tracingFilter) Log(ctx api.StreamContext) {
+	info := ctx.StreamInfo()
+	span := info.ActiveSpan()
+	if span == nil {
+		return
+	}
+	if !span.Sampled() {
+		span.Finish()
+		return
+	}
+
+	header := ctx.Request().Header()
+	cluster := ""
+	if re := info.RouteEntry(); re != nil {
+		cluster = re.ClusterName()
+		if op := re.Decorator(); op != "" {
+			span.SetOperation(op)
+		}
+	}
+	url := string(header.Host()) + string(header.RequestURI())
+	if len(url) > f.config.maxPathTagLength {
+		url = url[:f.config.maxPathTagLength]
+	}
+	code := ctx.Response().Header().StatusCode()
+
+	span.SetTag("component", "proxy")
+	span.SetTag("node_id", f.config.nodeID)
+	span.SetTag("guid:x-request-id", info.RequestID())
+	span.SetTag("http.url", url)
+	span.SetTag("http.method", string(header.Method()))
+	span.SetTag("http.protocol", info.Protocol())
+	span.SetTag("downstream_cluster", valueOrDash(string(header.Get(downstreamCluster))))
+	span.SetTag("user_agent", valueOrDash(string(header.Get("user-agent"))))
+	if addr := info.DownstreamRemoteAddress(); addr != nil {
+		span.SetTag("peer.address", addr.String())
+	}
+	span.SetTag("request_size", strconv.Itoa(len(ctx.Request().Body().Bytes())))
+	span.SetTag("response_size", strconv.Itoa(len(ctx.Response().Body().Bytes())))
+	span.SetTag("http.status_code", strconv.Itoa(code))
+	span.SetTag("response_flags", valueOrDash(info.ResponseFlags().String()))
+	span.SetTag("upstream_cluster", valueOrDash(cluster))
+	if code >= 500 {
+		span.SetTag("error", "true")
+	}
+	for _, t := range f.config.customTags {
+		if v, ok := t.value(ctx); ok {
+			span.SetTag(t.tag, v)
+		}
+	}
+	span.Finish()
+}
+
+func mustTracingConfig(c *envoy_filters_network_v3.HttpConnectionManager, context api.FactoryContext) *tracingConfig {
+	if c.GetTracing() == nil {
+		return nil
+	}
+	tc, err := newTracingConfig(c.GetTracing(), context)
+	if err != nil {
+		panic(fmt.Errorf("invalid tracing: %s", err))
+	}
+	return tc
+}
@@ -0,0 +1,165 @@
+package hcm
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	envoy_filters_network_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	envoy_type_tracing_v3 "github.com/envoyproxy/go-control-plane/envoy/type/tracing/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+type testSpan struct {
+	operation string
+	sampled   bool
+	tags      map[string]string
+	finished  bool
+}
+
+func (s *testSpan) SetOperation(name string)                              { s.operation = name }
+func (s *testSpan) SetTag(key, value string)                              { s.tags[key] = value }
+func (s *testSpan) InjectContext(header api.RequestHeader)                {}
+func (s *testSpan) SpawnChild(operation string, start time.Time) api.Span { return nil }
+func (s *testSpan) Sampled() bool                                         { return s.sampled }
+func (s *testSpan) Finish()                                               { s.finished = true }
+
+type testTracer struct{}
+
+func (t *testTracer) StartSpan(header api.RequestHeader, operation string, start time.Time, sampled bool) api.Span {
+	return &testSpan{operation: operation, sampled: sampled, tags: make(map[string]string)}
+}
+
+func TestTraceReason(t *testing.T) {
+	ext := newRequestIDExtension(nil)
+	newHeader := func(id string) api.RequestHeader {
+		header := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"),
+			&fasthttp.Request{}, &fasthttp.Response{}).Request().Header()
+		if id != "" {
+			header.Set(requestIDHeader, id)
+		}
+		return header
+	}
+	// request id mod 10000 is 10
+	id := "0000000a-6f9c-4e3b-9a4e-0f1a2b3c4d5e"
+
+	c := &tracingConfig{clientSampling: 0, randomSampling: 0, overallSampling: samplingDenominator}
+	assert.Equal(t, api.TraceNotTraceable, c.traceReason(newHeader(""), ext))
+	assert.Equal(t, api.TraceNotTraceable, c.traceReason(newHeader(id), ext))
+
+	header := newHeader(id)
+	header.Set(clientTraceIDHeader, "client")
+	assert.Equal(t, api.TraceNotTraceable, c.traceReason(header, ext))
+	c.clientSampling = samplingDenominator
+	assert.Equal(t, api.TraceClient, c.traceReason(header, ext))
+
+	header = newHeader(id)
+	header.Set(forceTraceHeader, "true")
+	assert.Equal(t, api.TraceForced, c.traceReason(header, ext))
+
+	c.randomSampling = 11
+	assert.Equal(t, api.TraceSampled, c.traceReason(newHeader(id), ext))
+	c.randomSampling = 10
+	assert.Equal(t, api.TraceNotTraceable, c.traceReason(newHeader(id), ext))
+
+	// the reason of request id is kept
+	header = newHeader(id)
+	ext.SetTraceReason(header, api.TraceForced)
+	assert.Equal(t, api.TraceForced, c.traceReason(header, ext))
+
+	// overall sampling is applied at last
+	c.overallSampling = 10
+	assert.Equal(t, api.TraceNotTraceable, c.traceReason(header, ext))
+
+	assert.Equal(t, uint64(samplingDenominator), percentToSampling(nil))
+	assert.Equal(t, uint64(150), percentToSampling(&envoy_type_v3.Percent{Value: 1.5}))
+}
+
+func TestTracingFilter(t *testing.T) {
+	os.Setenv("TRACING_TEST_POD", "productpage-v1")
+	defer os.Unsetenv("TRACING_TEST_POD")
+
+	hcm := &envoy_filters_network_v3.HttpConnectionManager{
+		UseRemoteAddress: &wrappers.BoolValue{Value: true},
+		Tracing: &envoy_filters_network_v3.HttpConnectionManager_Tracing{
+			MaxPathTagLength: &wrappers.UInt32Value{Value: 24},
+			CustomTags: []*envoy_type_tracing_v3.CustomTag{
+				{Tag: "istio.mesh_id", Type: &envoy_type_tracing_v3.CustomTag_Literal_{
+					Literal: &envoy_type_tracing_v3.CustomTag_Literal{Value: "cluster.local"}}},
+				{Tag: "istio.pod", Type: &envoy_type_tracing_v3.CustomTag_Environment_{
+					Environment: &envoy_type_tracing_v3.CustomTag_Environment{Name: "TRACING_TEST_POD"}}},
+				{Tag: "user", Type: &envoy_type_tracing_v3.CustomTag_RequestHeader{
+					RequestHeader: &envoy_type_tracing_v3.CustomTag_Header{Name: "x-user", DefaultValue: "unknown"}}},
+				{Tag: "metadata", Type: &envoy_type_tracing_v3.CustomTag_Metadata_{
+					Metadata: &envoy_type_tracing_v3.CustomTag_Metadata{}}},
+			},
+		},
+	}
+	config := &tracingConfig{
+		tracer:           &testTracer{},
+		clientSampling:   samplingDenominator,
+		randomSampling:   0,
+		overallSampling:  samplingDenominator,
+		maxPathTagLength: int(hcm.GetTracing().GetMaxPathTagLength().GetValue()),
+		customTags:       newCustomTags(hcm.GetTracing()),
+		nodeID:           "sidecar~10.0.0.1~productpage-v1.default",
+	}
+	assert.Len(t, config.customTags, 3)
+	rf := &requestIDFilter{config: newRequestIDConfig(hcm)}
+	f := &tracingFilter{config: config, requestID: rf.config}
+
+	newContext := func(remote string) api.StreamContext {
+		req := &fasthttp.Request{}
+		req.Header.SetHost("productpage:9080")
+		req.Header.SetRequestURI("/productpage?user=jason")
+		req.Header.Set(forceTraceHeader, "true")
+		ctx := http.NewStreamContext(context.TODO(), &remoteStreamInfo{
+			StreamInfo: http.NewStreamInfo(nil, "HTTP/1.1"),
+			remote:     &net.TCPAddr{IP: net.ParseIP(remote), Port: 1234},
+		}, req, &fasthttp.Response{})
+		rf.DecodeHeaders(ctx, true)
+		return ctx
+	}
+
+	// the force header of edge request is removed
+	ctx := newContext("8.8.8.8")
+	f.DecodeHeaders(ctx, true)
+	assert.Nil(t, ctx.Request().Header().Get(forceTraceHeader))
+	span := ctx.StreamInfo().ActiveSpan().(*testSpan)
+	assert.False(t, span.sampled)
+	f.Log(ctx)
+	assert.True(t, span.finished)
+	assert.Empty(t, span.tags)
+
+	ctx = newContext("10.0.0.2")
+	f.DecodeHeaders(ctx, true)
+	assert.Equal(t, api.TraceForced, f.requestID.extension.TraceReason(ctx.Request().Header()))
+	span = ctx.StreamInfo().ActiveSpan().(*testSpan)
+	assert.True(t, span.sampled)
+	assert.Equal(t, "productpage:9080/productpage", span.operation)
+
+	ctx.Response().Header().SetStatusCode(503)
+	f.Log(ctx)
+	assert.True(t, span.finished)
+	assert.Equal(t, "proxy", span.tags["component"])
+	assert.Equal(t, "sidecar~10.0.0.1~productpage-v1.default", span.tags["node_id"])
+	assert.Equal(t, ctx.StreamInfo().RequestID(), span.tags["guid:x-request-id"])
+	assert.Equal(t, "productpage:9080/product", span.tags["http.url"])
+	assert.Equal(t, "GET", span.tags["http.method"])
+	assert.Equal(t, "HTTP/1.1", span.tags["http.protocol"])
+	assert.Equal(t, "-", span.tags["downstream_cluster"])
+	assert.Equal(t, "10.0.0.2:1234", span.tags["peer.address"])
+	assert.Equal(t, "503", span.tags["http.status_code"])
+	assert.Equal(t, "true", span.tags["error"])
+	assert.Equal(t, "-", span.tags["upstream_cluster"])
+	assert.Equal(t, "cluster.local", span.tags["istio.mesh_id"])
+	assert.Equal(t, "productpage-v1", span.tags["istio.pod"])
+	assert.Equal(t, "unknown", span.tags["user"])
+}
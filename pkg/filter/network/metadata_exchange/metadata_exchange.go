@@ -0,0 +1,167 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package metadata_exchange
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/istio"
+	"github.com/wereliang/govoy/pkg/log"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"istio.io/api/envoy/config/filter/network/metadata_exchange"
+)
+
+const (
+	// exchangeMagic is the first 4 bytes of exchange header, same as istio proxy
+	exchangeMagic = 0x3D230467
+	// headerLength is the length of magic and payload size
+	headerLength = 8
+	// maxPayloadSize limits the size of exchanged metadata
+	maxPayloadSize = 1 << 20
+)
+
+func init() {
+	filter.NetworkFilterFactory.Regist(new(MetadataExchangeFactory))
+}
+
+// encodePayload returns header and payload of node metadata. The payload is google.protobuf.Any of
+// struct which contains x-envoy-peer-metadata and x-envoy-peer-metadata-id
+func encodePayload(id string, metadata *structpb.Struct) ([]byte, error) {
+	any, err := ptypes.MarshalAny(&structpb.Struct{Fields: map[string]*structpb.Value{
+		istio.HeaderPeerMetadata:   structpb.NewStructValue(metadata),
+		istio.HeaderPeerMetadataID: structpb.NewStringValue(id),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := proto.Marshal(any)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, headerLength, headerLength+len(payload))
+	binary.BigEndian.PutUint32(b, exchangeMagic)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(payload)))
+	return append(b, payload...), nil
+}
+
+func decodePayload(payload []byte) (string, *structpb.Struct, error) {
+	any := &anypb.Any{}
+	if err := proto.Unmarshal(payload, any); err != nil {
+		return "", nil, err
+	}
+	s := &structpb.Struct{}
+	if err := any.UnmarshalTo(s); err != nil {
+		return "", nil, fmt.Errorf("invalid exchange payload type %s: %s", any.GetTypeUrl(), err)
+	}
+	return s.Fields[istio.HeaderPeerMetadataID].GetStringValue(), s.Fields[istio.HeaderPeerMetadata].GetStructValue(), nil
+}
+
+// MetadataExchange reads node metadata of peer at the beginning of connection and replies local
+// node metadata, if the alpn of connection is the exchange protocol. The connection falls back to
+// no exchange if magic is not matched.
+type MetadataExchange struct {
+	protocol string
+	local    []byte
+	conn     api.Connection
+	done     bool
+}
+
+func (m *MetadataExchange) OnNewConnection() api.FilterStatus {
+	return api.Continue
+}
+
+func (m *MetadataExchange) OnData(buffer *bytes.Buffer) api.FilterStatus {
+	if m.done || buffer.Len() == 0 {
+		return api.Continue
+	}
+	if m.conn.Context().GetApplicationProtocol() != m.protocol {
+		m.done = true
+		return api.Continue
+	}
+	if buffer.Len() < headerLength {
+		return api.StopIteration
+	}
+	b := buffer.Bytes()
+	if binary.BigEndian.Uint32(b) != exchangeMagic {
+		log.Debug("metadata exchange magic not matched, fallback")
+		m.done = true
+		return api.Continue
+	}
+	size := int(binary.BigEndian.Uint32(b[4:]))
+	if size > maxPayloadSize {
+		log.Error("metadata exchange payload size %d exceeds limit", size)
+		return api.Stop
+	}
+	if buffer.Len() < headerLength+size {
+		return api.StopIteration
+	}
+	m.done = true
+	buffer.Next(headerLength)
+	id, metadata, err := decodePayload(buffer.Next(size))
+	if err != nil {
+		log.Error("decode metadata exchange payload error: %s", err)
+	} else {
+		m.conn.Context().SetPeerMetadata(id, metadata)
+	}
+	if _, err = m.conn.Write(m.local); err != nil {
+		log.Error("write metadata exchange payload error: %s", err)
+		return api.Stop
+	}
+	if buffer.Len() == 0 {
+		return api.StopIteration
+	}
+	return api.Continue
+}
+
+type MetadataExchangeFactory struct {
+}
+
+func (f *MetadataExchangeFactory) Name() string {
+	return filter.Network_MetadataExchange
+}
+
+func (f *MetadataExchangeFactory) CreateEmptyConfigProto() proto.Message {
+	return &metadata_exchange.MetadataExchange{}
+}
+
+func (f *MetadataExchangeFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.NetworkFilterCreator {
+	node := context.LocalNode()
+	local, err := encodePayload(node.GetId(), istio.LocalMetadata(node))
+	if err != nil {
+		panic(err)
+	}
+	protocol := pb.(*metadata_exchange.MetadataExchange).GetProtocol()
+	return func(fm api.FilterManager, cb api.ConnectionCallbacks) error {
+		fm.AddReadFilter(&MetadataExchange{protocol: protocol, local: local, conn: cb})
+		return nil
+	}
+}
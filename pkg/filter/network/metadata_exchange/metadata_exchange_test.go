@@ -0,0 +1,101 @@
+package metadata_exchange
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/network"
+	"google.golang.org/protobuf/types/known/structpb"
+	"istio.io/api/envoy/config/filter/network/metadata_exchange"
+)
+
+type testFactoryContext struct {
+	api.FactoryContext
+}
+
+func (c *testFactoryContext) LocalNode() *envoy_config_core_v3.Node {
+	return &envoy_config_core_v3.Node{Id: "sidecar~10.0.0.2~reviews-v1.default", Metadata: &structpb.Struct{
+		Fields: map[string]*structpb.Value{"NAME": structpb.NewStringValue("reviews-v1")}}}
+}
+
+type testConnection struct {
+	api.ConnectionCallbacks
+	ctx     *network.ConnectionContextImpl
+	written bytes.Buffer
+}
+
+func (c *testConnection) Context() api.ConnectionContext {
+	return c.ctx
+}
+
+func (c *testConnection) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+type testFilterManager struct {
+	api.FilterManager
+	filter api.ReadFilter
+}
+
+func (m *testFilterManager) AddReadFilter(f api.ReadFilter) {
+	m.filter = f
+}
+
+func newTestFilter(t *testing.T, alpn string) (api.ReadFilter, *testConnection) {
+	conn := &testConnection{ctx: &network.ConnectionContextImpl{}}
+	conn.ctx.SetApplicationProtocol(alpn)
+	fm := &testFilterManager{}
+	err := new(MetadataExchangeFactory).CreateFilterFactory(
+		&metadata_exchange.MetadataExchange{Protocol: "istio-peer-exchange"}, &testFactoryContext{})(fm, conn)
+	assert.NoError(t, err)
+	return fm.filter, conn
+}
+
+func TestMetadataExchange(t *testing.T) {
+	f, conn := newTestFilter(t, "istio-peer-exchange")
+	payload, err := encodePayload("sidecar~10.0.0.1~productpage-v1.default", &structpb.Struct{
+		Fields: map[string]*structpb.Value{"NAME": structpb.NewStringValue("productpage-v1")}})
+	assert.NoError(t, err)
+
+	// wait for the whole payload
+	buffer := bytes.NewBuffer(payload[:4])
+	assert.Equal(t, api.StopIteration, f.OnData(buffer))
+	buffer.Write(payload[4:10])
+	assert.Equal(t, api.StopIteration, f.OnData(buffer))
+	buffer.Write(payload[10:])
+	buffer.WriteString("GET / HTTP/1.1\r\n")
+	assert.Equal(t, api.Continue, f.OnData(buffer))
+	assert.Equal(t, "GET / HTTP/1.1\r\n", buffer.String())
+
+	id, metadata := conn.ctx.GetPeerMetadata()
+	assert.Equal(t, "sidecar~10.0.0.1~productpage-v1.default", id)
+	assert.Equal(t, "productpage-v1", metadata.Fields["NAME"].GetStringValue())
+
+	// local metadata is replied
+	written := conn.written.Len()
+	assert.Equal(t, uint32(exchangeMagic), binary.BigEndian.Uint32(conn.written.Bytes()))
+	id, metadata, err = decodePayload(conn.written.Bytes()[headerLength:])
+	assert.NoError(t, err)
+	assert.Equal(t, "sidecar~10.0.0.2~reviews-v1.default", id)
+	assert.Equal(t, "reviews-v1", metadata.Fields["NAME"].GetStringValue())
+
+	// exchanged only once
+	assert.Equal(t, api.Continue, f.OnData(bytes.NewBuffer(payload)))
+	assert.Equal(t, written, conn.written.Len())
+
+	// fallback if magic not matched
+	f, conn = newTestFilter(t, "istio-peer-exchange")
+	assert.Equal(t, api.Continue, f.OnData(bytes.NewBufferString("GET / HTTP/1.1\r\n")))
+	_, metadata = conn.ctx.GetPeerMetadata()
+	assert.Nil(t, metadata)
+	assert.Equal(t, 0, conn.written.Len())
+
+	// no exchange if alpn not matched
+	f, conn = newTestFilter(t, "h2")
+	assert.Equal(t, api.Continue, f.OnData(bytes.NewBuffer(payload)))
+	assert.Equal(t, 0, conn.written.Len())
+}
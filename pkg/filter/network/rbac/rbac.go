@@ -0,0 +1,122 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rbac
+
+import (
+	"bytes"
+	"fmt"
+
+	envoy_extensions_filters_network_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/rbac/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/rbac"
+)
+
+func init() {
+	filter.NetworkFilterFactory.Regist(new(RBACFactory))
+}
+
+// rbacConfig is built from envoy.extensions.filters.network.rbac.v3.RBAC, the engines are nil if rules not set
+type rbacConfig struct {
+	*envoy_extensions_filters_network_rbac_v3.RBAC
+	engine       *rbac.Engine
+	shadowEngine *rbac.Engine
+}
+
+func newRBACConfig(pb proto.Message) (*rbacConfig, error) {
+	c := &rbacConfig{RBAC: pb.(*envoy_extensions_filters_network_rbac_v3.RBAC)}
+	// matcher is not supported, the requests are never allowed by it without rules
+	if c.GetMatcher() != nil && c.GetRules() == nil {
+		return nil, fmt.Errorf("matcher of rbac is not supported, use rules instead")
+	}
+	if c.GetMatcher() != nil || c.GetShadowMatcher() != nil {
+		log.Warn("matcher of rbac is not supported, use rules instead")
+	}
+	var err error
+	if c.engine, err = rbac.NewEngine(c.GetRules()); err != nil {
+		return nil, err
+	}
+	if c.shadowEngine, err = rbac.NewEngine(c.GetShadowRules()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RBAC checks the connection when data is received, as the server name and peer certificate are
+// ready after handshake. The connection is closed if denied.
+type RBAC struct {
+	config *rbacConfig
+	conn   api.Connection
+	// checked is true if ONE_TIME_ON_FIRST_BYTE and allowed
+	checked bool
+}
+
+func (r *RBAC) OnNewConnection() api.FilterStatus {
+	return api.Continue
+}
+
+func (r *RBAC) OnData(buffer *bytes.Buffer) api.FilterStatus {
+	if r.checked {
+		return api.Continue
+	}
+	attrs := rbac.NewAttributes(r.conn)
+	if r.config.shadowEngine != nil {
+		allowed, policy := r.config.shadowEngine.Allowed(attrs)
+		log.Debug("[%s] rbac shadow rules allowed: %v, policy: %s", r.config.GetStatPrefix(), allowed, policy)
+	}
+	if r.config.engine == nil {
+		return api.Continue
+	}
+	if allowed, policy := r.config.engine.Allowed(attrs); !allowed {
+		log.Debug("[%s] rbac denied connection from %s, policy: %s", r.config.GetStatPrefix(), attrs.RemoteIP, policy)
+		return api.Stop
+	}
+	r.checked = r.config.GetEnforcementType() == envoy_extensions_filters_network_rbac_v3.RBAC_ONE_TIME_ON_FIRST_BYTE
+	return api.Continue
+}
+
+type RBACFactory struct {
+}
+
+func (f *RBACFactory) Name() string {
+	return filter.Network_RBAC
+}
+
+func (f *RBACFactory) CreateEmptyConfigProto() proto.Message {
+	return &envoy_extensions_filters_network_rbac_v3.RBAC{}
+}
+
+func (f *RBACFactory) CreateFilterFactory(pb proto.Message, context api.FactoryContext) api.NetworkFilterCreator {
+	config, err := newRBACConfig(pb)
+	if err != nil {
+		panic(err)
+	}
+	return func(fm api.FilterManager, cb api.ConnectionCallbacks) error {
+		fm.AddReadFilter(&RBAC{config: config, conn: cb})
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package filter
+
+import "github.com/wereliang/govoy/pkg/api"
+
+// PassThroughDecoderFilter continue all decoding phases, embed it and override the needed phases
+type PassThroughDecoderFilter struct {
+	DecoderCallbacks api.DecoderFilterCallbacks
+}
+
+func (f *PassThroughDecoderFilter) SetDecoderFilterCallbacks(cb api.DecoderFilterCallbacks) {
+	f.DecoderCallbacks = cb
+}
+
+func (f *PassThroughDecoderFilter) DecodeHeaders(api.StreamContext, bool) api.FilterStatus {
+	return api.Continue
+}
+
+func (f *PassThroughDecoderFilter) DecodeData(api.StreamContext, api.Body, bool) api.FilterStatus {
+	return api.Continue
+}
+
+func (f *PassThroughDecoderFilter) DecodeTrailers(api.StreamContext, api.HeaderMap) api.FilterStatus {
+	return api.Continue
+}
+
+// PassThroughEncoderFilter continue all encoding phases, embed it and override the needed phases
+type PassThroughEncoderFilter struct {
+	EncoderCallbacks api.EncoderFilterCallbacks
+}
+
+func (f *PassThroughEncoderFilter) SetEncoderFilterCallbacks(cb api.EncoderFilterCallbacks) {
+	f.EncoderCallbacks = cb
+}
+
+func (f *PassThroughEncoderFilter) EncodeHeaders(api.StreamContext, bool) api.FilterStatus {
+	return api.Continue
+}
+
+func (f *PassThroughEncoderFilter) EncodeData(api.StreamContext, api.Body, bool) api.FilterStatus {
+	return api.Continue
+}
+
+func (f *PassThroughEncoderFilter) EncodeTrailers(api.StreamContext, api.HeaderMap) api.FilterStatus {
+	return api.Continue
+}
+
+// PassThroughFilter is both decoder and encoder filter
+type PassThroughFilter struct {
+	PassThroughDecoderFilter
+	PassThroughEncoderFilter
+}
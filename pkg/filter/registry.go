@@ -71,7 +71,15 @@ func getFactory(a *any.Any, name string, r *registFactory) (api.Factory, proto.M
 		factory = r.GetFactoryByName(name)
 	} else {
 		if factory = r.GetFactoryByType(a.TypeUrl); factory == nil {
-			return nil, nil
+			// the factory registered by name only accepts any typed config, like istio's wasm filters
+			if factory = r.GetFactoryByName(name); factory == nil || factory.CreateEmptyConfigProto() != nil {
+				return nil, nil
+			}
+			var dynamic ptypes.DynamicAny
+			if err := ptypes.UnmarshalAny(a, &dynamic); err != nil {
+				panic(err)
+			}
+			return factory, dynamic.Message
 		}
 		pb = factory.CreateEmptyConfigProto()
 		err := ptypes.UnmarshalAny(a, pb)
@@ -31,8 +31,20 @@ const (
 
 	Network_Echo                  = "envoy.filters.network.echo"
 	Network_HttpConnectionManager = "envoy.filters.network.http_connection_manager"
+	Network_RBAC                  = "envoy.filters.network.rbac"
+	Network_MetadataExchange      = "istio.metadata_exchange"
 
-	HTTP_Router = "envoy.filters.http.router"
+	HTTP_Router           = "envoy.filters.http.router"
+	HTTP_Cors             = "envoy.filters.http.cors"
+	HTTP_Fault            = "envoy.filters.http.fault"
+	HTTP_LocalRateLimit   = "envoy.filters.http.local_ratelimit"
+	HTTP_ExtAuthz         = "envoy.filters.http.ext_authz"
+	HTTP_JwtAuthn         = "envoy.filters.http.jwt_authn"
+	HTTP_Compressor       = "envoy.filters.http.compressor"
+	HTTP_Decompressor     = "envoy.filters.http.decompressor"
+	HTTP_RBAC             = "envoy.filters.http.rbac"
+	HTTP_MetadataExchange = "istio.metadata_exchange"
+	HTTP_IstioStats       = "istio.stats"
 )
 
 var well_know_names = map[string]struct{}{
@@ -40,7 +52,18 @@ var well_know_names = map[string]struct{}{
 	Listener_OriginalDst:          {},
 	Listener_HttpInspector:        {},
 	Network_HttpConnectionManager: {},
+	Network_RBAC:                  {},
+	Network_MetadataExchange:      {}, // same as HTTP_MetadataExchange
 	HTTP_Router:                   {},
+	HTTP_Cors:                     {},
+	HTTP_Fault:                    {},
+	HTTP_LocalRateLimit:           {},
+	HTTP_ExtAuthz:                 {},
+	HTTP_JwtAuthn:                 {},
+	HTTP_Compressor:               {},
+	HTTP_Decompressor:             {},
+	HTTP_RBAC:                     {},
+	HTTP_IstioStats:               {},
 }
 
 func IsWellknowName(name string) bool {
@@ -0,0 +1,245 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package formatter
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+var hostname, _ = os.Hostname()
+
+func init() {
+	registCommand("REQ", newRequestHeaderCommand)
+	registCommand("RESP", newResponseHeaderCommand)
+	registCommand("START_TIME", newStartTimeCommand)
+	registCommand("PROTOCOL", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		return ctx.StreamInfo().Protocol(), true
+	}))
+	registCommand("HOSTNAME", simpleCommand(func(api.StreamContext) (string, bool) {
+		return hostname, true
+	}))
+
+	registCommand("DOWNSTREAM_REMOTE_ADDRESS", addressCommand(downstreamRemote, withPort))
+	registCommand("DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT", addressCommand(downstreamRemote, withoutPort))
+	registCommand("DOWNSTREAM_REMOTE_PORT", addressCommand(downstreamRemote, portOnly))
+	registCommand("DOWNSTREAM_LOCAL_ADDRESS", addressCommand(downstreamLocal, withPort))
+	registCommand("DOWNSTREAM_LOCAL_ADDRESS_WITHOUT_PORT", addressCommand(downstreamLocal, withoutPort))
+	registCommand("DOWNSTREAM_LOCAL_PORT", addressCommand(downstreamLocal, portOnly))
+	registCommand("UPSTREAM_HOST", addressCommand(upstreamHost, withPort))
+	registCommand("UPSTREAM_REMOTE_ADDRESS", addressCommand(upstreamHost, withPort))
+	registCommand("UPSTREAM_REMOTE_ADDRESS_WITHOUT_PORT", addressCommand(upstreamHost, withoutPort))
+	registCommand("RESPONSE_CODE", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		return strconv.Itoa(ResponseCode(ctx)), true
+	}))
+	registCommand("RESPONSE_CODE_DETAILS", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		details := ctx.StreamInfo().ResponseCodeDetails()
+		return details, details != ""
+	}))
+	registCommand("RESPONSE_FLAGS", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		flags := ctx.StreamInfo().ResponseFlags()
+		return flags.String(), flags != 0
+	}))
+	registCommand("STREAM_ID", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		id := ctx.StreamInfo().RequestID()
+		return id, id != ""
+	}))
+	registCommand("DURATION", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		return strconv.FormatInt(ctx.StreamInfo().Duration().Milliseconds(), 10), true
+	}))
+	// the body is read completely, so the bytes are the body length
+	registCommand("BYTES_RECEIVED", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		return strconv.Itoa(len(ctx.Request().Body().Bytes())), true
+	}))
+	registCommand("BYTES_SENT", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		if ctx.Response() == nil {
+			return "0", true
+		}
+		return strconv.Itoa(len(ctx.Response().Body().Bytes())), true
+	}))
+	// LOCAL_REPLY_BODY is only valid for local reply, whose body is set before formatting
+	registCommand("LOCAL_REPLY_BODY", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		if ctx.Response() == nil {
+			return "", false
+		}
+		return string(ctx.Response().Body().Bytes()), true
+	}))
+	registCommand("UPSTREAM_CLUSTER", simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		if re := ctx.StreamInfo().RouteEntry(); re != nil && re.ClusterName() != "" {
+			return re.ClusterName(), true
+		}
+		return "", false
+	}))
+}
+
+// ResponseCode returns 0 if there is no response, as fasthttp returns 200 for unset status code
+func ResponseCode(ctx api.StreamContext) int {
+	if ctx.Response() == nil {
+		return 0
+	}
+	return ctx.Response().Header().StatusCode()
+}
+
+// simpleCommand for commands without argument
+func simpleCommand(p provider) commandParser {
+	return func(arg string) (provider, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("no argument required")
+		}
+		return p, nil
+	}
+}
+
+// parseHeaderArg parse "X?Y" to main header and alternative header
+func parseHeaderArg(arg string) (string, string, error) {
+	if arg == "" {
+		return "", "", fmt.Errorf("header name required")
+	}
+	names := strings.Split(arg, "?")
+	if len(names) > 2 {
+		return "", "", fmt.Errorf("more than 1 alternative header: %s", arg)
+	}
+	if len(names) == 2 {
+		return names[0], names[1], nil
+	}
+	return names[0], "", nil
+}
+
+func newRequestHeaderCommand(arg string) (provider, error) {
+	main, alt, err := parseHeaderArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx api.StreamContext) (string, bool) {
+		header := ctx.Request().Header()
+		if v := requestHeaderValue(header, main); v != nil {
+			return string(v), true
+		}
+		if alt != "" {
+			if v := requestHeaderValue(header, alt); v != nil {
+				return string(v), true
+			}
+		}
+		return "", false
+	}, nil
+}
+
+// requestHeaderValue get request header, which supports pseudo headers of http2
+func requestHeaderValue(header api.RequestHeader, name string) []byte {
+	switch strings.ToLower(name) {
+	case ":path":
+		return header.RequestURI()
+	case ":method":
+		return header.Method()
+	case ":authority":
+		return header.Host()
+	case ":scheme":
+		return []byte("http")
+	}
+	return header.Get(name)
+}
+
+func newResponseHeaderCommand(arg string) (provider, error) {
+	main, alt, err := parseHeaderArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx api.StreamContext) (string, bool) {
+		if ctx.Response() == nil {
+			return "", false
+		}
+		header := ctx.Response().Header()
+		if v := header.Get(main); v != nil {
+			return string(v), true
+		}
+		if alt != "" {
+			if v := header.Get(alt); v != nil {
+				return string(v), true
+			}
+		}
+		return "", false
+	}, nil
+}
+
+func newStartTimeCommand(arg string) (provider, error) {
+	tf := newTimeFormatter(arg)
+	return func(ctx api.StreamContext) (string, bool) {
+		return tf(ctx.StreamInfo().StartTime()), true
+	}, nil
+}
+
+type addressGetter func(api.StreamInfo) net.Addr
+
+func downstreamRemote(info api.StreamInfo) net.Addr {
+	return info.DownstreamRemoteAddress()
+}
+
+func downstreamLocal(info api.StreamInfo) net.Addr {
+	return info.DownstreamLocalAddress()
+}
+
+func upstreamHost(info api.StreamInfo) net.Addr {
+	if h := info.UpstreamHost(); h != nil {
+		return h.Address()
+	}
+	return nil
+}
+
+type addressPart int
+
+const (
+	withPort addressPart = iota
+	withoutPort
+	portOnly
+)
+
+func addressCommand(getter addressGetter, part addressPart) commandParser {
+	return simpleCommand(func(ctx api.StreamContext) (string, bool) {
+		addr := getter(ctx.StreamInfo())
+		if addr == nil {
+			return "", false
+		}
+		switch part {
+		case withoutPort:
+			if tcp, ok := addr.(*net.TCPAddr); ok {
+				return tcp.IP.String(), true
+			}
+			host, _, err := net.SplitHostPort(addr.String())
+			return host, err == nil
+		case portOnly:
+			if tcp, ok := addr.(*net.TCPAddr); ok {
+				return strconv.Itoa(tcp.Port), true
+			}
+			_, port, err := net.SplitHostPort(addr.String())
+			return port, err == nil
+		}
+		return addr.String(), true
+	})
+}
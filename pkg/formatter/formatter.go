@@ -0,0 +1,194 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package formatter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// https://www.envoyproxy.io/docs/envoy/latest/configuration/observability/access_log/usage#command-operators
+// Format string is plain text mixed with command operators, like
+// %REQ(X?Y):Z% or %START_TIME(%Y/%m/%dT%H:%M:%S%z)%. "%%" is an escaped "%".
+
+// Formatter format string by stream context
+type Formatter interface {
+	Format(api.StreamContext) string
+}
+
+// provider returns the value of a command operator and whether the value exists
+type provider func(api.StreamContext) (string, bool)
+
+// commandParser create provider by command argument
+type commandParser func(arg string) (provider, error)
+
+var (
+	commandFactory = make(map[string]commandParser)
+)
+
+func registCommand(name string, parser commandParser) {
+	commandFactory[name] = parser
+}
+
+// NewFormatter create formatter for access log, absent value is formatted as "-"
+func NewFormatter(format string) (Formatter, error) {
+	return newFormatter(format, "-")
+}
+
+// NewHeaderFormatter create formatter for header value, absent value is formatted as empty
+func NewHeaderFormatter(format string) (Formatter, error) {
+	return newFormatter(format, "")
+}
+
+func newFormatter(format string, absent string) (*formatter, error) {
+	providers, err := parse(format)
+	if err != nil {
+		return nil, err
+	}
+	return &formatter{providers: providers, absent: absent}, nil
+}
+
+type formatter struct {
+	providers []provider
+	absent    string
+}
+
+func (f *formatter) Format(ctx api.StreamContext) string {
+	sb := &strings.Builder{}
+	for _, p := range f.providers {
+		if v, ok := p(ctx); ok {
+			sb.WriteString(v)
+		} else {
+			sb.WriteString(f.absent)
+		}
+	}
+	return sb.String()
+}
+
+func parse(format string) ([]provider, error) {
+	var (
+		providers []provider
+		text      strings.Builder
+	)
+	flushText := func() {
+		if text.Len() > 0 {
+			providers = append(providers, plainText(text.String()))
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			text.WriteByte(format[i])
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			text.WriteByte('%')
+			i++
+			continue
+		}
+		p, n, err := parseCommand(format[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid format %q: %s", format, err)
+		}
+		flushText()
+		providers = append(providers, p)
+		i += n
+	}
+	flushText()
+	return providers, nil
+}
+
+// parseCommand parse "NAME(arg):len%" and returns the provider and the length consumed
+func parseCommand(s string) (provider, int, error) {
+	pos := 0
+	for pos < len(s) && (s[pos] >= 'A' && s[pos] <= 'Z' || s[pos] >= '0' && s[pos] <= '9' || s[pos] == '_') {
+		pos++
+	}
+	name := s[:pos]
+	if name == "" {
+		return nil, 0, fmt.Errorf("empty command")
+	}
+
+	var arg string
+	if pos < len(s) && s[pos] == '(' {
+		end := strings.IndexByte(s[pos:], ')')
+		if end == -1 {
+			return nil, 0, fmt.Errorf("command %s missing ')'", name)
+		}
+		arg = s[pos+1 : pos+end]
+		pos += end + 1
+	}
+
+	maxLen := -1
+	if pos < len(s) && s[pos] == ':' {
+		end := strings.IndexByte(s[pos:], '%')
+		if end == -1 {
+			return nil, 0, fmt.Errorf("command %s missing '%%'", name)
+		}
+		n, err := strconv.Atoi(s[pos+1 : pos+end])
+		if err != nil {
+			return nil, 0, fmt.Errorf("command %s invalid length: %s", name, err)
+		}
+		maxLen = n
+		pos += end
+	}
+
+	if pos >= len(s) || s[pos] != '%' {
+		return nil, 0, fmt.Errorf("command %s missing '%%'", name)
+	}
+
+	parser, ok := commandFactory[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("not support command: %s", name)
+	}
+	p, err := parser(arg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("command %s: %s", name, err)
+	}
+	if maxLen >= 0 {
+		p = truncate(p, maxLen)
+	}
+	return p, pos + 1, nil
+}
+
+func plainText(s string) provider {
+	return func(api.StreamContext) (string, bool) {
+		return s, true
+	}
+}
+
+func truncate(p provider, maxLen int) provider {
+	return func(ctx api.StreamContext) (string, bool) {
+		v, ok := p(ctx)
+		if ok && len(v) > maxLen {
+			v = v[:maxLen]
+		}
+		return v, ok
+	}
+}
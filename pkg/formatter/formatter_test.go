@@ -0,0 +1,76 @@
+package formatter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+func newTestContext() api.StreamContext {
+	req, rsp := &fasthttp.Request{}, &fasthttp.Response{}
+	req.SetRequestURI("/productpage?u=normal")
+	req.Header.SetMethod("GET")
+	req.Header.SetHost("bookinfo.com")
+	req.Header.Set("User-Agent", "curl/7.79.1")
+	rsp.Header.Set("Content-Type", "text/html")
+	return http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+}
+
+func TestFormatter(t *testing.T) {
+	ctx := newTestContext()
+	ctx.StreamInfo().SetUpstreamHost(api.NewHost(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9080}))
+
+	tests := []struct {
+		format string
+		expect string
+	}{
+		{"plain text", "plain text"},
+		{"100%%", "100%"},
+		{"%REQ(:METHOD)% %REQ(:PATH)% %PROTOCOL%", "GET /productpage?u=normal HTTP/1.1"},
+		{"%REQ(X-FORWARDED-FOR?USER-AGENT)%", "curl/7.79.1"},
+		{"%REQ(USER-AGENT):4%", "curl"},
+		{"%REQ(X-NOT-EXIST)%", "-"},
+		{"%RESP(CONTENT-TYPE)%", "text/html"},
+		{"[%UPSTREAM_HOST%]", "[10.0.0.1:9080]"},
+		{"%UPSTREAM_REMOTE_ADDRESS_WITHOUT_PORT%", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		f, err := NewFormatter(tt.format)
+		assert.NoError(t, err, tt.format)
+		assert.Equal(t, tt.expect, f.Format(ctx), tt.format)
+	}
+
+	f, err := NewHeaderFormatter("%REQ(X-NOT-EXIST)%")
+	assert.NoError(t, err)
+	assert.Equal(t, "", f.Format(ctx))
+
+	for _, format := range []string{"%NOT_EXIST%", "%REQ%", "%PROTOCOL", "%REQ(A?B?C)%", "%PROTOCOL(x)%"} {
+		_, err := NewFormatter(format)
+		assert.Error(t, err, format)
+	}
+}
+
+func TestFormatterNoResponse(t *testing.T) {
+	// the connections logged by listener have no response
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), &fasthttp.Request{}, nil)
+	f, err := NewFormatter("%RESPONSE_CODE% %BYTES_SENT% %RESP(CONTENT-TYPE)% %LOCAL_REPLY_BODY%")
+	assert.NoError(t, err)
+	assert.Equal(t, "0 0 - -", f.Format(ctx))
+}
+
+func TestTimeFormatter(t *testing.T) {
+	ts := time.Date(2022, 11, 5, 8, 9, 10, 123456789, time.UTC)
+	assert.Equal(t, "2022-11-05T08:09:10.123Z", newTimeFormatter("")(ts))
+	assert.Equal(t, "2022/11/05 08:09:10.123456", newTimeFormatter("%Y/%m/%d %H:%M:%S.%6f")(ts))
+	assert.Equal(t, "10.123 1667635750", newTimeFormatter("%E3S %s")(ts))
+
+	f, err := NewFormatter("%START_TIME(%Y-%m-%d)%")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}$`, f.Format(newTestContext()))
+}
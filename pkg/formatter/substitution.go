@@ -0,0 +1,154 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/utils"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	ContentTypeText = "text/plain"
+	ContentTypeJSON = "application/json"
+)
+
+// NewSubstitutionFormatter create formatter by envoy.config.core.v3.SubstitutionFormatString,
+// and returns the content type of the formatted string
+func NewSubstitutionFormatter(c *envoy_config_core_v3.SubstitutionFormatString) (Formatter, string, error) {
+	var (
+		f           Formatter
+		contentType = ContentTypeText
+		err         error
+	)
+	absent := "-"
+	if c.GetOmitEmptyValues() {
+		absent = ""
+	}
+
+	switch format := c.GetFormat().(type) {
+	case *envoy_config_core_v3.SubstitutionFormatString_TextFormat:
+		f, err = newFormatter(format.TextFormat, absent)
+	case *envoy_config_core_v3.SubstitutionFormatString_TextFormatSource:
+		var text []byte
+		if text, err = utils.ReadDataSource(format.TextFormatSource); err == nil {
+			f, err = newFormatter(string(text), absent)
+		}
+	case *envoy_config_core_v3.SubstitutionFormatString_JsonFormat:
+		f, err = newJSONFormatter(format.JsonFormat, c.GetOmitEmptyValues())
+		contentType = ContentTypeJSON
+	default:
+		err = fmt.Errorf("not support substitution format: %T", format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if c.GetContentType() != "" {
+		contentType = c.GetContentType()
+	}
+	return f, contentType, nil
+}
+
+// jsonFormatter format every string value of the struct, absent value is null
+type jsonFormatter struct {
+	root      *jsonNode
+	omitEmpty bool
+}
+
+type jsonNode struct {
+	fields    map[string]*jsonNode
+	providers []provider
+	value     interface{}
+}
+
+func newJSONFormatter(s *structpb.Struct, omitEmpty bool) (*jsonFormatter, error) {
+	root, err := newJSONNode(structpb.NewStructValue(s))
+	if err != nil {
+		return nil, err
+	}
+	return &jsonFormatter{root: root, omitEmpty: omitEmpty}, nil
+}
+
+func newJSONNode(v *structpb.Value) (*jsonNode, error) {
+	switch kind := v.GetKind().(type) {
+	case *structpb.Value_StructValue:
+		node := &jsonNode{fields: make(map[string]*jsonNode)}
+		for k, fv := range kind.StructValue.GetFields() {
+			child, err := newJSONNode(fv)
+			if err != nil {
+				return nil, err
+			}
+			node.fields[k] = child
+		}
+		return node, nil
+	case *structpb.Value_StringValue:
+		providers, err := parse(kind.StringValue)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{providers: providers}, nil
+	default:
+		return &jsonNode{value: v.AsInterface()}, nil
+	}
+}
+
+func (f *jsonFormatter) Format(ctx api.StreamContext) string {
+	data, err := json.Marshal(f.root.format(ctx, f.omitEmpty))
+	if err != nil {
+		return ""
+	}
+	return string(data) + "\n"
+}
+
+func (n *jsonNode) format(ctx api.StreamContext, omitEmpty bool) interface{} {
+	if n.fields != nil {
+		m := make(map[string]interface{}, len(n.fields))
+		for k, child := range n.fields {
+			v := child.format(ctx, omitEmpty)
+			if v == nil && omitEmpty {
+				continue
+			}
+			m[k] = v
+		}
+		return m
+	}
+	if n.providers == nil {
+		return n.value
+	}
+
+	// single command is null if absent
+	if len(n.providers) == 1 {
+		if v, ok := n.providers[0](ctx); ok {
+			return v
+		}
+		return nil
+	}
+	f := &formatter{providers: n.providers, absent: "-"}
+	return f.Format(ctx)
+}
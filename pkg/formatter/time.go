@@ -0,0 +1,117 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package formatter
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// strftime layouts supported by go time package
+var timeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'Z': "MST", 'z': "-0700", 'F': "2006-01-02", 'T': "15:04:05",
+}
+
+// newTimeFormatter convert strftime format to a time formatter, which
+// also supports %s(epoch seconds), %[3|6|9]f(fractional seconds) and
+// %E[3|6|9]S(seconds with fractional) like envoy. time is in UTC.
+func newTimeFormatter(format string) func(time.Time) string {
+	if format == "" {
+		return func(t time.Time) string {
+			return t.UTC().Format(defaultTimeLayout)
+		}
+	}
+
+	var parts []func(time.Time, *strings.Builder)
+	literal := func(s string) func(time.Time, *strings.Builder) {
+		return func(_ time.Time, sb *strings.Builder) { sb.WriteString(s) }
+	}
+	fraction := func(digits int) func(time.Time, *strings.Builder) {
+		return func(t time.Time, sb *strings.Builder) {
+			ns := strconv.Itoa(t.Nanosecond() + 1e9)[1:]
+			sb.WriteString(ns[:digits])
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			parts = append(parts, literal(string(c)))
+			continue
+		}
+		i++
+		c = format[i]
+		digits := 9
+		if c >= '1' && c <= '9' && i+1 < len(format) && format[i+1] == 'f' {
+			digits = int(c - '0')
+			i++
+			c = 'f'
+		}
+		if c == 'E' && i+2 < len(format) && format[i+2] == 'S' {
+			digits = int(format[i+1] - '0')
+			i += 2
+			parts = append(parts, func(t time.Time, sb *strings.Builder) { sb.WriteString(t.Format("05")) })
+			if digits > 0 && digits <= 9 {
+				parts = append(parts, literal("."), fraction(digits))
+			}
+			continue
+		}
+
+		switch c {
+		case '%':
+			parts = append(parts, literal("%"))
+		case 's':
+			parts = append(parts, func(t time.Time, sb *strings.Builder) {
+				sb.WriteString(strconv.FormatInt(t.Unix(), 10))
+			})
+		case 'f':
+			if digits > 9 {
+				digits = 9
+			}
+			parts = append(parts, fraction(digits))
+		default:
+			if layout, ok := timeLayouts[c]; ok {
+				parts = append(parts, func(t time.Time, sb *strings.Builder) { sb.WriteString(t.Format(layout)) })
+			} else {
+				parts = append(parts, literal("%"+string(c)))
+			}
+		}
+	}
+
+	return func(t time.Time) string {
+		t = t.UTC()
+		sb := &strings.Builder{}
+		for _, p := range parts {
+			p(t, sb)
+		}
+		return sb.String()
+	}
+}
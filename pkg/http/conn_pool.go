@@ -0,0 +1,435 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_upstreams_http_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/log"
+	"github.com/wereliang/govoy/pkg/stats"
+)
+
+const (
+	httpProtocolOptionsName = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+
+	defaultCallTimeout      = time.Second * 4
+	defaultConnectTimeout   = time.Second * 5
+	defaultIdleTimeout      = time.Minute
+	defaultTCPKeepaliveTime = time.Second * 15
+)
+
+// httpProtocolOptions returns the common http protocol options of typed_extension_protocol_options,
+// or the deprecated common_http_protocol_options
+func httpProtocolOptions(c *envoy_config_cluster_v3.Cluster) *envoy_config_core_v3.HttpProtocolOptions {
+	if a, ok := c.GetTypedExtensionProtocolOptions()[httpProtocolOptionsName]; ok {
+		options := &envoy_extensions_upstreams_http_v3.HttpProtocolOptions{}
+		if err := ptypes.UnmarshalAny(a, options); err != nil {
+			log.Error("invalid http protocol options of cluster %s: %s", c.GetName(), err)
+		} else if options.GetCommonHttpProtocolOptions() != nil {
+			return options.GetCommonHttpProtocolOptions()
+		}
+	}
+	return c.GetCommonHttpProtocolOptions()
+}
+
+// sourceAddress returns the local address of upstream connections, such as 127.0.0.6 of istio inbound
+func sourceAddress(c *envoy_config_cluster_v3.Cluster) net.Addr {
+	if addr := c.GetUpstreamBindConfig().GetSourceAddress(); addr != nil {
+		return &net.TCPAddr{IP: net.ParseIP(addr.GetAddress()), Port: int(addr.GetPortValue())}
+	}
+	return nil
+}
+
+// ErrUpstreamOverflow is returned if the circuit breakers are open
+var ErrUpstreamOverflow = errors.New("upstream overflow")
+
+// connPool is created with the cluster for a priority, the idle connections to each host are kept
+// alive for the following requests, and the active connections are limited by the circuit breakers
+type connPool struct {
+	dialer          *net.Dialer
+	idleTimeout     time.Duration
+	maxConnDuration time.Duration
+	maxRequests     uint32
+	resources       api.ResourceManager
+
+	mu       sync.Mutex
+	waiters  []chan struct{}
+	idle     map[string][]*upstreamConn
+	cleaning bool
+	closed   bool
+
+	rqTotal         *stats.Counter
+	cxOverflow      *stats.Counter
+	pendingOverflow *stats.Counter
+}
+
+// NewConnPool creates the connection pool by common http protocol options and upstream connection options
+func NewConnPool(c *envoy_config_cluster_v3.Cluster, resources api.ResourceManager) api.ConnPool {
+	tags := []stats.Tag{{Name: "envoy_cluster_name", Value: c.GetName()}}
+	p := &connPool{
+		dialer: &net.Dialer{
+			Timeout:   defaultConnectTimeout,
+			LocalAddr: sourceAddress(c),
+		},
+		idleTimeout:     defaultIdleTimeout,
+		resources:       resources,
+		idle:            make(map[string][]*upstreamConn),
+		rqTotal:         stats.DefaultStore.Counter("envoy_cluster_upstream_rq_total", tags),
+		cxOverflow:      stats.DefaultStore.Counter("envoy_cluster_upstream_cx_overflow", tags),
+		pendingOverflow: stats.DefaultStore.Counter("envoy_cluster_upstream_rq_pending_overflow", tags),
+	}
+	if timeout := c.GetConnectTimeout(); timeout != nil {
+		p.dialer.Timeout = timeout.AsDuration()
+	}
+	// go sets both of the keepalive time and interval, the probes are not supported
+	if keepalive := c.GetUpstreamConnectionOptions().GetTcpKeepalive(); keepalive != nil {
+		p.dialer.KeepAlive = defaultTCPKeepaliveTime
+		if t := keepalive.GetKeepaliveTime(); t != nil {
+			p.dialer.KeepAlive = time.Duration(t.GetValue()) * time.Second
+		}
+	}
+
+	options := httpProtocolOptions(c)
+	if timeout := options.GetIdleTimeout(); timeout != nil && timeout.AsDuration() > 0 {
+		p.idleTimeout = timeout.AsDuration()
+	}
+	if duration := options.GetMaxConnectionDuration(); duration != nil {
+		p.maxConnDuration = duration.AsDuration()
+	}
+	if max := options.GetMaxRequestsPerConnection(); max != nil {
+		p.maxRequests = max.GetValue()
+	} else if max := c.GetMaxRequestsPerConnection(); max != nil {
+		p.maxRequests = max.GetValue()
+	}
+	return p
+}
+
+// upstreamConn is a http/1.1 connection, which carries one request at a time
+type upstreamConn struct {
+	net.Conn
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	created  time.Time
+	idleAt   time.Time
+	requests uint32
+}
+
+func (p *connPool) dial(addr string) (*upstreamConn, error) {
+	conn, err := p.dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &upstreamConn{
+		Conn:    conn,
+		br:      bufio.NewReader(conn),
+		bw:      bufio.NewWriter(conn),
+		created: time.Now(),
+	}, nil
+}
+
+// getConn returns the latest idle connection of the host, or dials a new one
+func (p *connPool) getConn(addr string) (*upstreamConn, bool, error) {
+	p.mu.Lock()
+	for conns := p.idle[addr]; len(conns) > 0; conns = p.idle[addr] {
+		conn := conns[len(conns)-1]
+		p.idle[addr] = conns[:len(conns)-1]
+		if time.Since(conn.idleAt) < p.idleTimeout {
+			p.mu.Unlock()
+			return conn, true, nil
+		}
+		conn.Close()
+	}
+	p.mu.Unlock()
+	conn, err := p.dial(addr)
+	return conn, false, err
+}
+
+// putConn keeps the connection alive unless it reaches the max requests or duration
+func (p *connPool) putConn(addr string, conn *upstreamConn) {
+	if (p.maxRequests > 0 && conn.requests >= p.maxRequests) ||
+		(p.maxConnDuration > 0 && time.Since(conn.created) >= p.maxConnDuration) {
+		conn.Close()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return
+	}
+	conn.idleAt = time.Now()
+	p.idle[addr] = append(p.idle[addr], conn)
+	if !p.cleaning {
+		p.cleaning = true
+		time.AfterFunc(p.idleTimeout, p.cleanIdle)
+	}
+}
+
+// cleanIdle closes the connections idle for idle_timeout, it's scheduled until no idle connections
+func (p *connPool) cleanIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conns := range p.idle {
+		alive := conns[:0]
+		for _, conn := range conns {
+			if time.Since(conn.idleAt) < p.idleTimeout {
+				alive = append(alive, conn)
+			} else {
+				conn.Close()
+			}
+		}
+		if len(alive) == 0 {
+			delete(p.idle, addr)
+		} else {
+			p.idle[addr] = alive
+		}
+	}
+	p.cleaning = len(p.idle) > 0
+	if p.cleaning {
+		time.AfterFunc(p.idleTimeout, p.cleanIdle)
+	}
+}
+
+// acquire takes a connection for the request. http/1.1 connection carries one request at a time, so
+// the connections are counted by the requests on them, and the idle ones are not counted. The request
+// is pending if the connections are exhausted, until a connection is released.
+func (p *connPool) acquire(ctx api.StreamContext, deadline time.Time) error {
+	connections, pending := p.resources.Connections(), p.resources.PendingRequests()
+	p.mu.Lock()
+	if connections.CanCreate() {
+		connections.Inc()
+		p.mu.Unlock()
+		return nil
+	}
+	p.cxOverflow.Inc()
+	if !pending.CanCreate() {
+		p.mu.Unlock()
+		p.pendingOverflow.Inc()
+		return ErrUpstreamOverflow
+	}
+	pending.Inc()
+	defer pending.Dec()
+	ready := make(chan struct{})
+	p.waiters = append(p.waiters, ready)
+	p.mu.Unlock()
+
+	// the context is done by itself if the deadline is the context's, so the error is the context's.
+	// The zero deadline means the route timeout is disabled.
+	var timeout <-chan time.Time
+	if d, ok := ctx.Context().Deadline(); !deadline.IsZero() && (!ok || deadline.Before(d)) {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Context().Done():
+	case <-timeout:
+	}
+	err := ctx.Context().Err()
+	if err == nil {
+		err = fasthttp.ErrTimeout
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, w := range p.waiters {
+		if w == ready {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return err
+		}
+	}
+	// the connection is handed over at the same time
+	p.releaseLocked()
+	return err
+}
+
+func (p *connPool) release() {
+	p.mu.Lock()
+	p.releaseLocked()
+	p.mu.Unlock()
+}
+
+// releaseLocked hands over the connection to the first pending request
+func (p *connPool) releaseLocked() {
+	if len(p.waiters) > 0 {
+		close(p.waiters[0])
+		p.waiters = p.waiters[1:]
+		return
+	}
+	p.resources.Connections().Dec()
+}
+
+// Call returns the error of context if the stream is done before the upstream response, the
+// upstream connection is closed then, so the upstream request is aborted before returning. The
+// round trip runs in the calling goroutine, so the circuit breakers are released after it's done.
+func (p *connPool) Call(ctx api.StreamContext) error {
+	p.rqTotal.Inc()
+	requests := p.resources.Requests()
+	if !requests.TryInc() {
+		p.pendingOverflow.Inc()
+		return ErrUpstreamOverflow
+	}
+	defer requests.Dec()
+
+	deadline := callDeadline(ctx)
+	if err := p.acquire(ctx, deadline); err != nil {
+		return err
+	}
+	defer p.release()
+
+	request := ctx.Request().Raw().(*fasthttp.Request)
+	response := ctx.Response().Raw().(*fasthttp.Response)
+	request.UseHostHeader = true
+	addr := string(request.URI().Host())
+	for {
+		conn, reused, err := p.getConn(addr)
+		if err != nil {
+			return err
+		}
+		written, err := p.roundTrip(ctx, conn, request, response, deadline)
+		if err == nil {
+			if request.ConnectionClose() || response.ConnectionClose() {
+				conn.Close()
+			} else {
+				p.putConn(addr, conn)
+			}
+			return nil
+		}
+		conn.Close()
+		if ctxErr := ctx.Context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		// the idle connection may be closed by upstream, so the request is sent again by a new one. The
+		// request may be processed by upstream if it's written, so it's retried only if it's idempotent.
+		if !reused || !staleConnError(err) || (written && !isIdempotent(request)) {
+			if err == io.EOF {
+				err = fasthttp.ErrConnectionClosed
+			}
+			return err
+		}
+	}
+}
+
+// callDeadline returns the deadline of route timeout, or the default timeout if no route like the
+// calls of filters. The deadline of stream context is used if it's earlier. Zero means no deadline.
+func callDeadline(ctx api.StreamContext) time.Time {
+	timeout := defaultCallTimeout
+	if entry := ctx.StreamInfo().RouteEntry(); entry != nil {
+		timeout = entry.Timeout()
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Context().Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return deadline
+}
+
+// isIdempotent is same as fasthttp client, which retries the idempotent requests only
+func isIdempotent(request *fasthttp.Request) bool {
+	return request.Header.IsGet() || request.Header.IsHead() || request.Header.IsPut()
+}
+
+// roundTrip sends the request and reads the response on the connection, which is closed to abort
+// the blocking io if the stream is done. It returns after the io is stopped, and whether the request
+// is written to the connection.
+func (p *connPool) roundTrip(ctx api.StreamContext, conn *upstreamConn,
+	request *fasthttp.Request, response *fasthttp.Response, deadline time.Time) (bool, error) {
+	if done := ctx.Context().Done(); done != nil {
+		finished, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-done:
+				conn.Close()
+			case <-finished:
+			}
+		}()
+		defer func() {
+			close(finished)
+			<-stopped
+		}()
+	}
+
+	conn.requests++
+	if err := conn.SetDeadline(deadline); err != nil {
+		return false, err
+	}
+	err := request.Write(conn.bw)
+	if err == nil {
+		err = conn.bw.Flush()
+	}
+	if err != nil {
+		return false, timeoutError(err)
+	}
+
+	skipBody := response.SkipBody || request.Header.IsHead()
+	response.Reset()
+	response.SkipBody = skipBody
+	response.Header.DisableNormalizing()
+	return true, timeoutError(response.Read(conn.br))
+}
+
+// timeoutError returns fasthttp.ErrTimeout if the deadline is exceeded, as what fasthttp client does
+func timeoutError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fasthttp.ErrTimeout
+	}
+	return err
+}
+
+func staleConnError(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// Close closes the idle connections, and the active connections are closed when the requests are done
+func (p *connPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for addr, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		delete(p.idle, addr)
+	}
+}
@@ -0,0 +1,340 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_upstreams_http_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testResource struct {
+	max   uint64
+	count uint64
+}
+
+func (r *testResource) CanCreate() bool { return atomic.LoadUint64(&r.count) < r.max }
+func (r *testResource) Inc()            { atomic.AddUint64(&r.count, 1) }
+func (r *testResource) Dec()            { atomic.AddUint64(&r.count, ^uint64(0)) }
+func (r *testResource) Count() uint64   { return atomic.LoadUint64(&r.count) }
+func (r *testResource) Max() uint64     { return r.max }
+
+func (r *testResource) TryInc() bool {
+	for {
+		count := atomic.LoadUint64(&r.count)
+		if count >= r.max {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&r.count, count, count+1) {
+			return true
+		}
+	}
+}
+
+type testResources struct {
+	connections, pending, requests, retries testResource
+}
+
+func (rm *testResources) Connections() api.ResourceLimit     { return &rm.connections }
+func (rm *testResources) PendingRequests() api.ResourceLimit { return &rm.pending }
+func (rm *testResources) Requests() api.ResourceLimit        { return &rm.requests }
+func (rm *testResources) Retries() api.ResourceLimit         { return &rm.retries }
+
+func newTestResources(connections, pending, requests uint64) *testResources {
+	return &testResources{
+		connections: testResource{max: connections},
+		pending:     testResource{max: pending},
+		requests:    testResource{max: requests},
+	}
+}
+
+func newUpstreamContext(c context.Context, addr string, method string) api.StreamContext {
+	req := &fasthttp.Request{}
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://" + addr + "/")
+	return NewStreamContext(c, NewStreamInfo(nil, "HTTP/1.1"), req, &fasthttp.Response{})
+}
+
+func TestConnPoolConfig(t *testing.T) {
+	options, err := ptypes.MarshalAny(&envoy_extensions_upstreams_http_v3.HttpProtocolOptions{
+		CommonHttpProtocolOptions: &envoy_config_core_v3.HttpProtocolOptions{
+			IdleTimeout:              durationpb.New(time.Hour),
+			MaxConnectionDuration:    durationpb.New(time.Minute),
+			MaxRequestsPerConnection: wrapperspb.UInt32(100),
+		},
+	})
+	assert.NoError(t, err)
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{
+		Name:                          "outbound|9080||reviews.default.svc.cluster.local",
+		ConnectTimeout:                durationpb.New(time.Second),
+		TypedExtensionProtocolOptions: map[string]*any.Any{httpProtocolOptionsName: options},
+		UpstreamConnectionOptions: &envoy_config_cluster_v3.UpstreamConnectionOptions{
+			TcpKeepalive: &envoy_config_core_v3.TcpKeepalive{KeepaliveTime: wrapperspb.UInt32(300)}},
+		UpstreamBindConfig: &envoy_config_core_v3.BindConfig{
+			SourceAddress: &envoy_config_core_v3.SocketAddress{Address: "127.0.0.6"}},
+	}, newTestResources(1, 1, 1)).(*connPool)
+	assert.Equal(t, time.Hour, p.idleTimeout)
+	assert.Equal(t, time.Minute, p.maxConnDuration)
+	assert.Equal(t, uint32(100), p.maxRequests)
+	assert.Equal(t, time.Second, p.dialer.Timeout)
+	assert.Equal(t, 300*time.Second, p.dialer.KeepAlive)
+	assert.Equal(t, "127.0.0.6:0", p.dialer.LocalAddr.String())
+
+	// deprecated options of cluster
+	p = NewConnPool(&envoy_config_cluster_v3.Cluster{
+		CommonHttpProtocolOptions: &envoy_config_core_v3.HttpProtocolOptions{IdleTimeout: durationpb.New(time.Second)},
+		MaxRequestsPerConnection:  wrapperspb.UInt32(1),
+	}, newTestResources(1, 1, 1)).(*connPool)
+	assert.Equal(t, time.Second, p.idleTimeout)
+	assert.Equal(t, uint32(1), p.maxRequests)
+	assert.Equal(t, defaultConnectTimeout, p.dialer.Timeout)
+	assert.Nil(t, p.dialer.LocalAddr)
+}
+
+func TestConnPoolMaxRequests(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state nethttp.ConnState) {
+		if state == nethttp.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{
+		CommonHttpProtocolOptions: &envoy_config_core_v3.HttpProtocolOptions{MaxRequestsPerConnection: wrapperspb.UInt32(2)},
+	}, newTestResources(1, 1, 1))
+	defer p.Close()
+	// the connection is closed after the max requests, and the next request takes a new one
+	for i := 0; i < 5; i++ {
+		ctx := newUpstreamContext(context.TODO(), server.Listener.Addr().String(), "POST")
+		assert.NoError(t, p.Call(ctx))
+		assert.Equal(t, "ok", string(ctx.Response().Body().Bytes()))
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&conns))
+}
+
+func TestConnPoolCancel(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("upstream"))
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{}, newTestResources(1, 1, 1))
+	defer p.Close()
+
+	ctx := newUpstreamContext(context.TODO(), addr, "GET")
+	assert.NoError(t, p.Call(ctx))
+	assert.Equal(t, "upstream", string(ctx.Response().Body().Bytes()))
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	ctx = newUpstreamContext(cancelCtx, addr, "GET")
+	assert.NoError(t, p.Call(ctx))
+	assert.Equal(t, "upstream", string(ctx.Response().Body().Bytes()))
+
+	// the call returns when cancelled without waiting for upstream
+	ctx = newUpstreamContext(cancelCtx, addr, "GET")
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	assert.ErrorIs(t, p.Call(ctx), context.Canceled)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Empty(t, ctx.Response().Body().Bytes())
+}
+
+func TestConnPoolCancelCloseUpstream(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	server := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-r.Context().Done()
+	}))
+	server.Config.ConnState = func(conn net.Conn, state nethttp.ConnState) {
+		if state == nethttp.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.Start()
+	defer server.Close()
+	resources := newTestResources(1, 1, 1)
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{}, resources)
+	defer p.Close()
+
+	// the upstream request is aborted by closing the connection when the downstream is gone
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	assert.ErrorIs(t, p.Call(newUpstreamContext(cancelCtx, server.Listener.Addr().String(), "GET")), context.Canceled)
+	assert.Equal(t, uint64(0), resources.connections.Count())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("upstream connection is not closed")
+	}
+}
+
+func TestConnPoolCircuitBreakers(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	resources := newTestResources(1, 1, 3)
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{Name: "reviews"}, resources)
+	defer p.Close()
+
+	// the first request takes the connection, and the second is pending
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- p.Call(newUpstreamContext(context.TODO(), addr, "GET")) }()
+		for resources.connections.Count()+resources.pending.Count() <= uint64(i) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.Equal(t, uint64(1), resources.connections.Count())
+	assert.Equal(t, uint64(1), resources.pending.Count())
+
+	// pending overflow
+	assert.ErrorIs(t, p.Call(newUpstreamContext(context.TODO(), addr, "GET")), ErrUpstreamOverflow)
+	// the cancelled pending request gives up waiting
+	cancelCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	resources.pending.max = 2
+	assert.ErrorIs(t, p.Call(newUpstreamContext(cancelCtx, addr, "GET")), context.DeadlineExceeded)
+	// request overflow
+	resources.requests.max = 2
+	assert.ErrorIs(t, p.Call(newUpstreamContext(context.TODO(), addr, "GET")), ErrUpstreamOverflow)
+
+	close(release)
+	assert.NoError(t, <-errs)
+	assert.NoError(t, <-errs)
+	assert.Equal(t, uint64(0), resources.connections.Count())
+	assert.Equal(t, uint64(0), resources.pending.Count())
+	assert.Equal(t, uint64(0), resources.requests.Count())
+}
+
+func TestConnPoolPendingDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-release
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	resources := newTestResources(1, 1, 2)
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{Name: "ratings"}, resources).(*connPool)
+	defer p.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Call(newUpstreamContext(context.TODO(), addr, "GET")) }()
+	for resources.connections.Count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the pending request hits the stream deadline, which is earlier than the call timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Call(newUpstreamContext(ctx, addr, "GET")), context.DeadlineExceeded)
+	p.mu.Lock()
+	assert.Empty(t, p.waiters)
+	p.mu.Unlock()
+	assert.Equal(t, uint64(0), resources.pending.Count())
+
+	close(release)
+	assert.NoError(t, <-errs)
+	assert.Equal(t, uint64(0), resources.connections.Count())
+}
+
+// newOneShotUpstream serves one request on each connection, and closes the connection after reading the next
+func newOneShotUpstream(t *testing.T) (string, *int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	received := new(int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for i := 0; i < 2; i++ {
+					req := &fasthttp.Request{}
+					if err := req.Read(br); err != nil {
+						return
+					}
+					atomic.AddInt32(received, 1)
+					if i == 0 {
+						conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestConnPoolRetryIdempotent(t *testing.T) {
+	addr, received := newOneShotUpstream(t)
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{}, newTestResources(1, 1, 1))
+	defer p.Close()
+
+	// the request on the reused connection may be processed by upstream, so POST is never sent twice
+	assert.NoError(t, p.Call(newUpstreamContext(context.TODO(), addr, "POST")))
+	assert.ErrorIs(t, p.Call(newUpstreamContext(context.TODO(), addr, "POST")), fasthttp.ErrConnectionClosed)
+	assert.Equal(t, int32(2), atomic.LoadInt32(received))
+
+	// GET is sent again by a new connection
+	assert.NoError(t, p.Call(newUpstreamContext(context.TODO(), addr, "GET")))
+	ctx := newUpstreamContext(context.TODO(), addr, "GET")
+	assert.NoError(t, p.Call(ctx))
+	assert.Equal(t, "ok", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, int32(5), atomic.LoadInt32(received))
+}
+
+type testRouteEntry struct {
+	api.RouteEntry
+	timeout time.Duration
+}
+
+func (e *testRouteEntry) Timeout() time.Duration {
+	return e.timeout
+}
+
+func TestConnPoolRouteTimeout(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+	p := NewConnPool(&envoy_config_cluster_v3.Cluster{}, newTestResources(1, 1, 1))
+	defer p.Close()
+
+	ctx := newUpstreamContext(context.TODO(), server.Listener.Addr().String(), "GET")
+	ctx.StreamInfo().SetRouteEntry(&testRouteEntry{timeout: 20 * time.Millisecond})
+	start := time.Now()
+	assert.ErrorIs(t, p.Call(ctx), fasthttp.ErrTimeout)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+
+	// the timeout is disabled by 0
+	ctx = newUpstreamContext(context.TODO(), server.Listener.Addr().String(), "GET")
+	ctx.StreamInfo().SetRouteEntry(&testRouteEntry{})
+	assert.Zero(t, callDeadline(ctx))
+	assert.NoError(t, p.Call(ctx))
+}
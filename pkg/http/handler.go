@@ -25,27 +25,47 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 
+	"github.com/valyala/fasthttp"
 	"github.com/wereliang/govoy/pkg/api"
 )
 
 type Handler interface {
 	api.HTTPFilterManager
 	api.DecoderFilterCallbacks
-	Decode(api.StreamContext) error
-	Encode(api.StreamContext) error
+	api.EncoderFilterCallbacks
+	// Handle run the filter chain of the stream, returns until the stream is done
+	Handle(api.StreamContext) error
+	// OnComplete is called after the response is written
+	OnComplete(api.StreamContext)
+	SetLocalReply(LocalReply)
+}
+
+// LocalReply rewrite the local reply before sending to downstream, see local_reply_config
+type LocalReply interface {
+	Rewrite(api.StreamContext)
 }
 
 func NewHandler(r api.RouteConfigMatcher, c api.Connection) Handler {
 	return &httpHandler{routeMatcher: r, connection: c}
 }
 
+// httpHandler is created per connection, and requests in one connection are handled serially,
+// so the filter callbacks are forwarded to the current stream.
 type httpHandler struct {
 	decodeFilters []api.StreamDecoderFilter
 	encodeFilters []api.StreamEncoderFilter
 	routeMatcher  api.RouteConfigMatcher
 	connection    api.Connection
+	localReply    LocalReply
+	accessLogs    []api.AccessLog
+	mu            sync.Mutex
+	stream        *activeStream
 }
 
 func (h *httpHandler) Route() api.RouteConfigMatcher {
@@ -56,33 +76,335 @@ func (h *httpHandler) SetRoute(r api.RouteConfigMatcher) {
 	h.routeMatcher = r
 }
 
+func (h *httpHandler) RouteEntry() api.RouteEntry {
+	s := h.current()
+	if s == nil || h.routeMatcher == nil {
+		return nil
+	}
+	info := s.ctx.StreamInfo()
+	if entry := info.RouteEntry(); entry != nil {
+		return entry
+	}
+	entry := h.routeMatcher.Match(s.ctx.Request().Header())
+	info.SetRouteEntry(entry)
+	return entry
+}
+
+func (h *httpHandler) ClearRouteCache() {
+	if s := h.current(); s != nil {
+		s.ctx.StreamInfo().SetRouteEntry(nil)
+	}
+}
+
 func (h *httpHandler) Connection() api.Connection {
 	return h.connection
 }
 
+func (h *httpHandler) SetLocalReply(l LocalReply) {
+	h.localReply = l
+}
+
 func (h *httpHandler) AddDecodeFilter(f api.StreamDecoderFilter) {
 	f.SetDecoderFilterCallbacks(h)
 	h.decodeFilters = append(h.decodeFilters, f)
 }
 
 func (h *httpHandler) AddEncodeFilter(f api.StreamEncoderFilter) {
+	f.SetEncoderFilterCallbacks(h)
 	h.encodeFilters = append(h.encodeFilters, f)
 }
 
-func (h *httpHandler) Decode(ctx api.StreamContext) error {
-	for _, f := range h.decodeFilters {
-		if f.Decode(ctx) == api.Stop {
-			return fmt.Errorf("decode error")
+func (h *httpHandler) AddAccessLog(l api.AccessLog) {
+	h.accessLogs = append(h.accessLogs, l)
+}
+
+func (h *httpHandler) current() *activeStream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stream
+}
+
+// Dispatcher returns a dispatcher dropping all events if no stream is running
+func (h *httpHandler) Dispatcher() api.Dispatcher {
+	if s := h.current(); s != nil {
+		return s
+	}
+	return &activeStream{done: true}
+}
+
+func (h *httpHandler) SendLocalReply(code int, body string, details string) {
+	if s := h.current(); s != nil {
+		s.post(func() { s.sendLocalReply(code, body, details) })
+	}
+}
+
+func (h *httpHandler) ContinueDecoding() {
+	if s := h.current(); s != nil {
+		s.post(func() { s.resume(true) })
+	}
+}
+
+func (h *httpHandler) ContinueEncoding() {
+	if s := h.current(); s != nil {
+		s.post(func() { s.resume(false) })
+	}
+}
+
+func (h *httpHandler) Handle(ctx api.StreamContext) error {
+	s := newActiveStream(h, ctx)
+	h.mu.Lock()
+	h.stream = s
+	h.mu.Unlock()
+
+	s.run()
+
+	h.mu.Lock()
+	h.stream = nil
+	h.mu.Unlock()
+	s.close()
+	return s.err
+}
+
+func (h *httpHandler) OnComplete(ctx api.StreamContext) {
+	ctx.StreamInfo().OnRequestComplete()
+	for _, l := range h.accessLogs {
+		l.Log(ctx)
+	}
+}
+
+type streamPhase int
+
+const (
+	phaseDecodeHeaders streamPhase = iota
+	phaseDecodeData
+	phaseDecodeTrailers
+	phaseEncodeHeaders
+	phaseEncodeData
+	phaseEncodeTrailers
+	phaseDone
+)
+
+func (p streamPhase) decoding() bool {
+	return p < phaseEncodeHeaders
+}
+
+// activeStream holds the filter iteration state of a stream. The filters and the events
+// posted by callbacks are all run in the stream goroutine, so no lock is needed for the state.
+// Iteration is stopped at the filter returning StopIteration, the following filters and phases
+// are not called until continue.
+type activeStream struct {
+	handler      *httpHandler
+	ctx          api.StreamContext
+	phase        streamPhase
+	index        int
+	stopped      bool
+	localReplied bool
+	err          error
+
+	mu     sync.Mutex
+	events []func()
+	notify chan struct{}
+	done   bool
+}
+
+func newActiveStream(h *httpHandler, ctx api.StreamContext) *activeStream {
+	return &activeStream{handler: h, ctx: ctx, notify: make(chan struct{}, 1)}
+}
+
+// Post the event to the stream goroutine, the event is dropped if the stream is done
+func (s *activeStream) Post(event func()) {
+	s.post(event)
+}
+
+// post the event to stream goroutine, it never blocks
+func (s *activeStream) post(event func()) {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close drops the pending and later events
+func (s *activeStream) close() {
+	s.mu.Lock()
+	s.done = true
+	s.events = nil
+	s.mu.Unlock()
+}
+
+func (s *activeStream) runEvents() {
+	s.mu.Lock()
+	events := s.events
+	s.events = nil
+	s.mu.Unlock()
+	for _, event := range events {
+		event()
+	}
+}
+
+// run iterate the filters until the stream is done, waiting for events if stopped
+func (s *activeStream) run() {
+	done := s.ctx.Context().Done()
+	for {
+		s.runEvents()
+		if s.phase == phaseDone {
+			return
+		}
+		if err := s.ctx.Context().Err(); err != nil {
+			s.cancel(err)
+			continue
+		}
+		if s.stopped {
+			select {
+			case <-s.notify:
+			case <-done:
+			}
+			continue
 		}
+		s.iterate()
 	}
-	return nil
 }
 
-func (h *httpHandler) Encode(ctx api.StreamContext) error {
-	for _, f := range h.encodeFilters {
-		if f.Encode(ctx) == api.Stop {
-			return fmt.Errorf("encode error")
+// cancel ends the stream when the context is done. 408 is replied if request timeout before
+// the response started, and the encoder filters are skipped.
+func (s *activeStream) cancel(err error) {
+	s.err = err
+	if errors.Is(err, context.DeadlineExceeded) {
+		if s.phase.decoding() {
+			s.sendLocalReply(http.StatusRequestTimeout, "request timeout", "request_overall_timeout")
 		}
+	} else {
+		s.ctx.StreamInfo().SetResponseFlag(api.DownstreamConnectionTermination)
+		s.ctx.StreamInfo().SetResponseCodeDetails("downstream_remote_disconnect")
+	}
+	s.phase = phaseDone
+}
+
+func (s *activeStream) hasBody(phase streamPhase) bool {
+	if phase.decoding() {
+		return len(s.ctx.Request().Body().Bytes()) > 0
+	}
+	return len(s.ctx.Response().Body().Bytes()) > 0
+}
+
+// fasthttp merges the trailers into headers, and only the trailer names are kept
+func (s *activeStream) hasTrailers(phase streamPhase) bool {
+	has := false
+	if phase.decoding() {
+		s.ctx.Request().Raw().(*fasthttp.Request).Header.VisitAllTrailer(func([]byte) { has = true })
+	} else {
+		s.ctx.Response().Raw().(*fasthttp.Response).Header.VisitAllTrailer(func([]byte) { has = true })
+	}
+	return has
+}
+
+func (s *activeStream) skipPhase() bool {
+	switch s.phase {
+	case phaseDecodeData, phaseEncodeData:
+		return !s.hasBody(s.phase)
+	case phaseDecodeTrailers, phaseEncodeTrailers:
+		return !s.hasTrailers(s.phase)
+	}
+	return false
+}
+
+func (s *activeStream) filterCount() int {
+	if s.phase.decoding() {
+		return len(s.handler.decodeFilters)
+	}
+	return len(s.handler.encodeFilters)
+}
+
+// iterate call the next filter of current phase, encoder filters are called in reverse order
+func (s *activeStream) iterate() {
+	if s.index == s.filterCount() || s.skipPhase() {
+		s.phase++
+		s.index = 0
+		return
+	}
+
+	var status api.FilterStatus
+	switch s.phase {
+	case phaseDecodeHeaders:
+		endStream := !s.hasBody(s.phase) && !s.hasTrailers(s.phase)
+		status = s.handler.decodeFilters[s.index].DecodeHeaders(s.ctx, endStream)
+	case phaseDecodeData:
+		status = s.handler.decodeFilters[s.index].DecodeData(s.ctx, s.ctx.Request().Body(), !s.hasTrailers(s.phase))
+	case phaseDecodeTrailers:
+		status = s.handler.decodeFilters[s.index].DecodeTrailers(s.ctx, s.ctx.Request().Header())
+	case phaseEncodeHeaders:
+		endStream := !s.hasBody(s.phase) && !s.hasTrailers(s.phase)
+		status = s.encoder().EncodeHeaders(s.ctx, endStream)
+	case phaseEncodeData:
+		status = s.encoder().EncodeData(s.ctx, s.ctx.Response().Body(), !s.hasTrailers(s.phase))
+	case phaseEncodeTrailers:
+		status = s.encoder().EncodeTrailers(s.ctx, s.ctx.Response().Header())
+	}
+	s.index++
+
+	switch status {
+	case api.Continue:
+	case api.StopIteration, api.StopAndBuffer:
+		s.stopped = true
+	default:
+		// local reply may be sent before stop
+		phase := s.phase
+		s.runEvents()
+		if s.phase != phase {
+			return
+		}
+		if s.phase.decoding() {
+			s.err = fmt.Errorf("decode error")
+			// filter stop without reply, avoid blank 200 response
+			s.sendLocalReply(http.StatusInternalServerError, "", "filter_chain_stopped")
+		} else {
+			s.err = fmt.Errorf("encode error")
+			s.phase = phaseDone
+		}
+	}
+}
+
+func (s *activeStream) encoder() api.StreamEncoderFilter {
+	filters := s.handler.encodeFilters
+	return filters[len(filters)-1-s.index]
+}
+
+func (s *activeStream) resume(decoding bool) {
+	if s.phase != phaseDone && s.phase.decoding() == decoding {
+		s.stopped = false
+	}
+}
+
+// sendLocalReply in decoding phases replace the response and go through all encoder filters,
+// but in encoding phases the stream is done directly.
+func (s *activeStream) sendLocalReply(code int, body string, details string) {
+	if s.phase == phaseDone || s.localReplied {
+		return
+	}
+	response := s.ctx.Response().Raw().(*fasthttp.Response)
+	response.Reset()
+	response.SetStatusCode(code)
+	if body != "" {
+		response.Header.SetContentType("text/plain")
+		response.SetBodyString(body)
+	}
+	s.ctx.StreamInfo().SetResponseCodeDetails(details)
+	if s.handler.localReply != nil {
+		s.handler.localReply.Rewrite(s.ctx)
+	}
+
+	s.localReplied = true
+	s.stopped = false
+	if s.phase.decoding() {
+		s.phase, s.index = phaseEncodeHeaders, 0
+	} else {
+		s.phase = phaseDone
 	}
-	return nil
 }
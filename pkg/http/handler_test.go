@@ -0,0 +1,187 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+)
+
+type testFilter struct {
+	filter.PassThroughFilter
+	name    string
+	decode  func(api.StreamContext, api.DecoderFilterCallbacks) api.FilterStatus
+	encode  func(api.StreamContext, api.EncoderFilterCallbacks) api.FilterStatus
+	records *[]string
+}
+
+func (f *testFilter) DecodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	*f.records = append(*f.records, "decode "+f.name)
+	if f.decode != nil {
+		return f.decode(ctx, f.DecoderCallbacks)
+	}
+	return api.Continue
+}
+
+func (f *testFilter) DecodeData(ctx api.StreamContext, data api.Body, endStream bool) api.FilterStatus {
+	*f.records = append(*f.records, "data "+f.name)
+	return api.Continue
+}
+
+func (f *testFilter) EncodeHeaders(ctx api.StreamContext, endStream bool) api.FilterStatus {
+	*f.records = append(*f.records, "encode "+f.name)
+	if f.encode != nil {
+		return f.encode(ctx, f.EncoderCallbacks)
+	}
+	return api.Continue
+}
+
+func newTestStreamContext() api.StreamContext {
+	return NewStreamContext(context.TODO(), NewStreamInfo(nil, "HTTP/1.1"),
+		&fasthttp.Request{}, &fasthttp.Response{})
+}
+
+func TestHandlerAsyncIteration(t *testing.T) {
+	var records []string
+	handler := NewHandler(nil, nil)
+	auth := &testFilter{name: "auth", records: &records,
+		decode: func(ctx api.StreamContext, cb api.DecoderFilterCallbacks) api.FilterStatus {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				ctx.Request().Header().Set("x-auth", "ok")
+				cb.ContinueDecoding()
+			}()
+			return api.StopIteration
+		},
+		encode: func(ctx api.StreamContext, cb api.EncoderFilterCallbacks) api.FilterStatus {
+			go cb.ContinueEncoding()
+			return api.StopAndBuffer
+		},
+	}
+	upstream := &testFilter{name: "upstream", records: &records,
+		decode: func(ctx api.StreamContext, cb api.DecoderFilterCallbacks) api.FilterStatus {
+			ctx.Response().Body().SetBody(ctx.Request().Header().Get("x-auth"))
+			return api.Continue
+		},
+	}
+	for _, f := range []*testFilter{auth, upstream} {
+		handler.AddDecodeFilter(f)
+		handler.AddEncodeFilter(f)
+	}
+
+	ctx := newTestStreamContext()
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, "ok", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, []string{"decode auth", "decode upstream", "encode upstream", "encode auth"}, records)
+
+	// request body go through data phase
+	records = nil
+	ctx = newTestStreamContext()
+	ctx.Request().Body().SetBody([]byte("body"))
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, []string{"decode auth", "decode upstream", "data auth", "data upstream",
+		"encode upstream", "encode auth"}, records)
+}
+
+func TestHandlerLocalReply(t *testing.T) {
+	var records []string
+	handler := NewHandler(nil, nil)
+	for _, f := range []*testFilter{
+		{name: "cors", records: &records},
+		{name: "ratelimit", records: &records,
+			decode: func(ctx api.StreamContext, cb api.DecoderFilterCallbacks) api.FilterStatus {
+				go cb.SendLocalReply(429, "too many requests", "local_rate_limited")
+				return api.StopIteration
+			}},
+		{name: "router", records: &records},
+	} {
+		handler.AddDecodeFilter(f)
+		handler.AddEncodeFilter(f)
+	}
+
+	ctx := newTestStreamContext()
+	ctx.Request().Body().SetBody([]byte("body"))
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, 429, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "too many requests", string(ctx.Response().Body().Bytes()))
+	assert.Equal(t, "local_rate_limited", ctx.StreamInfo().ResponseCodeDetails())
+	// local reply go through all the encoder filters
+	assert.Equal(t, []string{"decode cors", "decode ratelimit", "encode router", "encode ratelimit", "encode cors"}, records)
+
+	// stop without local reply
+	records = nil
+	handler = NewHandler(nil, nil)
+	handler.AddDecodeFilter(&testFilter{name: "router", records: &records,
+		decode: func(api.StreamContext, api.DecoderFilterCallbacks) api.FilterStatus { return api.Stop },
+	})
+	ctx = newTestStreamContext()
+	assert.Error(t, handler.Handle(ctx))
+	assert.Equal(t, 500, ctx.Response().Header().StatusCode())
+}
+
+func TestHandlerCancel(t *testing.T) {
+	var records []string
+	handler := NewHandler(nil, nil)
+	// the stream is stopped forever
+	handler.AddDecodeFilter(&testFilter{name: "wait", records: &records,
+		decode: func(ctx api.StreamContext, cb api.DecoderFilterCallbacks) api.FilterStatus {
+			return api.StopIteration
+		}})
+	handler.AddEncodeFilter(&testFilter{name: "encoder", records: &records})
+
+	// downstream is closed
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	ctx := NewStreamContext(cancelCtx, NewStreamInfo(nil, "HTTP/1.1"), &fasthttp.Request{}, &fasthttp.Response{})
+	time.AfterFunc(10*time.Millisecond, cancel)
+	assert.ErrorIs(t, handler.Handle(ctx), context.Canceled)
+	assert.True(t, ctx.StreamInfo().HasResponseFlag(api.DownstreamConnectionTermination))
+	assert.Equal(t, "downstream_remote_disconnect", ctx.StreamInfo().ResponseCodeDetails())
+
+	// request timeout
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ctx = NewStreamContext(timeoutCtx, NewStreamInfo(nil, "HTTP/1.1"), &fasthttp.Request{}, &fasthttp.Response{})
+	assert.ErrorIs(t, handler.Handle(ctx), context.DeadlineExceeded)
+	assert.Equal(t, 408, ctx.Response().Header().StatusCode())
+	assert.Equal(t, "request_overall_timeout", ctx.StreamInfo().ResponseCodeDetails())
+	assert.Equal(t, []string{"decode wait", "decode wait"}, records)
+}
+
+func TestHandlerDispatcher(t *testing.T) {
+	var (
+		records    []string
+		dispatcher api.Dispatcher
+		streams    int
+	)
+	handler := NewHandler(nil, nil)
+	handler.AddDecodeFilter(&testFilter{name: "auth", records: &records,
+		decode: func(ctx api.StreamContext, cb api.DecoderFilterCallbacks) api.FilterStatus {
+			streams++
+			if streams == 1 {
+				// the first stream is done before its callback
+				dispatcher = cb.Dispatcher()
+				return api.Continue
+			}
+			d := cb.Dispatcher()
+			go func() {
+				// the stale callback is dropped instead of replying the second stream
+				dispatcher.Post(func() { cb.SendLocalReply(403, "denied", "stale") })
+				time.Sleep(10 * time.Millisecond)
+				d.Post(cb.ContinueDecoding)
+			}()
+			return api.StopIteration
+		}})
+
+	assert.NoError(t, handler.Handle(newTestStreamContext()))
+	ctx := newTestStreamContext()
+	assert.NoError(t, handler.Handle(ctx))
+	assert.Equal(t, 200, ctx.Response().Header().StatusCode())
+	assert.Empty(t, ctx.StreamInfo().ResponseCodeDetails())
+
+	// no stream is running
+	handler.Dispatcher().Post(func() { t.Fatal("event of no stream") })
+}
@@ -30,7 +30,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
 	"time"
 
 	"github.com/valyala/fasthttp"
@@ -42,18 +41,18 @@ type StreamServer interface {
 	Dispatch(*bytes.Buffer) error
 }
 
-type StreamClient interface {
-	Call(api.StreamContext) error
-}
-
-func NewStreamServer(handler Handler, conn api.ConnectionCallbacks) StreamServer {
+// NewStreamServer the streams are cancelled when the downstream is closed, and the request
+// timeout is the deadline of stream if not zero
+func NewStreamServer(handler Handler, conn api.ConnectionCallbacks, requestTimeout time.Duration) StreamServer {
 	s := &httpStreamServer{
-		handler:   handler,
-		bufChan:   make(chan *bytes.Buffer),
-		endChan:   make(chan struct{}),
-		closeChan: make(chan struct{}),
-		conn:      conn,
+		handler:        handler,
+		bufChan:        make(chan *bytes.Buffer),
+		endChan:        make(chan struct{}),
+		closeChan:      make(chan struct{}),
+		conn:           conn,
+		requestTimeout: requestTimeout,
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 	s.br = bufio.NewReader(s)
 	go func() {
 		s.serve()
@@ -62,15 +61,22 @@ func NewStreamServer(handler Handler, conn api.ConnectionCallbacks) StreamServer
 }
 
 type httpStreamServer struct {
-	handler   Handler
-	bufChan   chan *bytes.Buffer
-	endChan   chan struct{}
-	closeChan chan struct{}
-	br        *bufio.Reader
-	conn      api.ConnectionCallbacks
+	handler        Handler
+	bufChan        chan *bytes.Buffer
+	endChan        chan struct{}
+	closeChan      chan struct{}
+	br             *bufio.Reader
+	conn           api.ConnectionCallbacks
+	requestTimeout time.Duration
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
+// Dispatch the empty buffer means the downstream is closed, the stream in flight is cancelled
 func (s *httpStreamServer) Dispatch(buffer *bytes.Buffer) error {
+	if buffer.Len() == 0 {
+		s.cancel()
+	}
 	select {
 	case <-s.closeChan:
 		return fmt.Errorf("stream server close")
@@ -93,11 +99,21 @@ func (s *httpStreamServer) Read(dst []byte) (n int, err error) {
 }
 
 func (s *httpStreamServer) close() {
+	s.cancel()
 	close(s.closeChan)
 	s.conn.Close()
 }
 
+// newContext returns the context of stream, which is done when the downstream is closed or request timeout
+func (s *httpStreamServer) newContext() (context.Context, context.CancelFunc) {
+	if s.requestTimeout > 0 {
+		return context.WithTimeout(s.ctx, s.requestTimeout)
+	}
+	return context.WithCancel(s.ctx)
+}
+
 func (s *httpStreamServer) serve() {
+	defer s.cancel()
 	for {
 		request, response := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
 		// blocking read using fasthttp.Request.Read
@@ -110,66 +126,20 @@ func (s *httpStreamServer) serve() {
 			break
 		}
 
-		ctx := NewStreamContext(context.TODO(), request, response)
+		info := NewStreamInfo(s.conn, string(request.Header.Protocol()))
+		streamCtx, cancel := s.newContext()
+		ctx := NewStreamContext(streamCtx, info, request, response)
 		err = s.handle(ctx)
 		if err != nil {
 			log.Error("handle error : %s", err)
 		}
 		response.WriteTo(s.conn)
+		s.handler.OnComplete(ctx)
+		cancel()
 	}
 	log.Debug("server close")
 }
 
 func (s *httpStreamServer) handle(ctx api.StreamContext) error {
-	if err := s.handler.Decode(ctx); err != nil {
-		return err
-	}
-	if err := s.handler.Encode(ctx); err != nil {
-		return err
-	}
-	return nil
-}
-
-// 此处只区分了127.0.0.6的特殊情况
-func NewStreamClient(addr net.Addr) StreamClient {
-	var sc *fasthttpStreamClient
-	if addr == nil {
-		sc = &fasthttpStreamClient{}
-	} else {
-		sc = &fasthttpStreamClient{
-			client: fasthttp.Client{Dial: Dial},
-		}
-	}
-	sc.client.MaxIdleConnDuration = time.Minute
-	sc.client.DisableHeaderNamesNormalizing = true
-	sc.client.MaxConnsPerHost = 30000
-	return sc
-}
-
-type fasthttpStreamClient struct {
-	client fasthttp.Client
-}
-
-func (sc *fasthttpStreamClient) Call(ctx api.StreamContext) error {
-	request := ctx.Request().Raw().(*fasthttp.Request)
-	response := ctx.Response().Raw().(*fasthttp.Response)
-	request.UseHostHeader = true
-	return sc.client.DoTimeout(request, response, time.Second*4)
-}
-
-var dialerWithLAddr = &fasthttp.TCPDialer{
-	Concurrency: 1000, LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.6")}}
-
-func Dial(addr string) (net.Conn, error) {
-	return dialerWithLAddr.Dial(addr)
-}
-
-var defaultStreamClient = NewStreamClient(nil)
-var streamClientWithLAddr = NewStreamClient(&net.TCPAddr{})
-
-func Call(ctx api.StreamContext, laddr net.Addr) error {
-	if laddr != nil {
-		return streamClientWithLAddr.Call(ctx)
-	}
-	return defaultStreamClient.Call(ctx)
+	return s.handler.Handle(ctx)
 }
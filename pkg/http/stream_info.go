@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package http
+
+import (
+	"net"
+	"time"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func NewStreamInfo(conn api.Connection, protocol string) api.StreamInfo {
+	info := &streamInfo{
+		startTime: time.Now(),
+		protocol:  protocol,
+	}
+	if conn != nil {
+		cc := conn.Context()
+		info.remoteAddr = &net.TCPAddr{IP: cc.GetSourceIP(), Port: int(cc.GetSourcePort())}
+		info.localAddr = &net.TCPAddr{IP: cc.GetDestinationIP(), Port: int(cc.GetDestinationPort())}
+	}
+	return info
+}
+
+type streamInfo struct {
+	startTime    time.Time
+	endTime      time.Time
+	protocol     string
+	remoteAddr   net.Addr
+	localAddr    net.Addr
+	upstreamHost api.Host
+	routeEntry   api.RouteEntry
+	flags        api.ResponseFlag
+	codeDetails  string
+	requestID    string
+	metadata     *envoy_config_core_v3.Metadata
+	activeSpan   api.Span
+}
+
+func (si *streamInfo) StartTime() time.Time {
+	return si.startTime
+}
+
+func (si *streamInfo) OnRequestComplete() {
+	si.endTime = time.Now()
+}
+
+func (si *streamInfo) Duration() time.Duration {
+	if si.endTime.IsZero() {
+		return time.Since(si.startTime)
+	}
+	return si.endTime.Sub(si.startTime)
+}
+
+func (si *streamInfo) Protocol() string {
+	return si.protocol
+}
+
+func (si *streamInfo) DownstreamRemoteAddress() net.Addr {
+	return si.remoteAddr
+}
+
+func (si *streamInfo) DownstreamLocalAddress() net.Addr {
+	return si.localAddr
+}
+
+func (si *streamInfo) UpstreamHost() api.Host {
+	return si.upstreamHost
+}
+
+func (si *streamInfo) SetUpstreamHost(h api.Host) {
+	si.upstreamHost = h
+}
+
+func (si *streamInfo) RouteEntry() api.RouteEntry {
+	return si.routeEntry
+}
+
+func (si *streamInfo) SetRouteEntry(re api.RouteEntry) {
+	si.routeEntry = re
+}
+
+func (si *streamInfo) ResponseFlags() api.ResponseFlag {
+	return si.flags
+}
+
+func (si *streamInfo) HasResponseFlag(flag api.ResponseFlag) bool {
+	return si.flags&flag != 0
+}
+
+func (si *streamInfo) SetResponseFlag(flag api.ResponseFlag) {
+	si.flags |= flag
+}
+
+func (si *streamInfo) ResponseCodeDetails() string {
+	return si.codeDetails
+}
+
+func (si *streamInfo) SetResponseCodeDetails(details string) {
+	si.codeDetails = details
+}
+
+func (si *streamInfo) RequestID() string {
+	return si.requestID
+}
+
+func (si *streamInfo) SetRequestID(id string) {
+	si.requestID = id
+}
+
+func (si *streamInfo) DynamicMetadata() *envoy_config_core_v3.Metadata {
+	if si.metadata == nil {
+		si.metadata = &envoy_config_core_v3.Metadata{FilterMetadata: make(map[string]*structpb.Struct)}
+	}
+	return si.metadata
+}
+
+func (si *streamInfo) SetDynamicMetadata(name string, value *structpb.Struct) {
+	metadata := si.DynamicMetadata()
+	existing, ok := metadata.FilterMetadata[name]
+	if !ok {
+		metadata.FilterMetadata[name] = value
+		return
+	}
+	if existing.Fields == nil {
+		existing.Fields = make(map[string]*structpb.Value)
+	}
+	for k, v := range value.GetFields() {
+		existing.Fields[k] = v
+	}
+}
+
+func (si *streamInfo) ActiveSpan() api.Span {
+	return si.activeSpan
+}
+
+func (si *streamInfo) SetActiveSpan(span api.Span) {
+	si.activeSpan = span
+}
@@ -31,17 +31,24 @@ import (
 	"github.com/wereliang/govoy/pkg/api"
 )
 
-func NewStreamContext(context context.Context,
+// NewStreamContext create stream context, rsp is nil if there is no response,
+// like the connections logged by listener access logs
+func NewStreamContext(context context.Context, info api.StreamInfo,
 	req *fasthttp.Request, rsp *fasthttp.Response) api.StreamContext {
-	return &streamContext{
-		context:  context,
-		request:  newRequest(req),
-		response: newResponse(rsp),
+	sc := &streamContext{
+		context: context,
+		info:    info,
+		request: newRequest(req),
 	}
+	if rsp != nil {
+		sc.response = newResponse(rsp)
+	}
+	return sc
 }
 
 type streamContext struct {
 	context  context.Context
+	info     api.StreamInfo
 	request  api.Request
 	response api.Response
 }
@@ -58,6 +65,10 @@ func (sc *streamContext) Response() api.Response {
 	return sc.response
 }
 
+func (sc *streamContext) StreamInfo() api.StreamInfo {
+	return sc.info
+}
+
 func newRequest(r *fasthttp.Request) api.Request {
 	return &request{
 		Request: r,
@@ -143,6 +154,11 @@ func (h *requestHeader) SetPath(path string) {
 	h.uri.SetPath(path)
 }
 
+func (h *requestHeader) QueryArg(key string) ([]byte, bool) {
+	args := h.uri.QueryArgs()
+	return args.Peek(key), args.Has(key)
+}
+
 type responseHeader struct {
 	*fasthttp.ResponseHeader
 }
@@ -155,6 +171,14 @@ type requestBody struct {
 	*fasthttp.Request
 }
 
+func (b *requestBody) Bytes() []byte {
+	return b.Request.Body()
+}
+
 type responseBody struct {
 	*fasthttp.Response
 }
+
+func (b *responseBody) Bytes() []byte {
+	return b.Response.Body()
+}
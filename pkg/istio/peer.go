@@ -0,0 +1,123 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package istio
+
+import (
+	"encoding/base64"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// HeaderPeerMetadata is the header of base64 encoded node metadata
+	HeaderPeerMetadata = "x-envoy-peer-metadata"
+	// HeaderPeerMetadataID is the header of node id
+	HeaderPeerMetadataID = "x-envoy-peer-metadata-id"
+
+	// MetadataExchange is the name of metadata exchange filters, and the peer metadata of stream is
+	// saved in the dynamic metadata of this name
+	MetadataExchange = "istio.metadata_exchange"
+
+	downstreamPeer   = "downstream_peer"
+	downstreamPeerID = "downstream_peer_id"
+	upstreamPeer     = "upstream_peer"
+	upstreamPeerID   = "upstream_peer_id"
+)
+
+// exchangeKeys are the keys of node metadata exchanged with peer, same as istio proxy
+var exchangeKeys = []string{
+	"NAME", "NAMESPACE", "LABELS", "OWNER", "PLATFORM_METADATA", "WORKLOAD_NAME",
+	"CANONICAL_TELEMETRY_SERVICE", "MESH_ID", "SERVICE_ACCOUNT", "CLUSTER_ID",
+	"APP_CONTAINERS", "INSTANCE_IPS", "ISTIO_VERSION",
+}
+
+// LocalMetadata returns the node metadata of exchange keys
+func LocalMetadata(node *envoy_config_core_v3.Node) *structpb.Struct {
+	metadata := &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	fields := node.GetMetadata().GetFields()
+	for _, key := range exchangeKeys {
+		if v, ok := fields[key]; ok {
+			metadata.Fields[key] = v
+		}
+	}
+	return metadata
+}
+
+// EncodeMetadata returns the base64 of serialized metadata for header
+func EncodeMetadata(metadata *structpb.Struct) (string, error) {
+	b, err := proto.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// DecodeMetadata decodes the header value of EncodeMetadata
+func DecodeMetadata(value string) (*structpb.Struct, error) {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &structpb.Struct{}
+	if err = proto.Unmarshal(b, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func setPeer(info api.StreamInfo, idKey, metadataKey string, id string, metadata *structpb.Struct) {
+	info.SetDynamicMetadata(MetadataExchange, &structpb.Struct{Fields: map[string]*structpb.Value{
+		idKey:       structpb.NewStringValue(id),
+		metadataKey: structpb.NewStructValue(metadata),
+	}})
+}
+
+func getPeer(info api.StreamInfo, idKey, metadataKey string) (string, *structpb.Struct) {
+	fields := info.DynamicMetadata().GetFilterMetadata()[MetadataExchange].GetFields()
+	return fields[idKey].GetStringValue(), fields[metadataKey].GetStructValue()
+}
+
+// SetDownstreamPeer saves the node id and metadata of downstream in stream info
+func SetDownstreamPeer(info api.StreamInfo, id string, metadata *structpb.Struct) {
+	setPeer(info, downstreamPeerID, downstreamPeer, id, metadata)
+}
+
+// DownstreamPeer returns the node id and metadata of downstream, metadata is nil if not exchanged
+func DownstreamPeer(info api.StreamInfo) (string, *structpb.Struct) {
+	return getPeer(info, downstreamPeerID, downstreamPeer)
+}
+
+// SetUpstreamPeer saves the node id and metadata of upstream in stream info
+func SetUpstreamPeer(info api.StreamInfo, id string, metadata *structpb.Struct) {
+	setPeer(info, upstreamPeerID, upstreamPeer, id, metadata)
+}
+
+// UpstreamPeer returns the node id and metadata of upstream, metadata is nil if not exchanged
+func UpstreamPeer(info api.StreamInfo) (string, *structpb.Struct) {
+	return getPeer(info, upstreamPeerID, upstreamPeer)
+}
@@ -0,0 +1,72 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package istio
+
+import (
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Workload is the workload information of node metadata
+type Workload struct {
+	Name              string
+	Namespace         string
+	CanonicalService  string
+	CanonicalRevision string
+	App               string
+	Version           string
+	Cluster           string
+}
+
+// NewWorkload returns the workload of node metadata, the canonical service and revision fall
+// back to the labels of app and version like istio
+func NewWorkload(metadata *structpb.Struct) *Workload {
+	fields := metadata.GetFields()
+	labels := fields["LABELS"].GetStructValue().GetFields()
+	label := func(keys ...string) string {
+		for _, k := range keys {
+			if v := labels[k].GetStringValue(); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	w := &Workload{
+		Name:              fields["WORKLOAD_NAME"].GetStringValue(),
+		Namespace:         fields["NAMESPACE"].GetStringValue(),
+		CanonicalService:  label("service.istio.io/canonical-name", "app.kubernetes.io/name", "app"),
+		CanonicalRevision: label("service.istio.io/canonical-revision", "app.kubernetes.io/version", "version"),
+		App:               label("app"),
+		Version:           label("version"),
+		Cluster:           fields["CLUSTER_ID"].GetStringValue(),
+	}
+	if w.CanonicalService == "" {
+		w.CanonicalService = w.Name
+	}
+	if w.CanonicalRevision == "" {
+		w.CanonicalRevision = "latest"
+	}
+	return w
+}
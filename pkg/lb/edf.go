@@ -0,0 +1,137 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package lb
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+type edfEntry struct {
+	deadline float64
+	// order keeps the entries with the same deadline in fifo
+	order uint64
+	host  api.Host
+}
+
+type edfQueue []*edfEntry
+
+func (q edfQueue) Len() int { return len(q) }
+
+func (q edfQueue) Less(i, j int) bool {
+	if q[i].deadline == q[j].deadline {
+		return q[i].order < q[j].order
+	}
+	return q[i].deadline < q[j].deadline
+}
+
+func (q edfQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *edfQueue) Push(x interface{}) { *q = append(*q, x.(*edfEntry)) }
+
+func (q *edfQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// edfScheduler is the earliest deadline first scheduler like envoy, the host with weight w is
+// picked every 1/w time, so the hosts are picked by weights and interleaved.
+type edfScheduler struct {
+	queue       edfQueue
+	currentTime float64
+	order       uint64
+	weight      func(api.Host) float64
+	// distinct is the number of distinct hosts, the same host may be added twice
+	distinct int
+}
+
+func newEdfScheduler(hosts api.HostSet, weight func(api.Host) float64) *edfScheduler {
+	s := &edfScheduler{weight: weight}
+	seen := make(map[api.Host]bool, len(hosts))
+	for _, h := range hosts {
+		s.add(h)
+		seen[h] = true
+	}
+	s.distinct = len(seen)
+	return s
+}
+
+func (s *edfScheduler) add(h api.Host) {
+	weight := s.weight(h)
+	if weight <= 0 {
+		weight = 1
+	}
+	s.order++
+	heap.Push(&s.queue, &edfEntry{deadline: s.currentTime + 1/weight, order: s.order, host: h})
+}
+
+// pick returns the host of earliest deadline which passes the filter, or nil if all hosts are filtered.
+// The host is added back with the current weight, so the weight can be changed between picks, such as
+// the active requests.
+func (s *edfScheduler) pick(filter func(api.Host) bool) api.Host {
+	var filtered map[api.Host]bool
+	for s.queue.Len() > 0 {
+		e := heap.Pop(&s.queue).(*edfEntry)
+		s.currentTime = e.deadline
+		s.add(e.host)
+		if filter(e.host) {
+			return e.host
+		}
+		if filtered == nil {
+			filtered = make(map[api.Host]bool)
+		}
+		if filtered[e.host] = true; len(filtered) == s.distinct {
+			return nil
+		}
+	}
+	return nil
+}
+
+// EdfRoundRobin is the weighted round robin by EDF scheduler
+type EdfRoundRobin struct {
+	sync.Mutex
+	hosts     api.HostSet
+	scheduler *edfScheduler
+}
+
+// Select skips the unhealthy hosts, see hostFilter
+func (lb *EdfRoundRobin) Select(api.LoadBalancerContext) api.Host {
+	lb.Lock()
+	defer lb.Unlock()
+	return lb.scheduler.pick(hostFilter(lb.hosts))
+}
+
+func init() {
+	registLoadBalancer(api.Weighted_Round_Robin, func(_ api.ClusterInfo, hosts api.HostSet) api.LoadBalancer {
+		return &EdfRoundRobin{
+			hosts:     hosts,
+			scheduler: newEdfScheduler(hosts, func(h api.Host) float64 { return float64(h.Weight()) }),
+		}
+	})
+}
@@ -0,0 +1,169 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package lb
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_load_balancing_policies_least_request_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/least_request/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/log"
+)
+
+const (
+	defaultChoiceCount       = 2
+	defaultActiveRequestBias = 1.0
+)
+
+// policyTypes are the supported extensions of load_balancing_policy
+var policyTypes = map[string]api.LoadBalancerType{
+	"envoy.extensions.load_balancing_policies.round_robin.v3.RoundRobin":     api.Weighted_Round_Robin,
+	"envoy.extensions.load_balancing_policies.least_request.v3.LeastRequest": api.Least_Request,
+}
+
+// loadBalancingPolicy returns the first supported policy of load_balancing_policy
+func loadBalancingPolicy(c *envoy_config_cluster_v3.Cluster) (api.LoadBalancerType, *any.Any, bool) {
+	for _, p := range c.GetLoadBalancingPolicy().GetPolicies() {
+		config := p.GetTypedExtensionConfig().GetTypedConfig()
+		url := config.GetTypeUrl()
+		if t, ok := policyTypes[url[strings.LastIndex(url, "/")+1:]]; ok {
+			return t, config, true
+		}
+	}
+	return 0, nil, false
+}
+
+// LoadBalancerType returns the load balancer type of lb_policy, and round robin is used if not supported
+func LoadBalancerType(c *envoy_config_cluster_v3.Cluster) api.LoadBalancerType {
+	t := api.LoadBalancerType(c.GetLbPolicy())
+	if c.GetLbPolicy() == envoy_config_cluster_v3.Cluster_LOAD_BALANCING_POLICY_CONFIG {
+		var ok bool
+		if t, _, ok = loadBalancingPolicy(c); !ok {
+			log.Warn("not support load balancing policy of cluster %s", c.GetName())
+			return api.Round_Robin
+		}
+	}
+	if _, ok := lbFactory[t]; !ok {
+		log.Warn("not support lb policy %s of cluster %s", c.GetLbPolicy(), c.GetName())
+		return api.Round_Robin
+	}
+	return t
+}
+
+// leastRequestConfig returns choice_count and active_request_bias of least_request_lb_config, or the
+// least_request extension of load_balancing_policy
+func leastRequestConfig(c *envoy_config_cluster_v3.Cluster) (uint32, float64) {
+	var (
+		choiceCount *wrappers.UInt32Value
+		bias        *envoy_config_core_v3.RuntimeDouble
+	)
+	if t, config, ok := loadBalancingPolicy(c); ok && t == api.Least_Request &&
+		c.GetLbPolicy() == envoy_config_cluster_v3.Cluster_LOAD_BALANCING_POLICY_CONFIG {
+		lr := &envoy_extensions_load_balancing_policies_least_request_v3.LeastRequest{}
+		if err := ptypes.UnmarshalAny(config, lr); err != nil {
+			log.Error("invalid least request policy of cluster %s: %s", c.GetName(), err)
+		}
+		choiceCount, bias = lr.GetChoiceCount(), lr.GetActiveRequestBias()
+	} else {
+		choiceCount, bias = c.GetLeastRequestLbConfig().GetChoiceCount(), c.GetLeastRequestLbConfig().GetActiveRequestBias()
+	}
+
+	count, activeRequestBias := uint32(defaultChoiceCount), defaultActiveRequestBias
+	if choiceCount != nil && choiceCount.GetValue() >= 2 {
+		count = choiceCount.GetValue()
+	}
+	// runtime is not supported, the default value is used
+	if bias != nil && bias.GetDefaultValue() >= 0 {
+		activeRequestBias = bias.GetDefaultValue()
+	}
+	return count, activeRequestBias
+}
+
+// LeastRequest is the least request load balancer like envoy. If all weights are equal, the host with
+// the fewest active requests is selected from choice_count random hosts (power of two choices). Otherwise
+// the hosts are scheduled by EDF with weight / (active_requests + 1) ^ active_request_bias.
+type LeastRequest struct {
+	sync.Mutex
+	hosts       api.HostSet
+	choiceCount uint32
+	scheduler   *edfScheduler
+}
+
+func (lb *LeastRequest) Select(api.LoadBalancerContext) api.Host {
+	filter := hostFilter(lb.hosts)
+	if lb.scheduler != nil {
+		lb.Lock()
+		defer lb.Unlock()
+		return lb.scheduler.pick(filter)
+	}
+
+	var available api.HostSet
+	for _, h := range lb.hosts {
+		if filter(h) {
+			available = append(available, h)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	var selected api.Host
+	for i := uint32(0); i < lb.choiceCount; i++ {
+		h := available[rand.Intn(len(available))]
+		if selected == nil || h.ActiveRequests() < selected.ActiveRequests() {
+			selected = h
+		}
+	}
+	return selected
+}
+
+func newLeastRequest(info api.ClusterInfo, hosts api.HostSet) api.LoadBalancer {
+	choiceCount, bias := leastRequestConfig(info.Config())
+	lb := &LeastRequest{hosts: hosts, choiceCount: choiceCount}
+	for _, h := range hosts {
+		if h.Weight() != hosts[0].Weight() {
+			lb.scheduler = newEdfScheduler(hosts, func(h api.Host) float64 {
+				active := float64(h.ActiveRequests() + 1)
+				if bias == 1 {
+					return float64(h.Weight()) / active
+				}
+				return float64(h.Weight()) / math.Pow(active, bias)
+			})
+			break
+		}
+	}
+	return lb
+}
+
+func init() {
+	registLoadBalancer(api.Least_Request, newLeastRequest)
+}
@@ -28,7 +28,8 @@ import (
 	"github.com/wereliang/govoy/pkg/api"
 )
 
-type LBCreator func(api.HostSet) api.LoadBalancer
+// LBCreator creates the load balancer with the cluster info, such as least_request_lb_config
+type LBCreator func(api.ClusterInfo, api.HostSet) api.LoadBalancer
 
 var (
 	lbFactory = make(map[api.LoadBalancerType]LBCreator)
@@ -38,9 +39,35 @@ func registLoadBalancer(t api.LoadBalancerType, creator LBCreator) {
 	lbFactory[t] = creator
 }
 
-func NewLoadBalancer(t api.LoadBalancerType, hosts api.HostSet) api.LoadBalancer {
-	if creator, ok := lbFactory[t]; ok {
-		return creator(hosts)
+func NewLoadBalancer(info api.ClusterInfo, hosts api.HostSet) api.LoadBalancer {
+	if creator, ok := lbFactory[info.LbType()]; ok {
+		return creator(info, hosts)
 	}
 	return nil
 }
+
+func availableHost(h api.Host) bool {
+	return h.Healthy() && !h.HealthFlagGet(api.DegradedEDSHealth)
+}
+
+func anyHost(api.Host) bool {
+	return true
+}
+
+// hostFilter returns the filter of hosts to select. The healthy hosts are preferred, then the degraded
+// hosts, and all hosts are selected if no host is healthy like envoy panic mode.
+func hostFilter(hosts api.HostSet) func(api.Host) bool {
+	degraded := false
+	for _, h := range hosts {
+		if availableHost(h) {
+			return availableHost
+		}
+		if h.Healthy() {
+			degraded = true
+		}
+	}
+	if degraded {
+		return api.Host.Healthy
+	}
+	return anyHost
+}
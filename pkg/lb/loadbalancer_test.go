@@ -0,0 +1,159 @@
+package lb
+
+import (
+	"net"
+	"testing"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_extensions_load_balancing_policies_least_request_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/least_request/v3"
+	envoy_extensions_load_balancing_policies_round_robin_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/round_robin/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testClusterInfo struct {
+	config *envoy_config_cluster_v3.Cluster
+}
+
+func (c *testClusterInfo) Name() string                             { return c.config.GetName() }
+func (c *testClusterInfo) ClusterType() api.ClusterType             { return api.Cluster_EDS }
+func (c *testClusterInfo) LbType() api.LoadBalancerType             { return LoadBalancerType(c.config) }
+func (c *testClusterInfo) Config() *envoy_config_cluster_v3.Cluster { return c.config }
+
+func newTestHosts(weights ...uint32) api.HostSet {
+	var hosts api.HostSet
+	for i, w := range weights {
+		h := api.NewHost(&net.TCPAddr{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 9080})
+		h.SetWeight(w)
+		hosts = append(hosts, h)
+	}
+	return hosts
+}
+
+func countSelected(lb api.LoadBalancer, n int) map[api.Host]int {
+	counts := make(map[api.Host]int)
+	for i := 0; i < n; i++ {
+		counts[lb.Select(nil)]++
+	}
+	return counts
+}
+
+func newPolicyCluster(policy proto.Message) *envoy_config_cluster_v3.Cluster {
+	config, _ := ptypes.MarshalAny(policy)
+	return &envoy_config_cluster_v3.Cluster{
+		Name:     "outbound|9080||reviews",
+		LbPolicy: envoy_config_cluster_v3.Cluster_LOAD_BALANCING_POLICY_CONFIG,
+		LoadBalancingPolicy: &envoy_config_cluster_v3.LoadBalancingPolicy{
+			Policies: []*envoy_config_cluster_v3.LoadBalancingPolicy_Policy{{
+				TypedExtensionConfig: &envoy_config_core_v3.TypedExtensionConfig{
+					Name:        "envoy.load_balancing_policies",
+					TypedConfig: config,
+				},
+			}},
+		},
+	}
+}
+
+func TestLoadBalancerType(t *testing.T) {
+	assert.Equal(t, api.Round_Robin, LoadBalancerType(&envoy_config_cluster_v3.Cluster{}))
+	assert.Equal(t, api.Least_Request, LoadBalancerType(&envoy_config_cluster_v3.Cluster{
+		LbPolicy: envoy_config_cluster_v3.Cluster_LEAST_REQUEST}))
+	assert.Equal(t, api.Random, LoadBalancerType(&envoy_config_cluster_v3.Cluster{
+		LbPolicy: envoy_config_cluster_v3.Cluster_RANDOM}))
+	// not supported
+	assert.Equal(t, api.Round_Robin, LoadBalancerType(&envoy_config_cluster_v3.Cluster{
+		LbPolicy: envoy_config_cluster_v3.Cluster_MAGLEV}))
+	assert.Equal(t, api.Weighted_Round_Robin,
+		LoadBalancerType(newPolicyCluster(&envoy_extensions_load_balancing_policies_round_robin_v3.RoundRobin{})))
+
+	c := newPolicyCluster(&envoy_extensions_load_balancing_policies_least_request_v3.LeastRequest{
+		ChoiceCount:       wrapperspb.UInt32(3),
+		ActiveRequestBias: &envoy_config_core_v3.RuntimeDouble{DefaultValue: 0.5},
+	})
+	assert.Equal(t, api.Least_Request, LoadBalancerType(c))
+	count, bias := leastRequestConfig(c)
+	assert.Equal(t, uint32(3), count)
+	assert.Equal(t, 0.5, bias)
+
+	count, bias = leastRequestConfig(&envoy_config_cluster_v3.Cluster{
+		LbPolicy: envoy_config_cluster_v3.Cluster_LEAST_REQUEST,
+		LbConfig: &envoy_config_cluster_v3.Cluster_LeastRequestLbConfig_{
+			LeastRequestLbConfig: &envoy_config_cluster_v3.Cluster_LeastRequestLbConfig{
+				ChoiceCount: wrapperspb.UInt32(1),
+			},
+		},
+	})
+	assert.Equal(t, uint32(defaultChoiceCount), count)
+	assert.Equal(t, defaultActiveRequestBias, bias)
+}
+
+func TestEdfRoundRobin(t *testing.T) {
+	hosts := newTestHosts(1, 2, 3)
+	lb := NewLoadBalancer(&testClusterInfo{
+		newPolicyCluster(&envoy_extensions_load_balancing_policies_round_robin_v3.RoundRobin{})}, hosts)
+	counts := countSelected(lb, 600)
+	assert.Equal(t, 100, counts[hosts[0]])
+	assert.Equal(t, 200, counts[hosts[1]])
+	assert.Equal(t, 300, counts[hosts[2]])
+
+	hosts[2].HealthFlagSet(api.FailedOutlierCheck)
+	counts = countSelected(lb, 300)
+	assert.Equal(t, 0, counts[hosts[2]])
+	// all hosts are unhealthy
+	hosts[0].HealthFlagSet(api.FailedActiveHealthCheck)
+	hosts[1].HealthFlagSet(api.FailedEDSHealth)
+	assert.NotNil(t, lb.Select(nil))
+}
+
+func TestLeastRequest(t *testing.T) {
+	info := &testClusterInfo{&envoy_config_cluster_v3.Cluster{LbPolicy: envoy_config_cluster_v3.Cluster_LEAST_REQUEST}}
+
+	// power of two choices, the busy host is selected only if it's chosen twice
+	hosts := newTestHosts(100, 100)
+	for i := 0; i < 10; i++ {
+		hosts[0].IncActiveRequests()
+	}
+	lb := NewLoadBalancer(info, hosts)
+	counts := countSelected(lb, 1000)
+	assert.True(t, counts[hosts[0]] < 400, counts[hosts[0]])
+	hosts[1].HealthFlagSet(api.FailedOutlierCheck)
+	assert.Equal(t, hosts[0], lb.Select(nil))
+
+	// the weights are divided by the active requests
+	hosts = newTestHosts(1, 3)
+	hosts[1].IncActiveRequests()
+	hosts[1].IncActiveRequests()
+	lb = NewLoadBalancer(info, hosts)
+	counts = countSelected(lb, 100)
+	assert.Equal(t, 50, counts[hosts[0]])
+	assert.Equal(t, 50, counts[hosts[1]])
+
+	// the active requests are ignored if active_request_bias is 0
+	info.config.LbConfig = &envoy_config_cluster_v3.Cluster_LeastRequestLbConfig_{
+		LeastRequestLbConfig: &envoy_config_cluster_v3.Cluster_LeastRequestLbConfig{
+			ActiveRequestBias: &envoy_config_core_v3.RuntimeDouble{DefaultValue: 0},
+		},
+	}
+	lb = NewLoadBalancer(info, hosts)
+	counts = countSelected(lb, 100)
+	assert.Equal(t, 25, counts[hosts[0]])
+	assert.Equal(t, 75, counts[hosts[1]])
+}
+
+func TestRandom(t *testing.T) {
+	hosts := newTestHosts(1, 100, 100)
+	lb := NewLoadBalancer(&testClusterInfo{
+		&envoy_config_cluster_v3.Cluster{LbPolicy: envoy_config_cluster_v3.Cluster_RANDOM}}, hosts)
+	counts := countSelected(lb, 300)
+	assert.Equal(t, 3, len(counts))
+
+	hosts[0].HealthFlagSet(api.FailedEDSHealth)
+	counts = countSelected(lb, 100)
+	assert.Equal(t, 0, counts[hosts[0]])
+	assert.Nil(t, NewLoadBalancer(&testClusterInfo{
+		&envoy_config_cluster_v3.Cluster{LbPolicy: envoy_config_cluster_v3.Cluster_RANDOM}}, nil).Select(nil))
+}
@@ -40,7 +40,7 @@ func (lb *OriginalDstLb) Select(lbCtx api.LoadBalancerContext) api.Host {
 }
 
 func init() {
-	registLoadBalancer(api.Original_Dst, func(hosts api.HostSet) api.LoadBalancer {
+	registLoadBalancer(api.Original_Dst, func(_ api.ClusterInfo, hosts api.HostSet) api.LoadBalancer {
 		return &OriginalDstLb{}
 	})
 }
@@ -0,0 +1,56 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package lb
+
+import (
+	"math/rand"
+
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// Random selects a random host, the weights are ignored like envoy
+type Random struct {
+	hosts api.HostSet
+}
+
+func (lb *Random) Select(api.LoadBalancerContext) api.Host {
+	filter := hostFilter(lb.hosts)
+	var available api.HostSet
+	for _, h := range lb.hosts {
+		if filter(h) {
+			available = append(available, h)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	return available[rand.Intn(len(available))]
+}
+
+func init() {
+	registLoadBalancer(api.Random, func(_ api.ClusterInfo, hosts api.HostSet) api.LoadBalancer {
+		return &Random{hosts: hosts}
+	})
+}
@@ -36,33 +36,43 @@ type rbItem struct {
 }
 
 type SmoothRoundRobin struct {
-	sync.RWMutex
-	total int32
+	sync.Mutex
+	hosts api.HostSet
 	items []*rbItem
 }
 
+// Select skips the unhealthy hosts, see hostFilter
 func (lb *SmoothRoundRobin) Select(api.LoadBalancerContext) api.Host {
-	lb.RLock()
-	defer lb.RUnlock()
+	lb.Lock()
+	defer lb.Unlock()
 
-	maxIndex := 0
+	filter := hostFilter(lb.hosts)
+	var total int32
+	maxIndex := -1
 	for i := 0; i < len(lb.items); i++ {
 		item := lb.items[i]
-		item.step += int32(item.host.Weight())
-		if item.step > lb.items[maxIndex].step {
+		if !filter(item.host) {
+			continue
+		}
+		weight := int32(item.host.Weight())
+		item.step += weight
+		total += weight
+		if maxIndex < 0 || item.step > lb.items[maxIndex].step {
 			maxIndex = i
 		}
 		// log.Trace("host:%s weight:%d", item.host.Address().String(), item.step)
 	}
-	lb.items[maxIndex].step -= lb.total
+	if maxIndex < 0 {
+		return nil
+	}
+	lb.items[maxIndex].step -= total
 	return lb.items[maxIndex].host
 }
 
 func init() {
-	registLoadBalancer(api.Round_Robin, func(hosts api.HostSet) api.LoadBalancer {
-		rb := &SmoothRoundRobin{}
+	registLoadBalancer(api.Round_Robin, func(_ api.ClusterInfo, hosts api.HostSet) api.LoadBalancer {
+		rb := &SmoothRoundRobin{hosts: hosts}
 		for _, h := range hosts {
-			rb.total += int32(h.Weight())
 			rb.items = append(rb.items, &rbItem{host: h})
 		}
 		return rb
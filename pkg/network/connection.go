@@ -29,6 +29,7 @@ import (
 	"net"
 
 	"github.com/wereliang/govoy/pkg/api"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func newConn(c net.Conn) api.Connection {
@@ -83,6 +84,8 @@ type ConnectionContextImpl struct {
 	SourceIP             net.IP
 	SourcePort           uint32
 	localAddressRestored bool
+	peerID               string
+	peerMetadata         *structpb.Struct
 }
 
 func (cs *ConnectionContextImpl) GetDestinationPort() uint32 {
@@ -134,3 +137,11 @@ func (cs *ConnectionContextImpl) SetApplicationProtocol(s string) {
 func (cs *ConnectionContextImpl) LocalAddressRestored() bool {
 	return cs.localAddressRestored
 }
+
+func (cs *ConnectionContextImpl) GetPeerMetadata() (string, *structpb.Struct) {
+	return cs.peerID, cs.peerMetadata
+}
+
+func (cs *ConnectionContextImpl) SetPeerMetadata(id string, metadata *structpb.Struct) {
+	cs.peerID, cs.peerMetadata = id, metadata
+}
@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rbac
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// Attributes are the properties of connection and request checked by the policies
+type Attributes struct {
+	DirectRemoteIP  net.IP
+	RemoteIP        net.IP
+	DestinationIP   net.IP
+	DestinationPort uint32
+	ServerName      string
+	// PeerPrincipals are the uri sans, dns sans and subject of peer certificate, nil if not mtls
+	PeerPrincipals []string
+	// Header is nil for network filter
+	Header api.RequestHeader
+	// Metadata is the dynamic metadata of stream, nil for network filter
+	Metadata *envoy_config_core_v3.Metadata
+}
+
+// NewAttributes returns the attributes of connection, the direct remote ip is the source ip
+// if not set by listener filters
+func NewAttributes(conn api.Connection) *Attributes {
+	attrs := &Attributes{}
+	if conn == nil {
+		return attrs
+	}
+	ctx := conn.Context()
+	attrs.RemoteIP = ctx.GetSourceIP()
+	attrs.DirectRemoteIP = ctx.GetDirectSourceIP()
+	if attrs.DirectRemoteIP == nil {
+		attrs.DirectRemoteIP = attrs.RemoteIP
+	}
+	attrs.DestinationIP = ctx.GetDestinationIP()
+	attrs.DestinationPort = ctx.GetDestinationPort()
+	attrs.ServerName = ctx.GetServerName()
+
+	if tlsConn, ok := conn.Raw().(*tls.Conn); ok {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			for _, uri := range certs[0].URIs {
+				attrs.PeerPrincipals = append(attrs.PeerPrincipals, uri.String())
+			}
+			attrs.PeerPrincipals = append(attrs.PeerPrincipals, certs[0].DNSNames...)
+			attrs.PeerPrincipals = append(attrs.PeerPrincipals, certs[0].Subject.String())
+		}
+	}
+	return attrs
+}
+
+type policy struct {
+	name string
+	// any of permissions and any of principals
+	permissions matcher
+	principals  matcher
+}
+
+// Engine evaluates the policies of envoy.config.rbac.v3.RBAC
+type Engine struct {
+	action   envoy_config_rbac_v3.RBAC_Action
+	policies []*policy
+}
+
+// NewEngine returns nil if the rbac is nil, which allows all
+func NewEngine(c *envoy_config_rbac_v3.RBAC) (*Engine, error) {
+	if c == nil {
+		return nil, nil
+	}
+	e := &Engine{action: c.GetAction()}
+	for name, p := range c.GetPolicies() {
+		if p.GetCondition() != nil || p.GetCheckedCondition() != nil {
+			return nil, fmt.Errorf("condition of rbac policy(%s) is not supported", name)
+		}
+		permissions, err := newPermissions(p.GetPermissions())
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission of rbac policy(%s): %s", name, err)
+		}
+		principals, err := newPrincipals(p.GetPrincipals())
+		if err != nil {
+			return nil, fmt.Errorf("invalid principal of rbac policy(%s): %s", name, err)
+		}
+		e.policies = append(e.policies, &policy{name: name, permissions: orMatcher(permissions), principals: orMatcher(principals)})
+	}
+	// the matched policy is deterministic
+	sort.Slice(e.policies, func(i, j int) bool { return e.policies[i].name < e.policies[j].name })
+	return e, nil
+}
+
+// Action returns the action of rbac
+func (e *Engine) Action() envoy_config_rbac_v3.RBAC_Action {
+	return e.action
+}
+
+// Allowed returns whether the attributes is allowed, and the name of the matched policy which is empty
+// if no policy matched. ALLOW action allows if any policy matched, DENY action allows if no policy
+// matched, and LOG action always allows.
+func (e *Engine) Allowed(attrs *Attributes) (bool, string) {
+	matched := ""
+	for _, p := range e.policies {
+		if p.permissions.match(attrs) && p.principals.match(attrs) {
+			matched = p.name
+			break
+		}
+	}
+	switch e.action {
+	case envoy_config_rbac_v3.RBAC_ALLOW:
+		return matched != "", matched
+	case envoy_config_rbac_v3.RBAC_DENY:
+		return matched == "", matched
+	}
+	return true, matched
+}
+
+// pseudoHeader supports the pseudo headers of http2, which are used by istio
+type pseudoHeader struct {
+	api.RequestHeader
+}
+
+func (h pseudoHeader) Get(key string) []byte {
+	switch strings.ToLower(key) {
+	case ":path":
+		return h.RequestURI()
+	case ":method":
+		return h.Method()
+	case ":authority":
+		return h.Host()
+	case ":scheme":
+		return []byte("http")
+	}
+	return h.RequestHeader.Get(key)
+}
@@ -0,0 +1,145 @@
+package rbac
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func exact(s string) *envoy_type_matcher_v3.StringMatcher {
+	return &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: s}}
+}
+
+func prefix(s string) *envoy_type_matcher_v3.StringMatcher {
+	return &envoy_type_matcher_v3.StringMatcher{MatchPattern: &envoy_type_matcher_v3.StringMatcher_Prefix{Prefix: s}}
+}
+
+// newIstioPolicy is like the policy of istio AuthorizationPolicy:
+// from principals [cluster.local/ns/default/sa/productpage] or ipBlocks [10.0.0.0/8],
+// to methods [GET] and paths [/reviews*] and ports [9080], when request.auth.claims[groups] [dev]
+func newIstioPolicy() *envoy_config_rbac_v3.Policy {
+	return &envoy_config_rbac_v3.Policy{
+		Permissions: []*envoy_config_rbac_v3.Permission{{
+			Rule: &envoy_config_rbac_v3.Permission_AndRules{AndRules: &envoy_config_rbac_v3.Permission_Set{
+				Rules: []*envoy_config_rbac_v3.Permission{
+					{Rule: &envoy_config_rbac_v3.Permission_Header{Header: &envoy_config_route_v3.HeaderMatcher{
+						Name: ":method", HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_StringMatch{StringMatch: exact("GET")}}}},
+					{Rule: &envoy_config_rbac_v3.Permission_UrlPath{UrlPath: &envoy_type_matcher_v3.PathMatcher{
+						Rule: &envoy_type_matcher_v3.PathMatcher_Path{Path: prefix("/reviews")}}}},
+					{Rule: &envoy_config_rbac_v3.Permission_DestinationPort{DestinationPort: 9080}},
+				}}}},
+		},
+		Principals: []*envoy_config_rbac_v3.Principal{{
+			Identifier: &envoy_config_rbac_v3.Principal_AndIds{AndIds: &envoy_config_rbac_v3.Principal_Set{
+				Ids: []*envoy_config_rbac_v3.Principal{
+					{Identifier: &envoy_config_rbac_v3.Principal_OrIds{OrIds: &envoy_config_rbac_v3.Principal_Set{
+						Ids: []*envoy_config_rbac_v3.Principal{
+							{Identifier: &envoy_config_rbac_v3.Principal_Authenticated_{Authenticated: &envoy_config_rbac_v3.Principal_Authenticated{
+								PrincipalName: exact("spiffe://cluster.local/ns/default/sa/productpage")}}},
+							{Identifier: &envoy_config_rbac_v3.Principal_DirectRemoteIp{DirectRemoteIp: &envoy_config_core_v3.CidrRange{
+								AddressPrefix: "10.0.0.0", PrefixLen: wrapperspb.UInt32(8)}}},
+						}}}},
+					{Identifier: &envoy_config_rbac_v3.Principal_Metadata{Metadata: &envoy_type_matcher_v3.MetadataMatcher{
+						Filter: "istio_authn",
+						Path: []*envoy_type_matcher_v3.MetadataMatcher_PathSegment{
+							{Segment: &envoy_type_matcher_v3.MetadataMatcher_PathSegment_Key{Key: "request.auth.claims"}},
+							{Segment: &envoy_type_matcher_v3.MetadataMatcher_PathSegment_Key{Key: "groups"}}},
+						Value: &envoy_type_matcher_v3.ValueMatcher{MatchPattern: &envoy_type_matcher_v3.ValueMatcher_ListMatch{
+							ListMatch: &envoy_type_matcher_v3.ListMatcher{MatchPattern: &envoy_type_matcher_v3.ListMatcher_OneOf{
+								OneOf: &envoy_type_matcher_v3.ValueMatcher{MatchPattern: &envoy_type_matcher_v3.ValueMatcher_StringMatch{
+									StringMatch: exact("dev")}}}}}},
+					}}},
+				}}}},
+		},
+	}
+}
+
+func newAttributes(method, uri string, groups ...interface{}) *Attributes {
+	req := &fasthttp.Request{}
+	req.SetRequestURI(uri)
+	req.Header.SetMethod(method)
+	claims, _ := structpb.NewStruct(map[string]interface{}{"request.auth.claims": map[string]interface{}{"groups": groups}})
+	return &Attributes{
+		DirectRemoteIP:  net.ParseIP("192.168.0.1"),
+		RemoteIP:        net.ParseIP("192.168.0.1"),
+		DestinationIP:   net.ParseIP("10.0.0.2"),
+		DestinationPort: 9080,
+		PeerPrincipals:  []string{"spiffe://cluster.local/ns/default/sa/productpage"},
+		Header:          http.NewStreamContext(context.TODO(), nil, req, &fasthttp.Response{}).Request().Header(),
+		Metadata:        &envoy_config_core_v3.Metadata{FilterMetadata: map[string]*structpb.Struct{"istio_authn": claims}},
+	}
+}
+
+func TestEngine(t *testing.T) {
+	e, err := NewEngine(&envoy_config_rbac_v3.RBAC{
+		Action:   envoy_config_rbac_v3.RBAC_ALLOW,
+		Policies: map[string]*envoy_config_rbac_v3.Policy{"ns[default]-policy[reviews]-rule[0]": newIstioPolicy()},
+	})
+	assert.NoError(t, err)
+
+	allowed, policy := e.Allowed(newAttributes("GET", "http://reviews/reviews/0?v=1", "test", "dev"))
+	assert.True(t, allowed)
+	assert.Equal(t, "ns[default]-policy[reviews]-rule[0]", policy)
+
+	for _, attrs := range []*Attributes{
+		newAttributes("POST", "http://reviews/reviews/0", "dev"),
+		newAttributes("GET", "http://reviews/ratings/0", "dev"),
+		newAttributes("GET", "http://reviews/reviews/0", "test"),
+		newAttributes("GET", "http://reviews/reviews/0"),
+	} {
+		allowed, policy = e.Allowed(attrs)
+		assert.False(t, allowed)
+		assert.Equal(t, "", policy)
+	}
+
+	attrs := newAttributes("GET", "http://reviews/reviews/0", "dev")
+	attrs.PeerPrincipals = nil
+	allowed, _ = e.Allowed(attrs)
+	assert.False(t, allowed)
+	attrs.DirectRemoteIP = net.ParseIP("10.1.1.1")
+	allowed, _ = e.Allowed(attrs)
+	assert.True(t, allowed)
+	attrs.DestinationPort = 9090
+	allowed, _ = e.Allowed(attrs)
+	assert.False(t, allowed)
+}
+
+func TestEngineAction(t *testing.T) {
+	deny := &envoy_config_rbac_v3.Policy{
+		Permissions: []*envoy_config_rbac_v3.Permission{{Rule: &envoy_config_rbac_v3.Permission_NotRule{
+			NotRule: &envoy_config_rbac_v3.Permission{Rule: &envoy_config_rbac_v3.Permission_RequestedServerName{
+				RequestedServerName: exact("reviews.default.svc.cluster.local")}}}}},
+		Principals: []*envoy_config_rbac_v3.Principal{{Identifier: &envoy_config_rbac_v3.Principal_Any{Any: true}}},
+	}
+	attrs := newAttributes("GET", "http://reviews/")
+
+	for action, expected := range map[envoy_config_rbac_v3.RBAC_Action]bool{
+		envoy_config_rbac_v3.RBAC_ALLOW: true,
+		envoy_config_rbac_v3.RBAC_DENY:  false,
+		envoy_config_rbac_v3.RBAC_LOG:   true,
+	} {
+		e, err := NewEngine(&envoy_config_rbac_v3.RBAC{Action: action, Policies: map[string]*envoy_config_rbac_v3.Policy{"deny": deny}})
+		assert.NoError(t, err)
+		allowed, policy := e.Allowed(attrs)
+		assert.Equal(t, expected, allowed, action.String())
+		assert.Equal(t, "deny", policy)
+	}
+
+	// no policy
+	e, _ := NewEngine(&envoy_config_rbac_v3.RBAC{Action: envoy_config_rbac_v3.RBAC_ALLOW})
+	allowed, _ := e.Allowed(attrs)
+	assert.False(t, allowed)
+	e, _ = NewEngine(&envoy_config_rbac_v3.RBAC{Action: envoy_config_rbac_v3.RBAC_DENY})
+	allowed, _ = e.Allowed(attrs)
+	assert.True(t, allowed)
+}
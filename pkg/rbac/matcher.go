@@ -0,0 +1,327 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package rbac
+
+import (
+	"fmt"
+	"net"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_rbac_v3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/wereliang/govoy/pkg/router"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type matcher interface {
+	match(attrs *Attributes) bool
+}
+
+type andMatcher []matcher
+
+func (m andMatcher) match(attrs *Attributes) bool {
+	for _, child := range m {
+		if !child.match(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+type orMatcher []matcher
+
+func (m orMatcher) match(attrs *Attributes) bool {
+	for _, child := range m {
+		if child.match(attrs) {
+			return true
+		}
+	}
+	return false
+}
+
+type notMatcher struct {
+	matcher
+}
+
+func (m notMatcher) match(attrs *Attributes) bool {
+	return !m.matcher.match(attrs)
+}
+
+type anyMatcher struct{}
+
+func (anyMatcher) match(*Attributes) bool {
+	return true
+}
+
+type headerMatcher struct {
+	router.HeaderMatcher
+}
+
+func (m headerMatcher) match(attrs *Attributes) bool {
+	return attrs.Header != nil && m.Match(pseudoHeader{attrs.Header})
+}
+
+// pathMatcher matches the path without query
+type pathMatcher struct {
+	router.StringMatcher
+}
+
+func (m pathMatcher) match(attrs *Attributes) bool {
+	return attrs.Header != nil && m.Match(string(attrs.Header.Path()))
+}
+
+type ipMatcher struct {
+	network *net.IPNet
+	ip      func(attrs *Attributes) net.IP
+}
+
+func (m *ipMatcher) match(attrs *Attributes) bool {
+	ip := m.ip(attrs)
+	return ip != nil && m.network.Contains(ip)
+}
+
+func newIPMatcher(c *envoy_config_core_v3.CidrRange, ip func(attrs *Attributes) net.IP) (matcher, error) {
+	_, network, err := net.ParseCIDR(fmt.Sprintf("%s/%d", c.GetAddressPrefix(), c.GetPrefixLen().GetValue()))
+	if err != nil {
+		return nil, err
+	}
+	return &ipMatcher{network: network, ip: ip}, nil
+}
+
+// portMatcher matches the destination port in [start, end)
+type portMatcher struct {
+	start, end uint32
+}
+
+func (m portMatcher) match(attrs *Attributes) bool {
+	return attrs.DestinationPort >= m.start && attrs.DestinationPort < m.end
+}
+
+type serverNameMatcher struct {
+	router.StringMatcher
+}
+
+func (m serverNameMatcher) match(attrs *Attributes) bool {
+	return m.Match(attrs.ServerName)
+}
+
+// authenticatedMatcher matches any authenticated connection if principal name is not set
+type authenticatedMatcher struct {
+	name router.StringMatcher
+}
+
+func (m authenticatedMatcher) match(attrs *Attributes) bool {
+	if m.name == nil {
+		return len(attrs.PeerPrincipals) > 0
+	}
+	for _, p := range attrs.PeerPrincipals {
+		if m.name.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func newPermissions(permissions []*envoy_config_rbac_v3.Permission) ([]matcher, error) {
+	var matchers []matcher
+	for _, p := range permissions {
+		m, err := newPermission(p)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func newPermission(p *envoy_config_rbac_v3.Permission) (matcher, error) {
+	switch rule := p.GetRule().(type) {
+	case *envoy_config_rbac_v3.Permission_AndRules:
+		matchers, err := newPermissions(rule.AndRules.GetRules())
+		return andMatcher(matchers), err
+	case *envoy_config_rbac_v3.Permission_OrRules:
+		matchers, err := newPermissions(rule.OrRules.GetRules())
+		return orMatcher(matchers), err
+	case *envoy_config_rbac_v3.Permission_Any:
+		return anyMatcher{}, nil
+	case *envoy_config_rbac_v3.Permission_Header:
+		m, err := router.NewHeaderMatcher(rule.Header)
+		return headerMatcher{m}, err
+	case *envoy_config_rbac_v3.Permission_UrlPath:
+		m, err := router.NewStringMatcher(rule.UrlPath.GetPath())
+		return pathMatcher{m}, err
+	case *envoy_config_rbac_v3.Permission_DestinationIp:
+		return newIPMatcher(rule.DestinationIp, func(attrs *Attributes) net.IP { return attrs.DestinationIP })
+	case *envoy_config_rbac_v3.Permission_DestinationPort:
+		return portMatcher{rule.DestinationPort, rule.DestinationPort + 1}, nil
+	case *envoy_config_rbac_v3.Permission_DestinationPortRange:
+		return portMatcher{uint32(rule.DestinationPortRange.GetStart()), uint32(rule.DestinationPortRange.GetEnd())}, nil
+	case *envoy_config_rbac_v3.Permission_Metadata:
+		return newMetadataMatcher(rule.Metadata)
+	case *envoy_config_rbac_v3.Permission_NotRule:
+		m, err := newPermission(rule.NotRule)
+		return notMatcher{m}, err
+	case *envoy_config_rbac_v3.Permission_RequestedServerName:
+		m, err := router.NewStringMatcher(rule.RequestedServerName)
+		return serverNameMatcher{m}, err
+	}
+	return nil, fmt.Errorf("not support permission: %T", p.GetRule())
+}
+
+func newPrincipals(principals []*envoy_config_rbac_v3.Principal) ([]matcher, error) {
+	var matchers []matcher
+	for _, p := range principals {
+		m, err := newPrincipal(p)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func newPrincipal(p *envoy_config_rbac_v3.Principal) (matcher, error) {
+	switch id := p.GetIdentifier().(type) {
+	case *envoy_config_rbac_v3.Principal_AndIds:
+		matchers, err := newPrincipals(id.AndIds.GetIds())
+		return andMatcher(matchers), err
+	case *envoy_config_rbac_v3.Principal_OrIds:
+		matchers, err := newPrincipals(id.OrIds.GetIds())
+		return orMatcher(matchers), err
+	case *envoy_config_rbac_v3.Principal_Any:
+		return anyMatcher{}, nil
+	case *envoy_config_rbac_v3.Principal_Authenticated_:
+		if id.Authenticated.GetPrincipalName() == nil {
+			return authenticatedMatcher{}, nil
+		}
+		m, err := router.NewStringMatcher(id.Authenticated.GetPrincipalName())
+		return authenticatedMatcher{m}, err
+	case *envoy_config_rbac_v3.Principal_SourceIp:
+		return newIPMatcher(id.SourceIp, func(attrs *Attributes) net.IP { return attrs.DirectRemoteIP })
+	case *envoy_config_rbac_v3.Principal_DirectRemoteIp:
+		return newIPMatcher(id.DirectRemoteIp, func(attrs *Attributes) net.IP { return attrs.DirectRemoteIP })
+	case *envoy_config_rbac_v3.Principal_RemoteIp:
+		return newIPMatcher(id.RemoteIp, func(attrs *Attributes) net.IP { return attrs.RemoteIP })
+	case *envoy_config_rbac_v3.Principal_Header:
+		m, err := router.NewHeaderMatcher(id.Header)
+		return headerMatcher{m}, err
+	case *envoy_config_rbac_v3.Principal_UrlPath:
+		m, err := router.NewStringMatcher(id.UrlPath.GetPath())
+		return pathMatcher{m}, err
+	case *envoy_config_rbac_v3.Principal_Metadata:
+		return newMetadataMatcher(id.Metadata)
+	case *envoy_config_rbac_v3.Principal_NotId:
+		m, err := newPrincipal(id.NotId)
+		return notMatcher{m}, err
+	}
+	return nil, fmt.Errorf("not support principal: %T", p.GetIdentifier())
+}
+
+// metadataMatcher matches the dynamic metadata by envoy.type.matcher.v3.MetadataMatcher
+type metadataMatcher struct {
+	filter string
+	path   []string
+	value  func(*structpb.Value) bool
+	invert bool
+}
+
+func newMetadataMatcher(c *envoy_type_matcher_v3.MetadataMatcher) (matcher, error) {
+	m := &metadataMatcher{filter: c.GetFilter(), invert: c.GetInvert()}
+	for _, segment := range c.GetPath() {
+		m.path = append(m.path, segment.GetKey())
+	}
+	var err error
+	if m.value, err = newValueMatcher(c.GetValue()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// match the value of path, the value is nil if not found
+func (m *metadataMatcher) match(attrs *Attributes) bool {
+	var value *structpb.Value
+	if s, ok := attrs.Metadata.GetFilterMetadata()[m.filter]; ok {
+		value = structpb.NewStructValue(s)
+		for _, key := range m.path {
+			if value = value.GetStructValue().GetFields()[key]; value == nil {
+				break
+			}
+		}
+	}
+	return m.value(value) != m.invert
+}
+
+func newValueMatcher(c *envoy_type_matcher_v3.ValueMatcher) (func(*structpb.Value) bool, error) {
+	switch spec := c.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.ValueMatcher_NullMatch_:
+		return func(v *structpb.Value) bool {
+			_, ok := v.GetKind().(*structpb.Value_NullValue)
+			return ok
+		}, nil
+	case *envoy_type_matcher_v3.ValueMatcher_DoubleMatch:
+		return func(v *structpb.Value) bool {
+			n, ok := v.GetKind().(*structpb.Value_NumberValue)
+			if !ok {
+				return false
+			}
+			if r := spec.DoubleMatch.GetRange(); r != nil {
+				return n.NumberValue >= r.GetStart() && n.NumberValue < r.GetEnd()
+			}
+			return n.NumberValue == spec.DoubleMatch.GetExact()
+		}, nil
+	case *envoy_type_matcher_v3.ValueMatcher_StringMatch:
+		sm, err := router.NewStringMatcher(spec.StringMatch)
+		if err != nil {
+			return nil, err
+		}
+		return func(v *structpb.Value) bool {
+			s, ok := v.GetKind().(*structpb.Value_StringValue)
+			return ok && sm.Match(s.StringValue)
+		}, nil
+	case *envoy_type_matcher_v3.ValueMatcher_BoolMatch:
+		return func(v *structpb.Value) bool {
+			b, ok := v.GetKind().(*structpb.Value_BoolValue)
+			return ok && b.BoolValue == spec.BoolMatch
+		}, nil
+	case *envoy_type_matcher_v3.ValueMatcher_PresentMatch:
+		return func(v *structpb.Value) bool {
+			return spec.PresentMatch && v.GetKind() != nil
+		}, nil
+	case *envoy_type_matcher_v3.ValueMatcher_ListMatch:
+		oneOf, err := newValueMatcher(spec.ListMatch.GetOneOf())
+		if err != nil {
+			return nil, err
+		}
+		return func(v *structpb.Value) bool {
+			for _, item := range v.GetListValue().GetValues() {
+				if oneOf(item) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, fmt.Errorf("not support value matcher: %T", c.GetMatchPattern())
+}
@@ -0,0 +1,99 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package router
+
+import (
+	"fmt"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/wereliang/govoy/pkg/utils"
+)
+
+type corsPolicy struct {
+	config  *envoy_config_route_v3.CorsPolicy
+	origins []matcher
+}
+
+// newCorsPolicy returns nil if not configured
+func newCorsPolicy(c *envoy_config_route_v3.CorsPolicy) (*corsPolicy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	cp := &corsPolicy{config: c}
+	for _, sm := range c.GetAllowOriginStringMatch() {
+		m, err := newStringMatcher(sm)
+		if err != nil {
+			return nil, err
+		}
+		cp.origins = append(cp.origins, m)
+	}
+	return cp, nil
+}
+
+func mustCorsPolicy(c *envoy_config_route_v3.CorsPolicy) *corsPolicy {
+	cp, err := newCorsPolicy(c)
+	if err != nil {
+		panic(fmt.Sprintf("invalid cors policy: %s", err))
+	}
+	return cp
+}
+
+// AllowOrigin the matcher matching "*" allows any origin
+func (cp *corsPolicy) AllowOrigin(origin string) bool {
+	for _, m := range cp.origins {
+		if m.MatchRoute([]byte(origin)) || m.MatchRoute([]byte("*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cp *corsPolicy) AllowMethods() string {
+	return cp.config.GetAllowMethods()
+}
+
+func (cp *corsPolicy) AllowHeaders() string {
+	return cp.config.GetAllowHeaders()
+}
+
+func (cp *corsPolicy) ExposeHeaders() string {
+	return cp.config.GetExposeHeaders()
+}
+
+func (cp *corsPolicy) MaxAge() string {
+	return cp.config.GetMaxAge()
+}
+
+func (cp *corsPolicy) AllowCredentials() bool {
+	return cp.config.GetAllowCredentials().GetValue()
+}
+
+func (cp *corsPolicy) Enabled() bool {
+	enabled := cp.config.GetFilterEnabled()
+	if enabled == nil {
+		return true
+	}
+	return utils.FractionalPercentSample(enabled.GetDefaultValue())
+}
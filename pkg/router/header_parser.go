@@ -0,0 +1,161 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package router
+
+import (
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/formatter"
+)
+
+type headerValue struct {
+	key          string
+	formatter    formatter.Formatter
+	appendAction envoy_config_core_v3.HeaderValueOption_HeaderAppendAction
+	keepEmpty    bool
+}
+
+// HeaderParser apply headers_to_add and headers_to_remove to the header map
+type HeaderParser interface {
+	Evaluate(api.HeaderMap, api.StreamContext)
+}
+
+// NewHeaderParser returns nil if no headers to add or remove
+func NewHeaderParser(toAdd []*envoy_config_core_v3.HeaderValueOption, toRemove []string) (HeaderParser, error) {
+	hp, err := newHeaderParser(toAdd, toRemove)
+	if hp == nil || err != nil {
+		return nil, err
+	}
+	return hp, nil
+}
+
+// headerParser apply headers_to_add and headers_to_remove of a level
+type headerParser struct {
+	toAdd    []*headerValue
+	toRemove []string
+}
+
+func newHeaderParser(toAdd []*envoy_config_core_v3.HeaderValueOption, toRemove []string) (*headerParser, error) {
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		return nil, nil
+	}
+
+	hp := &headerParser{toRemove: toRemove}
+	for _, opt := range toAdd {
+		f, err := formatter.NewHeaderFormatter(opt.GetHeader().GetValue())
+		if err != nil {
+			return nil, err
+		}
+		action := opt.GetAppendAction()
+		// deprecated append has higher priority
+		if opt.GetAppend() != nil {
+			if opt.GetAppend().GetValue() {
+				action = envoy_config_core_v3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD
+			} else {
+				action = envoy_config_core_v3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+			}
+		}
+		hp.toAdd = append(hp.toAdd, &headerValue{
+			key:          opt.GetHeader().GetKey(),
+			formatter:    f,
+			appendAction: action,
+			keepEmpty:    opt.GetKeepEmptyValue(),
+		})
+	}
+	return hp, nil
+}
+
+func (hp *headerParser) Evaluate(header api.HeaderMap, ctx api.StreamContext) {
+	hp.evaluate(header, ctx)
+}
+
+// evaluate remove headers first and then add headers
+func (hp *headerParser) evaluate(header api.HeaderMap, ctx api.StreamContext) {
+	if hp == nil {
+		return
+	}
+	for _, key := range hp.toRemove {
+		header.Del(key)
+	}
+	for _, hv := range hp.toAdd {
+		value := hv.formatter.Format(ctx)
+		if value == "" && !hv.keepEmpty {
+			continue
+		}
+		switch hv.appendAction {
+		case envoy_config_core_v3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD:
+			header.Set(hv.key, value)
+		case envoy_config_core_v3.HeaderValueOption_ADD_IF_ABSENT:
+			if header.Get(hv.key) == nil {
+				header.Add(hv.key, value)
+			}
+		default:
+			header.Add(hv.key, value)
+		}
+	}
+}
+
+// headerMutation holds request and response header parsers of a level
+type headerMutation struct {
+	request  *headerParser
+	response *headerParser
+}
+
+type headerMutationConfig interface {
+	GetRequestHeadersToAdd() []*envoy_config_core_v3.HeaderValueOption
+	GetRequestHeadersToRemove() []string
+	GetResponseHeadersToAdd() []*envoy_config_core_v3.HeaderValueOption
+	GetResponseHeadersToRemove() []string
+}
+
+// newHeaderMutation returns nil if no header mutation configured
+func newHeaderMutation(c headerMutationConfig) (*headerMutation, error) {
+	var (
+		hm  = &headerMutation{}
+		err error
+	)
+	if hm.request, err = newHeaderParser(c.GetRequestHeadersToAdd(), c.GetRequestHeadersToRemove()); err != nil {
+		return nil, err
+	}
+	if hm.response, err = newHeaderParser(c.GetResponseHeadersToAdd(), c.GetResponseHeadersToRemove()); err != nil {
+		return nil, err
+	}
+	if hm.request == nil && hm.response == nil {
+		return nil, nil
+	}
+	return hm, nil
+}
+
+func (hm *headerMutation) evaluateRequest(ctx api.StreamContext) {
+	if hm != nil {
+		hm.request.evaluate(ctx.Request().Header(), ctx)
+	}
+}
+
+func (hm *headerMutation) evaluateResponse(ctx api.StreamContext) {
+	if hm != nil {
+		hm.response.evaluate(ctx.Response().Header(), ctx)
+	}
+}
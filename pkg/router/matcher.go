@@ -0,0 +1,153 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// HeaderMatcher matches headers by envoy.config.route.v3.HeaderMatcher
+type HeaderMatcher interface {
+	Match(api.HeaderMap) bool
+}
+
+func NewHeaderMatcher(hm *envoy_config_route_v3.HeaderMatcher) (HeaderMatcher, error) {
+	return newHeaderMatcherWrap(hm)
+}
+
+func newHeaderMatcherWrap(hm *envoy_config_route_v3.HeaderMatcher) (*matcherWrap, error) {
+	m, err := newHeaderMatcher(hm)
+	if err != nil {
+		return nil, err
+	}
+	invert := hm.GetInvertMatch()
+	// present_match false means the header must be absent
+	if pm, ok := hm.GetHeaderMatchSpecifier().(*envoy_config_route_v3.HeaderMatcher_PresentMatch); ok && !pm.PresentMatch {
+		invert = !invert
+	}
+	return &matcherWrap{matcher: m, rtype: TypeHeader, key: hm.GetName(), invert: invert}, nil
+}
+
+// Match match the header value, which is nil if not present
+func (m *matcherWrap) Match(header api.HeaderMap) bool {
+	value := header.Get(m.key)
+	return m.matchValue(value, value != nil)
+}
+
+// StringMatcher matches string by envoy.type.matcher.v3.StringMatcher
+type StringMatcher interface {
+	Match(string) bool
+}
+
+func NewStringMatcher(sm *envoy_type_matcher_v3.StringMatcher) (StringMatcher, error) {
+	m, err := newStringMatcher(sm)
+	if err != nil {
+		return nil, err
+	}
+	return stringMatcherWrap{m}, nil
+}
+
+type stringMatcherWrap struct {
+	matcher
+}
+
+func (m stringMatcherWrap) Match(s string) bool {
+	return m.MatchRoute([]byte(s))
+}
+
+// newStringMatcher create matcher by envoy.type.matcher.v3.StringMatcher
+func newStringMatcher(sm *envoy_type_matcher_v3.StringMatcher) (matcher, error) {
+	var m matcher
+	ignoreCase := sm.GetIgnoreCase()
+	pattern := func(s string) string {
+		if ignoreCase {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+
+	switch p := sm.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.StringMatcher_Exact:
+		m = &exactMatcher{pattern(p.Exact)}
+	case *envoy_type_matcher_v3.StringMatcher_Prefix:
+		m = &prefixMatcher{pattern(p.Prefix)}
+	case *envoy_type_matcher_v3.StringMatcher_Suffix:
+		m = &suffixMatcher{pattern(p.Suffix)}
+	case *envoy_type_matcher_v3.StringMatcher_Contains:
+		m = &containsMatcher{pattern(p.Contains)}
+	case *envoy_type_matcher_v3.StringMatcher_SafeRegex:
+		// ignore_case has no effect for safe_regex
+		return newRegexMatcher(p.SafeRegex.GetRegex())
+	default:
+		return nil, fmt.Errorf("not support string matcher: %T", p)
+	}
+	if ignoreCase {
+		m = &ignoreCaseMatcher{m}
+	}
+	return m, nil
+}
+
+func newHeaderMatcher(hm *envoy_config_route_v3.HeaderMatcher) (matcher, error) {
+	switch spec := hm.GetHeaderMatchSpecifier().(type) {
+	case nil:
+		// no specifier means present match
+		return &wildcardMatcher{}, nil
+	case *envoy_config_route_v3.HeaderMatcher_ExactMatch:
+		return &exactMatcher{spec.ExactMatch}, nil
+	case *envoy_config_route_v3.HeaderMatcher_PrefixMatch:
+		return &prefixMatcher{spec.PrefixMatch}, nil
+	case *envoy_config_route_v3.HeaderMatcher_SuffixMatch:
+		return &suffixMatcher{spec.SuffixMatch}, nil
+	case *envoy_config_route_v3.HeaderMatcher_ContainsMatch:
+		return &containsMatcher{spec.ContainsMatch}, nil
+	case *envoy_config_route_v3.HeaderMatcher_SafeRegexMatch:
+		return newRegexMatcher(spec.SafeRegexMatch.GetRegex())
+	case *envoy_config_route_v3.HeaderMatcher_RangeMatch:
+		return &rangeMatcher{spec.RangeMatch.GetStart(), spec.RangeMatch.GetEnd()}, nil
+	case *envoy_config_route_v3.HeaderMatcher_PresentMatch:
+		// present_match false is handled as invert by caller
+		return &wildcardMatcher{}, nil
+	case *envoy_config_route_v3.HeaderMatcher_StringMatch:
+		return newStringMatcher(spec.StringMatch)
+	default:
+		return nil, fmt.Errorf("not support header matcher: %T", spec)
+	}
+}
+
+func newQueryParameterMatcher(qm *envoy_config_route_v3.QueryParameterMatcher) (matcher, error) {
+	switch spec := qm.GetQueryParameterMatchSpecifier().(type) {
+	case *envoy_config_route_v3.QueryParameterMatcher_StringMatch:
+		return newStringMatcher(spec.StringMatch)
+	case *envoy_config_route_v3.QueryParameterMatcher_PresentMatch:
+		return &wildcardMatcher{}, nil
+	default:
+		return nil, fmt.Errorf("not support query parameter matcher: %T", spec)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package router
+
+import (
+	"fmt"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/log"
+)
+
+// perFilterConfigs holds the typed_per_filter_config of a level by filter name
+type perFilterConfigs map[string]interface{}
+
+func newPerFilterConfigs(configs map[string]*any.Any) (perFilterConfigs, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	pfc := make(perFilterConfigs)
+	for name, a := range configs {
+		// unwrap envoy.config.route.v3.FilterConfig
+		if ptypes.Is(a, &envoy_config_route_v3.FilterConfig{}) {
+			fc := &envoy_config_route_v3.FilterConfig{}
+			if err := ptypes.UnmarshalAny(a, fc); err != nil {
+				return nil, err
+			}
+			a = fc.GetConfig()
+		}
+		factory, pb := filter.GetHTTPFactory(a, name)
+		if factory == nil {
+			// the per route config may have its own type, like RBACPerRoute, find factory by name
+			if factory, _ = filter.GetHTTPFactory(nil, name); factory != nil {
+				var dynamic ptypes.DynamicAny
+				if err := ptypes.UnmarshalAny(a, &dynamic); err != nil {
+					return nil, err
+				}
+				pb = dynamic.Message
+			}
+		}
+		if factory == nil {
+			log.Debug("not support per filter config: %s", name)
+			continue
+		}
+		if rf, ok := factory.(api.RouteSpecificFilterFactory); ok {
+			c, err := rf.CreateRouteSpecificFilterConfig(pb)
+			if err != nil {
+				return nil, err
+			}
+			pfc[name] = c
+		} else {
+			pfc[name] = pb
+		}
+	}
+	return pfc, nil
+}
+
+func mustPerFilterConfigs(configs map[string]*any.Any) perFilterConfigs {
+	pfc, err := newPerFilterConfigs(configs)
+	if err != nil {
+		panic(fmt.Sprintf("invalid typed_per_filter_config: %s", err))
+	}
+	return pfc
+}
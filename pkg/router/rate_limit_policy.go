@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package router
+
+import (
+	"fmt"
+	"net"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// rateLimitAction populate a descriptor entry, returns false if the descriptor should be skipped
+type rateLimitAction interface {
+	populate(ctx api.StreamContext, descriptor api.RateLimitDescriptor) (api.RateLimitDescriptor, bool)
+}
+
+type rateLimitEntry struct {
+	stage   uint32
+	actions []rateLimitAction
+}
+
+// rateLimitPolicy is the rate limits of a level
+type rateLimitPolicy []*rateLimitEntry
+
+func newRateLimitPolicy(limits []*envoy_config_route_v3.RateLimit) (rateLimitPolicy, error) {
+	var policy rateLimitPolicy
+	for _, limit := range limits {
+		entry := &rateLimitEntry{stage: limit.GetStage().GetValue()}
+		for _, action := range limit.GetActions() {
+			a, err := newRateLimitAction(action)
+			if err != nil {
+				return nil, err
+			}
+			entry.actions = append(entry.actions, a)
+		}
+		policy = append(policy, entry)
+	}
+	return policy, nil
+}
+
+func mustRateLimitPolicy(limits []*envoy_config_route_v3.RateLimit) rateLimitPolicy {
+	policy, err := newRateLimitPolicy(limits)
+	if err != nil {
+		panic(fmt.Sprintf("invalid rate limits: %s", err))
+	}
+	return policy
+}
+
+func (p rateLimitPolicy) Descriptors(stage uint32, ctx api.StreamContext) []api.RateLimitDescriptor {
+	var descriptors []api.RateLimitDescriptor
+	for _, entry := range p {
+		if entry.stage != stage {
+			continue
+		}
+		var (
+			descriptor api.RateLimitDescriptor
+			ok         = true
+		)
+		for _, action := range entry.actions {
+			if descriptor, ok = action.populate(ctx, descriptor); !ok {
+				break
+			}
+		}
+		if ok && len(descriptor) > 0 {
+			descriptors = append(descriptors, descriptor)
+		}
+	}
+	return descriptors
+}
+
+// combinedRateLimitPolicy is the rate limits of route followed by virtual host's
+type combinedRateLimitPolicy []rateLimitPolicy
+
+func (p combinedRateLimitPolicy) Descriptors(stage uint32, ctx api.StreamContext) []api.RateLimitDescriptor {
+	var descriptors []api.RateLimitDescriptor
+	for _, policy := range p {
+		descriptors = append(descriptors, policy.Descriptors(stage, ctx)...)
+	}
+	return descriptors
+}
+
+func newRateLimitAction(action *envoy_config_route_v3.RateLimit_Action) (rateLimitAction, error) {
+	switch spec := action.GetActionSpecifier().(type) {
+	case *envoy_config_route_v3.RateLimit_Action_DestinationCluster_:
+		return destinationClusterAction{}, nil
+	case *envoy_config_route_v3.RateLimit_Action_RequestHeaders_:
+		return requestHeadersAction{spec.RequestHeaders}, nil
+	case *envoy_config_route_v3.RateLimit_Action_RemoteAddress_:
+		return remoteAddressAction{}, nil
+	case *envoy_config_route_v3.RateLimit_Action_GenericKey_:
+		return genericKeyAction{spec.GenericKey}, nil
+	case *envoy_config_route_v3.RateLimit_Action_HeaderValueMatch_:
+		return newHeaderValueMatchAction(spec.HeaderValueMatch)
+	}
+	return nil, fmt.Errorf("not support rate limit action: %T", action.GetActionSpecifier())
+}
+
+type destinationClusterAction struct{}
+
+func (destinationClusterAction) populate(ctx api.StreamContext, descriptor api.RateLimitDescriptor) (api.RateLimitDescriptor, bool) {
+	entry := ctx.StreamInfo().RouteEntry()
+	if entry == nil {
+		return descriptor, false
+	}
+	return append(descriptor, api.RateLimitDescriptorEntry{Key: "destination_cluster", Value: entry.ClusterName()}), true
+}
+
+type requestHeadersAction struct {
+	*envoy_config_route_v3.RateLimit_Action_RequestHeaders
+}
+
+// populate skips the action but not the descriptor if header is absent and skip_if_absent
+func (a requestHeadersAction) populate(ctx api.StreamContext, descriptor api.RateLimitDescriptor) (api.RateLimitDescriptor, bool) {
+	value := ctx.Request().Header().Get(a.GetHeaderName())
+	if value == nil {
+		return descriptor, a.GetSkipIfAbsent()
+	}
+	return append(descriptor, api.RateLimitDescriptorEntry{Key: a.GetDescriptorKey(), Value: string(value)}), true
+}
+
+type remoteAddressAction struct{}
+
+func (remoteAddressAction) populate(ctx api.StreamContext, descriptor api.RateLimitDescriptor) (api.RateLimitDescriptor, bool) {
+	addr, ok := ctx.StreamInfo().DownstreamRemoteAddress().(*net.TCPAddr)
+	if !ok || addr == nil {
+		return descriptor, false
+	}
+	return append(descriptor, api.RateLimitDescriptorEntry{Key: "remote_address", Value: addr.IP.String()}), true
+}
+
+type genericKeyAction struct {
+	*envoy_config_route_v3.RateLimit_Action_GenericKey
+}
+
+func (a genericKeyAction) populate(ctx api.StreamContext, descriptor api.RateLimitDescriptor) (api.RateLimitDescriptor, bool) {
+	key := a.GetDescriptorKey()
+	if key == "" {
+		key = "generic_key"
+	}
+	return append(descriptor, api.RateLimitDescriptorEntry{Key: key, Value: a.GetDescriptorValue()}), true
+}
+
+type headerValueMatchAction struct {
+	key         string
+	value       string
+	expectMatch bool
+	headers     []HeaderMatcher
+}
+
+func newHeaderValueMatchAction(c *envoy_config_route_v3.RateLimit_Action_HeaderValueMatch) (*headerValueMatchAction, error) {
+	a := &headerValueMatchAction{key: c.GetDescriptorKey(), value: c.GetDescriptorValue(), expectMatch: true}
+	if a.key == "" {
+		a.key = "header_match"
+	}
+	if em := c.GetExpectMatch(); em != nil {
+		a.expectMatch = em.GetValue()
+	}
+	for _, h := range c.GetHeaders() {
+		m, err := NewHeaderMatcher(h)
+		if err != nil {
+			return nil, err
+		}
+		a.headers = append(a.headers, m)
+	}
+	return a, nil
+}
+
+func (a *headerValueMatchAction) populate(ctx api.StreamContext, descriptor api.RateLimitDescriptor) (api.RateLimitDescriptor, bool) {
+	matched := true
+	for _, h := range a.headers {
+		if !h.Match(ctx.Request().Header()) {
+			matched = false
+			break
+		}
+	}
+	if matched != a.expectMatch {
+		return descriptor, false
+	}
+	return append(descriptor, api.RateLimitDescriptorEntry{Key: a.key, Value: a.value}), true
+}
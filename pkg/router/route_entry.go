@@ -0,0 +1,233 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package router
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+const (
+	defaultTotalWeight  = 100
+	defaultRouteTimeout = 15 * time.Second
+)
+
+type routeEntry struct {
+	cluster  string
+	config   *envoy_config_route_v3.Route
+	headers  *headerMutation
+	cors     *corsPolicy
+	filters  perFilterConfigs
+	limits   combinedRateLimitPolicy
+	mirrors  []api.MirrorPolicy
+	vhost    *virtualHost
+	weighted []*weightedClusterEntry
+	total    uint32
+}
+
+func NewRouteEntry(cluster string) api.RouteEntry {
+	return &routeEntry{cluster: cluster}
+}
+
+func newRouteEntry(config *envoy_config_route_v3.Route, vh *virtualHost) *routeEntry {
+	re := &routeEntry{
+		config:  config,
+		headers: mustHeaderMutation(config),
+		cors:    mustCorsPolicy(config.GetRoute().GetCors()),
+		filters: mustPerFilterConfigs(config.GetTypedPerFilterConfig()),
+		mirrors: newMirrorPolicies(config.GetRoute().GetRequestMirrorPolicies()),
+		vhost:   vh,
+	}
+
+	action := config.GetRoute()
+	if limits := mustRateLimitPolicy(action.GetRateLimits()); len(limits) > 0 {
+		re.limits = append(re.limits, limits)
+	}
+	if len(re.limits) == 0 || action.GetIncludeVhRateLimits().GetValue() {
+		if vh != nil && len(vh.limits) > 0 {
+			re.limits = append(re.limits, vh.limits)
+		}
+	}
+
+	switch spec := action.GetClusterSpecifier().(type) {
+	case *envoy_config_route_v3.RouteAction_Cluster:
+		re.cluster = spec.Cluster
+	case *envoy_config_route_v3.RouteAction_WeightedClusters:
+		for _, c := range spec.WeightedClusters.GetClusters() {
+			re.weighted = append(re.weighted, &weightedClusterEntry{
+				routeEntry: re,
+				cluster:    c.GetName(),
+				weight:     c.GetWeight().GetValue(),
+				headers:    mustHeaderMutation(c),
+				filters:    mustPerFilterConfigs(c.GetTypedPerFilterConfig()),
+			})
+			re.total += c.GetWeight().GetValue()
+		}
+		if tw := spec.WeightedClusters.GetTotalWeight(); tw != nil {
+			re.total = tw.GetValue()
+		} else if re.total == 0 {
+			re.total = defaultTotalWeight
+		}
+	default:
+		// just support cluster and weighted clusters action
+		panic(fmt.Sprintf("invalid route action(just support cluster): %T", spec))
+	}
+	return re
+}
+
+// pickEntry choose a cluster by weight if the route has weighted clusters
+func (re *routeEntry) pickEntry() api.RouteEntry {
+	if len(re.weighted) == 0 {
+		return re
+	}
+	selected := uint32(rand.Int63n(int64(re.total)))
+	var begin uint32
+	for _, w := range re.weighted {
+		if selected >= begin && selected < begin+w.weight {
+			return w
+		}
+		begin += w.weight
+	}
+	return re.weighted[len(re.weighted)-1]
+}
+
+func (re *routeEntry) ClusterName() string {
+	return re.cluster
+}
+
+func (re *routeEntry) CorsPolicy() api.CorsPolicy {
+	if re.cors != nil {
+		return re.cors
+	}
+	if re.vhost != nil && re.vhost.cors != nil {
+		return re.vhost.cors
+	}
+	return nil
+}
+
+func (re *routeEntry) PerFilterConfig(name string) interface{} {
+	if c, ok := re.filters[name]; ok {
+		return c
+	}
+	if re.vhost != nil {
+		if c, ok := re.vhost.filters[name]; ok {
+			return c
+		}
+	}
+	return nil
+}
+
+func (re *routeEntry) RateLimitPolicy() api.RateLimitPolicy {
+	return re.limits
+}
+
+func (re *routeEntry) Decorator() string {
+	return re.config.GetDecorator().GetOperation()
+}
+
+func (re *routeEntry) Priority() api.RoutingPriority {
+	return api.RoutingPriority(re.config.GetRoute().GetPriority())
+}
+
+func (re *routeEntry) Timeout() time.Duration {
+	if t := re.config.GetRoute().GetTimeout(); t != nil {
+		return t.AsDuration()
+	}
+	return defaultRouteTimeout
+}
+
+func (re *routeEntry) MirrorPolicies() []api.MirrorPolicy {
+	if len(re.mirrors) == 0 && re.vhost != nil {
+		return re.vhost.mirrors
+	}
+	return re.mirrors
+}
+
+// headerMutations returns header mutations from the most specific level by default,
+// so that the less specific level overwrite. it's reversed if most_specific_header_mutations_wins.
+// weighted is the level of the selected weighted cluster, which is more specific than the route.
+func (re *routeEntry) headerMutations(weighted *headerMutation) []*headerMutation {
+	if re.vhost == nil {
+		return []*headerMutation{weighted}
+	}
+	global := re.vhost.global
+	if global.config.GetMostSpecificHeaderMutationsWins() {
+		return []*headerMutation{global.headers, re.vhost.headers, re.headers, weighted}
+	}
+	return []*headerMutation{weighted, re.headers, re.vhost.headers, global.headers}
+}
+
+func (re *routeEntry) FinalizeRequestHeaders(ctx api.StreamContext) {
+	re.finalizeRequestHeaders(ctx, nil)
+}
+
+func (re *routeEntry) FinalizeResponseHeaders(ctx api.StreamContext) {
+	re.finalizeResponseHeaders(ctx, nil)
+}
+
+func (re *routeEntry) finalizeRequestHeaders(ctx api.StreamContext, weighted *headerMutation) {
+	for _, hm := range re.headerMutations(weighted) {
+		hm.evaluateRequest(ctx)
+	}
+}
+
+func (re *routeEntry) finalizeResponseHeaders(ctx api.StreamContext, weighted *headerMutation) {
+	for _, hm := range re.headerMutations(weighted) {
+		hm.evaluateResponse(ctx)
+	}
+}
+
+// weightedClusterEntry is a cluster of weighted clusters, whose header
+// mutations are the most specific level of the route
+type weightedClusterEntry struct {
+	*routeEntry
+	cluster string
+	weight  uint32
+	headers *headerMutation
+	filters perFilterConfigs
+}
+
+func (we *weightedClusterEntry) ClusterName() string {
+	return we.cluster
+}
+
+func (we *weightedClusterEntry) FinalizeRequestHeaders(ctx api.StreamContext) {
+	we.routeEntry.finalizeRequestHeaders(ctx, we.headers)
+}
+
+func (we *weightedClusterEntry) FinalizeResponseHeaders(ctx api.StreamContext) {
+	we.routeEntry.finalizeResponseHeaders(ctx, we.headers)
+}
+
+func (we *weightedClusterEntry) PerFilterConfig(name string) interface{} {
+	if c, ok := we.filters[name]; ok {
+		return c
+	}
+	return we.routeEntry.PerFilterConfig(name)
+}
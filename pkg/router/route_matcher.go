@@ -39,18 +39,6 @@ import (
 // Prefix domain wildcards: foo.* or foo-*.
 // Special wildcard * matching any domain.
 
-type routeEntry struct {
-	cluster string
-}
-
-func (re *routeEntry) ClusterName() string {
-	return re.cluster
-}
-
-func NewRouteEntry(cluster string) api.RouteEntry {
-	return &routeEntry{cluster}
-}
-
 func NewRouterMatcher(c *envoy_config_route_v3.RouteConfiguration) api.RouteConfigMatcher {
 	rc := &routeConfigMatcher{config: c, domains: radix.NewPatternTrie()}
 	rc.build()
@@ -60,30 +48,27 @@ func NewRouterMatcher(c *envoy_config_route_v3.RouteConfiguration) api.RouteConf
 type routeConfigMatcher struct {
 	config  *envoy_config_route_v3.RouteConfiguration
 	domains *radix.PatternTrie
+	headers *headerMutation
 }
 
 func (rc *routeConfigMatcher) build() {
+	rc.headers = mustHeaderMutation(rc.config)
+
 	for _, vhConfig := range rc.config.VirtualHosts {
 
 		vh := &virtualHost{
-			name:   vhConfig.Name,
-			routes: NewRouter()}
+			name:    vhConfig.Name,
+			routes:  NewRouter(),
+			headers: mustHeaderMutation(vhConfig),
+			cors:    mustCorsPolicy(vhConfig.GetCors()),
+			filters: mustPerFilterConfigs(vhConfig.GetTypedPerFilterConfig()),
+			limits:  mustRateLimitPolicy(vhConfig.GetRateLimits()),
+			mirrors: newMirrorPolicies(vhConfig.GetRequestMirrorPolicies()),
+			global:  rc}
 
 		for _, route := range vhConfig.Routes {
-			var r Route
-			if obj := route.Match.GetPath(); obj != "" {
-				r = vh.routes.Path(obj)
-			} else if obj := route.Match.GetPrefix(); obj != "" {
-				r = vh.routes.PathPrefix(obj)
-			} else {
-				panic(fmt.Sprintf("invalid match path:%#v", route))
-			}
-			// just support cluster action
-			if cluster := route.GetRoute().GetCluster(); cluster != "" {
-				r.Handler(cluster)
-			} else {
-				panic("invalid route action(just support cluster)")
-			}
+			r := newRoute(vh.routes, route.GetMatch())
+			r.Handler(newRouteEntry(route, vh))
 		}
 
 		for _, host := range vhConfig.Domains {
@@ -92,6 +77,61 @@ func (rc *routeConfigMatcher) build() {
 	}
 }
 
+func mustHeaderMutation(c headerMutationConfig) *headerMutation {
+	hm, err := newHeaderMutation(c)
+	if err != nil {
+		panic(fmt.Sprintf("invalid header mutation: %s", err))
+	}
+	return hm
+}
+
+// RequestMatcher matches request by envoy.config.route.v3.RouteMatch
+type RequestMatcher interface {
+	Match(api.RequestHeader) bool
+}
+
+// NewRequestMatcher panics if the match is invalid, same as route config
+func NewRequestMatcher(match *envoy_config_route_v3.RouteMatch) RequestMatcher {
+	return requestMatcher{newRoute(NewRouter(), match)}
+}
+
+type requestMatcher struct {
+	route Route
+}
+
+func (m requestMatcher) Match(header api.RequestHeader) bool {
+	_, ok := m.route.Match(header)
+	return ok
+}
+
+func newRoute(routes Router, match *envoy_config_route_v3.RouteMatch) Route {
+	r := routes.NewRoute()
+	if cs := match.GetCaseSensitive(); cs != nil && !cs.GetValue() {
+		r.CaseInsensitive()
+	}
+
+	switch path := match.GetPathSpecifier().(type) {
+	case *envoy_config_route_v3.RouteMatch_Path:
+		r.Path(path.Path)
+	case *envoy_config_route_v3.RouteMatch_Prefix:
+		r.PathPrefix(path.Prefix)
+	case *envoy_config_route_v3.RouteMatch_PathSeparatedPrefix:
+		r.PathSeparatedPrefix(path.PathSeparatedPrefix)
+	case *envoy_config_route_v3.RouteMatch_SafeRegex:
+		r.PathRegex(path.SafeRegex.GetRegex())
+	default:
+		panic(fmt.Sprintf("invalid match path:%#v", match))
+	}
+
+	for _, h := range match.GetHeaders() {
+		r.Header(h)
+	}
+	for _, q := range match.GetQueryParameters() {
+		r.QueryParameter(q)
+	}
+	return r
+}
+
 func (rc *routeConfigMatcher) Config() *envoy_config_route_v3.RouteConfiguration {
 	return rc.config
 }
@@ -110,7 +150,13 @@ func (rc *routeConfigMatcher) Match(header api.RequestHeader) api.RouteEntry {
 type virtualHost struct {
 	name string
 	// domains Router
-	routes Router
+	routes  Router
+	headers *headerMutation
+	cors    *corsPolicy
+	filters perFilterConfigs
+	limits  rateLimitPolicy
+	mirrors []api.MirrorPolicy
+	global  *routeConfigMatcher
 }
 
 func (vh *virtualHost) Match(header api.RequestHeader) api.RouteEntry {
@@ -121,6 +167,6 @@ func (vh *virtualHost) Match(header api.RequestHeader) api.RouteEntry {
 	if h, b := vh.routes.Match(header); !b {
 		return nil
 	} else {
-		return NewRouteEntry(h.(string))
+		return h.(*routeEntry).pickEntry()
 	}
 }
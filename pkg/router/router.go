@@ -25,9 +25,13 @@
 package router
 
 import (
+	"bytes"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	"github.com/wereliang/govoy/pkg/api"
 )
 
@@ -36,6 +40,8 @@ type routerType int8
 const (
 	TypeHost routerType = iota
 	TypePath
+	TypeHeader
+	TypeQuery
 )
 
 const (
@@ -54,6 +60,12 @@ type Route interface {
 	Host(tpl string) Route
 	Path(tpl string) Route
 	PathPrefix(tpl string) Route
+	PathSeparatedPrefix(tpl string) Route
+	PathRegex(regex string) Route
+	// CaseInsensitive make the path matchers added after it ignore case
+	CaseInsensitive() Route
+	Header(*envoy_config_route_v3.HeaderMatcher) Route
+	QueryParameter(*envoy_config_route_v3.QueryParameterMatcher) Route
 	Handler(handler interface{}) Route
 	Match(api.RequestHeader) (interface{}, bool)
 }
@@ -96,11 +108,20 @@ func (r *router) Match(headers api.RequestHeader) (interface{}, bool) {
 type matcherWrap struct {
 	matcher matcher
 	rtype   routerType
+	key     string // header or query parameter name
+	invert  bool
+}
+
+// match the value of header or query parameter, nil value means not present
+func (m *matcherWrap) matchValue(value []byte, present bool) bool {
+	matched := present && m.matcher.MatchRoute(value)
+	return matched != m.invert
 }
 
 type route struct {
-	handler interface{}
-	matcher []matcherWrap // 全部满足
+	handler    interface{}
+	matcher    []matcherWrap // 全部满足
+	ignoreCase bool
 }
 
 func (r *route) Host(tpl string) Route {
@@ -124,12 +145,62 @@ func (r *route) Host(tpl string) Route {
 }
 
 func (r *route) Path(tpl string) Route {
-	r.matcher = append(r.matcher, matcherWrap{&exactMatcher{tpl}, TypePath})
-	return r
+	return r.addPath(&exactMatcher{r.pattern(tpl)})
 }
 
 func (r *route) PathPrefix(tpl string) Route {
-	r.matcher = append(r.matcher, matcherWrap{&prefixMatcher{tpl}, TypePath})
+	return r.addPath(&prefixMatcher{r.pattern(tpl)})
+}
+
+func (r *route) PathSeparatedPrefix(tpl string) Route {
+	return r.addPath(&separatedPrefixMatcher{r.pattern(tpl)})
+}
+
+// PathRegex is always case sensitive as envoy
+func (r *route) PathRegex(regex string) Route {
+	m, err := newRegexMatcher(regex)
+	if err != nil {
+		panic(fmt.Sprintf("invalid path regex %s: %s", regex, err))
+	}
+	r.matcher = append(r.matcher, matcherWrap{matcher: m, rtype: TypePath})
+	return r
+}
+
+func (r *route) CaseInsensitive() Route {
+	r.ignoreCase = true
+	return r
+}
+
+func (r *route) Header(hm *envoy_config_route_v3.HeaderMatcher) Route {
+	m, err := newHeaderMatcherWrap(hm)
+	if err != nil {
+		panic(fmt.Sprintf("invalid header matcher %s: %s", hm.GetName(), err))
+	}
+	r.matcher = append(r.matcher, *m)
+	return r
+}
+
+func (r *route) QueryParameter(qm *envoy_config_route_v3.QueryParameterMatcher) Route {
+	m, err := newQueryParameterMatcher(qm)
+	if err != nil {
+		panic(fmt.Sprintf("invalid query parameter matcher %s: %s", qm.GetName(), err))
+	}
+	r.matcher = append(r.matcher, matcherWrap{matcher: m, rtype: TypeQuery, key: qm.GetName()})
+	return r
+}
+
+func (r *route) pattern(tpl string) string {
+	if r.ignoreCase {
+		return strings.ToLower(tpl)
+	}
+	return tpl
+}
+
+func (r *route) addPath(m matcher) Route {
+	if r.ignoreCase {
+		m = &ignoreCaseMatcher{m}
+	}
+	r.matcher = append(r.matcher, matcherWrap{matcher: m, rtype: TypePath})
 	return r
 }
 
@@ -144,6 +215,14 @@ func (r *route) Match(headers api.RequestHeader) (interface{}, bool) {
 			if !m.matcher.MatchRoute(headers.Path()) {
 				return nil, false
 			}
+		case TypeHeader:
+			if !m.Match(headers) {
+				return nil, false
+			}
+		case TypeQuery:
+			if !m.matchValue(headers.QueryArg(m.key)) {
+				return nil, false
+			}
 		}
 	}
 	return r.handler, true
@@ -189,6 +268,64 @@ func (m *wildcardMatcher) MatchRoute(obj []byte) bool {
 	return true
 }
 
-// TODO
+// separatedPrefixMatcher match path equal to prefix or followed by '/'
+type separatedPrefixMatcher struct {
+	prefix string
+}
+
+func (m *separatedPrefixMatcher) MatchRoute(obj []byte) bool {
+	path := string(obj)
+	if !strings.HasPrefix(path, m.prefix) {
+		return false
+	}
+	return len(path) == len(m.prefix) || path[len(m.prefix)] == '/'
+}
+
+type containsMatcher struct {
+	substr string
+}
+
+func (m *containsMatcher) MatchRoute(obj []byte) bool {
+	return bytes.Contains(obj, []byte(m.substr))
+}
+
+// regexMatcher must match the whole string like RE2::FullMatch
 type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func newRegexMatcher(regex string) (*regexMatcher, error) {
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &regexMatcher{re}, nil
+}
+
+func (m *regexMatcher) MatchRoute(obj []byte) bool {
+	return m.re.Match(obj)
+}
+
+// rangeMatcher match integer in [start, end)
+type rangeMatcher struct {
+	start int64
+	end   int64
+}
+
+func (m *rangeMatcher) MatchRoute(obj []byte) bool {
+	v, err := strconv.ParseInt(string(obj), 10, 64)
+	if err != nil {
+		return false
+	}
+	return v >= m.start && v < m.end
+}
+
+// ignoreCaseMatcher lower the object before match, so the inner matcher
+// must be created by lower case pattern
+type ignoreCaseMatcher struct {
+	matcher matcher
+}
+
+func (m *ignoreCaseMatcher) MatchRoute(obj []byte) bool {
+	return m.matcher.MatchRoute(bytes.ToLower(obj))
 }
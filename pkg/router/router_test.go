@@ -1,11 +1,20 @@
 package router
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fasthttp"
 	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/http"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type requestHeader struct {
@@ -25,6 +34,11 @@ func (h *requestHeader) SetPath(path string) {
 	h.uri.SetPath(path)
 }
 
+func (h *requestHeader) QueryArg(key string) ([]byte, bool) {
+	args := h.uri.QueryArgs()
+	return args.Peek(key), args.Has(key)
+}
+
 func newRequestHeader(request *fasthttp.Request) api.RequestHeader {
 	return &requestHeader{RequestHeader: &request.Header, uri: request.URI()}
 }
@@ -63,20 +77,20 @@ func TestPath(t *testing.T) {
 
 	r := router.Path("/foo").Handler("foo")
 	request := &fasthttp.Request{}
-	header := &request.Header
-	header.SetRequestURI("/foo")
+	request.SetRequestURI("/foo")
 	h, b := r.Match(newRequestHeader(request))
 	assert.True(t, b)
 	assert.EqualValues(t, h, "foo")
 
 	r = router.PathPrefix("/").Handler("root")
-	header.SetRequestURI("/foo")
+	request.SetRequestURI("/foo")
 	h, b = r.Match(newRequestHeader(request))
 	assert.True(t, b)
 	assert.EqualValues(t, h, "root")
 
-	header.SetRequestURI("hello")
-	h, b = r.Match(newRequestHeader(request))
+	r = router.PathPrefix("/bar").Handler("bar")
+	request.SetRequestURI("/foo")
+	_, b = r.Match(newRequestHeader(request))
 	assert.False(t, b)
 }
 
@@ -100,8 +114,317 @@ func TestRouter(t *testing.T) {
 	router.Path("/foo").Handler("foo")
 	router.Path("/bar").Handler("bar")
 
-	header.SetRequestURI("/bar")
+	request.SetRequestURI("/bar")
 	h, b := router.Match(newRequestHeader(request))
 	assert.True(t, b)
 	assert.EqualValues(t, h, "bar")
 }
+
+func TestPathMatcher(t *testing.T) {
+	router := NewRouter()
+	request := &fasthttp.Request{}
+
+	r := router.NewRoute().PathSeparatedPrefix("/api")
+	request.SetRequestURI("/api")
+	_, b := r.Match(newRequestHeader(request))
+	assert.True(t, b)
+	request.SetRequestURI("/api/v1?x=1")
+	_, b = r.Match(newRequestHeader(request))
+	assert.True(t, b)
+	request.SetRequestURI("/apiv1")
+	_, b = r.Match(newRequestHeader(request))
+	assert.False(t, b)
+
+	r = router.NewRoute().PathRegex("/user/[0-9]+")
+	request.SetRequestURI("/user/123")
+	_, b = r.Match(newRequestHeader(request))
+	assert.True(t, b)
+	request.SetRequestURI("/user/123/profile")
+	_, b = r.Match(newRequestHeader(request))
+	assert.False(t, b)
+
+	r = router.NewRoute().CaseInsensitive().PathPrefix("/Foo")
+	request.SetRequestURI("/FOO/bar")
+	_, b = r.Match(newRequestHeader(request))
+	assert.True(t, b)
+
+	assert.Panics(t, func() { router.NewRoute().PathRegex("(") })
+}
+
+func TestHeaderMatcher(t *testing.T) {
+	request := &fasthttp.Request{}
+	request.SetRequestURI("/productpage")
+	request.Header.Set("end-user", "jason")
+	request.Header.Set("x-version", "15")
+
+	tests := []struct {
+		matcher *envoy_config_route_v3.HeaderMatcher
+		expect  bool
+	}{
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_StringMatch{
+				StringMatch: &envoy_type_matcher_v3.StringMatcher{
+					MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: "jason"}}}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_StringMatch{
+				StringMatch: &envoy_type_matcher_v3.StringMatcher{
+					MatchPattern: &envoy_type_matcher_v3.StringMatcher_Exact{Exact: "JASON"},
+					IgnoreCase:   true}}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_PrefixMatch{PrefixMatch: "ja"}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_SuffixMatch{SuffixMatch: "son"}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_ContainsMatch{ContainsMatch: "aso"}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_SafeRegexMatch{
+				SafeRegexMatch: &envoy_type_matcher_v3.RegexMatcher{Regex: "j.*n"}}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_SafeRegexMatch{
+				SafeRegexMatch: &envoy_type_matcher_v3.RegexMatcher{Regex: "j"}}}, false},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_PrefixMatch{PrefixMatch: "ja"},
+			InvertMatch:          true}, false},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "x-version",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_RangeMatch{
+				RangeMatch: &envoy_type_v3.Int64Range{Start: 10, End: 20}}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "x-version",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_RangeMatch{
+				RangeMatch: &envoy_type_v3.Int64Range{Start: 0, End: 15}}}, false},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "end-user",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_PresentMatch{PresentMatch: true}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "x-missing",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_PresentMatch{PresentMatch: true}}, false},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "x-missing",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_PresentMatch{PresentMatch: false}}, true},
+		{&envoy_config_route_v3.HeaderMatcher{Name: "x-missing",
+			HeaderMatchSpecifier: &envoy_config_route_v3.HeaderMatcher_ExactMatch{ExactMatch: "x"},
+			InvertMatch:          true}, true},
+	}
+
+	for i, tt := range tests {
+		r := NewRouter().NewRoute().PathPrefix("/").Header(tt.matcher)
+		_, b := r.Match(newRequestHeader(request))
+		assert.Equal(t, tt.expect, b, "case %d", i)
+	}
+}
+
+func TestQueryParameterMatcher(t *testing.T) {
+	request := &fasthttp.Request{}
+	request.SetRequestURI("/search?q=govoy&debug")
+
+	r := NewRouter().NewRoute().PathPrefix("/").QueryParameter(
+		&envoy_config_route_v3.QueryParameterMatcher{Name: "q",
+			QueryParameterMatchSpecifier: &envoy_config_route_v3.QueryParameterMatcher_StringMatch{
+				StringMatch: &envoy_type_matcher_v3.StringMatcher{
+					MatchPattern: &envoy_type_matcher_v3.StringMatcher_Prefix{Prefix: "go"}}}})
+	_, b := r.Match(newRequestHeader(request))
+	assert.True(t, b)
+
+	r = NewRouter().NewRoute().PathPrefix("/").QueryParameter(
+		&envoy_config_route_v3.QueryParameterMatcher{Name: "debug",
+			QueryParameterMatchSpecifier: &envoy_config_route_v3.QueryParameterMatcher_PresentMatch{PresentMatch: true}})
+	_, b = r.Match(newRequestHeader(request))
+	assert.True(t, b)
+
+	r = NewRouter().NewRoute().PathPrefix("/").QueryParameter(
+		&envoy_config_route_v3.QueryParameterMatcher{Name: "page",
+			QueryParameterMatchSpecifier: &envoy_config_route_v3.QueryParameterMatcher_PresentMatch{PresentMatch: true}})
+	_, b = r.Match(newRequestHeader(request))
+	assert.False(t, b)
+}
+
+func headerOption(key, value string, append bool) *envoy_config_core_v3.HeaderValueOption {
+	return &envoy_config_core_v3.HeaderValueOption{
+		Header: &envoy_config_core_v3.HeaderValue{Key: key, Value: value},
+		Append: wrapperspb.Bool(append),
+	}
+}
+
+func TestRouteHeaderMutation(t *testing.T) {
+	config := &envoy_config_route_v3.RouteConfiguration{
+		Name:                    "test",
+		RequestHeadersToAdd:     []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "global", false)},
+		ResponseHeadersToRemove: []string{"x-internal"},
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:                "reviews",
+			Domains:             []string{"*"},
+			RequestHeadersToAdd: []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "vhost", false)},
+			Routes: []*envoy_config_route_v3.Route{{
+				Match: &envoy_config_route_v3.RouteMatch{
+					PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+					ClusterSpecifier: &envoy_config_route_v3.RouteAction_WeightedClusters{
+						WeightedClusters: &envoy_config_route_v3.WeightedCluster{
+							Clusters: []*envoy_config_route_v3.WeightedCluster_ClusterWeight{{
+								Name:                 "reviews-v1",
+								Weight:               wrapperspb.UInt32(100),
+								RequestHeadersToAdd:  []*envoy_config_core_v3.HeaderValueOption{headerOption("x-version", "v1", false)},
+								ResponseHeadersToAdd: []*envoy_config_core_v3.HeaderValueOption{headerOption("x-upstream", "%UPSTREAM_HOST%", false)},
+							}}}}}},
+				RequestHeadersToAdd:    []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "route", false)},
+				RequestHeadersToRemove: []string{"x-remove"},
+			}},
+		}},
+	}
+
+	req, rsp := &fasthttp.Request{}, &fasthttp.Response{}
+	req.SetRequestURI("/reviews/0")
+	req.Header.SetHost("reviews:9080")
+	req.Header.Set("x-remove", "1")
+	rsp.Header.Set("x-internal", "1")
+	ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+
+	entry := NewRouterMatcher(config).Match(ctx.Request().Header())
+	assert.NotNil(t, entry)
+	assert.Equal(t, "reviews-v1", entry.ClusterName())
+
+	entry.FinalizeRequestHeaders(ctx)
+	assert.Equal(t, "global", string(req.Header.Peek("x-level")))
+	assert.Equal(t, "v1", string(req.Header.Peek("x-version")))
+	assert.Nil(t, req.Header.Peek("x-remove"))
+
+	config.MostSpecificHeaderMutationsWins = true
+	entry.FinalizeRequestHeaders(ctx)
+	assert.Equal(t, "route", string(req.Header.Peek("x-level")))
+
+	entry.FinalizeResponseHeaders(ctx)
+	assert.Nil(t, rsp.Header.Peek("x-internal"))
+	// no upstream host, empty value is not added
+	assert.Nil(t, rsp.Header.Peek("x-upstream"))
+}
+
+func TestWeightedClusterHeaderMutation(t *testing.T) {
+	config := &envoy_config_route_v3.RouteConfiguration{
+		Name: "test",
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:                 "reviews",
+			Domains:              []string{"*"},
+			RequestHeadersToAdd:  []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "vhost", false)},
+			ResponseHeadersToAdd: []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "vhost", false)},
+			Routes: []*envoy_config_route_v3.Route{{
+				Match: &envoy_config_route_v3.RouteMatch{
+					PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: "/"}},
+				Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+					ClusterSpecifier: &envoy_config_route_v3.RouteAction_WeightedClusters{
+						WeightedClusters: &envoy_config_route_v3.WeightedCluster{
+							Clusters: []*envoy_config_route_v3.WeightedCluster_ClusterWeight{{
+								Name:                 "reviews-v1",
+								Weight:               wrapperspb.UInt32(100),
+								RequestHeadersToAdd:  []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "weighted", false)},
+								ResponseHeadersToAdd: []*envoy_config_core_v3.HeaderValueOption{headerOption("x-level", "weighted", false)},
+							}}}}}},
+			}},
+		}},
+	}
+
+	finalize := func() (string, string) {
+		req, rsp := &fasthttp.Request{}, &fasthttp.Response{}
+		req.SetRequestURI("/reviews/0")
+		req.Header.SetHost("reviews:9080")
+		ctx := http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"), req, rsp)
+		entry := NewRouterMatcher(config).Match(ctx.Request().Header())
+		assert.Equal(t, "reviews-v1", entry.ClusterName())
+		entry.FinalizeRequestHeaders(ctx)
+		entry.FinalizeResponseHeaders(ctx)
+		return string(req.Header.Peek("x-level")), string(rsp.Header.Peek("x-level"))
+	}
+
+	// the virtual host level overwrites by default
+	reqLevel, rspLevel := finalize()
+	assert.Equal(t, "vhost", reqLevel)
+	assert.Equal(t, "vhost", rspLevel)
+
+	config.MostSpecificHeaderMutationsWins = true
+	reqLevel, rspLevel = finalize()
+	assert.Equal(t, "weighted", reqLevel)
+	assert.Equal(t, "weighted", rspLevel)
+}
+
+func TestMirrorPolicy(t *testing.T) {
+	route := func(prefix string, mirrors ...*envoy_config_route_v3.RouteAction_RequestMirrorPolicy) *envoy_config_route_v3.Route {
+		return &envoy_config_route_v3.Route{
+			Match: &envoy_config_route_v3.RouteMatch{
+				PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: prefix}},
+			Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+				ClusterSpecifier:      &envoy_config_route_v3.RouteAction_Cluster{Cluster: "reviews-v1"},
+				RequestMirrorPolicies: mirrors,
+			}},
+		}
+	}
+	config := &envoy_config_route_v3.RouteConfiguration{
+		Name: "test",
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:    "reviews",
+			Domains: []string{"*"},
+			RequestMirrorPolicies: []*envoy_config_route_v3.RouteAction_RequestMirrorPolicy{{
+				Cluster: "reviews-vhost"}},
+			Routes: []*envoy_config_route_v3.Route{
+				route("/header", &envoy_config_route_v3.RouteAction_RequestMirrorPolicy{ClusterHeader: "x-mirror"}),
+				route("/disabled", &envoy_config_route_v3.RouteAction_RequestMirrorPolicy{
+					Cluster: "reviews-v2",
+					RuntimeFraction: &envoy_config_core_v3.RuntimeFractionalPercent{
+						DefaultValue: &envoy_type_v3.FractionalPercent{Numerator: 0}}}),
+				route("/"),
+			},
+		}},
+	}
+	matcher := NewRouterMatcher(config)
+	newHeader := func(path string) api.RequestHeader {
+		req := &fasthttp.Request{}
+		req.SetRequestURI(path)
+		req.Header.SetHost("reviews:9080")
+		req.Header.Set("x-mirror", "reviews-v3")
+		return http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"),
+			req, &fasthttp.Response{}).Request().Header()
+	}
+
+	header := newHeader("/header")
+	mirrors := matcher.Match(header).MirrorPolicies()
+	assert.Len(t, mirrors, 1)
+	assert.True(t, mirrors[0].Enabled())
+	assert.Equal(t, "reviews-v3", mirrors[0].ClusterName(header))
+
+	header = newHeader("/disabled")
+	mirrors = matcher.Match(header).MirrorPolicies()
+	assert.False(t, mirrors[0].Enabled())
+	assert.Equal(t, "reviews-v2", mirrors[0].ClusterName(header))
+
+	// route without mirror policies uses virtual host's
+	header = newHeader("/")
+	mirrors = matcher.Match(header).MirrorPolicies()
+	assert.Len(t, mirrors, 1)
+	assert.Equal(t, "reviews-vhost", mirrors[0].ClusterName(header))
+}
+
+func TestRouteTimeout(t *testing.T) {
+	route := func(prefix string, timeout *durationpb.Duration) *envoy_config_route_v3.Route {
+		return &envoy_config_route_v3.Route{
+			Match: &envoy_config_route_v3.RouteMatch{
+				PathSpecifier: &envoy_config_route_v3.RouteMatch_Prefix{Prefix: prefix}},
+			Action: &envoy_config_route_v3.Route_Route{Route: &envoy_config_route_v3.RouteAction{
+				ClusterSpecifier: &envoy_config_route_v3.RouteAction_Cluster{Cluster: "reviews"},
+				Timeout:          timeout,
+			}},
+		}
+	}
+	matcher := NewRouterMatcher(&envoy_config_route_v3.RouteConfiguration{
+		VirtualHosts: []*envoy_config_route_v3.VirtualHost{{
+			Name:    "reviews",
+			Domains: []string{"*"},
+			Routes: []*envoy_config_route_v3.Route{
+				route("/disabled", durationpb.New(0)),
+				route("/slow", durationpb.New(time.Minute)),
+				route("/", nil),
+			},
+		}},
+	})
+	timeout := func(path string) time.Duration {
+		req := &fasthttp.Request{}
+		req.SetRequestURI("http://reviews" + path)
+		return matcher.Match(newRequestHeader(req)).Timeout()
+	}
+	assert.Equal(t, time.Duration(0), timeout("/disabled"))
+	assert.Equal(t, time.Minute, timeout("/slow"))
+	assert.Equal(t, defaultRouteTimeout, timeout("/reviews"))
+}
@@ -45,11 +45,9 @@ type activeConnection struct {
 	wfs []api.WriteFilter
 }
 
+// AddReadFilter the filters before the terminal filter like http connection manager should not
+// consume the buffer, such as rbac
 func (ac *activeConnection) AddReadFilter(f api.ReadFilter) {
-	// TODO: 现阶段只支持一个network filter
-	if len(ac.rfs) > 0 {
-		panic("network filter just support only one")
-	}
 	ac.rfs = append(ac.rfs, f)
 }
 
@@ -107,9 +105,14 @@ func (ac *activeConnection) onNewConnection() bool {
 	return true
 }
 
+// onData returns false to close the connection, StopIteration waits for more data
 func (ac *activeConnection) onData(buf *bytes.Buffer) bool {
 	for _, f := range ac.rfs {
-		if f.OnData(buf) != api.Continue {
+		switch f.OnData(buf) {
+		case api.Continue:
+		case api.StopIteration:
+			return true
+		default:
 			return false
 		}
 	}
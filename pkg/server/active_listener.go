@@ -25,12 +25,17 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
 
 	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/accesslog"
 	"github.com/wereliang/govoy/pkg/api"
 	"github.com/wereliang/govoy/pkg/filter"
+	"github.com/wereliang/govoy/pkg/http"
 	"github.com/wereliang/govoy/pkg/log"
 	"github.com/wereliang/govoy/pkg/network"
 	"github.com/wereliang/govoy/pkg/utils"
@@ -47,8 +52,14 @@ func NewActiveListener(typ api.ListenerType, pb *envoy_config_listener_v3.Listen
 		l = network.NewListener(addr)
 	}
 
+	accessLogs, err := accesslog.NewAccessLogs(pb.GetAccessLog())
+	if err != nil {
+		return nil, err
+	}
+
 	fcm := newFilterChainManager(pb.GetFilterChains(), pb.GetDefaultFilterChain())
 	al := &activeListener{
+		accessLogs:         accessLogs,
 		pb:                 pb,
 		context:            context,
 		typ:                typ,
@@ -76,6 +87,9 @@ type activeListener struct {
 	filterChainManager api.FilterChainManager
 	useOriginalDst     bool
 	bindToPort         bool
+	accessLogs         []api.AccessLog
+	// network filter factories of filter chain, created at the first connection
+	filterFactories sync.Map
 }
 
 func (al *activeListener) Listener() api.Listener {
@@ -127,14 +141,33 @@ func (al *activeListener) OnAccept(conn api.Connection) {
 	}
 
 	ac := NewActiveConnection(conn)
-	filters := al.matchFilters(conn.Context())
-	if filters == nil {
+	filterChain := al.matchFilterChain(conn.Context())
+	if filterChain == nil {
 		log.Error("Match filter chain fail")
 		conn.Close()
 		return
 	}
 
-	for _, f := range filters {
+	info := http.NewStreamInfo(conn, "")
+	for _, creator := range al.networkFilterFactories(filterChain) {
+		if err := creator(ac, ac); err != nil {
+			log.Error("create network filter fail: %s", err)
+			conn.Close()
+			return
+		}
+	}
+
+	ac.OnLoop()
+	al.log(info)
+}
+
+func (al *activeListener) networkFilterFactories(fc *envoy_config_listener_v3.FilterChain) []api.NetworkFilterCreator {
+	if creators, ok := al.filterFactories.Load(fc); ok {
+		return creators.([]api.NetworkFilterCreator)
+	}
+
+	var creators []api.NetworkFilterCreator
+	for _, f := range fc.Filters {
 		factory, pb := filter.GetNetworkFactory(f.GetTypedConfig(), f.Name)
 		if factory == nil {
 			if filter.IsWellknowName(f.Name) {
@@ -144,14 +177,27 @@ func (al *activeListener) OnAccept(conn api.Connection) {
 				continue
 			}
 		}
-		if err := factory.CreateFilterFactory(pb, al.context)(ac, ac); err != nil {
-			log.Error("create network filter fail: %s", err)
-			conn.Close()
-			return
-		}
+		creators = append(creators, factory.CreateFilterFactory(pb, al.context))
+	}
+	actual, _ := al.filterFactories.LoadOrStore(fc, creators)
+	return actual.([]api.NetworkFilterCreator)
+}
+
+// log the connection by listener access logs, there is no request and response
+func (al *activeListener) log(info api.StreamInfo) {
+	if len(al.accessLogs) == 0 {
+		return
 	}
+	info.OnRequestComplete()
+	ctx := http.NewStreamContext(context.TODO(), info, &fasthttp.Request{}, nil)
+	for _, l := range al.accessLogs {
+		l.Log(ctx)
+	}
+}
 
-	ac.OnLoop()
+// close flushes the listener access logs, the network listener may be reused by the updated one
+func (al *activeListener) close() {
+	accesslog.Flush(al.accessLogs)
 }
 
 func (al *activeListener) onListenerFilter(cb api.ListenerFilterCallbacks) bool {
@@ -163,13 +209,12 @@ func (al *activeListener) onListenerFilter(cb api.ListenerFilterCallbacks) bool
 	return true
 }
 
-func (al *activeListener) matchFilters(cs api.ConnectionContext) []*envoy_config_listener_v3.Filter {
+func (al *activeListener) matchFilterChain(cs api.ConnectionContext) *envoy_config_listener_v3.FilterChain {
 	filterChain := al.filterChainManager.FindFilterChains(cs)
 	if filterChain != nil {
 		log.Debug("[FilterChain: %s]", filterChain.GetName())
-		return filterChain.Filters
 	}
-	return nil
+	return filterChain
 }
 
 func (al *activeListener) getRedirectListener(ip net.IP, port uint32) api.ActiveListener {
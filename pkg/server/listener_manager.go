@@ -48,13 +48,15 @@ type listenerManagerImpl struct {
 func (lm *listenerManagerImpl) AddOrUpdateListener(typ api.ListenerType, pb *envoy_config_listener_v3.Listener) error {
 	var (
 		actl   api.ActiveListener
+		old    api.ActiveListener
 		netl   api.Listener
 		err    error
 		update bool = false
 	)
 
 	if any, ok := lm.listenerMap.Load(pb.Name); ok {
-		netl = any.(api.ObjectConfig).Object().(api.ActiveListener).Listener()
+		old = any.(api.ObjectConfig).Object().(api.ActiveListener)
+		netl = old.Listener()
 		// addr must be same
 		if addr, err := utils.ToNetAddr(pb.GetAddress()); err != nil {
 			return err
@@ -76,6 +78,9 @@ func (lm *listenerManagerImpl) AddOrUpdateListener(typ api.ListenerType, pb *env
 
 	actl.Listener().SetCallback(actl.(api.ListenerCallback))
 	lm.listenerMap.Store(pb.Name, api.NewObjectConfig(actl, pb))
+	if al, ok := old.(*activeListener); ok {
+		al.close()
+	}
 
 	// TODO: stop and destory
 	if !update && actl.GetBindToPort() {
@@ -161,6 +166,10 @@ func (lm *listenerManagerImpl) Range(cb func(string, api.ObjectConfig) bool) {
 }
 
 func (lm *listenerManagerImpl) DeleteListener(name string) error {
-	lm.listenerMap.Delete(name)
+	if any, ok := lm.listenerMap.LoadAndDelete(name); ok {
+		if al, ok := any.(api.ObjectConfig).Object().(*activeListener); ok {
+			al.close()
+		}
+	}
 	return nil
 }
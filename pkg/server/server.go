@@ -30,6 +30,8 @@ import (
 	_ "net/http/pprof"
 
 	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/wereliang/govoy/pkg/accesslog"
 	"github.com/wereliang/govoy/pkg/admin"
 	"github.com/wereliang/govoy/pkg/api"
 	"github.com/wereliang/govoy/pkg/cluster"
@@ -158,6 +160,10 @@ func (s *Govoy) ListenerManager() api.ListenerManager {
 	return s.lm
 }
 
+func (s *Govoy) LocalNode() *envoy_config_core_v3.Node {
+	return s.bootrap().GetNode()
+}
+
 func (s *Govoy) Start() error {
 	// admin
 	if s.am != nil {
@@ -170,4 +176,5 @@ func (s *Govoy) Start() error {
 
 func (s *Govoy) Stop() {
 	// TODO
+	accesslog.Close()
 }
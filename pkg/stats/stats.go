@@ -0,0 +1,207 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets, same as envoy
+var DefaultBuckets = []float64{0.5, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000,
+	60000, 300000, 600000, 1800000, 3600000}
+
+// DefaultStore is the store exposed by admin
+var DefaultStore = NewStore()
+
+// Tag is the name and value of a metric label
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	tags  []Tag
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+func (c *Counter) Add(v uint64) {
+	atomic.AddUint64(&c.value, v)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge is a value which can go up and down
+type Gauge struct {
+	tags  []Tag
+	value int64
+}
+
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.value, v)
+}
+
+func (g *Gauge) Add(v int64) {
+	atomic.AddInt64(&g.value, v)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Histogram records the distribution of values in DefaultBuckets
+type Histogram struct {
+	tags    []Tag
+	mu      sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Record(v float64) {
+	i := sort.SearchFloat64s(DefaultBuckets, v)
+	h.mu.Lock()
+	if i < len(DefaultBuckets) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// snapshot returns the cumulative bucket counts, the count and the sum
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative := make([]uint64, len(h.buckets))
+	var total uint64
+	for i, n := range h.buckets {
+		total += n
+		cumulative[i] = total
+	}
+	return cumulative, h.count, h.sum
+}
+
+// Store holds the metrics keyed by name and tags
+type Store struct {
+	mu         sync.RWMutex
+	counters   map[string]map[string]*Counter
+	gauges     map[string]map[string]*Gauge
+	histograms map[string]map[string]*Histogram
+}
+
+func NewStore() *Store {
+	return &Store{
+		counters:   make(map[string]map[string]*Counter),
+		gauges:     make(map[string]map[string]*Gauge),
+		histograms: make(map[string]map[string]*Histogram),
+	}
+}
+
+func tagsKey(tags []Tag) string {
+	var b strings.Builder
+	for _, t := range tags {
+		b.WriteString(t.Name)
+		b.WriteByte('=')
+		b.WriteString(t.Value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// Counter returns the counter of name and tags, which is created if not exist
+func (s *Store) Counter(name string, tags []Tag) *Counter {
+	key := tagsKey(tags)
+	s.mu.RLock()
+	c, ok := s.counters[name][key]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok = s.counters[name]; !ok {
+		s.counters[name] = make(map[string]*Counter)
+	}
+	if c, ok = s.counters[name][key]; !ok {
+		c = &Counter{tags: tags}
+		s.counters[name][key] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge of name and tags, which is created if not exist
+func (s *Store) Gauge(name string, tags []Tag) *Gauge {
+	key := tagsKey(tags)
+	s.mu.RLock()
+	g, ok := s.gauges[name][key]
+	s.mu.RUnlock()
+	if ok {
+		return g
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok = s.gauges[name]; !ok {
+		s.gauges[name] = make(map[string]*Gauge)
+	}
+	if g, ok = s.gauges[name][key]; !ok {
+		g = &Gauge{tags: tags}
+		s.gauges[name][key] = g
+	}
+	return g
+}
+
+// Histogram returns the histogram of name and tags, which is created if not exist
+func (s *Store) Histogram(name string, tags []Tag) *Histogram {
+	key := tagsKey(tags)
+	s.mu.RLock()
+	h, ok := s.histograms[name][key]
+	s.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok = s.histograms[name]; !ok {
+		s.histograms[name] = make(map[string]*Histogram)
+	}
+	if h, ok = s.histograms[name][key]; !ok {
+		h = &Histogram{tags: tags, buckets: make([]uint64, len(DefaultBuckets))}
+		s.histograms[name][key] = h
+	}
+	return h
+}
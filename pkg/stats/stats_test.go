@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	tags := []Tag{{Name: "response_code", Value: "200"}, {Name: "path", Value: `/"a"`}}
+	s.Counter("requests_total", tags).Inc()
+	s.Counter("requests_total", []Tag{{Name: "response_code", Value: "200"}, {Name: "path", Value: `/"a"`}}).Add(2)
+	s.Counter("requests_total", []Tag{{Name: "response_code", Value: "503"}}).Inc()
+	assert.Equal(t, uint64(3), s.Counter("requests_total", tags).Value())
+
+	g := s.Gauge("remaining_rq", []Tag{{Name: "cluster", Value: "reviews"}})
+	g.Set(10)
+	g.Add(-3)
+	assert.Equal(t, int64(7), s.Gauge("remaining_rq", []Tag{{Name: "cluster", Value: "reviews"}}).Value())
+
+	h := s.Histogram("duration", nil)
+	h.Record(0.5)
+	h.Record(7)
+	h.Record(5000000)
+
+	var b bytes.Buffer
+	s.WritePrometheus(&b)
+	out := b.String()
+	assert.True(t, strings.HasPrefix(out, "# TYPE requests_total counter\n"))
+	assert.Contains(t, out, `requests_total{response_code="200",path="/\"a\""} 3`+"\n")
+	assert.Contains(t, out, `requests_total{response_code="503"} 1`+"\n")
+	assert.Contains(t, out, "# TYPE remaining_rq gauge\nremaining_rq{cluster=\"reviews\"} 7\n")
+	assert.Contains(t, out, "# TYPE duration histogram\n")
+	assert.Contains(t, out, `duration_bucket{le="0.5"} 1`+"\n")
+	assert.Contains(t, out, `duration_bucket{le="5"} 1`+"\n")
+	assert.Contains(t, out, `duration_bucket{le="10"} 2`+"\n")
+	assert.Contains(t, out, `duration_bucket{le="3600000"} 2`+"\n")
+	assert.Contains(t, out, `duration_bucket{le="+Inf"} 3`+"\n")
+	assert.Contains(t, out, "duration_sum 5000007.5\nduration_count 3\n")
+
+	b.Reset()
+	s.WriteText(&b)
+	assert.Contains(t, b.String(), `requests_total{response_code="503"}: 1`+"\n")
+	assert.Contains(t, b.String(), `remaining_rq{cluster="reviews"}: 7`+"\n")
+	assert.Contains(t, b.String(), "duration: count=3 sum=5000007.5\n")
+}
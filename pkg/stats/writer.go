@@ -0,0 +1,162 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(tags []Tag, extra ...Tag) string {
+	if len(tags)+len(extra) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(tags)+len(extra))
+	for _, t := range append(tags[:len(tags):len(tags)], extra...) {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, t.Name, labelValueEscaper.Replace(t.Value)))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// sortedKeys returns the keys of metrics in order
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// storeSnapshot holds the names of metrics in order, and the metrics of each name ordered by tags
+type storeSnapshot struct {
+	counterNames   []string
+	counters       map[string][]*Counter
+	gaugeNames     []string
+	gauges         map[string][]*Gauge
+	histogramNames []string
+	histograms     map[string][]*Histogram
+}
+
+func (s *Store) snapshot() *storeSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ss := &storeSnapshot{
+		counters:   make(map[string][]*Counter, len(s.counters)),
+		gauges:     make(map[string][]*Gauge, len(s.gauges)),
+		histograms: make(map[string][]*Histogram, len(s.histograms)),
+	}
+	for name, m := range s.counters {
+		ss.counterNames = append(ss.counterNames, name)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		for _, key := range sortedKeys(keys) {
+			ss.counters[name] = append(ss.counters[name], m[key])
+		}
+	}
+	for name, m := range s.gauges {
+		ss.gaugeNames = append(ss.gaugeNames, name)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		for _, key := range sortedKeys(keys) {
+			ss.gauges[name] = append(ss.gauges[name], m[key])
+		}
+	}
+	for name, m := range s.histograms {
+		ss.histogramNames = append(ss.histogramNames, name)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		for _, key := range sortedKeys(keys) {
+			ss.histograms[name] = append(ss.histograms[name], m[key])
+		}
+	}
+	sortedKeys(ss.counterNames)
+	sortedKeys(ss.gaugeNames)
+	sortedKeys(ss.histogramNames)
+	return ss
+}
+
+// WritePrometheus writes all metrics in prometheus text format
+func (s *Store) WritePrometheus(w io.Writer) {
+	ss := s.snapshot()
+	for _, name := range ss.counterNames {
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		for _, c := range ss.counters[name] {
+			fmt.Fprintf(w, "%s%s %d\n", name, formatLabels(c.tags), c.Value())
+		}
+	}
+	for _, name := range ss.gaugeNames {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		for _, g := range ss.gauges[name] {
+			fmt.Fprintf(w, "%s%s %d\n", name, formatLabels(g.tags), g.Value())
+		}
+	}
+	for _, name := range ss.histogramNames {
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		for _, h := range ss.histograms[name] {
+			buckets, count, sum := h.snapshot()
+			for i, n := range buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name,
+					formatLabels(h.tags, Tag{Name: "le", Value: formatFloat(DefaultBuckets[i])}), n)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(h.tags, Tag{Name: "le", Value: "+Inf"}), count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(h.tags), formatFloat(sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(h.tags), count)
+		}
+	}
+}
+
+// WriteText writes all metrics as "name{tags}: value", the value of histogram is count and sum
+func (s *Store) WriteText(w io.Writer) {
+	ss := s.snapshot()
+	for _, name := range ss.counterNames {
+		for _, c := range ss.counters[name] {
+			fmt.Fprintf(w, "%s%s: %d\n", name, formatLabels(c.tags), c.Value())
+		}
+	}
+	for _, name := range ss.gaugeNames {
+		for _, g := range ss.gauges[name] {
+			fmt.Fprintf(w, "%s%s: %d\n", name, formatLabels(g.tags), g.Value())
+		}
+	}
+	for _, name := range ss.histogramNames {
+		for _, h := range ss.histograms[name] {
+			_, count, sum := h.snapshot()
+			fmt.Fprintf(w, "%s%s: count=%d sum=%s\n", name, formatLabels(h.tags), count, formatFloat(sum))
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+// propagation headers of b3 and w3c trace context
+const (
+	headerB3TraceID      = "x-b3-traceid"
+	headerB3SpanID       = "x-b3-spanid"
+	headerB3ParentSpanID = "x-b3-parentspanid"
+	headerB3Sampled      = "x-b3-sampled"
+	headerB3Flags        = "x-b3-flags"
+	headerB3             = "b3"
+	headerTraceParent    = "traceparent"
+	headerTraceState     = "tracestate"
+)
+
+// TraceID is the 128 bits trace id, the high 64 bits are zero for 64 bits id
+type TraceID struct {
+	High uint64
+	Low  uint64
+}
+
+func (id TraceID) IsZero() bool {
+	return id.High == 0 && id.Low == 0
+}
+
+// String returns 16 hex chars for 64 bits id, otherwise 32 hex chars
+func (id TraceID) String() string {
+	if id.High == 0 {
+		return fmt.Sprintf("%016x", id.Low)
+	}
+	return id.Hex128()
+}
+
+// Hex128 returns 32 hex chars, used by w3c trace context and otlp
+func (id TraceID) Hex128() string {
+	return fmt.Sprintf("%016x%016x", id.High, id.Low)
+}
+
+func parseTraceID(s string) (TraceID, error) {
+	var id TraceID
+	var err error
+	switch len(s) {
+	case 16:
+		id.Low, err = parseSpanID(s)
+	case 32:
+		if id.High, err = parseSpanID(s[:16]); err == nil {
+			id.Low, err = parseSpanID(s[16:])
+		}
+	default:
+		err = fmt.Errorf("invalid trace id length: %d", len(s))
+	}
+	if err == nil && id.IsZero() {
+		err = fmt.Errorf("invalid zero trace id")
+	}
+	return id, err
+}
+
+func parseSpanID(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid span id length: %d", len(s))
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func formatSpanID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
+
+// randomID returns a non zero random id
+func randomID() uint64 {
+	var b [8]byte
+	for {
+		rand.Read(b[:])
+		if id := binary.BigEndian.Uint64(b[:]); id != 0 {
+			return id
+		}
+	}
+}
+
+// SpanContext is the propagated context of a span
+type SpanContext struct {
+	TraceID  TraceID
+	SpanID   uint64
+	ParentID uint64
+	// Sampled is nil if the sampling decision is not propagated
+	Sampled *bool
+	// TraceState is the vendor data of w3c trace context, which is propagated as is
+	TraceState string
+}
+
+func parseSampled(s string) *bool {
+	var sampled bool
+	switch strings.ToLower(s) {
+	case "1", "true", "d":
+		sampled = true
+	case "0", "false":
+	default:
+		return nil
+	}
+	return &sampled
+}
+
+// Extract returns the span context of request headers, b3 headers take precedence over traceparent,
+// nil if no valid context
+func Extract(header api.RequestHeader) *SpanContext {
+	if ctx := extractB3(header); ctx != nil {
+		return ctx
+	}
+	return extractTraceParent(header)
+}
+
+func extractB3(header api.RequestHeader) *SpanContext {
+	if single := string(header.Get(headerB3)); single != "" {
+		return parseB3Single(single)
+	}
+
+	var sampled *bool
+	if string(header.Get(headerB3Flags)) == "1" {
+		sampled = parseSampled("1")
+	} else {
+		sampled = parseSampled(string(header.Get(headerB3Sampled)))
+	}
+	traceID := string(header.Get(headerB3TraceID))
+	if traceID == "" {
+		if sampled != nil {
+			// only the sampling decision is propagated
+			return &SpanContext{Sampled: sampled}
+		}
+		return nil
+	}
+	ctx := &SpanContext{Sampled: sampled}
+	var err error
+	if ctx.TraceID, err = parseTraceID(traceID); err != nil {
+		return nil
+	}
+	if ctx.SpanID, err = parseSpanID(string(header.Get(headerB3SpanID))); err != nil {
+		return nil
+	}
+	if parent := string(header.Get(headerB3ParentSpanID)); parent != "" {
+		if ctx.ParentID, err = parseSpanID(parent); err != nil {
+			return nil
+		}
+	}
+	return ctx
+}
+
+// parseB3Single parses b3: {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}, or only {SamplingState}
+func parseB3Single(s string) *SpanContext {
+	parts := strings.Split(s, "-")
+	if len(parts) == 1 {
+		if sampled := parseSampled(parts[0]); sampled != nil {
+			return &SpanContext{Sampled: sampled}
+		}
+		return nil
+	}
+	if len(parts) > 4 {
+		return nil
+	}
+	ctx := &SpanContext{}
+	var err error
+	if ctx.TraceID, err = parseTraceID(parts[0]); err != nil {
+		return nil
+	}
+	if ctx.SpanID, err = parseSpanID(parts[1]); err != nil {
+		return nil
+	}
+	if len(parts) > 2 {
+		if ctx.Sampled = parseSampled(parts[2]); ctx.Sampled == nil {
+			return nil
+		}
+	}
+	if len(parts) > 3 {
+		if ctx.ParentID, err = parseSpanID(parts[3]); err != nil {
+			return nil
+		}
+	}
+	return ctx
+}
+
+// extractTraceParent parses traceparent: {version}-{trace-id}-{parent-id}-{trace-flags}
+func extractTraceParent(header api.RequestHeader) *SpanContext {
+	parts := strings.Split(string(header.Get(headerTraceParent)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[3]) != 2 {
+		return nil
+	}
+	// the future versions may have more fields
+	if parts[0] == "00" && len(parts) != 4 {
+		return nil
+	}
+	ctx := &SpanContext{TraceState: string(header.Get(headerTraceState))}
+	var err error
+	if ctx.TraceID, err = parseTraceID(parts[1]); err != nil {
+		return nil
+	}
+	if ctx.SpanID, err = parseSpanID(parts[2]); err != nil || ctx.SpanID == 0 {
+		return nil
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil
+	}
+	sampled := flags[0]&0x01 != 0
+	ctx.Sampled = &sampled
+	return ctx
+}
+
+// Inject set both b3 and traceparent headers of the span context, the single b3 header is removed
+// as it may be stale
+func Inject(header api.RequestHeader, ctx *SpanContext) {
+	sampled := ctx.Sampled != nil && *ctx.Sampled
+	header.Del(headerB3)
+	header.Set(headerB3TraceID, ctx.TraceID.String())
+	header.Set(headerB3SpanID, formatSpanID(ctx.SpanID))
+	if ctx.ParentID != 0 {
+		header.Set(headerB3ParentSpanID, formatSpanID(ctx.ParentID))
+	} else {
+		header.Del(headerB3ParentSpanID)
+	}
+	flags := "00"
+	if sampled {
+		header.Set(headerB3Sampled, "1")
+		flags = "01"
+	} else {
+		header.Set(headerB3Sampled, "0")
+	}
+	header.Set(headerTraceParent, "00-"+ctx.TraceID.Hex128()+"-"+formatSpanID(ctx.SpanID)+"-"+flags)
+	if ctx.TraceState != "" {
+		header.Set(headerTraceState, ctx.TraceState)
+	}
+}
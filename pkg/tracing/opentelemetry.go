@@ -0,0 +1,157 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	envoy_config_trace_v3 "github.com/envoyproxy/go-control-plane/envoy/config/trace/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+const (
+	otlpTracesEndpoint = "/v1/traces"
+	otlpScopeName      = "govoy"
+
+	// span kind and status code of otlp
+	otlpSpanKindServer  = 2
+	otlpSpanKindClient  = 3
+	otlpStatusCodeError = 2
+)
+
+func init() {
+	registDriver(&envoy_config_trace_v3.OpenTelemetryConfig{}, newOpenTelemetryTracer)
+}
+
+// the json encoding of otlp ExportTraceServiceRequest, the ids are hex strings and the
+// nanoseconds are strings as int64 in proto3 json
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(tags map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: tags[k]}})
+	}
+	return attrs
+}
+
+func encodeOTLP(service string, spans []*Span) ([]byte, error) {
+	scope := &otlpScopeSpans{}
+	scope.Scope.Name = otlpScopeName
+	for _, s := range spans {
+		name, tags := s.snapshot()
+		os := &otlpSpan{
+			TraceID:           s.context.TraceID.Hex128(),
+			SpanID:            formatSpanID(s.context.SpanID),
+			TraceState:        s.context.TraceState,
+			Name:              name,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.start.Add(s.duration).UnixNano(), 10),
+			Attributes:        otlpAttributes(tags),
+		}
+		if s.context.ParentID != 0 {
+			os.ParentSpanID = formatSpanID(s.context.ParentID)
+		}
+		if s.kind == spanKindClient {
+			os.Kind = otlpSpanKindClient
+		}
+		if tags["error"] == "true" {
+			os.Status.Code = otlpStatusCodeError
+		}
+		scope.Spans = append(scope.Spans, os)
+	}
+	resource := &otlpResourceSpans{ScopeSpans: []*otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: service}}}
+	return json.Marshal(&otlpTraces{ResourceSpans: []*otlpResourceSpans{resource}})
+}
+
+// newOpenTelemetryTracer create tracer by envoy.config.trace.v3.OpenTelemetryConfig, the spans are
+// exported by otlp/http json to the cluster of envoy_grpc
+func newOpenTelemetryTracer(pb proto.Message, context api.FactoryContext) (*Tracer, error) {
+	c := pb.(*envoy_config_trace_v3.OpenTelemetryConfig)
+	grpc := c.GetGrpcService().GetEnvoyGrpc()
+	if grpc == nil || grpc.GetClusterName() == "" {
+		return nil, fmt.Errorf("opentelemetry just support envoy_grpc cluster")
+	}
+	service := serviceName(context)
+	t := &Tracer{traceID128: true}
+	t.reporter = newBatchReporter(context.ClusterManager(), grpc.GetClusterName(), otlpTracesEndpoint,
+		grpc.GetAuthority(), "application/json", func(spans []*Span) ([]byte, error) {
+			return encodeOTLP(service, spans)
+		})
+	return t, nil
+}
@@ -0,0 +1,143 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/cluster"
+	"github.com/wereliang/govoy/pkg/log"
+)
+
+const (
+	// spans are flushed when the number of buffered spans reaches minFlushSpans, or every flushInterval
+	minFlushSpans = 5
+	flushInterval = 5 * time.Second
+	reportTimeout = 5 * time.Second
+)
+
+// batchReporter buffers the finished spans and posts them to the collector cluster
+type batchReporter struct {
+	cm          api.ClusterManager
+	cluster     string
+	endpoint    string
+	hostname    string
+	contentType string
+	encode      func([]*Span) ([]byte, error)
+	client      *fasthttp.Client
+
+	mu    sync.Mutex
+	spans []*Span
+	timer *time.Timer
+}
+
+func newBatchReporter(cm api.ClusterManager, cluster, endpoint, hostname, contentType string,
+	encode func([]*Span) ([]byte, error)) *batchReporter {
+	if hostname == "" {
+		hostname = cluster
+	}
+	return &batchReporter{
+		cm:          cm,
+		cluster:     cluster,
+		endpoint:    endpoint,
+		hostname:    hostname,
+		contentType: contentType,
+		encode:      encode,
+		client:      &fasthttp.Client{MaxIdleConnDuration: time.Minute},
+	}
+}
+
+func (r *batchReporter) report(span *Span) {
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	if len(r.spans) < minFlushSpans {
+		// the timer is started by the first buffered span, so no goroutine is left when idle
+		if r.timer == nil {
+			r.timer = time.AfterFunc(flushInterval, r.Flush)
+		}
+		r.mu.Unlock()
+		return
+	}
+	spans := r.take()
+	r.mu.Unlock()
+	go r.send(spans)
+}
+
+// take returns the buffered spans and stop the timer, must be called with lock
+func (r *batchReporter) take() []*Span {
+	spans := r.spans
+	r.spans = nil
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	return spans
+}
+
+// Flush sends the buffered spans synchronously
+func (r *batchReporter) Flush() {
+	r.mu.Lock()
+	spans := r.take()
+	r.mu.Unlock()
+	if len(spans) > 0 {
+		r.send(spans)
+	}
+}
+
+func (r *batchReporter) send(spans []*Span) {
+	body, err := r.encode(spans)
+	if err != nil {
+		log.Error("encode spans error: %s", err)
+		return
+	}
+	host, err := cluster.SelectHost(r.cm, r.cluster, nil)
+	if err != nil {
+		log.Warn("report spans error: %s", err)
+		return
+	}
+
+	request := fasthttp.AcquireRequest()
+	response := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(request)
+		fasthttp.ReleaseResponse(response)
+	}()
+	request.Header.SetMethod(fasthttp.MethodPost)
+	request.SetRequestURI("http://" + host.Address().String() + r.endpoint)
+	request.UseHostHeader = true
+	request.Header.SetHost(r.hostname)
+	request.Header.SetContentType(r.contentType)
+	request.SetBody(body)
+	if err = r.client.DoTimeout(request, response, reportTimeout); err != nil {
+		log.Warn("report spans to cluster %s error: %s", r.cluster, err)
+		return
+	}
+	if code := response.StatusCode(); code < 200 || code >= 300 {
+		log.Warn("report spans to cluster %s failed, status: %d", r.cluster, code)
+	}
+}
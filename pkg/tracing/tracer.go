@@ -0,0 +1,193 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	envoy_config_trace_v3 "github.com/envoyproxy/go-control-plane/envoy/config/trace/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/wereliang/govoy/pkg/api"
+)
+
+type spanKind int
+
+const (
+	spanKindServer spanKind = iota
+	spanKindClient
+)
+
+// Span implements api.Span, which is reported by the reporter of tracer when finished
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	kind    spanKind
+	// shared is true if the server span shares the span id with the client span of downstream
+	shared   bool
+	start    time.Time
+	mu       sync.Mutex
+	name     string
+	duration time.Duration
+	tags     map[string]string
+	finished bool
+}
+
+func (s *Span) SetOperation(name string) {
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+func (s *Span) SetTag(key, value string) {
+	s.mu.Lock()
+	s.tags[key] = value
+	s.mu.Unlock()
+}
+
+func (s *Span) InjectContext(header api.RequestHeader) {
+	Inject(header, &s.context)
+}
+
+func (s *Span) SpawnChild(operation string, start time.Time) api.Span {
+	sampled := s.Sampled()
+	return s.tracer.newSpan(SpanContext{
+		TraceID:    s.context.TraceID,
+		SpanID:     randomID(),
+		ParentID:   s.context.SpanID,
+		Sampled:    &sampled,
+		TraceState: s.context.TraceState,
+	}, spanKindClient, operation, start)
+}
+
+func (s *Span) Sampled() bool {
+	return *s.context.Sampled
+}
+
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	s.finished = true
+	s.duration = time.Since(s.start)
+	s.mu.Unlock()
+	if s.Sampled() {
+		s.tracer.reporter.report(s)
+	}
+}
+
+// snapshot returns the name and a copy of tags
+func (s *Span) snapshot() (string, map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return s.name, tags
+}
+
+type reporter interface {
+	report(*Span)
+}
+
+// Tracer implements api.Tracer with the reporter of zipkin or opentelemetry
+type Tracer struct {
+	// traceID128 generates 128 bits trace id for new trace
+	traceID128 bool
+	// sharedSpanContext let the server span use the span id of downstream, like zipkin
+	sharedSpanContext bool
+	reporter          reporter
+}
+
+func (t *Tracer) newSpan(ctx SpanContext, kind spanKind, operation string, start time.Time) *Span {
+	return &Span{
+		tracer:  t,
+		context: ctx,
+		kind:    kind,
+		name:    operation,
+		start:   start,
+		tags:    make(map[string]string),
+	}
+}
+
+func (t *Tracer) StartSpan(header api.RequestHeader, operation string, start time.Time, sampled bool) api.Span {
+	parent := Extract(header)
+	if parent != nil && parent.Sampled != nil {
+		sampled = *parent.Sampled
+	}
+	ctx := SpanContext{Sampled: &sampled}
+	if parent == nil || parent.TraceID.IsZero() {
+		ctx.TraceID.Low = randomID()
+		if t.traceID128 {
+			ctx.TraceID.High = randomID()
+		}
+		ctx.SpanID = ctx.TraceID.Low
+		return t.newSpan(ctx, spanKindServer, operation, start)
+	}
+
+	ctx.TraceID = parent.TraceID
+	ctx.TraceState = parent.TraceState
+	if t.sharedSpanContext {
+		ctx.SpanID, ctx.ParentID = parent.SpanID, parent.ParentID
+		span := t.newSpan(ctx, spanKindServer, operation, start)
+		span.shared = true
+		return span
+	}
+	ctx.SpanID, ctx.ParentID = randomID(), parent.SpanID
+	return t.newSpan(ctx, spanKindServer, operation, start)
+}
+
+type driverFactory struct {
+	config proto.Message
+	create func(proto.Message, api.FactoryContext) (*Tracer, error)
+}
+
+var driverFactories = make(map[string]*driverFactory)
+
+func registDriver(config proto.Message, create func(proto.Message, api.FactoryContext) (*Tracer, error)) {
+	driverFactories[proto.MessageName(config)] = &driverFactory{config: config, create: create}
+}
+
+// NewTracer create tracer by the provider of http connection manager, like envoy.tracers.zipkin
+func NewTracer(c *envoy_config_trace_v3.Tracing_Http, context api.FactoryContext) (api.Tracer, error) {
+	name, err := ptypes.AnyMessageName(c.GetTypedConfig())
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := driverFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("not support tracing provider: %s", name)
+	}
+	pb := proto.Clone(factory.config)
+	if err = ptypes.UnmarshalAny(c.GetTypedConfig(), pb); err != nil {
+		return nil, err
+	}
+	return factory.create(pb, context)
+}
@@ -0,0 +1,240 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	envoy_config_cluster_v3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_endpoint_v3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	envoy_config_trace_v3 "github.com/envoyproxy/go-control-plane/envoy/config/trace/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/cluster"
+	"github.com/wereliang/govoy/pkg/http"
+)
+
+type factoryContext struct {
+	api.FactoryContext
+	cm api.ClusterManager
+}
+
+func (c *factoryContext) ClusterManager() api.ClusterManager {
+	return c.cm
+}
+
+func (c *factoryContext) LocalNode() *envoy_config_core_v3.Node {
+	return &envoy_config_core_v3.Node{Id: "sidecar~10.0.0.1~productpage-v1.default", Cluster: "productpage.default"}
+}
+
+func newFactoryContext(t *testing.T, name string, addr string) api.FactoryContext {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	cm, err := cluster.NewClusterManager([]*envoy_config_cluster_v3.Cluster{{
+		Name:                 name,
+		ClusterDiscoveryType: &envoy_config_cluster_v3.Cluster_Type{Type: envoy_config_cluster_v3.Cluster_STATIC},
+		LoadAssignment: &envoy_config_endpoint_v3.ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints: []*envoy_config_endpoint_v3.LocalityLbEndpoints{{
+				LbEndpoints: []*envoy_config_endpoint_v3.LbEndpoint{{
+					HostIdentifier: &envoy_config_endpoint_v3.LbEndpoint_Endpoint{
+						Endpoint: &envoy_config_endpoint_v3.Endpoint{
+							Address: &envoy_config_core_v3.Address{
+								Address: &envoy_config_core_v3.Address_SocketAddress{
+									SocketAddress: &envoy_config_core_v3.SocketAddress{
+										Address:       host,
+										PortSpecifier: &envoy_config_core_v3.SocketAddress_PortValue{PortValue: uint32(p)},
+									}}}}}}},
+			}},
+		},
+	}})
+	assert.NoError(t, err)
+	return &factoryContext{cm: cm}
+}
+
+func newHeader() api.RequestHeader {
+	return http.NewStreamContext(context.TODO(), http.NewStreamInfo(nil, "HTTP/1.1"),
+		&fasthttp.Request{}, &fasthttp.Response{}).Request().Header()
+}
+
+func TestPropagation(t *testing.T) {
+	header := newHeader()
+	assert.Nil(t, Extract(header))
+
+	// b3 multi headers
+	header.Set(headerB3TraceID, "463ac35c9f6413ad48485a3953bb6124")
+	header.Set(headerB3SpanID, "a2fb4a1d1a96d312")
+	header.Set(headerB3ParentSpanID, "0020000000000001")
+	header.Set(headerB3Sampled, "1")
+	ctx := Extract(header)
+	assert.Equal(t, TraceID{High: 0x463ac35c9f6413ad, Low: 0x48485a3953bb6124}, ctx.TraceID)
+	assert.Equal(t, uint64(0xa2fb4a1d1a96d312), ctx.SpanID)
+	assert.Equal(t, uint64(0x0020000000000001), ctx.ParentID)
+	assert.True(t, *ctx.Sampled)
+	header.Set(headerB3SpanID, "invalid")
+	assert.Nil(t, Extract(header))
+
+	// b3 single header takes precedence
+	header.Set(headerB3, "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0-05e3ac9a4f6e3b90")
+	ctx = Extract(header)
+	assert.Equal(t, "80f198ee56343ba864fe8b2a57d3eff7", ctx.TraceID.String())
+	assert.Equal(t, uint64(0x05e3ac9a4f6e3b90), ctx.ParentID)
+	assert.False(t, *ctx.Sampled)
+	header.Set(headerB3, "d")
+	ctx = Extract(header)
+	assert.True(t, ctx.TraceID.IsZero())
+	assert.True(t, *ctx.Sampled)
+
+	// w3c trace context
+	header = newHeader()
+	header.Set(headerTraceParent, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	header.Set(headerTraceState, "congo=t61rcWkgMzE")
+	ctx = Extract(header)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", ctx.TraceID.String())
+	assert.Equal(t, uint64(0xb7ad6b7169203331), ctx.SpanID)
+	assert.True(t, *ctx.Sampled)
+	assert.Equal(t, "congo=t61rcWkgMzE", ctx.TraceState)
+	header.Set(headerTraceParent, "00-00000000000000000000000000000000-b7ad6b7169203331-01")
+	assert.Nil(t, Extract(header))
+
+	// both b3 and traceparent are injected
+	sampled := false
+	header = newHeader()
+	header.Set(headerB3, "stale")
+	Inject(header, &SpanContext{TraceID: TraceID{Low: 0x1234}, SpanID: 0x5678, Sampled: &sampled})
+	assert.Nil(t, header.Get(headerB3))
+	assert.Equal(t, "0000000000001234", string(header.Get(headerB3TraceID)))
+	assert.Equal(t, "0000000000005678", string(header.Get(headerB3SpanID)))
+	assert.Nil(t, header.Get(headerB3ParentSpanID))
+	assert.Equal(t, "0", string(header.Get(headerB3Sampled)))
+	assert.Equal(t, "00-00000000000000000000000000001234-0000000000005678-00", string(header.Get(headerTraceParent)))
+}
+
+func newTracer(t *testing.T, config proto.Message, addr string) *Tracer {
+	a, err := ptypes.MarshalAny(config)
+	assert.NoError(t, err)
+	tracer, err := NewTracer(&envoy_config_trace_v3.Tracing_Http{
+		Name:       "tracer",
+		ConfigType: &envoy_config_trace_v3.Tracing_Http_TypedConfig{TypedConfig: a},
+	}, newFactoryContext(t, "collector", addr))
+	assert.NoError(t, err)
+	return tracer.(*Tracer)
+}
+
+func TestZipkin(t *testing.T) {
+	received := make(chan []*zipkinSpan, 1)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		assert.Equal(t, "/api/v2/spans", r.URL.Path)
+		assert.Equal(t, "zipkin.istio-system", r.Host)
+		body, _ := io.ReadAll(r.Body)
+		var spans []*zipkinSpan
+		assert.NoError(t, json.Unmarshal(body, &spans))
+		received <- spans
+		w.WriteHeader(nethttp.StatusAccepted)
+	}))
+	defer server.Close()
+
+	tracer := newTracer(t, &envoy_config_trace_v3.ZipkinConfig{
+		CollectorCluster:         "collector",
+		CollectorEndpointVersion: envoy_config_trace_v3.ZipkinConfig_HTTP_JSON,
+		CollectorHostname:        "zipkin.istio-system",
+	}, server.Listener.Addr().String())
+
+	// the server span shares the span id of downstream
+	header := newHeader()
+	header.Set(headerB3TraceID, "463ac35c9f6413ad")
+	header.Set(headerB3SpanID, "a2fb4a1d1a96d312")
+	span := tracer.StartSpan(header, "productpage:9080/*", time.Now(), false).(*Span)
+	assert.False(t, span.Sampled())
+	assert.Equal(t, uint64(0xa2fb4a1d1a96d312), span.context.SpanID)
+	assert.True(t, span.shared)
+	span.Finish()
+
+	header = newHeader()
+	span = tracer.StartSpan(header, "productpage:9080/*", time.Now(), true).(*Span)
+	assert.True(t, span.Sampled())
+	assert.Zero(t, span.context.TraceID.High)
+	span.SetTag("http.status_code", "200")
+	child := span.SpawnChild("router reviews egress", time.Now())
+	child.InjectContext(header)
+	assert.Equal(t, span.context.TraceID.String(), string(header.Get(headerB3TraceID)))
+	assert.Equal(t, formatSpanID(span.context.SpanID), string(header.Get(headerB3ParentSpanID)))
+	assert.Equal(t, "1", string(header.Get(headerB3Sampled)))
+	child.Finish()
+	span.Finish()
+	span.Finish()
+
+	// flushed by timer or manually
+	tracer.reporter.(*batchReporter).Flush()
+	spans := <-received
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "router reviews egress", spans[0].Name)
+	assert.Equal(t, "CLIENT", spans[0].Kind)
+	assert.Equal(t, spans[1].ID, spans[0].ParentID)
+	assert.Equal(t, "productpage:9080/*", spans[1].Name)
+	assert.Equal(t, "SERVER", spans[1].Kind)
+	assert.Equal(t, "productpage.default", spans[1].LocalEndpoint.ServiceName)
+	assert.Equal(t, map[string]string{"http.status_code": "200"}, spans[1].Tags)
+
+	// flushed when the batch is full
+	for i := 0; i < minFlushSpans; i++ {
+		tracer.StartSpan(newHeader(), "productpage:9080/*", time.Now(), true).Finish()
+	}
+	select {
+	case spans = <-received:
+		assert.Len(t, spans, minFlushSpans)
+	case <-time.After(time.Second):
+		t.Fatal("spans are not reported")
+	}
+}
+
+func TestOpenTelemetry(t *testing.T) {
+	received := make(chan *otlpTraces, 1)
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("content-type"))
+		body, _ := io.ReadAll(r.Body)
+		traces := &otlpTraces{}
+		assert.NoError(t, json.Unmarshal(body, traces))
+		received <- traces
+	}))
+	defer server.Close()
+
+	tracer := newTracer(t, &envoy_config_trace_v3.OpenTelemetryConfig{
+		GrpcService: &envoy_config_core_v3.GrpcService{
+			TargetSpecifier: &envoy_config_core_v3.GrpcService_EnvoyGrpc_{
+				EnvoyGrpc: &envoy_config_core_v3.GrpcService_EnvoyGrpc{ClusterName: "collector"}}},
+	}, server.Listener.Addr().String())
+
+	// a new span is created as child of traceparent
+	header := newHeader()
+	header.Set(headerTraceParent, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	span := tracer.StartSpan(header, "productpage:9080/*", time.Now(), false)
+	assert.True(t, span.Sampled())
+	span.SetTag("error", "true")
+	span.Finish()
+	tracer.reporter.(*batchReporter).Flush()
+
+	traces := <-received
+	assert.Equal(t, "service.name", traces.ResourceSpans[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "productpage.default", traces.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	s := traces.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", s.TraceID)
+	assert.Equal(t, "b7ad6b7169203331", s.ParentSpanID)
+	assert.NotEqual(t, "b7ad6b7169203331", s.SpanID)
+	assert.Equal(t, otlpSpanKindServer, s.Kind)
+	assert.Equal(t, otlpStatusCodeError, s.Status.Code)
+
+	_, err := NewTracer(&envoy_config_trace_v3.Tracing_Http{Name: "invalid"}, nil)
+	assert.Error(t, err)
+}
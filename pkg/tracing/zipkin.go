@@ -0,0 +1,123 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+
+	envoy_config_trace_v3 "github.com/envoyproxy/go-control-plane/envoy/config/trace/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/wereliang/govoy/pkg/api"
+	"github.com/wereliang/govoy/pkg/log"
+)
+
+const (
+	defaultServiceName    = "govoy"
+	defaultZipkinEndpoint = "/api/v2/spans"
+)
+
+func init() {
+	registDriver(&envoy_config_trace_v3.ZipkinConfig{}, newZipkinTracer)
+}
+
+// serviceName is the cluster of local node, like envoy's service cluster
+func serviceName(context api.FactoryContext) string {
+	if name := context.LocalNode().GetCluster(); name != "" {
+		return name
+	}
+	return defaultServiceName
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// zipkinSpan is the span of zipkin v2 json api
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint *zipkinEndpoint   `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	Shared        bool              `json:"shared,omitempty"`
+}
+
+func encodeZipkin(service string, spans []*Span) ([]byte, error) {
+	endpoint := &zipkinEndpoint{ServiceName: service}
+	zspans := make([]*zipkinSpan, 0, len(spans))
+	for _, s := range spans {
+		name, tags := s.snapshot()
+		zs := &zipkinSpan{
+			TraceID:       s.context.TraceID.String(),
+			ID:            formatSpanID(s.context.SpanID),
+			Name:          name,
+			Kind:          "SERVER",
+			Timestamp:     s.start.UnixMicro(),
+			Duration:      s.duration.Microseconds(),
+			LocalEndpoint: endpoint,
+			Tags:          tags,
+			Shared:        s.shared,
+		}
+		if s.context.ParentID != 0 {
+			zs.ParentID = formatSpanID(s.context.ParentID)
+		}
+		if s.kind == spanKindClient {
+			zs.Kind = "CLIENT"
+		}
+		zspans = append(zspans, zs)
+	}
+	return json.Marshal(zspans)
+}
+
+// newZipkinTracer create tracer by envoy.config.trace.v3.ZipkinConfig, just support HTTP_JSON
+func newZipkinTracer(pb proto.Message, context api.FactoryContext) (*Tracer, error) {
+	c := pb.(*envoy_config_trace_v3.ZipkinConfig)
+	if c.GetCollectorCluster() == "" {
+		return nil, fmt.Errorf("zipkin collector cluster is empty")
+	}
+	if v := c.GetCollectorEndpointVersion(); v != envoy_config_trace_v3.ZipkinConfig_HTTP_JSON &&
+		v != envoy_config_trace_v3.ZipkinConfig_DEPRECATED_AND_UNAVAILABLE_DO_NOT_USE {
+		log.Warn("zipkin collector endpoint version %s is not supported, use HTTP_JSON", v)
+	}
+	endpoint := c.GetCollectorEndpoint()
+	if endpoint == "" {
+		endpoint = defaultZipkinEndpoint
+	}
+	service := serviceName(context)
+	t := &Tracer{traceID128: c.GetTraceId_128Bit(), sharedSpanContext: true}
+	if v := c.GetSharedSpanContext(); v != nil {
+		t.sharedSpanContext = v.GetValue()
+	}
+	t.reporter = newBatchReporter(context.ClusterManager(), c.GetCollectorCluster(), endpoint,
+		c.GetCollectorHostname(), "application/json", func(spans []*Span) ([]byte, error) {
+			return encodeZipkin(service, spans)
+		})
+	return t, nil
+}
@@ -0,0 +1,52 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+// ReadDataSource returns the data of envoy.config.core.v3.DataSource
+func ReadDataSource(ds *envoy_config_core_v3.DataSource) ([]byte, error) {
+	switch spec := ds.GetSpecifier().(type) {
+	case *envoy_config_core_v3.DataSource_InlineString:
+		return []byte(spec.InlineString), nil
+	case *envoy_config_core_v3.DataSource_InlineBytes:
+		return spec.InlineBytes, nil
+	case *envoy_config_core_v3.DataSource_Filename:
+		return ioutil.ReadFile(spec.Filename)
+	case *envoy_config_core_v3.DataSource_EnvironmentVariable:
+		v, ok := os.LookupEnv(spec.EnvironmentVariable)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s not found", spec.EnvironmentVariable)
+		}
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("invalid data source")
+}
@@ -0,0 +1,53 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2022 wereliang
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package utils
+
+import (
+	"math/rand"
+
+	envoy_type_v3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+)
+
+// FractionalPercentDenominator returns the denominator value of fractional percent
+func FractionalPercentDenominator(fp *envoy_type_v3.FractionalPercent) uint64 {
+	switch fp.GetDenominator() {
+	case envoy_type_v3.FractionalPercent_TEN_THOUSAND:
+		return 10000
+	case envoy_type_v3.FractionalPercent_MILLION:
+		return 1000000
+	default:
+		return 100
+	}
+}
+
+// FractionalPercentHit returns whether the value falls in the percent, nil percent is 0%
+func FractionalPercentHit(fp *envoy_type_v3.FractionalPercent, value uint64) bool {
+	return value%FractionalPercentDenominator(fp) < uint64(fp.GetNumerator())
+}
+
+// FractionalPercentSample samples randomly by the percent
+func FractionalPercentSample(fp *envoy_type_v3.FractionalPercent) bool {
+	return FractionalPercentHit(fp, rand.Uint64())
+}